@@ -17,12 +17,15 @@ limitations under the License.
 package rest
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -30,6 +33,10 @@ import (
 const (
 	defaultLogPrefix     = "rest "
 	defaultDocsDirectory = "_docs/"
+
+	// drainInterval is how often Shutdown checks whether requests in flight have
+	// completed.
+	drainInterval = 10 * time.Millisecond
 )
 
 // Address is the address and port to bind to (e.g. ":8080").
@@ -44,15 +51,148 @@ type Configuration struct {
 	Logger        *log.Logger
 	GenerateDocs  bool
 	DocsDirectory string
+
+	// ValidateUTF8, NormalizeUnicode, and StripControlChars apply the corresponding
+	// Rule string hygiene to every string in request payloads, regardless of Rules.
+	ValidateUTF8      bool
+	NormalizeUnicode  bool
+	StripControlChars bool
+
+	// UseNumber decodes numbers in request payloads as json.Number rather than
+	// float64, so integers beyond 2^53 and high-precision decimals reach handlers
+	// exactly. Payload getters and Rule coercion parse the preserved literal.
+	UseNumber bool
+
+	// AcceptedCharsets are the charsets other than UTF-8 which request bodies may be
+	// encoded in, e.g. UTF16LE or Latin1. Bodies are transcoded to UTF-8 before being
+	// decoded. Requests specifying any other charset receive a 415.
+	AcceptedCharsets []string
+
+	// StaleCursorStatus is the status returned when a pagination cursor is stale,
+	// either because a CursorVersioner's version changed or the handler returned a
+	// StaleCursor error. Defaults to 410 Gone, but 400 Bad Request may be preferable
+	// for some clients.
+	StaleCursorStatus int
+
+	// VerifyDigest enables verification of request bodies against the Digest and
+	// Content-MD5 headers. Digests are computed over the body exactly as received,
+	// before charset transcoding, normalization, or Rules are applied.
+	VerifyDigest bool
+
+	// DigestAlgorithms are the algorithms accepted for request digest verification,
+	// e.g. DigestSHA256. Defaults to all supported algorithms if empty.
+	DigestAlgorithms []string
+
+	// ResponseDigest is the algorithm used to generate a Digest header for responses,
+	// e.g. DigestSHA256. The digest is computed over the serialized response body.
+	// DigestMD5 generates a legacy Content-MD5 header instead. Response digests are
+	// disabled if empty.
+	ResponseDigest string
+
+	// StatsURI is the URI at which the API's Stats are served as JSON. The stats
+	// endpoint is disabled if empty.
+	StatsURI string
+
+	// HealthCheckURIs are the paths of health and readiness endpoints, which remain
+	// available during maintenance.
+	HealthCheckURIs []string
+
+	// Maintenance schedules a window during which the API is in maintenance mode.
+	Maintenance *MaintenanceWindow
+
+	// OnMaintenanceChange is invoked whenever maintenance mode begins or ends, either
+	// because of API#EnterMaintenance and API#ExitMaintenance or the Maintenance
+	// window.
+	OnMaintenanceChange func(MaintenanceState)
+
+	// SupportedVersions are the API versions the API serves. If set, every resource
+	// registered using ForVersions must have a handler for each of them and no
+	// others.
+	SupportedVersions []string
+
+	// PropagatedHeaders are the request headers, such as X-Request-Id or traceparent,
+	// which are forwarded on downstream requests made using
+	// RequestContext#WrapHTTPClient. If X-Request-Id is included, an ID is generated
+	// for requests which don't have one.
+	PropagatedHeaders []string
+
+	// ExamplesDirectory is where ValidateExamples keeps the golden files for
+	// ResourceHandler examples. Defaults to testdata/examples.
+	ExamplesDirectory string
+
+	// CORS is the CORSPolicy for every resource registered without one. Cross-origin
+	// requests are denied for those resources if it's nil.
+	CORS *CORSPolicy
+
+	// MaxPathLength is the maximum length, in bytes, of escaped request paths. Requests
+	// with longer paths receive a 400. Defaults to 2048.
+	MaxPathLength int
+
+	// MaxPathSegments is the maximum number of segments in request paths, counting
+	// encoded slashes. Requests with more receive a 400. Defaults to 64.
+	MaxPathSegments int
+
+	// MaxPassthroughBytes is the maximum size, in bytes, of PassthroughError bodies.
+	// Larger ones are replaced with a 502. Defaults to 1 MiB.
+	MaxPassthroughBytes int
+
+	// StrictValidation makes API#Validate treat warnings, such as a ResourceHandler
+	// without Rules or a route shadowed by another, as problems. Defaults to false, in
+	// which case they're logged in debug mode.
+	StrictValidation bool
+
+	// DeadlineBudget propagates request deadlines across services. Deadlines aren't
+	// propagated if it's nil.
+	DeadlineBudget *DeadlineBudget
+
+	// RejectionAudit records rejected requests, such as those which fail
+	// authentication, for security investigations. Rejections are counted in the
+	// Metrics per stage whether or not it's set.
+	RejectionAudit *RejectionAudit
+
+	// Scopes is the ScopePolicy for every resource registered without one. Scopes
+	// aren't enforced for those resources if it's nil.
+	Scopes *ScopePolicy
+
+	// StrictOutput is the StrictOutput for every resource registered without one.
+	// Responses aren't checked for undeclared fields if it's nil.
+	StrictOutput *StrictOutput
+
+	// Store holds the state framework features share across API instances, such as
+	// rate limit counts. Defaults to an in-memory Store, so state is per instance.
+	Store Store
+
+	// SlowRequestThreshold is the duration after which requests to resources are
+	// counted and logged as slow. Slow requests aren't logged if it's zero.
+	SlowRequestThreshold time.Duration
+
+	// RuntimeFlags serves the API's runtime flags so debug mode, log levels, and
+	// similar settings can be adjusted without redeploying. The flags can still be set
+	// using API#SetRuntimeFlag if it's nil.
+	RuntimeFlags *RuntimeFlagsEndpoint
+
+	// AuthCache is the AuthCache for every resource registered without one.
+	// Authentication decisions aren't cached for those resources if it's nil.
+	AuthCache *AuthCache
 }
 
 // Debugf prints the formatted string to the Configuration Logger if Debug is enabled.
 func (c *Configuration) Debugf(format string, v ...interface{}) {
 	if c.Debug {
-		c.Logger.Printf(format, v)
+		c.Logger.Printf(format, v...)
 	}
 }
 
+// Logf prints the formatted string to the Configuration Logger, or the standard logger
+// if there isn't one, regardless of Debug.
+func (c *Configuration) Logf(format string, v ...interface{}) {
+	if c.Logger == nil {
+		log.Printf(format, v...)
+		return
+	}
+	c.Logger.Printf(format, v...)
+}
+
 // NewConfiguration returns a default Configuration.
 func NewConfiguration() *Configuration {
 	logger := log.New(os.Stdout, defaultLogPrefix, log.LstdFlags)
@@ -117,9 +257,9 @@ type API interface {
 	StartTLS(Address, FilePath, FilePath, ...Middleware) error
 
 	// RegisterResourceHandler binds the provided ResourceHandler to the appropriate REST
-	// endpoints and applies any specified middleware. Endpoints will have the following
-	// base URL: /api/:version/resourceName.
-	RegisterResourceHandler(ResourceHandler, ...RequestMiddleware)
+	// endpoints and applies any specified ResourceOptions, such as RequestMiddleware.
+	// Endpoints will have the following base URL: /api/:version/resourceName.
+	RegisterResourceHandler(ResourceHandler, ...ResourceOption)
 
 	// RegisterHandlerFunc binds the http.HandlerFunc to the provided URI and applies any
 	// specified middleware.
@@ -133,6 +273,12 @@ type API interface {
 	// prefix and applies any specified middleware.
 	RegisterPathPrefix(string, http.HandlerFunc, ...RequestMiddleware)
 
+	// MountLegacyHandler binds the http.Handler to the method and gorilla/mux path so
+	// services can be migrated to the API incrementally. The handler writes its own
+	// responses and reads path variables using mux.Vars. Returns an error if the route
+	// conflicts with a resource's.
+	MountLegacyHandler(string, string, http.Handler, ...RequestMiddleware) error
+
 	// RegisterResponseSerializer registers the provided ResponseSerializer with the given
 	// format. If the format has already been registered, it will be overwritten.
 	RegisterResponseSerializer(string, ResponseSerializer)
@@ -141,6 +287,10 @@ type API interface {
 	// format. If the format hasn't been registered, this is a no-op.
 	UnregisterResponseSerializer(string)
 
+	// RegisterErrorMapper appends the ErrorMapper to those consulted, in the order they
+	// were registered, when a ResourceHandler returns an error which isn't an Error.
+	RegisterErrorMapper(ErrorMapper)
+
 	// AvailableFormats returns a slice containing all of the available serialization
 	// formats currently available.
 	AvailableFormats() []string
@@ -151,14 +301,86 @@ type API interface {
 	// ResourceHandlers returns a slice containing the registered ResourceHandlers.
 	ResourceHandlers() []ResourceHandler
 
-	// Validate will validate the Rules configured for this API. It returns nil
-	// if all Rules are valid, otherwise returns the first encountered
-	// validation error.
+	// Metrics returns the counters maintained by the API.
+	Metrics() Metrics
+
+	// Stats returns a snapshot of the API's operational state, including its counters,
+	// maintenance state, background tasks, runtime flags, and authentication cache.
+	// This is what the stats endpoint serves.
+	Stats() map[string]interface{}
+
+	// SetRuntimeFlag sets the named runtime flag, one of the Flag constants, to the
+	// value until it's reset or, if the TTL is positive, the TTL elapses. Returns an
+	// error if the flag can't be set at runtime or the value is invalid for it.
+	SetRuntimeFlag(string, string, time.Duration) error
+
+	// ResetRuntimeFlag unsets the named runtime flag so the configured value applies
+	// again.
+	ResetRuntimeFlag(string) error
+
+	// RuntimeFlags returns the runtime flags which are set, ordered by name.
+	RuntimeFlags() []RuntimeFlag
+
+	// InvalidateAuth discards the authentication decisions cached by AuthCache for the
+	// credential with the AuthCredentialHash, e.g. when it's revoked or its session
+	// ends, so its next request is authenticated again.
+	InvalidateAuth(string)
+
+	// EnterMaintenance puts the API into maintenance mode. Every request receives a
+	// 503 with the provided message except for requests to the allowed route names,
+	// health checks, and the stats endpoint. Requests already in flight are allowed
+	// to complete. Maintenance lasts until ExitMaintenance is called.
+	EnterMaintenance(string, []string)
+
+	// ExitMaintenance takes the API out of maintenance mode, including any scheduled
+	// Maintenance window.
+	ExitMaintenance()
+
+	// Maintenance returns the current MaintenanceState.
+	Maintenance() MaintenanceState
+
+	// BackgroundTasks returns the status of the goroutines the framework runs in the
+	// background, such as those refreshing state, in the order they were started.
+	BackgroundTasks() []TaskStatus
+
+	// Shutdown stops the API within the timeout. The servers started by Start and
+	// StartTLS stop listening and close idle connections, and new requests receive a
+	// 503 while requests in flight are allowed to complete. Once they have, background tasks are canceled and waited
+	// for. Returns an error if either doesn't finish before the timeout.
+	Shutdown(time.Duration) error
+
+	// Routes returns the routes registered with the API in precedence order, i.e. a
+	// request is routed to the first one matching it.
+	Routes() []RouteInfo
+
+	// WhichRoute returns the route a request with the method and path would be routed
+	// to, or false if none would.
+	WhichRoute(string, string) (RouteInfo, bool)
+
+	// ExportManifest returns a document describing every route registered with the API
+	// in the format, either "json" or one with a registered ResponseSerializer, for
+	// configuring API gateways.
+	ExportManifest(string) ([]byte, error)
+
+	// Validate will validate the Rules configured for this API, that resources
+	// registered using ForVersions serve each of the SupportedVersions, that a zero
+	// value of each resource type serializes with each registered ResponseSerializer,
+	// and that no two routes serve exactly the same requests. Routes shadowed by ones
+	// registered before them are warnings. It returns nil if everything is valid,
+	// otherwise returns a ValidationError describing every problem found.
 	Validate() error
 
 	// responseSerializer returns a ResponseSerializer for the given format type. If the
 	// format is not implemented, the returned serializer will be nil and the error set.
 	responseSerializer(string) (ResponseSerializer, error)
+
+	// documentedResourceHandlers returns a slice containing the registered
+	// ResourceHandlers which should be included in generated documentation.
+	documentedResourceHandlers() []ResourceHandler
+
+	// mapError returns the Error the registered ErrorMappers translate the error to.
+	// Returns false if the error is already an Error or no ErrorMapper handles it.
+	mapError(error) (Error, bool)
 }
 
 // RequestMiddleware is a function that returns a HandlerFunc wrapping the provided HandlerFunc.
@@ -170,6 +392,7 @@ func newAuthMiddleware(authenticate func(*http.Request) error) RequestMiddleware
 	return func(wrapped http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			if err := authenticate(r); err != nil {
+				markRejected(r, RejectedAuthentication, "")
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte(err.Error()))
 				return
@@ -187,7 +410,22 @@ type muxAPI struct {
 	mu                 sync.RWMutex
 	handler            *requestHandler
 	serializerRegistry map[string]ResponseSerializer
-	resourceHandlers   []ResourceHandler
+	errorMappers       []ErrorMapper
+	memoryStore        *MemoryStore
+	breakers           map[string]*circuitBreaker
+	exporters          map[string]*exporter
+	authDecisions      *authDecisions
+	flags              *runtimeFlags
+	registrations      []*registration
+	routes             []RouteInfo
+	routeConflicts     []RouteConflict
+	versionRouters     map[string]*versionRouter
+	metrics            Metrics
+	maintenance        *maintenanceMode
+	tasks              *supervisor
+	servers            []*http.Server
+	inFlight           int64
+	shuttingDown       int32
 }
 
 // NewAPI returns a newly allocated API instance.
@@ -197,9 +435,25 @@ func NewAPI(config *Configuration) API {
 		config:             config,
 		router:             r,
 		serializerRegistry: map[string]ResponseSerializer{"json": &jsonSerializer{}},
-		resourceHandlers:   make([]ResourceHandler, 0),
+		registrations:      make([]*registration, 0),
+		versionRouters:     map[string]*versionRouter{},
+		breakers:           map[string]*circuitBreaker{},
+		exporters:          map[string]*exporter{},
+		authDecisions:      newAuthDecisions(),
+		flags:              newRuntimeFlags(config.Logf),
+		metrics:            newMetrics(),
+		maintenance:        newMaintenanceMode(config.Maintenance, config.OnMaintenanceChange),
+		tasks:              newSupervisor(config.Logf),
 	}
 	restAPI.handler = &requestHandler{restAPI}
+	if config.StatsURI != "" {
+		route := r.HandleFunc(config.StatsURI, restAPI.handleStats).Methods("GET").Name("stats")
+		restAPI.addRoute(RouteInfo{Name: "stats", Kind: StatsRoute, Method: "GET",
+			Path: config.StatsURI, CallSite: callSite(1)}, route)
+	}
+	if config.RuntimeFlags != nil {
+		restAPI.registerRuntimeFlagsEndpoint(config.RuntimeFlags)
+	}
 	return restAPI
 }
 
@@ -207,7 +461,8 @@ func NewAPI(config *Configuration) API {
 // returned.
 func (r *muxAPI) Start(addr Address, middleware ...Middleware) error {
 	r.preprocess()
-	return http.ListenAndServe(string(addr), wrapMiddleware(r.router, middleware...))
+	server := &http.Server{Addr: string(addr), Handler: wrapMiddleware(r, middleware...)}
+	return r.serve(server, server.ListenAndServe)
 }
 
 // StartTLS begins serving requests received over HTTPS connections. This will block unless it
@@ -217,7 +472,79 @@ func (r *muxAPI) Start(addr Address, middleware ...Middleware) error {
 // the CA's certificate.
 func (r *muxAPI) StartTLS(addr Address, certFile, keyFile FilePath, middleware ...Middleware) error {
 	r.preprocess()
-	return http.ListenAndServeTLS(string(addr), string(certFile), string(keyFile), wrapMiddleware(r.router, middleware...))
+	server := &http.Server{Addr: string(addr), Handler: wrapMiddleware(r, middleware...)}
+	return r.serve(server, func() error {
+		return server.ListenAndServeTLS(string(certFile), string(keyFile))
+	})
+}
+
+// serve runs the function serving requests using the Server until it fails or the API
+// is shut down, in which case nil is returned.
+func (r *muxAPI) serve(server *http.Server, listenAndServe func() error) error {
+	r.mu.Lock()
+	if r.isShuttingDown() {
+		r.mu.Unlock()
+		return nil
+	}
+	r.servers = append(r.servers, server)
+	r.mu.Unlock()
+
+	err := listenAndServe()
+	if r.isShuttingDown() {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the API within the timeout. The servers started by Start and StartTLS
+// stop listening and close idle connections, including those kept alive between
+// requests, and new requests receive a 503 while requests in flight are allowed to
+// complete. Once they have, background tasks are canceled and waited for. Returns an
+// error if either doesn't finish before the timeout.
+func (r *muxAPI) Shutdown(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	r.mu.Lock()
+	atomic.StoreInt32(&r.shuttingDown, 1)
+	servers := r.servers
+	r.servers = nil
+	r.mu.Unlock()
+
+	r.debugf("", "Shutting down")
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	shutdowns := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			shutdowns <- server.Shutdown(ctx)
+		}(server)
+	}
+	for range servers {
+		if err := <-shutdowns; err != nil {
+			r.config.Logf("Failed to close connections: %s", err)
+		}
+	}
+
+	for atomic.LoadInt64(&r.inFlight) > 0 {
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("Timed out waiting for %d requests to complete",
+				atomic.LoadInt64(&r.inFlight))
+		}
+		time.Sleep(drainInterval)
+	}
+
+	return r.tasks.stop(deadline.Sub(time.Now()))
+}
+
+// isShuttingDown returns true if Shutdown has been called.
+func (r *muxAPI) isShuttingDown() bool {
+	return atomic.LoadInt32(&r.shuttingDown) == 1
+}
+
+// BackgroundTasks returns the status of the goroutines the framework runs in the
+// background in the order they were started.
+func (r *muxAPI) BackgroundTasks() []TaskStatus {
+	return r.tasks.statuses()
 }
 
 // preprocess performs any necessary preprocessing before the server can be started, including
@@ -225,91 +552,325 @@ func (r *muxAPI) StartTLS(addr Address, certFile, keyFile FilePath, middleware .
 func (r *muxAPI) preprocess() {
 	r.validateRulesOrPanic()
 	if r.config.GenerateDocs {
-		newDocGenerator().generateDocs(r)
+		newDocGenerator(r.config).generateDocs(r)
 	}
 }
 
 // RegisterResourceHandler binds the provided ResourceHandler to the appropriate REST endpoints and
-// applies any specified middleware. Endpoints will have the following base URL:
-// /api/:version/resourceName.
-func (r *muxAPI) RegisterResourceHandler(h ResourceHandler, middleware ...RequestMiddleware) {
+// applies any specified ResourceOptions, such as RequestMiddleware. Endpoints will have the
+// following base URL: /api/:version/resourceName.
+func (r *muxAPI) RegisterResourceHandler(h ResourceHandler, options ...ResourceOption) {
+	site := callSite(1)
 	h = resourceHandlerProxy{h}
 	resource := h.ResourceName()
-	middleware = append(middleware, newAuthMiddleware(h.Authenticate))
+	opts := newResourceOptions(options)
+	if opts.lookupKeys != nil {
+		h = newKeyedHandler(h, opts.lookupKeys)
+	}
+	if opts.transactions != nil {
+		h = transactionalHandler{h, opts.transactions, r}
+	}
+	if opts.retries != nil {
+		// Retries wrap transactions so each attempt runs in its own transaction.
+		h = retryingHandler{h, opts.retries, r.metrics}
+	}
+	if opts.hedging != nil {
+		// Hedging wraps retries so each invocation retries independently.
+		h = newHedgingHandler(h, opts.hedging, r.metrics)
+	}
+	strict := opts.strictOutput
+	if strict == nil {
+		strict = r.config.StrictOutput
+	}
+	if strict != nil {
+		h = strictHandler{h, strict}
+	}
+	middleware := r.resourceMiddleware(h, opts)
+	routes := r.resourceRoutes(h, middleware)
+	if opts.validation != nil {
+		routes = r.validationRoutes(h, opts.validation, routes, middleware)
+	}
+	if opts.export != nil {
+		routes = r.exportRoutes(h, opts.export, routes, middleware)
+	}
+	if opts.capabilities != nil {
+		var options []resourceRoute
+		routes, options = r.capabilityRoutes(h, opts, routes, middleware)
+		routes = append(routes, options...)
+	} else {
+		routes = append(routes, preflightRoutes(r.effectiveCORSPolicy(resource, opts), routes)...)
+	}
+
+	router, versioned := r.versionRouters[resource]
+	if opts.versions != nil {
+		versions := make(map[string]bool, len(opts.versions))
+		for _, version := range opts.versions {
+			versions[normalizeVersion(version)] = true
+		}
+		h = versionedHandler{h, versions}
+
+		if !versioned {
+			if r.isRegistered(resource) {
+				panic(fmt.Sprintf("Handler for %s registered without ForVersions", resource))
+			}
+			router = newVersionRouter(resource, routes)
+			r.versionRouters[resource] = router
+		}
+		router.add(h.(versionedHandler), routes)
+	} else if versioned {
+		panic(fmt.Sprintf("Handler for %s registered without ForVersions", resource))
+	}
+
+	// Versioned resources share a single set of routes which dispatch by version.
+	if opts.versions == nil || !versioned {
+		for _, route := range routes {
+			handler := route.handler
+			if router != nil {
+				handler = router.route(route.name)
+			}
+			mr := r.router.HandleFunc(route.uri, handler).Methods(route.method)
+			if route.override != "" {
+				mr = mr.Headers("X-HTTP-Method-Override", route.override)
+			} else {
+				// Leave requests overriding the method to the routes for them, e.g.
+				// POST with PUT routes to update list rather than create.
+				if overrides := routeOverrides(routes, route); len(overrides) > 0 {
+					mr = mr.MatcherFunc(withoutOverride(overrides))
+				}
+				r.debugf("", "Registered %s handler at %s %s",
+					route.description, route.method, route.uri)
+			}
+			mr.Name(resource + ":" + route.name)
+			r.addRoute(RouteInfo{Name: resource + ":" + route.name, Kind: ResourceRoute,
+				Method: route.method, Path: route.uri, MethodOverride: route.override,
+				CallSite: site}, mr)
+		}
+	}
 
-	r.router.HandleFunc(
-		h.CreateURI(), applyMiddleware(r.handler.handleCreate(h), middleware),
-	).Methods("POST").Name(resource + ":create")
-	r.config.Debugf("Registered create handler at POST %s", h.CreateURI())
+	r.registrations = append(r.registrations, &registration{handler: h, options: opts, routes: routes})
+}
 
-	r.router.HandleFunc(
-		h.ReadListURI(), applyMiddleware(r.handler.handleReadList(h), middleware),
-	).Methods("GET").Name(resource + ":readList")
-	r.config.Debugf("Registered read list handler at GET %s", h.ReadListURI())
+// resourceRoute is an endpoint bound to a ResourceHandler.
+type resourceRoute struct {
+	name        string
+	description string
+	method      string
+	uri         string
+	override    string
+	handler     http.HandlerFunc
+}
 
-	r.router.HandleFunc(
-		h.ReadURI(), applyMiddleware(r.handler.handleRead(h), middleware),
-	).Methods("GET").Name(resource + ":read")
-	r.config.Debugf("Registered read handler at GET %s", h.ReadURI())
+// routeOverrides returns the X-HTTP-Method-Override values of the routes which serve
+// the route's method at its URI with an override.
+func routeOverrides(routes []resourceRoute, route resourceRoute) []string {
+	overrides := []string{}
+	for _, other := range routes {
+		if other.override != "" && other.method == route.method && other.uri == route.uri {
+			overrides = append(overrides, other.override)
+		}
+	}
+	return overrides
+}
 
-	r.router.HandleFunc(
-		h.UpdateListURI(), applyMiddleware(r.handler.handleUpdateList(h), middleware),
-	).Methods("PUT").Name(resource + ":updateList")
-	r.config.Debugf("Registered update list handler at PUT %s", h.UpdateListURI())
+// withoutOverride returns a mux.MatcherFunc matching requests which don't override
+// their method with any of the values.
+func withoutOverride(overrides []string) mux.MatcherFunc {
+	return func(req *http.Request, match *mux.RouteMatch) bool {
+		override := req.Header.Get("X-HTTP-Method-Override")
+		for _, o := range overrides {
+			if o == override {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// resourceRoutes returns the endpoints for the ResourceHandler with the middleware
+// applied.
+func (r *muxAPI) resourceRoutes(h ResourceHandler, middleware []RequestMiddleware) []resourceRoute {
+	create := applyMiddleware(r.handler.handleCreate(h), middleware)
+	readList := applyMiddleware(r.handler.handleReadList(h), middleware)
+	read := applyMiddleware(r.handler.handleRead(h), middleware)
+	updateList := applyMiddleware(r.handler.handleUpdateList(h), middleware)
+	update := applyMiddleware(r.handler.handleUpdate(h), middleware)
+	del := applyMiddleware(r.handler.handleDelete(h), middleware)
+
+	routes := []resourceRoute{
+		{"create", "create", "POST", h.CreateURI(), "", create},
+		{"readList", "read list", "GET", h.ReadListURI(), "", readList},
+	}
 
-	r.router.HandleFunc(
-		h.UpdateURI(), applyMiddleware(r.handler.handleUpdate(h), middleware),
-	).Methods("PUT").Name(resource + ":update")
-	r.config.Debugf("Registered update handler at PUT %s", h.UpdateURI())
+	// The count routes must precede the read route, which would otherwise match them.
+	if _, ok := unwrapHandler(h).(ResourceCounter); ok {
+		count := applyMiddleware(r.handler.handleCount(h), middleware)
+		routes = append(routes,
+			resourceRoute{"count", "count", "GET", countURI(h), "", count},
+			resourceRoute{"countHead", "count", "HEAD", countURI(h), "", count},
+		)
+	}
 
-	r.router.HandleFunc(
-		h.DeleteURI(), applyMiddleware(r.handler.handleDelete(h), middleware),
-	).Methods("DELETE").Name(resource + ":delete")
-	r.config.Debugf("Registered delete handler at DELETE %s", h.DeleteURI())
+	routes = append(routes, []resourceRoute{
+		{"read", "read", "GET", h.ReadURI(), "", read},
+		{"updateList", "update list", "PUT", h.UpdateListURI(), "", updateList},
+		{"update", "update", "PUT", h.UpdateURI(), "", update},
+		{"delete", "delete", "DELETE", h.DeleteURI(), "", del},
+
+		// Some browsers don't support PUT and DELETE, so allow method overriding.
+		// POST requests with X-HTTP-Method-Override=PUT/DELETE will route to the
+		// respective handlers.
+		{"updateListOverride", "update list", "POST", h.UpdateListURI(), "PUT", updateList},
+		{"updateOverride", "update", "POST", h.UpdateURI(), "PUT", update},
+		{"deleteOverride", "delete", "POST", h.DeleteURI(), "DELETE", del},
+	}...)
+
+	if _, ok := resolverFor(h); ok {
+		routes = append(routes, resourceRoute{"readHead", "read", "HEAD", h.ReadURI(), "", read})
+	}
+	if keys := lookupKeys(h); keys != nil && keys.Style == KeyRoutes {
+		routes = append(routes, resourceRoute{
+			"readByKey", "read by key", "GET", keys.routeURI(h.ReadURI()), "", read,
+		})
+	}
+	return routes
+}
 
-	// Some browsers don't support PUT and DELETE, so allow method overriding.
-	// POST requests with X-HTTP-Method-Override=PUT/DELETE will route to the
-	// respective handlers.
-	r.router.HandleFunc(
-		h.UpdateListURI(), applyMiddleware(r.handler.handleUpdateList(h), middleware),
-	).Methods("POST").Headers("X-HTTP-Method-Override", "PUT").Name(resource + ":updateListOverride")
+// isRegistered returns true if a ResourceHandler is registered for the resource.
+func (r *muxAPI) isRegistered(resource string) bool {
+	for _, reg := range r.registrations {
+		if reg.handler.ResourceName() == resource {
+			return true
+		}
+	}
+	return false
+}
 
-	r.router.HandleFunc(
-		h.UpdateURI(), applyMiddleware(r.handler.handleUpdate(h), middleware),
-	).Methods("POST").Headers("X-HTTP-Method-Override", "PUT").Name(resource + ":updateOverride")
+// resourceMiddleware returns the RequestMiddleware to apply to the ResourceHandler's
+// endpoints. Middleware is applied in order such that the last RequestMiddleware is
+// invoked first, so authentication runs before any user-provided middleware.
+func (r *muxAPI) resourceMiddleware(h ResourceHandler, opts *resourceOptions) []RequestMiddleware {
+	resource := h.ResourceName()
+	middleware := []RequestMiddleware{}
 
-	r.router.HandleFunc(
-		h.DeleteURI(), applyMiddleware(r.handler.handleDelete(h), middleware),
-	).Methods("POST").Headers("X-HTTP-Method-Override", "DELETE").Name(resource + ":deleteOverride")
+	if opts.breaker != nil {
+		// The circuit breaker runs closest to the handler, inside the cache and the
+		// provided middleware, so only the handler's outcomes trip it and cached
+		// responses are served while it's open.
+		middleware = append(middleware, newBreakerMiddleware(r, resource, opts.breaker))
+	}
+	if opts.cache != nil {
+		middleware = append(middleware, newCacheMiddleware(r, resource, opts.cache))
+	}
+	// The provided middleware wraps the cache so requests it rejects aren't served
+	// cached responses.
+	middleware = append(middleware, opts.middleware...)
+	if opts.capture != nil {
+		// Capture runs after authentication and gating so rejected requests aren't
+		// captured.
+		middleware = append(middleware, newCaptureMiddleware(r, h, opts.capture))
+	}
+	if opts.rateLimit != nil {
+		// Rate limiting runs after authentication so keys can be derived from the
+		// principal.
+		middleware = append(middleware, newRateLimitMiddleware(r, resource, opts.rateLimit))
+	}
+	if opts.quota != nil {
+		// Quotas run after authentication so keys can be derived from the principal.
+		middleware = append(middleware, newQuotaMiddleware(r, resource, opts.quota))
+	}
+	if policy := r.effectiveScopePolicy(opts); policy != nil {
+		// Scopes are checked after authentication attaches them.
+		middleware = append(middleware, newScopeMiddleware(r, resource, policy))
+	}
+	gate := opts.gate
+	if gate != nil && gate.AfterAuthentication {
+		middleware = append(middleware, newGateMiddleware(r, resource, gate))
+	}
+	middleware = append(middleware, newAuthMiddleware(
+		r.cachedAuthenticate(resource, h.Authenticate, r.effectiveAuthCache(opts))))
+	if gate != nil && !gate.AfterAuthentication {
+		middleware = append(middleware, newGateMiddleware(r, resource, gate))
+	}
+	if opts.disabled {
+		middleware = append(middleware, newDisabledMiddleware(r, resource))
+	}
+	middleware = append(middleware, newCORSMiddleware(r.effectiveCORSPolicy(resource, opts)))
+	middleware = append(middleware, newDeadlineMiddleware(r, resource, r.config.DeadlineBudget))
+	middleware = append(middleware, newRejectionMiddleware(r, h, r.config.RejectionAudit))
+	middleware = append(middleware, newPropagationMiddleware(r.config.PropagatedHeaders))
+	middleware = append(middleware, newSlowRequestMiddleware(r, resource))
 
-	r.resourceHandlers = append(r.resourceHandlers, h)
+	return middleware
 }
 
 // RegisterHandlerFunc binds the http.HandlerFunc to the provided URI and applies any
 // specified middleware.
 func (r *muxAPI) RegisterHandlerFunc(uri string, handler http.HandlerFunc,
 	middleware ...RequestMiddleware) {
-	r.router.HandleFunc(uri, applyMiddleware(handler, middleware))
+	middleware = append(middleware, newPropagationMiddleware(r.config.PropagatedHeaders))
+	route := r.router.HandleFunc(uri, applyMiddleware(handler, middleware))
+	r.addRoute(RouteInfo{Kind: HandlerRoute, Path: uri, CallSite: callSite(1)}, route)
 }
 
 // RegisterHandler binds the http.Handler to the provided URI and applies any specified
 // middleware.
 func (r *muxAPI) RegisterHandler(uri string, handler http.Handler, middleware ...RequestMiddleware) {
-	r.router.HandleFunc(uri, applyMiddleware(handler.ServeHTTP, middleware))
+	middleware = append(middleware, newPropagationMiddleware(r.config.PropagatedHeaders))
+	route := r.router.HandleFunc(uri, applyMiddleware(handler.ServeHTTP, middleware))
+	r.addRoute(RouteInfo{Kind: HandlerRoute, Path: uri, CallSite: callSite(1)}, route)
 }
 
 // RegisterPathPrefix binds the http.HandlerFunc to URIs matched by the given path
 // prefix and applies any specified middleware.
 func (r *muxAPI) RegisterPathPrefix(uri string, handler http.HandlerFunc,
 	middleware ...RequestMiddleware) {
-	r.router.PathPrefix(uri).HandlerFunc(applyMiddleware(handler, middleware))
+	middleware = append(middleware, newPropagationMiddleware(r.config.PropagatedHeaders))
+	route := r.router.PathPrefix(uri).HandlerFunc(applyMiddleware(handler, middleware))
+	r.addRoute(RouteInfo{Kind: PathPrefixRoute, Path: uri, CallSite: callSite(1)}, route)
 }
 
-// ServeHTTP handles an HTTP request.
+// ServeHTTP handles an HTTP request. Requests are rejected before being routed if
+// the API is shutting down, their path is malformed or escapes the API prefix, or the
+// API is in maintenance mode. Dot-segments are removed from paths before routing.
 func (r *muxAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+	if r.isShuttingDown() {
+		w.Header().Set("Connection", "close")
+		ctx := NewContext(nil, req).setError(ServiceUnavailable("The API is shutting down"))
+		r.handler.sendResponse(w, ctx)
+		return
+	}
+	if r.rejectInvalidPath(w, req) {
+		return
+	}
+	if r.rejectForMaintenance(w, req) {
+		return
+	}
 	r.router.ServeHTTP(w, req)
 }
 
+// EnterMaintenance puts the API into maintenance mode. Every request receives a 503
+// with the provided message except for requests to the allowed route names, health
+// checks, and the stats endpoint. Requests already in flight are allowed to complete.
+// Maintenance lasts until ExitMaintenance is called.
+func (r *muxAPI) EnterMaintenance(message string, allow []string) {
+	r.debugf("", "Entering maintenance mode")
+	r.maintenance.enter(message, allow)
+}
+
+// ExitMaintenance takes the API out of maintenance mode, including any scheduled
+// Maintenance window.
+func (r *muxAPI) ExitMaintenance() {
+	r.debugf("", "Exiting maintenance mode")
+	r.maintenance.exit()
+}
+
+// Maintenance returns the current MaintenanceState.
+func (r *muxAPI) Maintenance() MaintenanceState {
+	return r.maintenance.state()
+}
+
 // RegisterResponseSerializer registers the provided ResponseSerializer with the given format. If the
 // format has already been registered, it will be overwritten.
 func (r *muxAPI) RegisterResponseSerializer(format string, serializer ResponseSerializer) {
@@ -326,6 +887,14 @@ func (r *muxAPI) UnregisterResponseSerializer(format string) {
 	delete(r.serializerRegistry, format)
 }
 
+// RegisterErrorMapper appends the ErrorMapper to those consulted, in the order they were
+// registered, when a ResourceHandler returns an error which isn't an Error.
+func (r *muxAPI) RegisterErrorMapper(mapper ErrorMapper) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errorMappers = append(r.errorMappers, mapper)
+}
+
 // AvailableFormats returns a slice containing all of the available serialization formats
 // currently available.
 func (r *muxAPI) AvailableFormats() []string {
@@ -341,7 +910,29 @@ func (r *muxAPI) AvailableFormats() []string {
 
 // ResourceHandlers returns a slice containing the registered ResourceHandlers.
 func (r *muxAPI) ResourceHandlers() []ResourceHandler {
-	return r.resourceHandlers
+	handlers := make([]ResourceHandler, 0, len(r.registrations))
+	for _, reg := range r.registrations {
+		handlers = append(handlers, reg.handler)
+	}
+	return handlers
+}
+
+// documentedResourceHandlers returns a slice containing the registered ResourceHandlers
+// which should be included in generated documentation. Statically disabled resources
+// are excluded.
+func (r *muxAPI) documentedResourceHandlers() []ResourceHandler {
+	handlers := make([]ResourceHandler, 0, len(r.registrations))
+	for _, reg := range r.registrations {
+		if !reg.options.disabled {
+			handlers = append(handlers, reg.handler)
+		}
+	}
+	return handlers
+}
+
+// Metrics returns the counters maintained by the API.
+func (r *muxAPI) Metrics() Metrics {
+	return r.metrics
 }
 
 // Configuration returns the API Configuration.
@@ -349,21 +940,13 @@ func (r *muxAPI) Configuration() *Configuration {
 	return r.config
 }
 
-// Validate will validate the Rules configured for this API. It returns nil if
-// all Rules are valid, otherwise returns the first encountered validation
-// error.
+// Validate will validate the Rules configured for this API, that resources registered
+// using ForVersions serve each of the SupportedVersions, that a zero value of each
+// resource type serializes, and that routes don't collide. It returns nil if
+// everything is valid, otherwise returns a ValidationError describing every problem
+// found.
 func (r *muxAPI) Validate() error {
-	for _, handler := range r.resourceHandlers {
-		rules := handler.Rules()
-		if rules == nil || rules.Size() == 0 {
-			continue
-		}
-
-		if err := rules.Validate(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return r.validate()
 }
 
 // validateRulesOrPanic verifies that the Rules for each ResourceHandler
@@ -0,0 +1,267 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// AuthCacheHitsCounter counts requests whose authentication decision was served
+	// from the AuthCache.
+	AuthCacheHitsCounter = "auth_cache_hits"
+
+	// AuthCacheMissesCounter counts requests with a credential whose authentication
+	// decision wasn't in the AuthCache.
+	AuthCacheMissesCounter = "auth_cache_misses"
+
+	// defaultAuthCacheTTL is how long decisions are cached if AuthCache doesn't
+	// specify a TTL.
+	defaultAuthCacheTTL = 2 * time.Second
+)
+
+// AuthCache is a ResourceOption which caches the outcomes of the ResourceHandler's
+// Authenticate for a short TTL, so requests repeating a credential, such as a bearer
+// token, skip its validation and policy lookups. Decisions are cached in memory per
+// API instance, keyed by a SHA-256 hash of the credential along with the route and
+// method, and raw credentials are never stored. The Scopes Authenticate attaches
+// using SetScopes are restored on cache hits, but nothing else it sets on the request
+// is, so Authenticate implementations relying on other request state, or whose
+// decisions depend on more than the credential and operation, shouldn't be cached.
+//
+// Only successful decisions are cached unless CacheFailures is set. A revoked
+// credential is accepted for at most the TTL, or until API#InvalidateAuth is called
+// with its AuthCredentialHash. The Configuration's AuthCache applies to every
+// resource registered without one.
+type AuthCache struct {
+	// TTL is how long decisions are cached. Defaults to 2 seconds.
+	TTL time.Duration
+
+	// Credential returns the credential the request is authenticated with. Requests
+	// without one aren't cached. Defaults to the Authorization header.
+	Credential func(*http.Request) string
+
+	// CacheFailures enables caching rejections as well, so repeated requests with a
+	// bad credential are rejected without invoking Authenticate. Defaults to false.
+	CacheFailures bool
+
+	// now returns the current time, which tests replace.
+	now func() time.Time
+}
+
+// apply sets the AuthCache on the resource.
+func (c AuthCache) apply(opts *resourceOptions) {
+	opts.authCache = &c
+}
+
+// ttl returns how long decisions are cached.
+func (c *AuthCache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return defaultAuthCacheTTL
+}
+
+// credential returns the credential the request is authenticated with.
+func (c *AuthCache) credential(r *http.Request) string {
+	if c.Credential != nil {
+		return c.Credential(r)
+	}
+	return r.Header.Get("Authorization")
+}
+
+// clock returns the current time.
+func (c *AuthCache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// AuthCredentialHash returns the hash of the credential which cached authentication
+// decisions are keyed by, for passing to API#InvalidateAuth when a token is revoked
+// or its session ends.
+func AuthCredentialHash(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
+
+// effectiveAuthCache returns the AuthCache for a resource registered with the
+// options, or nil if its decisions aren't cached.
+func (r *muxAPI) effectiveAuthCache(opts *resourceOptions) *AuthCache {
+	if opts.authCache != nil {
+		return opts.authCache
+	}
+	return r.config.AuthCache
+}
+
+// InvalidateAuth discards the cached authentication decisions for the credential with
+// the AuthCredentialHash, so its next request is authenticated again.
+func (r *muxAPI) InvalidateAuth(credentialHash string) {
+	r.authDecisions.invalidate(credentialHash)
+	r.debugf("", "Invalidated cached authentication for %s", credentialHash)
+}
+
+// cachedAuthenticate returns a function which authenticates requests to the resource
+// using the AuthCache, falling back to the provided function. Returns the function
+// unchanged if the cache is nil.
+func (r *muxAPI) cachedAuthenticate(resource string, authenticate func(*http.Request) error,
+	cache *AuthCache) func(*http.Request) error {
+
+	if cache == nil {
+		return authenticate
+	}
+	ttl := cache.ttl()
+	return func(req *http.Request) error {
+		credential := cache.credential(req)
+		if credential == "" {
+			return authenticate(req)
+		}
+		hash := AuthCredentialHash(credential)
+		key := req.Method
+		if route := mux.CurrentRoute(req); route != nil {
+			key = route.GetName() + " " + key
+		}
+
+		now := cache.clock()
+		if decision, ok := r.authDecisions.lookup(hash, key, now); ok {
+			r.metrics.incr(AuthCacheHitsCounter, resource)
+			if decision.scopes != nil {
+				SetScopes(req, decision.scopes)
+			}
+			return decision.err
+		}
+		r.metrics.incr(AuthCacheMissesCounter, resource)
+
+		generation := r.authDecisions.generation()
+		err := authenticate(req)
+		if err == nil || cache.CacheFailures {
+			r.authDecisions.store(hash, key, authDecision{
+				expires: now.Add(ttl),
+				err:     err,
+				scopes:  RequestScopes(req),
+			}, generation, now)
+		}
+		return err
+	}
+}
+
+// authDecision is a cached outcome of Authenticate.
+type authDecision struct {
+	expires time.Time
+	err     error
+	scopes  Scopes
+}
+
+// authDecisions are the cached authentication decisions of an API, keyed by
+// credential hash and then by route and method. It's safe for concurrent use.
+type authDecisions struct {
+	mu          sync.Mutex
+	entries     map[string]map[string]authDecision
+	invalidated uint64
+	swept       time.Time
+	hits        uint64
+	misses      uint64
+}
+
+// newAuthDecisions returns an empty authDecisions.
+func newAuthDecisions() *authDecisions {
+	return &authDecisions{entries: map[string]map[string]authDecision{}}
+}
+
+// lookup returns the unexpired decision for the credential hash and key, if any.
+func (d *authDecisions) lookup(hash, key string, now time.Time) (authDecision, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	decision, ok := d.entries[hash][key]
+	if !ok || !now.Before(decision.expires) {
+		d.misses++
+		return authDecision{}, false
+	}
+	d.hits++
+	return decision, true
+}
+
+// generation returns the number of invalidations so far. Decisions made before an
+// invalidation aren't stored, since they may predate the revocation prompting it.
+func (d *authDecisions) generation() uint64 {
+	return atomic.LoadUint64(&d.invalidated)
+}
+
+// store caches the decision for the credential hash and key if no credential has
+// been invalidated since the generation. Expired decisions are discarded
+// periodically.
+func (d *authDecisions) store(hash, key string, decision authDecision, generation uint64,
+	now time.Time) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if atomic.LoadUint64(&d.invalidated) != generation {
+		return
+	}
+	if !now.Before(d.swept.Add(decision.expires.Sub(now))) {
+		for h, decisions := range d.entries {
+			for k, cached := range decisions {
+				if !now.Before(cached.expires) {
+					delete(decisions, k)
+				}
+			}
+			if len(decisions) == 0 {
+				delete(d.entries, h)
+			}
+		}
+		d.swept = now
+	}
+
+	decisions, ok := d.entries[hash]
+	if !ok {
+		decisions = map[string]authDecision{}
+		d.entries[hash] = decisions
+	}
+	decisions[key] = decision
+}
+
+// invalidate discards the decisions for the credential hash.
+func (d *authDecisions) invalidate(hash string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	atomic.AddUint64(&d.invalidated, 1)
+	delete(d.entries, hash)
+}
+
+// stats returns the number of cached credentials and the cache's hit rate.
+func (d *authDecisions) stats() map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rate := 0.0
+	if total := d.hits + d.misses; total > 0 {
+		rate = float64(d.hits) / float64(total)
+	}
+	return map[string]interface{}{
+		"credentials": len(d.entries),
+		"hits":        d.hits,
+		"misses":      d.misses,
+		"hit_rate":    rate,
+	}
+}
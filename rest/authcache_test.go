@@ -0,0 +1,209 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenHandler is a ResourceHandler for tokens whose Authenticate accepts bearer
+// tokens which haven't been revoked and counts how often it's invoked.
+type tokenHandler struct {
+	BaseResourceHandler
+	mu      *sync.Mutex
+	revoked map[string]bool
+	calls   *int
+}
+
+// newTokenHandler returns a tokenHandler with no revoked tokens.
+func newTokenHandler() tokenHandler {
+	return tokenHandler{mu: &sync.Mutex{}, revoked: map[string]bool{}, calls: new(int)}
+}
+
+func (t tokenHandler) ResourceName() string {
+	return "tokens"
+}
+
+func (t tokenHandler) Authenticate(r *http.Request) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	*t.calls++
+	token := r.Header.Get("Authorization")
+	if token == "" || t.revoked[token] {
+		return errors.New("Invalid token")
+	}
+	SetScopes(r, Scopes{"tokens:read"})
+	return nil
+}
+
+func (t tokenHandler) ReadResource(ctx RequestContext, id string, version string) (Resource,
+	error) {
+	return map[string]interface{}{"id": id}, nil
+}
+
+func (t tokenHandler) DeleteResource(ctx RequestContext, id string, version string) (Resource,
+	error) {
+	return map[string]interface{}{"id": id}, nil
+}
+
+// revoke revokes the token.
+func (t tokenHandler) revoke(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.revoked[token] = true
+}
+
+// invocations returns how often Authenticate has been invoked.
+func (t tokenHandler) invocations() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return *t.calls
+}
+
+// serveToken sends a request with the token to the API and returns the response code.
+func serveToken(api API, method, token string) int {
+	req, _ := http.NewRequest(method, "http://example.com/api/v1/tokens/1", nil)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+// Ensures that successful decisions are cached per credential and operation, with
+// the Scopes Authenticate attached restored, and counted as hits and misses.
+func TestAuthCacheCachesDecisions(t *testing.T) {
+	assert := assert.New(t)
+	handler := newTokenHandler()
+	api := NewAPI(&Configuration{Scopes: &ScopePolicy{Write: "tokens:read"}})
+	api.RegisterResourceHandler(handler, AuthCache{})
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(http.StatusOK, serveToken(api, "GET", "Bearer a"))
+	}
+	assert.Equal(1, handler.invocations())
+	assert.Equal(uint64(2), api.Metrics().Counter(AuthCacheHitsCounter, "tokens"))
+	assert.Equal(uint64(1), api.Metrics().Counter(AuthCacheMissesCounter, "tokens"))
+
+	// Other credentials and operations are decided separately.
+	assert.Equal(http.StatusOK, serveToken(api, "GET", "Bearer b"))
+	assert.Equal(http.StatusOK, serveToken(api, "DELETE", "Bearer a"))
+	assert.Equal(http.StatusOK, serveToken(api, "DELETE", "Bearer a"))
+	assert.Equal(3, handler.invocations())
+
+	stats := api.Stats()["auth_cache"].(map[string]interface{})
+	assert.Equal(2, stats["credentials"])
+	assert.Equal(uint64(3), stats["hits"])
+	assert.Equal(uint64(3), stats["misses"])
+	assert.Equal(0.5, stats["hit_rate"])
+
+	// Requests without a credential aren't cached.
+	assert.Equal(http.StatusUnauthorized, serveToken(api, "GET", ""))
+	assert.Equal(http.StatusUnauthorized, serveToken(api, "GET", ""))
+	assert.Equal(5, handler.invocations())
+}
+
+// Ensures that only hashes of credentials are cached.
+func TestAuthCacheStoresHashes(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{AuthCache: &AuthCache{}})
+	api.RegisterResourceHandler(newTokenHandler())
+
+	assert.Equal(http.StatusOK, serveToken(api, "GET", "Bearer secret"))
+	entries := api.(*muxAPI).authDecisions.entries
+	if assert.Len(entries, 1) {
+		for hash := range entries {
+			assert.Equal(AuthCredentialHash("Bearer secret"), hash)
+			assert.NotContains(hash, "secret")
+		}
+	}
+}
+
+// Ensures that a revoked token is rejected once its decision's TTL elapses, and
+// immediately once it's invalidated.
+func TestAuthCacheRevocation(t *testing.T) {
+	assert := assert.New(t)
+	clock := &fakeClock{now: time.Now()}
+	handler := newTokenHandler()
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, AuthCache{TTL: time.Second, now: clock.time})
+
+	assert.Equal(http.StatusOK, serveToken(api, "GET", "Bearer a"))
+	assert.Equal(http.StatusOK, serveToken(api, "GET", "Bearer b"))
+	handler.revoke("Bearer a")
+	handler.revoke("Bearer b")
+
+	// Within the TTL, revocations aren't noticed until the token is invalidated.
+	clock.advance(999 * time.Millisecond)
+	assert.Equal(http.StatusOK, serveToken(api, "GET", "Bearer a"))
+	api.InvalidateAuth(AuthCredentialHash("Bearer a"))
+	assert.Equal(http.StatusUnauthorized, serveToken(api, "GET", "Bearer a"))
+	assert.Equal(http.StatusOK, serveToken(api, "GET", "Bearer b"))
+
+	clock.advance(time.Millisecond)
+	assert.Equal(http.StatusUnauthorized, serveToken(api, "GET", "Bearer b"))
+}
+
+// Ensures that rejections are only cached when CacheFailures is set.
+func TestAuthCacheFailures(t *testing.T) {
+	assert := assert.New(t)
+	handler := newTokenHandler()
+	handler.revoke("Bearer bad")
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, AuthCache{})
+
+	assert.Equal(http.StatusUnauthorized, serveToken(api, "GET", "Bearer bad"))
+	assert.Equal(http.StatusUnauthorized, serveToken(api, "GET", "Bearer bad"))
+	assert.Equal(2, handler.invocations())
+
+	handler = newTokenHandler()
+	handler.revoke("Bearer bad")
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, AuthCache{CacheFailures: true})
+
+	assert.Equal(http.StatusUnauthorized, serveToken(api, "GET", "Bearer bad"))
+	assert.Equal(http.StatusUnauthorized, serveToken(api, "GET", "Bearer bad"))
+	assert.Equal(1, handler.invocations())
+}
+
+// Ensures that decisions made while a credential is invalidated aren't cached, since
+// they may predate the revocation.
+func TestAuthCacheInvalidationDuringAuthentication(t *testing.T) {
+	assert := assert.New(t)
+	decisions := newAuthDecisions()
+	now := time.Now()
+
+	generation := decisions.generation()
+	decisions.invalidate(AuthCredentialHash("Bearer a"))
+	decisions.store(AuthCredentialHash("Bearer a"), "GET",
+		authDecision{expires: now.Add(time.Second)}, generation, now)
+	_, ok := decisions.lookup(AuthCredentialHash("Bearer a"), "GET", now)
+	assert.False(ok)
+
+	decisions.store(AuthCredentialHash("Bearer a"), "GET",
+		authDecision{expires: now.Add(time.Second)}, decisions.generation(), now)
+	_, ok = decisions.lookup(AuthCredentialHash("Bearer a"), "GET", now)
+	assert.True(ok)
+}
@@ -224,3 +224,28 @@ func (r resourceHandlerProxy) DeleteURI() string {
 	}
 	return uri
 }
+
+// handlerWrapper is implemented by ResourceHandlers which wrap another
+// ResourceHandler, such as resourceHandlerProxy.
+type handlerWrapper interface {
+	// unwrap returns the wrapped ResourceHandler.
+	unwrap() ResourceHandler
+}
+
+// unwrap returns the proxied ResourceHandler.
+func (r resourceHandlerProxy) unwrap() ResourceHandler {
+	return r.ResourceHandler
+}
+
+// unwrapHandler returns the innermost ResourceHandler wrapped by the framework or the
+// provided ResourceHandler if it's not wrapped. This is used to check if a handler
+// implements optional interfaces.
+func unwrapHandler(h ResourceHandler) ResourceHandler {
+	for {
+		wrapper, ok := h.(handlerWrapper)
+		if !ok {
+			return h
+		}
+		h = wrapper.unwrap()
+	}
+}
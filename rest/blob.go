@@ -0,0 +1,144 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BlobStore holds files produced by framework features, such as DataExport archives,
+// until they're downloaded. Blobs are written through a BlobWriter and only become
+// readable once committed, so a failed write never leaves a partial blob behind.
+// Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Create returns a BlobWriter for the blob with the key, replacing any existing
+	// one once it's committed.
+	Create(key string) (BlobWriter, error)
+
+	// Open returns the committed blob with the key and true, or false if it doesn't
+	// exist.
+	Open(key string) (Blob, bool, error)
+
+	// Delete deletes the blob with the key, if it exists.
+	Delete(key string) error
+}
+
+// BlobWriter writes a blob which is readable once Commit returns. Abort discards
+// everything written.
+type BlobWriter interface {
+	io.Writer
+	Commit() error
+	Abort() error
+}
+
+// Blob is a committed blob. It's seekable so it can be served with range support.
+type Blob interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// BlobURLSigner is implemented by BlobStores which can serve blobs directly, e.g.
+// from object storage, at URLs which expire.
+type BlobURLSigner interface {
+	// SignedURL returns a URL at which the blob with the key can be downloaded until
+	// the TTL elapses.
+	SignedURL(key string, ttl time.Duration) (string, error)
+}
+
+// FileBlobStore is a BlobStore which keeps blobs as files in a local directory, so
+// they're only available from the instance which wrote them.
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore returns a FileBlobStore keeping blobs in the directory, which is
+// created if it doesn't exist. The system's temporary directory is used if it's
+// empty.
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if dir == "" {
+		var err error
+		if dir, err = ioutil.TempDir("", "rest-blobs"); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+// path returns the path of the file holding the blob with the key. Path separators in
+// the key are replaced so every blob is kept directly in the directory.
+func (f *FileBlobStore) path(key string) string {
+	return filepath.Join(f.dir, strings.NewReplacer("/", "_", "\\", "_").Replace(key))
+}
+
+// Create returns a BlobWriter which writes to a temporary file in the directory and
+// renames it to the blob's file when committed.
+func (f *FileBlobStore) Create(key string) (BlobWriter, error) {
+	file, err := ioutil.TempFile(f.dir, ".partial-")
+	if err != nil {
+		return nil, err
+	}
+	return &fileBlobWriter{file, f.path(key)}, nil
+}
+
+// Open returns the file holding the blob with the key.
+func (f *FileBlobStore) Open(key string) (Blob, bool, error) {
+	file, err := os.Open(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return file, true, nil
+}
+
+// Delete removes the file holding the blob with the key.
+func (f *FileBlobStore) Delete(key string) error {
+	if err := os.Remove(f.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// fileBlobWriter is a BlobWriter for a FileBlobStore.
+type fileBlobWriter struct {
+	*os.File
+	path string
+}
+
+// Commit closes the temporary file and renames it to the blob's file.
+func (w *fileBlobWriter) Commit() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	return os.Rename(w.File.Name(), w.path)
+}
+
+// Abort closes and removes the temporary file.
+func (w *fileBlobWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
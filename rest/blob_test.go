@@ -0,0 +1,62 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that a FileBlobStore's blobs are only readable once committed and that
+// aborted blobs are discarded.
+func TestFileBlobStore(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "blob-test")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	blobs, err := NewFileBlobStore(dir)
+	assert.Nil(err)
+
+	writer, err := blobs.Create("a/b")
+	assert.Nil(err)
+	writer.Write([]byte("hello"))
+	_, ok, err := blobs.Open("a/b")
+	assert.Nil(err)
+	assert.False(ok)
+	assert.Nil(writer.Commit())
+
+	blob, ok, err := blobs.Open("a/b")
+	assert.Nil(err)
+	if assert.True(ok) {
+		data, _ := ioutil.ReadAll(blob)
+		blob.Close()
+		assert.Equal("hello", string(data))
+	}
+
+	writer, _ = blobs.Create("c")
+	writer.Write([]byte("partial"))
+	assert.Nil(writer.Abort())
+	_, ok, _ = blobs.Open("c")
+	assert.False(ok)
+	assert.Nil(blobs.Delete("a/b"))
+	_, ok, _ = blobs.Open("a/b")
+	assert.False(ok)
+	assert.Nil(blobs.Delete("missing"))
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// CacheHitsCounter counts requests served from the ResponseCache.
+	CacheHitsCounter = "cache_hits"
+
+	// CacheMissesCounter counts cacheable requests which weren't in the ResponseCache.
+	CacheMissesCounter = "cache_misses"
+
+	// CacheBypassedCounter counts requests which skipped the ResponseCache because no
+	// key could be computed for them or they carry a consistency token.
+	CacheBypassedCounter = "cache_bypassed"
+
+	// defaultCacheTTL is how long responses are cached if ResponseCache doesn't
+	// specify a TTL.
+	defaultCacheTTL = time.Minute
+)
+
+// CachePrincipal identifies who a cached response was produced for. Responses are
+// only shared by requests with the same tenant and roles.
+type CachePrincipal struct {
+	// Tenant is the tenant the request is for.
+	Tenant string
+
+	// Roles are the roles of the principal, which determine what it's allowed to see.
+	Roles []string
+}
+
+// ResponseCache is a ResourceOption which caches successful GET responses from a
+// resource in the Configuration's Store. Responses are cached after the request is
+// authenticated, keyed by the method, path, query, response format, version, tenant,
+// and a digest of the principal's roles, so principals who may see different
+// responses for the same URL never share them. Requests whose CachePrincipal can't be
+// determined bypass the cache unless Shared is set, and requests carrying a consistency
+// token always do, so they read their writes. The headers the resource sets,
+// such as ETag and X-Total-Count, are cached with the body, except Set-Cookie and
+// Content-Length, and cache hits answer If-None-Match and If-Match headers using the
+// cached validators.
+type ResponseCache struct {
+	// TTL is how long responses are cached. Defaults to one minute.
+	TTL time.Duration
+
+	// Principal returns the CachePrincipal for the request and true, or false if it
+	// can't be determined. Defaults to none.
+	Principal func(*http.Request) (CachePrincipal, bool)
+
+	// Shared makes requests whose CachePrincipal can't be determined share responses
+	// with every other such request instead of bypassing the cache. It's only safe if
+	// responses don't depend on the principal. Defaults to false.
+	Shared bool
+
+	// Key returns the cache key for the request and true, or false if the request
+	// should bypass the cache. It replaces the default key and must distinguish
+	// requests which may receive different responses.
+	Key func(RequestContext) (string, bool)
+}
+
+// apply sets the ResponseCache on the resource.
+func (c ResponseCache) apply(opts *resourceOptions) {
+	opts.cache = &c
+}
+
+// ttl returns how long responses are cached.
+func (c *ResponseCache) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return defaultCacheTTL
+	}
+	return c.TTL
+}
+
+// key returns the cache key for the request and true, or false if it should bypass
+// the cache.
+func (c *ResponseCache) key(ctx RequestContext) (string, bool) {
+	if c.Key != nil {
+		return c.Key(ctx)
+	}
+	req, ok := ctx.Request()
+	if !ok {
+		return "", false
+	}
+
+	parts := []string{req.Method, req.URL.Path, req.URL.Query().Encode(),
+		ctx.ResponseFormat(), ctx.Version()}
+	principal, ok := CachePrincipal{}, false
+	if c.Principal != nil {
+		principal, ok = c.Principal(req)
+	}
+	if !ok && !c.Shared {
+		return "", false
+	}
+	if ok {
+		parts = append(parts, "tenant="+principal.Tenant, "roles="+rolesDigest(principal.Roles))
+	} else {
+		parts = append(parts, "public")
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:]), true
+}
+
+// rolesDigest returns a digest of the roles which doesn't depend on their order.
+func rolesDigest(roles []string) string {
+	sorted := append([]string{}, roles...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// cachedResponse is a response stored in the ResponseCache.
+type cachedResponse struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// uncachedHeaders are the response headers which aren't cached. Headers set before
+// the cache runs, such as rate limit headers, aren't cached either, so hits carry
+// their current values.
+var uncachedHeaders = []string{"Set-Cookie", "Content-Length"}
+
+// cacheableHeader returns the headers in the header which weren't in the header
+// before the request was handled, or have changed since, excluding uncachedHeaders.
+func cacheableHeader(header, before http.Header) http.Header {
+	cached := http.Header{}
+	for key, values := range header {
+		if containsFold(uncachedHeaders, key) ||
+			strings.Join(before[key], "\n") == strings.Join(values, "\n") {
+			continue
+		}
+		cached[key] = append([]string{}, values...)
+	}
+	return cached
+}
+
+// serveCached writes the cached response, or a 304 or 412 if the request's
+// preconditions evaluated against the cached ETag and Last-Modified headers call for
+// one.
+func (r *muxAPI) serveCached(w http.ResponseWriter, req *http.Request, cached cachedResponse) {
+	ref := ResourceRef{ID: NewContext(nil, req).ResourceID(), ETag: cached.Header.Get("ETag")}
+	if modified, err := http.ParseTime(cached.Header.Get("Last-Modified")); err == nil {
+		ref.LastModified = modified
+	}
+	if err := checkIfMatch(req, ref); err != nil {
+		r.handler.sendResponse(w, NewContext(nil, req).setError(err))
+		return
+	}
+	if notModified(req, ref) {
+		for _, key := range []string{"ETag", "Last-Modified"} {
+			if value := cached.Header.Get(key); value != "" {
+				w.Header().Set(key, value)
+			}
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	for key, values := range cached.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(cached.Body)
+}
+
+// newCacheMiddleware returns a RequestMiddleware which serves GET requests for the
+// resource from the ResponseCache and caches successful responses.
+func newCacheMiddleware(api *muxAPI, resource string, cache *ResponseCache) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != httpGet {
+				wrapped(w, r)
+				return
+			}
+			key, ok := cache.key(NewContext(nil, r))
+			if !ok || consistencyToken(r) != "" {
+				api.metrics.incr(CacheBypassedCounter, resource)
+				wrapped(w, r)
+				return
+			}
+			key = resource + ":" + key
+
+			store := api.store()
+			if data, ok, err := store.Get(ResponseCacheNamespace, key); err != nil {
+				api.config.Logf("Response cache failed for %s: %s", resource, err)
+			} else if ok {
+				var cached cachedResponse
+				if err := json.Unmarshal(data, &cached); err == nil {
+					api.metrics.incr(CacheHitsCounter, resource)
+					api.serveCached(w, r, cached)
+					return
+				}
+			}
+
+			api.metrics.incr(CacheMissesCounter, resource)
+			before := http.Header{}
+			for key, values := range w.Header() {
+				before[key] = append([]string{}, values...)
+			}
+			recorder := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+			wrapped(recorder, r)
+			if recorder.status != http.StatusOK {
+				return
+			}
+			data, err := json.Marshal(cachedResponse{
+				Header: cacheableHeader(w.Header(), before),
+				Body:   recorder.body.Bytes(),
+			})
+			if err == nil {
+				err = store.Set(ResponseCacheNamespace, key, data, cache.ttl())
+			}
+			if err != nil {
+				api.config.Logf("Response cache failed for %s: %s", resource, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roleHandler is a ResourceHandler whose responses depend on the requester's role.
+type roleHandler struct {
+	BaseResourceHandler
+	calls int
+}
+
+func (r *roleHandler) ResourceName() string {
+	return "reports"
+}
+
+func (r *roleHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	r.calls++
+	if ctx.Header().Get("X-Role") == "admin" {
+		return map[string]interface{}{"id": id, "salary": 100}, nil
+	}
+	return map[string]interface{}{"id": id}, nil
+}
+
+// headerPrincipal returns the CachePrincipal from the X-Tenant and X-Role headers.
+func headerPrincipal(r *http.Request) (CachePrincipal, bool) {
+	role := r.Header.Get("X-Role")
+	if role == "" {
+		return CachePrincipal{}, false
+	}
+	return CachePrincipal{Tenant: r.Header.Get("X-Tenant"), Roles: strings.Split(role, ",")}, true
+}
+
+// serveCached sends a read request with the tenant and role to the API.
+func serveCached(api API, tenant, role string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/reports/1", nil)
+	if tenant != "" {
+		req.Header.Set("X-Tenant", tenant)
+	}
+	if role != "" {
+		req.Header.Set("X-Role", role)
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that cached responses are only served to requests with the same tenant and
+// roles, so principals with different roles never receive each other's responses.
+func TestResponseCachePrincipals(t *testing.T) {
+	assert := assert.New(t)
+	handler := &roleHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, ResponseCache{Principal: headerPrincipal})
+
+	admin := serveCached(api, "acme", "admin")
+	viewer := serveCached(api, "acme", "viewer")
+	adminAgain := serveCached(api, "acme", "admin")
+	viewerAgain := serveCached(api, "acme", "viewer")
+	otherTenant := serveCached(api, "globex", "admin")
+
+	assert.Contains(admin.Body.String(), "salary")
+	assert.NotContains(viewer.Body.String(), "salary")
+	assert.Equal(admin.Body.String(), adminAgain.Body.String())
+	assert.Equal(viewer.Body.String(), viewerAgain.Body.String())
+	assert.Equal(admin.Header().Get("Content-Type"), adminAgain.Header().Get("Content-Type"))
+	assert.Contains(otherTenant.Body.String(), "salary")
+	assert.Equal(3, handler.calls)
+	assert.Equal(uint64(2), api.Metrics().Counter(CacheHitsCounter, "reports"))
+	assert.Equal(uint64(3), api.Metrics().Counter(CacheMissesCounter, "reports"))
+}
+
+// Ensures that the order of a principal's roles doesn't affect its cache key.
+func TestResponseCacheRoleOrder(t *testing.T) {
+	assert := assert.New(t)
+	handler := &roleHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, ResponseCache{Principal: headerPrincipal})
+
+	serveCached(api, "acme", "viewer,editor")
+	serveCached(api, "acme", "editor,viewer")
+
+	assert.Equal(1, handler.calls)
+}
+
+// Ensures that the cache is bypassed when the principal can't be determined unless
+// it's Shared.
+func TestResponseCacheBypassWithoutPrincipal(t *testing.T) {
+	assert := assert.New(t)
+	handler := &roleHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, ResponseCache{Principal: headerPrincipal})
+
+	serveCached(api, "", "")
+	serveCached(api, "", "")
+
+	assert.Equal(2, handler.calls)
+	assert.Equal(uint64(2), api.Metrics().Counter(CacheBypassedCounter, "reports"))
+	assert.Equal(uint64(0), api.Metrics().Counter(CacheMissesCounter, "reports"))
+
+	handler = &roleHandler{}
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, ResponseCache{Principal: headerPrincipal, Shared: true})
+
+	serveCached(api, "", "")
+	serveCached(api, "", "")
+
+	assert.Equal(1, handler.calls)
+	assert.Equal(uint64(1), api.Metrics().Counter(CacheHitsCounter, "reports"))
+}
+
+// Ensures that a ResponseCache without a Principal doesn't share responses between
+// principals.
+func TestResponseCacheDefaultNotShared(t *testing.T) {
+	assert := assert.New(t)
+	handler := &roleHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, ResponseCache{})
+
+	admin := serveCached(api, "acme", "admin")
+	viewer := serveCached(api, "acme", "viewer")
+
+	assert.Contains(admin.Body.String(), "salary")
+	assert.NotContains(viewer.Body.String(), "salary")
+	assert.Equal(2, handler.calls)
+	assert.Equal(uint64(0), api.Metrics().Counter(CacheHitsCounter, "reports"))
+}
+
+// Ensures that a custom Key replaces the default key and can bypass the cache.
+func TestResponseCacheCustomKey(t *testing.T) {
+	assert := assert.New(t)
+	handler := &roleHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, ResponseCache{
+		Key: func(ctx RequestContext) (string, bool) {
+			role := ctx.Header().Get("X-Role")
+			return role, role != "admin"
+		},
+	})
+
+	serveCached(api, "acme", "viewer")
+	serveCached(api, "globex", "viewer")
+	serveCached(api, "acme", "admin")
+
+	assert.Equal(2, handler.calls)
+	assert.Equal(uint64(1), api.Metrics().Counter(CacheHitsCounter, "reports"))
+	assert.Equal(uint64(1), api.Metrics().Counter(CacheBypassedCounter, "reports"))
+}
+
+// Ensures that requests carrying a consistency token bypass the cache, so they aren't
+// served a response cached before their write.
+func TestResponseCacheBypassWithConsistencyToken(t *testing.T) {
+	assert := assert.New(t)
+	handler := &roleHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, ResponseCache{Principal: headerPrincipal})
+
+	serveCached(api, "acme", "viewer")
+	header, _ := http.NewRequest("GET", "http://foo.com/api/v1/reports/1", nil)
+	header.Header.Set(ConsistencyTokenHeader, "1")
+	query, _ := http.NewRequest("GET", "http://foo.com/api/v1/reports/1?consistency=2", nil)
+	for _, req := range []*http.Request{header, query} {
+		req.Header.Set("X-Tenant", "acme")
+		req.Header.Set("X-Role", "viewer")
+		api.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	assert.Equal(3, handler.calls)
+	assert.Equal(uint64(0), api.Metrics().Counter(CacheHitsCounter, "reports"))
+	assert.Equal(uint64(2), api.Metrics().Counter(CacheBypassedCounter, "reports"))
+}
+
+// Ensures that unsuccessful responses aren't cached.
+func TestResponseCacheErrors(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(&roleHandler{}, ResponseCache{})
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/reports", nil)
+	for i := 0; i < 2; i++ {
+		resp := httptest.NewRecorder()
+		api.ServeHTTP(resp, req)
+		assert.NotEqual(http.StatusOK, resp.Code)
+	}
+
+	assert.Equal(uint64(0), api.Metrics().Counter(CacheHitsCounter, "reports"))
+}
+
+// Ensures that requests rejected by the resource's middleware aren't served cached
+// responses.
+func TestResponseCacheInsideMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	handler := &roleHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, ResponseCache{Principal: headerPrincipal},
+		RequestMiddleware(func(wrapped http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("X-Banned") != "" {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				wrapped(w, r)
+			}
+		}))
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/reports/1", nil)
+	req.Header.Set("X-Role", "viewer")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+	req.Header.Set("X-Banned", "true")
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusForbidden, resp.Code)
+	assert.Equal(1, handler.calls)
+}
+
+// Ensures that cache hits carry the headers the resource set and answer conditional
+// requests using the cached validators.
+func TestResponseCacheHeadersAndPreconditions(t *testing.T) {
+	assert := assert.New(t)
+	handler := &blobHandler{resolved: new(int), materialized: new(int), deleted: new(int)}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(*handler, ResponseCache{Shared: true})
+	url := "http://foo.com/api/v1/blobs/1"
+
+	miss := serveConditional(api, "GET", url, "", "")
+	hit := serveConditional(api, "GET", url, "", "")
+	assert.Equal(http.StatusOK, hit.Code)
+	assert.Equal(miss.Body.String(), hit.Body.String())
+	assert.Equal(`"rev-1"`, hit.Header().Get("ETag"))
+	assert.Equal(miss.Header().Get("Last-Modified"), hit.Header().Get("Last-Modified"))
+	assert.Equal(miss.Header().Get("Content-Type"), hit.Header().Get("Content-Type"))
+
+	resp := serveConditional(api, "GET", url, "If-None-Match", `"rev-1"`)
+	assert.Equal(http.StatusNotModified, resp.Code)
+	assert.Equal("", resp.Body.String())
+	assert.Equal(`"rev-1"`, resp.Header().Get("ETag"))
+
+	resp = serveConditional(api, "GET", url, "If-Match", `"rev-2"`)
+	assert.Equal(http.StatusPreconditionFailed, resp.Code)
+	assert.Equal(1, *handler.resolved)
+	assert.Equal(uint64(3), api.Metrics().Counter(CacheHitsCounter, "blobs"))
+}
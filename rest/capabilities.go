@@ -0,0 +1,338 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// CapabilitiesSchemaVersion is the version of the CapabilitiesDocument format. It's
+	// incremented whenever the format changes in a way which isn't backward compatible.
+	CapabilitiesSchemaVersion = 1
+
+	// capabilitiesPath is appended to a resource's read list URI to form the URI
+	// serving its CapabilitiesDocument.
+	capabilitiesPath = "/_capabilities"
+
+	// preflightMethodHeader is the header identifying an OPTIONS request as a CORS
+	// preflight request.
+	preflightMethodHeader = "Access-Control-Request-Method"
+)
+
+// Capabilities is a ResourceOption which serves a CapabilitiesDocument describing the
+// resource for generic clients at GET /api/:version/resource/_capabilities and in the
+// body of OPTIONS requests to the resource's URIs which aren't CORS preflight requests.
+// The document is generated from the resource's registration, Rules, and the API's
+// serializers, along with what's specified here. Requests are authenticated,
+// gated, and limited like any other read of the resource. Versioned resources must
+// specify Capabilities for every version or none.
+type Capabilities struct {
+	// Query is the ListQuery the resource's list endpoint uses, if any, describing its
+	// filters, sorts, and page limit.
+	Query *ListQuery
+
+	// Expansions are the related resources which can be expanded in responses.
+	Expansions []string
+
+	// RequiredHeaders are the request headers clients must send, e.g. X-Tenant-Id.
+	RequiredHeaders []string
+}
+
+// apply sets the Capabilities on the resource.
+func (c Capabilities) apply(opts *resourceOptions) {
+	opts.capabilities = &c
+}
+
+// CapabilitiesDocument describes what a resource supports for a version. Its fields are
+// always present, and lists are sorted, so it can be compared between builds.
+type CapabilitiesDocument struct {
+	SchemaVersion int    `json:"schema_version"`
+	Resource      string `json:"resource"`
+	Version       string `json:"version"`
+
+	// Operations are the resource's endpoints.
+	Operations []CapabilityOperation `json:"operations"`
+
+	// Fields are the fields the resource's Rules declare for the version.
+	Fields []CapabilityField `json:"fields"`
+
+	// Filters are the fields the list can be filtered by.
+	Filters []CapabilityFilter `json:"filters"`
+
+	// Sorts are the fields the list can be sorted by.
+	Sorts []string `json:"sorts"`
+
+	// DefaultSort is the order of the list when none is requested, in the sort query
+	// string format, e.g. -created.
+	DefaultSort []string `json:"default_sort"`
+
+	// MaxLimit is the largest page size, or 0 if it isn't limited.
+	MaxLimit int `json:"max_limit"`
+
+	// PaginationConsistency is the consistency of the list's pages, PaginationSnapshot
+	// or PaginationLive.
+	PaginationConsistency string `json:"pagination_consistency"`
+
+	// SnapshotTTLSeconds is how long a snapshot may be paged through, or 0 if it
+	// doesn't expire or the list isn't snapshot-consistent.
+	SnapshotTTLSeconds int64 `json:"snapshot_ttl_seconds"`
+
+	Expansions      []string `json:"expansions"`
+	RequiredHeaders []string `json:"required_headers"`
+
+	// ContentTypes are the content types responses can be serialized as.
+	ContentTypes []string `json:"content_types"`
+
+	// RateLimited indicates if requests are subject to a RateLimit or Quota.
+	RateLimited bool `json:"rate_limited"`
+}
+
+// CapabilityOperation describes an endpoint of a resource.
+type CapabilityOperation struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+
+	// Scope is the scope the resource's ScopePolicy requires for the operation, or
+	// empty if scopes aren't enforced.
+	Scope string `json:"scope"`
+}
+
+// CapabilityField describes a field of a resource.
+type CapabilityField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	Readable bool   `json:"readable"`
+	Writable bool   `json:"writable"`
+}
+
+// CapabilityFilter describes a field a list can be filtered by.
+type CapabilityFilter struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Operators []string `json:"operators"`
+}
+
+// capabilityRoutes returns the routes with the route serving the CapabilitiesDocument
+// added before the read route, which would otherwise match it, and the preflight
+// routes with OPTIONS requests for each URI which aren't preflights answered with the
+// document.
+func (r *muxAPI) capabilityRoutes(h ResourceHandler, opts *resourceOptions,
+	routes []resourceRoute, middleware []RequestMiddleware) ([]resourceRoute, []resourceRoute) {
+
+	var operations []CapabilityOperation
+	handler := applyMiddleware(func(w http.ResponseWriter, req *http.Request) {
+		r.handleCapabilities(w, req, h, opts, operations)
+	}, middleware)
+
+	withCapabilities := make([]resourceRoute, 0, len(routes)+1)
+	for _, route := range routes {
+		if route.name == "read" {
+			withCapabilities = append(withCapabilities, resourceRoute{
+				"capabilities", "capabilities", "GET",
+				strings.TrimSuffix(h.ReadListURI(), "/") + capabilitiesPath, "", handler,
+			})
+		}
+		withCapabilities = append(withCapabilities, route)
+	}
+
+	preflights := preflightRoutes(r.effectiveCORSPolicy(h.ResourceName(), opts), withCapabilities)
+	uris := []string{}
+	seen := map[string]bool{}
+	for _, route := range withCapabilities {
+		if route.override == "" && !seen[route.uri] {
+			seen[route.uri] = true
+			uris = append(uris, route.uri)
+		}
+	}
+	for i, uri := range uris {
+		if j := routeForURI(preflights, uri); j >= 0 {
+			preflight := preflights[j].handler
+			preflights[j].handler = func(w http.ResponseWriter, req *http.Request) {
+				if req.Header.Get(preflightMethodHeader) != "" {
+					preflight(w, req)
+					return
+				}
+				handler(w, req)
+			}
+			continue
+		}
+		preflights = append(preflights, resourceRoute{
+			fmt.Sprintf("options%d", i), "capabilities", "OPTIONS", uri, "", handler,
+		})
+	}
+
+	for _, route := range append(withCapabilities, preflights...) {
+		if route.override == "" {
+			operations = append(operations, CapabilityOperation{
+				Name: route.name, Method: route.method, Path: route.uri,
+			})
+		}
+	}
+	return withCapabilities, preflights
+}
+
+// routeForURI returns the index of the route with the URI, or -1 if there isn't one.
+func routeForURI(routes []resourceRoute, uri string) int {
+	for i, route := range routes {
+		if route.uri == uri {
+			return i
+		}
+	}
+	return -1
+}
+
+// handleCapabilities responds with the CapabilitiesDocument for the resource. OPTIONS
+// responses also include the Allow header listing the methods served at the URI.
+func (r *muxAPI) handleCapabilities(w http.ResponseWriter, req *http.Request,
+	h ResourceHandler, opts *resourceOptions, operations []CapabilityOperation) {
+
+	ctx := NewContext(nil, req)
+	format := ctx.ResponseFormat()
+	serializer, err := r.responseSerializer(format)
+	if err != nil {
+		// sendResponse reports the unimplemented format.
+		r.handler.sendResponse(w, ctx)
+		return
+	}
+
+	if req.Method == "OPTIONS" {
+		template, _ := mux.CurrentRoute(req).GetPathTemplate()
+		methods := []string{}
+		for _, operation := range operations {
+			if operation.Path == template && !containsFold(methods, operation.Method) {
+				methods = append(methods, operation.Method)
+			}
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+	}
+	document := r.capabilities(h, opts, ctx.Version(), operations)
+	sendResponse(w, response{Payload: Payload{"capabilities": document}, Status: http.StatusOK},
+		serializer, r.config.ResponseDigest)
+}
+
+// capabilities returns the CapabilitiesDocument for the resource's version.
+func (r *muxAPI) capabilities(h ResourceHandler, opts *resourceOptions, version string,
+	operations []CapabilityOperation) CapabilitiesDocument {
+
+	capabilities := opts.capabilities
+	document := CapabilitiesDocument{
+		SchemaVersion:   CapabilitiesSchemaVersion,
+		Resource:        h.ResourceName(),
+		Version:         version,
+		Operations:      append([]CapabilityOperation{}, operations...),
+		Fields:          []CapabilityField{},
+		Filters:         []CapabilityFilter{},
+		Sorts:           []string{},
+		DefaultSort:     []string{},
+		Expansions:      sortedCopy(capabilities.Expansions),
+		RequiredHeaders: sortedCopy(capabilities.RequiredHeaders),
+		ContentTypes:    r.contentTypes(),
+		RateLimited:     opts.rateLimit != nil || opts.quota != nil,
+
+		PaginationConsistency: PaginationLive,
+	}
+	if policy := r.effectiveScopePolicy(opts); policy != nil {
+		for i, operation := range document.Operations {
+			document.Operations[i].Scope = policy.required(h.ResourceName(), operation.Name,
+				operation.Method)
+		}
+	}
+	sort.Slice(document.Operations, func(i, j int) bool {
+		a, b := document.Operations[i], document.Operations[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+
+	if rules := h.Rules(); rules != nil {
+		for _, rule := range rules.ForVersion(version).Contents() {
+			document.Fields = append(document.Fields, CapabilityField{
+				Name:     rule.Name(),
+				Type:     typeToName[rule.Type],
+				Required: rule.Required,
+				Readable: !rule.InputOnly && rule.isResourceRule(),
+				Writable: !rule.OutputOnly,
+			})
+		}
+		sort.Slice(document.Fields, func(i, j int) bool {
+			return document.Fields[i].Name < document.Fields[j].Name
+		})
+	}
+
+	if query := capabilities.Query; query != nil {
+		for _, filter := range query.Filters {
+			operators := filter.Operators
+			if len(operators) == 0 {
+				operators = []string{FilterEqual}
+			}
+			document.Filters = append(document.Filters, CapabilityFilter{
+				Name:      filter.Name,
+				Type:      typeToName[filter.Type],
+				Operators: sortedCopy(operators),
+			})
+		}
+		sort.Slice(document.Filters, func(i, j int) bool {
+			return document.Filters[i].Name < document.Filters[j].Name
+		})
+		document.Sorts = sortedCopy(query.Sorts)
+		for _, field := range query.DefaultSort {
+			if field.Descending {
+				document.DefaultSort = append(document.DefaultSort, "-"+field.Field)
+			} else {
+				document.DefaultSort = append(document.DefaultSort, field.Field)
+			}
+		}
+		document.MaxLimit = query.maxLimit()
+		document.PaginationConsistency = query.consistency()
+		if query.Snapshot != nil {
+			document.SnapshotTTLSeconds = int64(query.SnapshotTTL / time.Second)
+		}
+	}
+	return document
+}
+
+// contentTypes returns the sorted content types of the API's serializers.
+func (r *muxAPI) contentTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := []string{}
+	for _, serializer := range r.serializerRegistry {
+		if contentType := serializer.ContentType(); !containsFold(types, contentType) {
+			types = append(types, contentType)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// sortedCopy returns a sorted copy of the values, which is empty rather than nil.
+func sortedCopy(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
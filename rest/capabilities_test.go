@@ -0,0 +1,166 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// capabilityHandler is a ResourceHandler with Rules which requires an API key.
+type capabilityHandler struct {
+	BaseResourceHandler
+}
+
+func (c capabilityHandler) ResourceName() string {
+	return "gadgets"
+}
+
+func (c capabilityHandler) Authenticate(r *http.Request) error {
+	if r.Header.Get("X-Api-Key") != "key" {
+		return UnauthorizedRequest("Missing API key")
+	}
+	return nil
+}
+
+func (c capabilityHandler) Rules() Rules {
+	return NewRules((*listRow)(nil),
+		&Rule{Field: "ID", FieldAlias: "id", Type: Int, OutputOnly: true},
+		&Rule{Field: "Group", FieldAlias: "group", Type: String, Required: true},
+		&Rule{Field: "Score", FieldAlias: "score", Type: Int, Versions: []string{"2"}},
+	)
+}
+
+// readCapabilities sends an authenticated request for the resource's capabilities and
+// returns the response and decoded document.
+func readCapabilities(api API, method, url string) (*httptest.ResponseRecorder,
+	CapabilitiesDocument) {
+
+	req, _ := http.NewRequest(method, url, nil)
+	req.Header.Set("X-Api-Key", "key")
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	var decoded struct{ Capabilities CapabilitiesDocument }
+	json.Unmarshal(resp.Body.Bytes(), &decoded)
+	return resp, decoded.Capabilities
+}
+
+// Ensures that the capabilities route describes the resource's operations, fields,
+// query, and content types, and is authenticated like other reads.
+func TestCapabilitiesDocument(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(capabilityHandler{}, Capabilities{
+		Query:           newListQuery(nil),
+		Expansions:      []string{"owner"},
+		RequiredHeaders: []string{"X-Api-Key"},
+	})
+
+	resp := serve(api, "GET", "http://example.com/api/v1/gadgets/_capabilities")
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+
+	resp, document := readCapabilities(api, "GET", "http://example.com/api/v1/gadgets/_capabilities")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(CapabilitiesSchemaVersion, document.SchemaVersion)
+	assert.Equal("gadgets", document.Resource)
+	assert.Equal("1", document.Version)
+	assert.Contains(document.Operations, CapabilityOperation{"capabilities", "GET",
+		"/api/v{version:[^/]+}/gadgets/_capabilities", ""})
+	assert.Contains(document.Operations, CapabilityOperation{"read", "GET",
+		"/api/v{version:[^/]+}/gadgets/{resource_id}", ""})
+	assert.Equal([]CapabilityField{
+		{Name: "group", Type: "string", Required: true, Readable: true, Writable: true},
+		{Name: "id", Type: "int", Readable: true},
+	}, document.Fields)
+	assert.Equal([]CapabilityFilter{
+		{Name: "group", Type: "interface{}", Operators: []string{FilterEqual}},
+		{Name: "score", Type: "int", Operators: []string{FilterEqual, FilterGreaterThan,
+			FilterLessThan}},
+	}, document.Filters)
+	assert.Equal([]string{"group", "id", "score"}, document.Sorts)
+	assert.Equal([]string{"-score"}, document.DefaultSort)
+	assert.Equal(10, document.MaxLimit)
+	assert.Equal([]string{"owner"}, document.Expansions)
+	assert.Equal([]string{"X-Api-Key"}, document.RequiredHeaders)
+	assert.Equal([]string{"application/json; charset=utf-8"}, document.ContentTypes)
+	assert.False(document.RateLimited)
+
+	_, document = readCapabilities(api, "GET", "http://example.com/api/v2/gadgets/_capabilities")
+	assert.Len(document.Fields, 3)
+}
+
+// Ensures that OPTIONS requests receive the document and the Allow header while CORS
+// preflight requests are still answered by the CORSPolicy.
+func TestCapabilitiesOptions(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(capabilityHandler{}, Capabilities{},
+		CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}})
+
+	resp, document := readCapabilities(api, "OPTIONS", "http://example.com/api/v1/gadgets/1")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("DELETE, GET, OPTIONS, PUT", resp.Header().Get("Allow"))
+	assert.Equal("gadgets", document.Resource)
+
+	resp, _ = readCapabilities(api, "OPTIONS", "http://example.com/api/v1/gadgets")
+	assert.Equal("GET, OPTIONS, POST, PUT", resp.Header().Get("Allow"))
+
+	resp = serveCORS(api, "OPTIONS", "/api/v1/gadgets/1",
+		"https://app.example.com", http.Header{"Access-Control-Request-Method": {"GET"}})
+	assert.Equal(http.StatusNoContent, resp.Code)
+	assert.Equal("", resp.Body.String())
+}
+
+// Ensures that the document reflects the options the resource is registered with and
+// isn't served without Capabilities.
+func TestCapabilitiesRegistrationOptions(t *testing.T) {
+	assert := assert.New(t)
+	plain := NewAPI(NewConfiguration())
+	plain.RegisterResourceHandler(capabilityHandler{}, Capabilities{})
+	_, before := readCapabilities(plain, "GET", "http://example.com/api/v1/gadgets/_capabilities")
+
+	limited := NewAPI(NewConfiguration())
+	limited.RegisterResourceHandler(capabilityHandler{},
+		Capabilities{Query: &ListQuery{MaxLimit: 25, Sorts: []string{"group"}}},
+		RateLimit{Tier: func(string) RateLimitTier {
+			return RateLimitTier{Limit: 100, Window: time.Minute}
+		}})
+	_, after := readCapabilities(limited, "GET", "http://example.com/api/v1/gadgets/_capabilities")
+
+	assert.False(before.RateLimited)
+	assert.True(after.RateLimited)
+	assert.Equal(0, before.MaxLimit)
+	assert.Equal(25, after.MaxLimit)
+	assert.Equal(PaginationLive, before.PaginationConsistency)
+	assert.Equal(PaginationLive, after.PaginationConsistency)
+	assert.Equal([]string{}, before.Sorts)
+	assert.Equal([]string{"group"}, after.Sorts)
+	assert.Equal(before.Operations, after.Operations)
+	assert.Equal(before.Fields, after.Fields)
+
+	absent := NewAPI(NewConfiguration())
+	absent.RegisterResourceHandler(capabilityHandler{})
+	resp, document := readCapabilities(absent, "GET",
+		"http://example.com/api/v1/gadgets/_capabilities")
+	assert.NotEqual(http.StatusOK, resp.Code)
+	assert.Equal("", document.Resource)
+}
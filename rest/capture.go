@@ -0,0 +1,308 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	// redactedValue replaces the values of sensitive fields in captured bodies.
+	redactedValue = "[REDACTED]"
+
+	// defaultCaptureBytes is the maximum captured body size if BodyCapture doesn't
+	// specify one.
+	defaultCaptureBytes = 64 * 1024
+)
+
+// capturedHeaders are request and response headers which are never captured since
+// they carry credentials.
+var capturedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization",
+	replayHeader}
+
+// CapturedExchange is a request and response captured by BodyCapture.
+type CapturedExchange struct {
+	Resource          string
+	Method            string
+	URL               string
+	RequestHeader     http.Header
+	RequestBody       []byte
+	RequestTruncated  bool
+	Status            int
+	ResponseHeader    http.Header
+	ResponseBody      []byte
+	ResponseTruncated bool
+	Duration          time.Duration
+
+	// Principal is the snapshot of the authenticated principal taken by the
+	// BodyCapture's Principal function, with sensitive fields redacted.
+	Principal interface{}
+
+	// ConfigFingerprint identifies the API Configuration which handled the request.
+	ConfigFingerprint string
+
+	// Triggered indicates if the exchange was captured because of the Trigger or
+	// debug header rather than sampling.
+	Triggered bool
+}
+
+// BodyCapture is a ResourceOption which captures the full request and response bodies
+// for a sample of a resource's requests and delivers them to a Sink once the response
+// has been written. This is intended for debugging specific integrations without
+// logging every body.
+//
+// A request is captured if it's triggered, either by the Trigger or by the debug
+// header carrying the DebugToken, or is sampled at the SampleRate. The decision is
+// made after the request is authenticated and before the handler is invoked, so
+// requests which aren't captured aren't buffered. Credential headers are never
+// captured. Values of fields whose Rule is Sensitive or which are listed in Redact
+// are replaced at any depth before bodies are truncated to MaxBytes. Bodies which
+// aren't JSON are dropped since they can't be redacted.
+type BodyCapture struct {
+	// Sink receives each CapturedExchange. It's invoked synchronously after the
+	// response is written, so it should hand off slow work.
+	Sink func(CapturedExchange)
+
+	// SampleRate is the fraction of requests captured, between 0 and 1. Defaults to 0,
+	// meaning only triggered requests are captured. The FlagCaptureSampleRate runtime
+	// flag overrides it.
+	SampleRate float64
+
+	// Trigger reports whether a request should be captured, e.g. because the
+	// authenticated principal is allowlisted.
+	Trigger func(*http.Request) bool
+
+	// DebugHeader is the name of a request header which triggers capture when its
+	// value is the DebugToken. The header itself is not captured.
+	DebugHeader string
+
+	// DebugToken is the secret value of the DebugHeader which triggers capture. The
+	// DebugHeader is ignored if it's empty.
+	DebugToken string
+
+	// MaxBytes is the maximum number of bytes captured from each body. Defaults to
+	// 64KB.
+	MaxBytes int
+
+	// Redact lists additional field names whose values are redacted.
+	Redact []string
+
+	// Principal returns a snapshot of the request's authenticated principal to
+	// include in the CapturedExchange. It must be encodable as JSON so sensitive
+	// fields can be redacted.
+	Principal func(*http.Request) interface{}
+}
+
+// apply sets the BodyCapture on the resource.
+func (b BodyCapture) apply(opts *resourceOptions) {
+	opts.capture = &b
+}
+
+// triggered returns true if the request triggers capture.
+func (b *BodyCapture) triggered(r *http.Request) bool {
+	if b.DebugHeader != "" && b.DebugToken != "" {
+		token := r.Header.Get(b.DebugHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(b.DebugToken)) == 1 {
+			return true
+		}
+	}
+	return b.Trigger != nil && b.Trigger(r)
+}
+
+// maxBytes returns the maximum number of bytes captured from each body.
+func (b *BodyCapture) maxBytes() int {
+	if b.MaxBytes <= 0 {
+		return defaultCaptureBytes
+	}
+	return b.MaxBytes
+}
+
+// newCaptureMiddleware returns a RequestMiddleware which captures requests to the
+// ResourceHandler as configured by the BodyCapture.
+func newCaptureMiddleware(api *muxAPI, h ResourceHandler, capture *BodyCapture) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			triggered := capture.triggered(r)
+			rate := api.captureSampleRate(capture.SampleRate)
+			if capture.Sink == nil || !triggered && (rate <= 0 || rand.Float64() >= rate) {
+				wrapped(w, r)
+				return
+			}
+
+			start := time.Now()
+			requestBody := &bytes.Buffer{}
+			if r.Body != nil {
+				r.Body = readCloser{io.TeeReader(r.Body, requestBody), r.Body}
+			}
+			recorder := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+
+			wrapped(recorder, r)
+
+			ctx := NewContext(nil, r)
+			redact := sensitiveFields(h.Rules().ForVersion(ctx.Version()), capture.Redact)
+			exchange := CapturedExchange{
+				Resource:          h.ResourceName(),
+				Method:            r.Method,
+				URL:               r.URL.String(),
+				RequestHeader:     captureHeader(r.Header, capture.DebugHeader),
+				Status:            recorder.status,
+				ResponseHeader:    captureHeader(w.Header(), ""),
+				Duration:          time.Since(start),
+				ConfigFingerprint: configFingerprint(api.config),
+				Triggered:         triggered,
+			}
+			if capture.Principal != nil {
+				exchange.Principal = capturePrincipal(capture.Principal(r), redact)
+			}
+			exchange.RequestBody, exchange.RequestTruncated = captureBody(
+				requestBody.Bytes(), redact, capture.maxBytes())
+			exchange.ResponseBody, exchange.ResponseTruncated = captureBody(
+				recorder.body.Bytes(), redact, capture.maxBytes())
+			capture.Sink(exchange)
+		}
+	}
+}
+
+// readCloser is an io.ReadCloser which reads from a Reader and closes a Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// captureWriter is an http.ResponseWriter which records the status and body written.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status and writes it.
+func (c *captureWriter) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the bytes and writes them.
+func (c *captureWriter) Write(b []byte) (int, error) {
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// captureHeader returns a copy of the header without credentials or the excluded
+// header.
+func captureHeader(header http.Header, exclude string) http.Header {
+	captured := http.Header{}
+	for key, values := range header {
+		captured[key] = append([]string{}, values...)
+	}
+	for _, key := range capturedHeaders {
+		captured.Del(key)
+	}
+	if exclude != "" {
+		captured.Del(exclude)
+	}
+	return captured
+}
+
+// captureBody returns the body with the sensitive fields redacted, truncated to max
+// bytes, and whether it was truncated. Returns nil if there are sensitive fields and
+// the body isn't JSON.
+func captureBody(body []byte, redact map[string]bool, max int) ([]byte, bool) {
+	if len(body) == 0 {
+		return nil, false
+	}
+
+	if len(redact) > 0 {
+		var decoded interface{}
+		if err := unmarshalJSON(body, &decoded, true); err != nil {
+			return nil, false
+		}
+		redacted, err := json.Marshal(redactValue(decoded, redact))
+		if err != nil {
+			return nil, false
+		}
+		body = redacted
+	}
+
+	if len(body) > max {
+		return append([]byte{}, body[:max]...), true
+	}
+	return append([]byte{}, body...), false
+}
+
+// capturePrincipal returns the principal decoded from JSON with the sensitive fields
+// redacted, or nil if it can't be encoded.
+func capturePrincipal(principal interface{}, redact map[string]bool) interface{} {
+	encoded, err := json.Marshal(principal)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := unmarshalJSON(encoded, &decoded, true); err != nil {
+		return nil
+	}
+	return redactValue(decoded, redact)
+}
+
+// redactValue returns the decoded JSON value with the values of sensitive fields
+// replaced at any depth.
+func redactValue(value interface{}, redact map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			if redact[key] {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactValue(val, redact)
+			}
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = redactValue(val, redact)
+		}
+	}
+	return value
+}
+
+// sensitiveFields returns the names of the fields whose Rules, including nested Rules,
+// are Sensitive along with the additional fields.
+func sensitiveFields(rules Rules, additional []string) map[string]bool {
+	fields := map[string]bool{}
+	for _, field := range additional {
+		fields[field] = true
+	}
+	if rules == nil {
+		return fields
+	}
+	for _, rule := range rules.Contents() {
+		if rule.Sensitive {
+			fields[rule.Name()] = true
+		}
+		if rule.Rules != nil {
+			for field := range sensitiveFields(rule.Rules, nil) {
+				fields[field] = true
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// account is the resource type used by the body capture tests.
+type account struct {
+	Name     string                 `json:"name"`
+	Password string                 `json:"password"`
+	Profile  map[string]interface{} `json:"profile"`
+}
+
+// accountHandler is a ResourceHandler which echoes created accounts.
+type accountHandler struct {
+	BaseResourceHandler
+	authErr error
+}
+
+func (a accountHandler) ResourceName() string {
+	return "accounts"
+}
+
+func (a accountHandler) Authenticate(r *http.Request) error {
+	return a.authErr
+}
+
+func (a accountHandler) Rules() Rules {
+	return NewRules((*account)(nil),
+		&Rule{Field: "Name", FieldAlias: "name"},
+		&Rule{Field: "Password", FieldAlias: "password", Sensitive: true},
+		&Rule{Field: "Profile", FieldAlias: "profile"},
+	)
+}
+
+func (a accountHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	name, _ := data.GetString("name")
+	password, _ := data.GetString("password")
+	profile, _ := data["profile"].(map[string]interface{})
+	return &account{Name: name, Password: password, Profile: profile}, nil
+}
+
+// captureAPI returns an API with the accountHandler registered using the BodyCapture
+// along with the captured exchanges.
+func captureAPI(handler accountHandler, capture BodyCapture) (API, *[]CapturedExchange) {
+	captured := &[]CapturedExchange{}
+	capture.Sink = func(exchange CapturedExchange) {
+		*captured = append(*captured, exchange)
+	}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, capture)
+	return api, captured
+}
+
+// postAccount sends a create request with the headers to the API.
+func postAccount(api API, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v1/accounts", bytes.NewBufferString(
+		`{"name":"bob","password":"hunter2","profile":{"ssn":"123-45-6789","age":40}}`))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that requests are only captured when triggered by the debug header with
+// the right token, and captured bodies have sensitive fields redacted.
+func TestBodyCaptureDebugHeader(t *testing.T) {
+	assert := assert.New(t)
+	api, captured := captureAPI(accountHandler{}, BodyCapture{
+		DebugHeader: "X-Debug-Capture",
+		DebugToken:  "secret",
+		Redact:      []string{"ssn"},
+	})
+
+	postAccount(api, nil)
+	postAccount(api, map[string]string{"X-Debug-Capture": "guess"})
+	assert.Len(*captured, 0)
+
+	resp := postAccount(api, map[string]string{
+		"X-Debug-Capture": "secret",
+		"Authorization":   "Bearer token",
+		"X-Request-Id":    "abc",
+	})
+
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Contains(resp.Body.String(), `"password":"hunter2"`)
+	if assert.Len(*captured, 1) {
+		exchange := (*captured)[0]
+		assert.True(exchange.Triggered)
+		assert.Equal("accounts", exchange.Resource)
+		assert.Equal("POST", exchange.Method)
+		assert.Equal(http.StatusCreated, exchange.Status)
+		assert.Equal(
+			`{"name":"bob","password":"[REDACTED]","profile":{"age":40,"ssn":"[REDACTED]"}}`,
+			string(exchange.RequestBody))
+		assert.Contains(string(exchange.ResponseBody), `"password":"[REDACTED]"`)
+		assert.NotContains(string(exchange.ResponseBody), "123-45-6789")
+		assert.Equal(http.Header{"X-Request-Id": {"abc"}}, exchange.RequestHeader)
+		assert.Equal("application/json; charset=utf-8",
+			exchange.ResponseHeader.Get("Content-Type"))
+	}
+}
+
+// Ensures that sampled requests are captured with bodies truncated to MaxBytes and
+// the Trigger is honored.
+func TestBodyCaptureSampling(t *testing.T) {
+	assert := assert.New(t)
+	api, captured := captureAPI(accountHandler{}, BodyCapture{SampleRate: 1, MaxBytes: 10})
+
+	postAccount(api, nil)
+
+	if assert.Len(*captured, 1) {
+		exchange := (*captured)[0]
+		assert.False(exchange.Triggered)
+		assert.Equal(`{"name":"b`, string(exchange.RequestBody))
+		assert.True(exchange.RequestTruncated)
+		assert.True(exchange.ResponseTruncated)
+	}
+
+	api, captured = captureAPI(accountHandler{}, BodyCapture{
+		Trigger: func(r *http.Request) bool { return r.Header.Get("X-Partner") == "acme" },
+	})
+	postAccount(api, map[string]string{"X-Partner": "other"})
+	postAccount(api, map[string]string{"X-Partner": "acme"})
+
+	assert.Len(*captured, 1)
+}
+
+// Ensures that requests which fail authentication aren't captured.
+func TestBodyCaptureUnauthenticated(t *testing.T) {
+	assert := assert.New(t)
+	api, captured := captureAPI(accountHandler{authErr: fmt.Errorf("denied")},
+		BodyCapture{SampleRate: 1})
+
+	resp := postAccount(api, nil)
+
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+	assert.Len(*captured, 0)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Charsets which request bodies can be transcoded from.
+const (
+	UTF8    = "utf-8"
+	UTF16   = "utf-16"
+	UTF16LE = "utf-16le"
+	UTF16BE = "utf-16be"
+	Latin1  = "iso-8859-1"
+)
+
+// utf8BOM is the UTF-8 encoded byte order mark.
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// charsetAliases maps alternate charset names to their canonical names.
+var charsetAliases = map[string]string{
+	"utf8":      UTF8,
+	"latin1":    Latin1,
+	"latin-1":   Latin1,
+	"iso8859-1": Latin1,
+	"l1":        Latin1,
+}
+
+// transcoders maps canonical charset names to functions which convert bodies in that
+// charset to UTF-8.
+var transcoders = map[string]func([]byte) ([]byte, error){
+	UTF16:   transcodeUTF16,
+	UTF16LE: func(b []byte) ([]byte, error) { return decodeUTF16(b, false, UTF16LE) },
+	UTF16BE: func(b []byte) ([]byte, error) { return decodeUTF16(b, true, UTF16BE) },
+	Latin1:  transcodeLatin1,
+}
+
+// canonicalCharset returns the canonical, lowercase name of the charset.
+func canonicalCharset(charset string) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if canonical, ok := charsetAliases[charset]; ok {
+		return canonical
+	}
+	return charset
+}
+
+// requestCharset returns the canonical charset specified by the Content-Type header,
+// defaulting to UTF-8 if there isn't one.
+func requestCharset(contentType string) string {
+	if contentType == "" {
+		return UTF8
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["charset"] == "" {
+		return UTF8
+	}
+	return canonicalCharset(params["charset"])
+}
+
+// transcodeBody converts the request body from the charset specified by the
+// Content-Type header to UTF-8 and strips any leading byte order mark. Charsets other
+// than UTF-8 must be in the accepted list. Returns a 415 Error if the charset is not
+// accepted or not supported and a 400 Error if the body is malformed.
+func transcodeBody(body []byte, contentType string, accepted []string) ([]byte, error) {
+	charset := requestCharset(contentType)
+	if charset != UTF8 {
+		transcode, ok := transcoders[charset]
+		if !ok || !charsetAccepted(charset, accepted) {
+			return nil, UnsupportedMediaType(fmt.Sprintf("Unsupported charset: %s", charset))
+		}
+		transcoded, err := transcode(body)
+		if err != nil {
+			return nil, BadRequest(err.Error())
+		}
+		body = transcoded
+	}
+
+	return bytes.TrimPrefix(body, utf8BOM), nil
+}
+
+// charsetAccepted returns true if the canonical charset is in the accepted list.
+func charsetAccepted(charset string, accepted []string) bool {
+	for _, a := range accepted {
+		if canonicalCharset(a) == charset {
+			return true
+		}
+	}
+	return false
+}
+
+// transcodeUTF16 converts a UTF-16 body to UTF-8, using the byte order mark to
+// determine endianness. Bodies without a byte order mark are assumed to be big
+// endian.
+func transcodeUTF16(b []byte) ([]byte, error) {
+	bigEndian := true
+	if len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe {
+		bigEndian = false
+	}
+	return decodeUTF16(b, bigEndian, UTF16)
+}
+
+// decodeUTF16 converts a UTF-16 body with the given endianness to UTF-8.
+func decodeUTF16(b []byte, bigEndian bool, charset string) ([]byte, error) {
+	if len(b)%2 != 0 {
+		return nil, fmt.Errorf("Invalid %s body: odd number of bytes", charset)
+	}
+
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+		} else {
+			units[i] = uint16(b[2*i+1])<<8 | uint16(b[2*i])
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, r := range utf16.Decode(units) {
+		buf.WriteRune(r)
+	}
+	return buf.Bytes(), nil
+}
+
+// transcodeLatin1 converts an ISO-8859-1 body to UTF-8.
+func transcodeLatin1(b []byte) ([]byte, error) {
+	buf := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c < utf8.RuneSelf {
+			buf = append(buf, c)
+			continue
+		}
+		buf = append(buf, string(rune(c))...)
+	}
+	return buf, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// charsetFixture is the JSON document every fixture body encodes.
+const charsetFixture = "{\"foo\": \"caf\u00e9\"}"
+
+// encodeUTF16 returns the string encoded as UTF-16 with the given endianness,
+// optionally preceded by a byte order mark.
+func encodeUTF16(s string, bigEndian, bom bool) []byte {
+	if bom {
+		s = "\ufeff" + s
+	}
+	var buf bytes.Buffer
+	for _, unit := range utf16.Encode([]rune(s)) {
+		if bigEndian {
+			buf.Write([]byte{byte(unit >> 8), byte(unit)})
+		} else {
+			buf.Write([]byte{byte(unit), byte(unit >> 8)})
+		}
+	}
+	return buf.Bytes()
+}
+
+// Ensures that transcodeBody converts each supported encoding, with and without byte
+// order marks, to UTF-8.
+func TestTranscodeBody(t *testing.T) {
+	assert := assert.New(t)
+	accepted := []string{"UTF-16", "utf-16le", "utf-16be", "latin1"}
+	fixtures := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"", []byte(charsetFixture)},
+		{"application/json", append([]byte{0xef, 0xbb, 0xbf}, charsetFixture...)},
+		{"application/json; charset=UTF-8", append([]byte{0xef, 0xbb, 0xbf}, charsetFixture...)},
+		{"application/json; charset=utf8", []byte(charsetFixture)},
+		{"application/json; charset=utf-16le", encodeUTF16(charsetFixture, false, false)},
+		{"application/json; charset=utf-16le", encodeUTF16(charsetFixture, false, true)},
+		{"application/json; charset=utf-16be", encodeUTF16(charsetFixture, true, false)},
+		{"application/json; charset=utf-16be", encodeUTF16(charsetFixture, true, true)},
+		{"application/json; charset=utf-16", encodeUTF16(charsetFixture, false, true)},
+		{"application/json; charset=utf-16", encodeUTF16(charsetFixture, true, true)},
+		{"application/json; charset=utf-16", encodeUTF16(charsetFixture, true, false)},
+		{"application/json; charset=ISO-8859-1", []byte("{\"foo\": \"caf\xe9\"}")},
+		{"application/json; charset=latin-1", []byte("{\"foo\": \"caf\xe9\"}")},
+	}
+
+	for _, fixture := range fixtures {
+		body, err := transcodeBody(fixture.body, fixture.contentType, accepted)
+		assert.Nil(err, fixture.contentType)
+		assert.Equal(charsetFixture, string(body), fixture.contentType)
+	}
+}
+
+// Ensures that transcodeBody returns a 415 naming the charset for charsets which are
+// unsupported or not accepted by the Configuration.
+func TestTranscodeBodyUnsupported(t *testing.T) {
+	assert := assert.New(t)
+	body := encodeUTF16(charsetFixture, false, true)
+
+	_, err := transcodeBody(body, "application/json; charset=utf-16le", nil)
+	assert.Equal(UnsupportedMediaType("Unsupported charset: utf-16le"), err)
+
+	_, err = transcodeBody(body, "application/json; charset=UTF-32", []string{"utf-32"})
+	assert.Equal(UnsupportedMediaType("Unsupported charset: utf-32"), err)
+}
+
+// Ensures that transcodeBody returns a 400 for malformed UTF-16 bodies.
+func TestTranscodeBodyMalformed(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := transcodeBody([]byte{0x7b, 0x00, 0x7d}, "application/json; charset=utf-16le",
+		[]string{UTF16LE})
+
+	assert.Equal(BadRequest("Invalid utf-16le body: odd number of bytes"), err)
+}
+
+// Ensures that the create handler decodes a UTF-16 body with a byte order mark,
+// rejects unsupported charsets with a 415, and advertises UTF-8 responses.
+func TestHandleCreateCharset(t *testing.T) {
+	assert := assert.New(t)
+	handler := &normalizingHandler{}
+	api := NewAPI(&Configuration{AcceptedCharsets: []string{UTF16LE}})
+
+	api.RegisterResourceHandler(handler)
+	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+
+	body := encodeUTF16(charsetFixture, false, true)
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v0.1/foo", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-16le")
+	resp := httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusCreated, resp.Code, "Incorrect response code")
+	assert.Equal("application/json; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Equal(Payload{"foo": "caf\u00e9"}, handler.data)
+	assert.Equal(body, handler.ctx.RawBody())
+
+	req, _ = http.NewRequest("POST", "http://foo.com/api/v0.1/foo", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-16be")
+	resp = httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusUnsupportedMediaType, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":["Unsupported charset: utf-16be"],"reason":"Unsupported Media Type","status":415}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+}
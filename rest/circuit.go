@@ -0,0 +1,361 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// CircuitOpenedCounter counts the times a resource's circuit opened.
+	CircuitOpenedCounter = "circuit_opened"
+
+	// CircuitClosedCounter counts the times a resource's circuit closed after
+	// recovering.
+	CircuitClosedCounter = "circuit_closed"
+
+	// CircuitRejectedCounter counts requests rejected because the resource's circuit
+	// was open.
+	CircuitRejectedCounter = "circuit_rejected"
+
+	// CircuitOpenCode is the error code of responses to requests rejected because the
+	// resource's circuit was open.
+	CircuitOpenCode = "circuit_open"
+
+	// defaultCircuitOpenDuration is how long a circuit stays open if CircuitBreaker
+	// doesn't specify an OpenDuration.
+	defaultCircuitOpenDuration = 30 * time.Second
+
+	// defaultCircuitWindow is the error rate window if CircuitBreaker doesn't specify
+	// one.
+	defaultCircuitWindow = 10 * time.Second
+
+	// defaultCircuitMinRequests is the number of requests needed in a window for the
+	// error rate to apply if CircuitBreaker doesn't specify MinRequests.
+	defaultCircuitMinRequests = 10
+)
+
+// CircuitState is the state of a resource's circuit.
+type CircuitState string
+
+const (
+	// CircuitClosed means requests are handled normally.
+	CircuitClosed CircuitState = "closed"
+
+	// CircuitOpen means requests are rejected without invoking the handler.
+	CircuitOpen CircuitState = "open"
+
+	// CircuitHalfOpen means a limited number of probe requests are handled to detect
+	// recovery while others are rejected.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitEvent describes a resource's circuit changing state.
+type CircuitEvent struct {
+	Resource string
+	From     CircuitState
+	To       CircuitState
+}
+
+// CircuitBreaker is a ResourceOption which stops invoking a resource's handler while
+// it's failing, e.g. because a database it depends on is down. The circuit opens after
+// ConsecutiveFailures failed requests in a row or once the fraction of failed requests
+// in a Window reaches ErrorRate. While open, requests receive a 503 with Retry-After
+// without invoking the handler. After OpenDuration, the circuit is half-open and up to
+// HalfOpenProbes requests at a time are handled. The circuit closes when one succeeds
+// and opens again when one fails.
+//
+// Unlike health checks, the circuit reacts to the resource's real traffic. Requests
+// rejected by authentication, gates, or rate limits never reach it, and cached
+// responses are served while it's open.
+type CircuitBreaker struct {
+	// ConsecutiveFailures is the number of failed requests in a row which open the
+	// circuit. Zero disables the threshold.
+	ConsecutiveFailures int
+
+	// ErrorRate is the fraction of failed requests in a Window, between 0 and 1,
+	// which opens the circuit. Zero disables the threshold.
+	ErrorRate float64
+
+	// MinRequests is the number of requests needed in a Window before ErrorRate
+	// applies. Defaults to 10.
+	MinRequests int
+
+	// Window is the duration of each fixed window ErrorRate applies to. Defaults to
+	// 10 seconds.
+	Window time.Duration
+
+	// OpenDuration is how long the circuit stays open before probe requests are
+	// allowed. Defaults to 30 seconds.
+	OpenDuration time.Duration
+
+	// HalfOpenProbes is the number of probe requests handled at a time while the
+	// circuit is half-open. Defaults to 1.
+	HalfOpenProbes int
+
+	// Failure reports whether a response status, after errors are mapped, counts as a
+	// failure. Defaults to statuses of 500 and above, so client errors don't open the
+	// circuit.
+	Failure func(status int) bool
+
+	// OnStateChange is invoked when the circuit changes state. It's invoked
+	// synchronously, so it should hand off slow work.
+	OnStateChange func(CircuitEvent)
+}
+
+// apply sets the CircuitBreaker on the resource.
+func (c CircuitBreaker) apply(opts *resourceOptions) {
+	opts.breaker = &c
+}
+
+// failure reports whether the response status counts as a failure.
+func (c *CircuitBreaker) failure(status int) bool {
+	if c.Failure != nil {
+		return c.Failure(status)
+	}
+	return status >= http.StatusInternalServerError
+}
+
+// circuitBreaker tracks the state of a resource's circuit. It's safe for concurrent
+// use.
+type circuitBreaker struct {
+	resource string
+	options  *CircuitBreaker
+	metrics  Metrics
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	window   time.Time
+	requests int
+	failed   int
+	openedAt time.Time
+	probes   int
+	pending  []CircuitEvent
+	now      func() time.Time
+}
+
+// newCircuitBreaker returns a closed circuitBreaker for the resource.
+func newCircuitBreaker(resource string, options *CircuitBreaker, metrics Metrics) *circuitBreaker {
+	return &circuitBreaker{
+		resource: resource,
+		options:  options,
+		metrics:  metrics,
+		state:    CircuitClosed,
+		now:      time.Now,
+	}
+}
+
+// openDuration returns how long the circuit stays open.
+func (c *circuitBreaker) openDuration() time.Duration {
+	if c.options.OpenDuration <= 0 {
+		return defaultCircuitOpenDuration
+	}
+	return c.options.OpenDuration
+}
+
+// allow returns true if a request may be handled, and whether it's a probe. If not,
+// it returns when the circuit will allow probes.
+func (c *circuitBreaker) allow() (bool, bool, time.Time) {
+	c.lock()
+	defer c.unlock()
+	reopen := c.openedAt.Add(c.openDuration())
+	if c.state == CircuitOpen && !c.now().Before(reopen) {
+		c.transition(CircuitHalfOpen)
+	}
+
+	switch c.state {
+	case CircuitClosed:
+		return true, false, time.Time{}
+	case CircuitHalfOpen:
+		max := c.options.HalfOpenProbes
+		if max <= 0 {
+			max = 1
+		}
+		if c.probes < max {
+			c.probes++
+			return true, true, time.Time{}
+		}
+		return false, false, c.now()
+	default:
+		return false, false, reopen
+	}
+}
+
+// record records the outcome of a handled request.
+func (c *circuitBreaker) record(failed, probe bool) {
+	c.lock()
+	defer c.unlock()
+	if probe {
+		c.probes--
+		if c.state != CircuitHalfOpen {
+			return
+		}
+		if failed {
+			c.open()
+		} else {
+			c.reset()
+			c.transition(CircuitClosed)
+		}
+		return
+	}
+	if c.state != CircuitClosed {
+		return
+	}
+
+	now := c.now()
+	window := c.options.Window
+	if window <= 0 {
+		window = defaultCircuitWindow
+	}
+	if !c.window.Add(window).After(now) {
+		c.window = now.Truncate(window)
+		c.requests, c.failed = 0, 0
+	}
+	c.requests++
+	if !failed {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	c.failed++
+
+	minRequests := c.options.MinRequests
+	if minRequests <= 0 {
+		minRequests = defaultCircuitMinRequests
+	}
+	if c.options.ConsecutiveFailures > 0 && c.failures >= c.options.ConsecutiveFailures ||
+		c.options.ErrorRate > 0 && c.requests >= minRequests &&
+			float64(c.failed)/float64(c.requests) >= c.options.ErrorRate {
+		c.open()
+	}
+}
+
+// open opens the circuit.
+func (c *circuitBreaker) open() {
+	c.reset()
+	c.openedAt = c.now()
+	c.transition(CircuitOpen)
+}
+
+// reset clears the failures counted towards opening the circuit.
+func (c *circuitBreaker) reset() {
+	c.failures, c.requests, c.failed = 0, 0, 0
+	c.window = time.Time{}
+}
+
+// lock locks the circuitBreaker.
+func (c *circuitBreaker) lock() {
+	c.mu.Lock()
+}
+
+// unlock unlocks the circuitBreaker and then invokes the hook for the transitions made
+// while it was locked, so the hook may inspect the API's state.
+func (c *circuitBreaker) unlock() {
+	events := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if c.options.OnStateChange != nil {
+		for _, event := range events {
+			c.options.OnStateChange(event)
+		}
+	}
+}
+
+// transition changes the circuit's state and counts it. The circuitBreaker must be
+// locked.
+func (c *circuitBreaker) transition(to CircuitState) {
+	c.pending = append(c.pending, CircuitEvent{Resource: c.resource, From: c.state, To: to})
+	c.state = to
+	switch to {
+	case CircuitOpen:
+		c.metrics.incr(CircuitOpenedCounter, c.resource)
+	case CircuitClosed:
+		c.metrics.incr(CircuitClosedCounter, c.resource)
+	}
+}
+
+// stats returns the circuit's state for the stats endpoint.
+func (c *circuitBreaker) stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := map[string]interface{}{
+		"state":                c.state,
+		"consecutive_failures": c.failures,
+	}
+	if c.state != CircuitClosed {
+		stats["opened_at"] = c.openedAt
+	}
+	return stats
+}
+
+// circuitStats returns the state of each resource's circuit.
+func (r *muxAPI) circuitStats() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stats := make(map[string]interface{}, len(r.breakers))
+	for resource, breaker := range r.breakers {
+		stats[resource] = breaker.stats()
+	}
+	return stats
+}
+
+// newBreakerMiddleware returns a RequestMiddleware which applies the CircuitBreaker to
+// requests for the resource. Versions of a resource share its circuit.
+func newBreakerMiddleware(api *muxAPI, resource string, options *CircuitBreaker) RequestMiddleware {
+	api.mu.Lock()
+	breaker, ok := api.breakers[resource]
+	if !ok {
+		breaker = newCircuitBreaker(resource, options, api.metrics)
+		api.breakers[resource] = breaker
+	}
+	api.mu.Unlock()
+
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			allowed, probe, retry := breaker.allow()
+			if !allowed {
+				api.metrics.incr(CircuitRejectedCounter, resource)
+				api.debugf(resource, "Circuit open for %s: %s %s (503)", resource,
+					r.Method, r.URL.Path)
+				retryAfter := int(math.Ceil(retry.Sub(breaker.now()).Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				ctx := NewContext(nil, r).setError(ServiceUnavailable(
+					fmt.Sprintf("Resource %s is temporarily unavailable", resource)).
+					WithCode(CircuitOpenCode))
+				api.handler.sendResponse(w, ctx)
+				return
+			}
+
+			recorder := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			completed := false
+			defer func() {
+				// A panic counts as a failure.
+				breaker.record(!completed || options.failure(recorder.status), probe)
+			}()
+			wrapped(recorder, r)
+			completed = true
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// outageHandler is a ResourceHandler whose reads fail with a configurable error.
+type outageHandler struct {
+	BaseResourceHandler
+	err   error
+	calls int
+}
+
+func (o *outageHandler) ResourceName() string {
+	return "outage"
+}
+
+func (o *outageHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	o.calls++
+	if o.err != nil {
+		return nil, o.err
+	}
+	return id, nil
+}
+
+// serveOutage sends a read request to the API.
+func serveOutage(api API) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/outage/1", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// advanceCircuit moves the resource's circuit clock forward by the duration.
+func advanceCircuit(api API, resource string, d time.Duration) {
+	breaker := api.(*muxAPI).breakers[resource]
+	now := breaker.now()
+	breaker.now = func() time.Time { return now.Add(d) }
+}
+
+// Ensures that consecutive failures open the circuit, after which requests are
+// rejected with a 503 and Retry-After without invoking the handler.
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	assert := assert.New(t)
+	events := []CircuitEvent{}
+	handler := &outageHandler{err: InternalServerError("database down")}
+	api := NewAPI(&Configuration{StatsURI: "/stats"})
+	api.RegisterResourceHandler(handler, CircuitBreaker{
+		ConsecutiveFailures: 3,
+		OpenDuration:        time.Minute,
+		OnStateChange:       func(event CircuitEvent) { events = append(events, event) },
+	})
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(http.StatusInternalServerError, serveOutage(api).Code)
+	}
+	resp := serveOutage(api)
+
+	assert.Equal(http.StatusServiceUnavailable, resp.Code)
+	assert.Equal("60", resp.Header().Get("Retry-After"))
+	assert.Contains(resp.Body.String(), CircuitOpenCode)
+	assert.Equal(3, handler.calls)
+	assert.Equal([]CircuitEvent{{"outage", CircuitClosed, CircuitOpen}}, events)
+	assert.Equal(uint64(1), api.Metrics().Counter(CircuitOpenedCounter, "outage"))
+	assert.Equal(uint64(1), api.Metrics().Counter(CircuitRejectedCounter, "outage"))
+	circuits := api.Stats()["circuits"].(map[string]interface{})
+	assert.Equal(CircuitOpen, circuits["outage"].(map[string]interface{})["state"])
+}
+
+// Ensures that client errors don't count as failures, and successes reset the count
+// of consecutive failures.
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	assert := assert.New(t)
+	handler := &outageHandler{err: ResourceNotFound("no such thing")}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, CircuitBreaker{ConsecutiveFailures: 2})
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(http.StatusNotFound, serveOutage(api).Code)
+	}
+	handler.err = InternalServerError("database down")
+	serveOutage(api)
+	handler.err = nil
+	serveOutage(api)
+	handler.err = InternalServerError("database down")
+	serveOutage(api)
+
+	assert.Equal(http.StatusInternalServerError, serveOutage(api).Code)
+	assert.Equal(http.StatusServiceUnavailable, serveOutage(api).Code)
+	assert.Equal(9, handler.calls)
+}
+
+// Ensures that the circuit opens once the error rate in a window reaches the
+// threshold, given enough requests.
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	assert := assert.New(t)
+	handler := &outageHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, CircuitBreaker{ErrorRate: 0.5, MinRequests: 4})
+
+	for _, failing := range []bool{false, true, false, true} {
+		handler.err = nil
+		if failing {
+			handler.err = InternalServerError("database down")
+		}
+		assert.NotEqual(http.StatusServiceUnavailable, serveOutage(api).Code)
+	}
+
+	assert.Equal(http.StatusServiceUnavailable, serveOutage(api).Code)
+	assert.Equal(4, handler.calls)
+}
+
+// Ensures that after the open duration a probe is handled, closing the circuit if it
+// succeeds and reopening it if it fails.
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	assert := assert.New(t)
+	events := []CircuitEvent{}
+	handler := &outageHandler{err: InternalServerError("database down")}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, CircuitBreaker{
+		ConsecutiveFailures: 1,
+		OpenDuration:        time.Minute,
+		OnStateChange:       func(event CircuitEvent) { events = append(events, event) },
+	})
+
+	serveOutage(api)
+	advanceCircuit(api, "outage", time.Minute)
+	assert.Equal(http.StatusInternalServerError, serveOutage(api).Code)
+	assert.Equal(http.StatusServiceUnavailable, serveOutage(api).Code)
+
+	handler.err = nil
+	advanceCircuit(api, "outage", time.Minute)
+	assert.Equal(http.StatusOK, serveOutage(api).Code)
+	assert.Equal(http.StatusOK, serveOutage(api).Code)
+
+	assert.Equal([]CircuitEvent{
+		{"outage", CircuitClosed, CircuitOpen},
+		{"outage", CircuitOpen, CircuitHalfOpen},
+		{"outage", CircuitHalfOpen, CircuitOpen},
+		{"outage", CircuitOpen, CircuitHalfOpen},
+		{"outage", CircuitHalfOpen, CircuitClosed},
+	}, events)
+	assert.Equal(uint64(1), api.Metrics().Counter(CircuitClosedCounter, "outage"))
+}
+
+// Ensures that only HalfOpenProbes requests are handled at a time while the circuit
+// is half-open.
+func TestCircuitBreakerProbeLimit(t *testing.T) {
+	assert := assert.New(t)
+	breaker := newCircuitBreaker("outage", &CircuitBreaker{ConsecutiveFailures: 1,
+		HalfOpenProbes: 2}, newMetrics())
+	breaker.record(true, false)
+	now := time.Now().Add(time.Hour)
+	breaker.now = func() time.Time { return now }
+
+	first, firstProbe, _ := breaker.allow()
+	second, _, _ := breaker.allow()
+	third, _, _ := breaker.allow()
+
+	assert.True(first)
+	assert.True(firstProbe)
+	assert.True(second)
+	assert.False(third)
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxReportedMismatches is the number of mismatches described when Compare fails.
+const maxReportedMismatches = 10
+
+// arrayIndexes matches the array indexes in a JSON path.
+var arrayIndexes = regexp.MustCompile(`\[\d+\]`)
+
+// CompareOptions configures Compare.
+type CompareOptions struct {
+	// IgnoreHeaders are the response headers which aren't compared, e.g. Date.
+	IgnoreHeaders []string
+
+	// IgnoreFields are the volatile body fields which aren't compared. A field name,
+	// e.g. updated_at, is ignored at any depth, while a path, e.g. result.updated_at,
+	// is ignored only there. Array indexes are omitted from paths.
+	IgnoreFields []string
+
+	// Threshold is the fraction of requests, between 0 and 1, which may differ
+	// without failing the test. Defaults to 0, so any difference fails it.
+	Threshold float64
+
+	// Workers is the number of requests sent concurrently. Defaults to 1.
+	Workers int
+
+	// ReportFile is where the CompareReport is written as JSON, e.g. for CI
+	// artifacts. No report is written if it's empty.
+	ReportFile string
+}
+
+// ignoredField returns true if the body path, e.g. body.result[0].id, is for one of
+// the IgnoreFields.
+func (o CompareOptions) ignoredField(path string) bool {
+	path = strings.TrimPrefix(arrayIndexes.ReplaceAllString(path, ""), "body.")
+	name := path[strings.LastIndex(path, ".")+1:]
+	for _, field := range o.IgnoreFields {
+		if field == path || !strings.Contains(field, ".") && field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CompareReport summarizes how the responses of two APIs to the same requests
+// compare.
+type CompareReport struct {
+	Total     int     `json:"total"`
+	Matched   int     `json:"matched"`
+	MatchRate float64 `json:"match_rate"`
+
+	// Routes summarizes the comparison for each route the requests were sent to,
+	// ordered by route.
+	Routes []RouteComparison `json:"routes"`
+
+	// Mismatches describes each request whose responses differed, in the order the
+	// requests were given.
+	Mismatches []CompareMismatch `json:"mismatches"`
+}
+
+// RouteComparison summarizes the comparison of the requests to a route.
+type RouteComparison struct {
+	Route     string  `json:"route"`
+	Total     int     `json:"total"`
+	Matched   int     `json:"matched"`
+	MatchRate float64 `json:"match_rate"`
+}
+
+// CompareMismatch describes a request whose responses differed.
+type CompareMismatch struct {
+	Route  string `json:"route"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+
+	// Diff describes each difference between the first API's response and the
+	// second's.
+	Diff []string `json:"diff"`
+}
+
+// Compare sends each recorded request to both APIs as Replay would and compares their
+// responses' statuses, headers, and bodies, e.g. to verify a new version of a
+// ResourceHandler behaves like the one it replaces. The test fails if the fraction of
+// requests whose responses differ exceeds the Threshold. Requests are marked as
+// replays, so they should be reads or handlers must avoid side effects using
+// RequestContext#Replayed. Returns the CompareReport, which is also written to the
+// ReportFile if there is one.
+func Compare(t TestingT, first, second API, recordings []Recording,
+	opts CompareOptions) *CompareReport {
+
+	mismatches := make([]*CompareMismatch, len(recordings))
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				mismatches[index] = compareRecording(first, second, recordings[index], opts)
+			}
+		}()
+	}
+	for i := range recordings {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	report := newCompareReport(first, recordings, mismatches)
+	if opts.ReportFile != "" {
+		if err := report.write(opts.ReportFile); err != nil {
+			t.Errorf("Failed to write comparison report: %s", err)
+		}
+	}
+	if float64(report.Total-report.Matched) > opts.Threshold*float64(report.Total) {
+		t.Errorf("%s", report.failure(opts.Threshold))
+	}
+	return report
+}
+
+// compareRecording sends the recorded request to both APIs and returns how their
+// responses differ, or nil if they match.
+func compareRecording(first, second API, recording Recording,
+	opts CompareOptions) *CompareMismatch {
+
+	mismatch := &CompareMismatch{Method: recording.Method, URL: recording.URL}
+	a, err := replayRecording(first, recording)
+	if err != nil {
+		mismatch.Diff = []string{fmt.Sprintf("request: %s", err)}
+		return mismatch
+	}
+	b, err := replayRecording(second, recording)
+	if err != nil {
+		mismatch.Diff = []string{fmt.Sprintf("request: %s", err)}
+		return mismatch
+	}
+
+	if a.Code != b.Code {
+		mismatch.Diff = append(mismatch.Diff, fmt.Sprintf("status: first %d, second %d",
+			a.Code, b.Code))
+	}
+	mismatch.Diff = append(mismatch.Diff, diffHeaders(a.Header(), b.Header(),
+		opts.IgnoreHeaders)...)
+	differ := valueDiff{labels: [2]string{"first", "second"}, ignored: opts.ignoredField}
+	mismatch.Diff = append(mismatch.Diff, differ.bodies(a.Body.Bytes(), b.Body.Bytes())...)
+	if len(mismatch.Diff) == 0 {
+		return nil
+	}
+	return mismatch
+}
+
+// diffHeaders returns the differences between the headers other than the ignored
+// ones.
+func diffHeaders(a, b http.Header, ignored []string) []string {
+	names := []string{}
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := []string{}
+	for _, name := range names {
+		if containsFold(ignored, name) {
+			continue
+		}
+		aValue, bValue := strings.Join(a[name], ", "), strings.Join(b[name], ", ")
+		if aValue != bValue {
+			diffs = append(diffs, fmt.Sprintf("header %s: first %q, second %q", name, aValue,
+				bValue))
+		}
+	}
+	return diffs
+}
+
+// compareRoute returns the name of the route the first API serves the recorded
+// request with, or its method and path if none does.
+func compareRoute(api API, recording Recording) string {
+	path := recording.URL
+	if parsed, err := url.Parse(recording.URL); err == nil {
+		path = parsed.Path
+	}
+	if route, ok := api.WhichRoute(recording.Method, path); ok && route.Name != "" {
+		return route.Name
+	}
+	return recording.Method + " " + path
+}
+
+// newCompareReport returns the CompareReport for the recordings given the mismatch,
+// if any, of each.
+func newCompareReport(api API, recordings []Recording,
+	mismatches []*CompareMismatch) *CompareReport {
+
+	report := &CompareReport{Routes: []RouteComparison{}, Mismatches: []CompareMismatch{}}
+	routes := map[string]*RouteComparison{}
+	for i, recording := range recordings {
+		name := compareRoute(api, recording)
+		route, ok := routes[name]
+		if !ok {
+			route = &RouteComparison{Route: name}
+			routes[name] = route
+		}
+		route.Total++
+		report.Total++
+		if mismatches[i] == nil {
+			route.Matched++
+			report.Matched++
+			continue
+		}
+		mismatches[i].Route = name
+		report.Mismatches = append(report.Mismatches, *mismatches[i])
+	}
+
+	for _, route := range routes {
+		route.MatchRate = matchRate(route.Matched, route.Total)
+		report.Routes = append(report.Routes, *route)
+	}
+	sort.Slice(report.Routes, func(i, j int) bool {
+		return report.Routes[i].Route < report.Routes[j].Route
+	})
+	report.MatchRate = matchRate(report.Matched, report.Total)
+	return report
+}
+
+// matchRate returns the fraction of the total which matched, or 1 if the total is 0.
+func matchRate(matched, total int) float64 {
+	if total == 0 {
+		return 1
+	}
+	return float64(matched) / float64(total)
+}
+
+// write writes the CompareReport to the file as JSON.
+func (c *CompareReport) write(file string) error {
+	encoded, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, encoded, 0644)
+}
+
+// failure describes the comparison failing for exceeding the threshold, summarizing
+// each route and the first mismatches.
+func (c *CompareReport) failure(threshold float64) string {
+	lines := []string{fmt.Sprintf(
+		"%d of %d requests differed (match rate %.1f%%, threshold %.1f%%)",
+		c.Total-c.Matched, c.Total, c.MatchRate*100, threshold*100)}
+	for _, route := range c.Routes {
+		lines = append(lines, fmt.Sprintf("  %s: %d of %d matched", route.Route,
+			route.Matched, route.Total))
+	}
+	for i, mismatch := range c.Mismatches {
+		if i == maxReportedMismatches {
+			lines = append(lines, fmt.Sprintf("  ... and %d more",
+				len(c.Mismatches)-maxReportedMismatches))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  %s %s: %s", mismatch.Method, mismatch.URL,
+			strings.Join(mismatch.Diff, "; ")))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// profileHandler reads profiles. The second generation stamps a different updated_at
+// and renames profile 3.
+type profileHandler struct {
+	BaseResourceHandler
+	second bool
+}
+
+// ResourceName returns "profiles".
+func (p profileHandler) ResourceName() string {
+	return "profiles"
+}
+
+// ReadResource returns the profile with the id.
+func (p profileHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	if id == "404" {
+		return nil, ResourceNotFound("No such profile")
+	}
+	name := "profile " + id
+	if p.second && id == "3" {
+		name = "renamed"
+	}
+	return map[string]interface{}{
+		"id":         id,
+		"name":       name,
+		"updated_at": time.Now().UnixNano(),
+	}, nil
+}
+
+// newProfileAPI returns an API serving the generation of profileHandler with a
+// response header naming it.
+func newProfileAPI(second bool) API {
+	api := NewAPI(&Configuration{})
+	served := "v1"
+	if second {
+		served = "v2"
+	}
+	api.RegisterResourceHandler(profileHandler{second: second},
+		RequestMiddleware(func(wrapped http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Served-By", served)
+				wrapped(w, r)
+			}
+		}))
+	return api
+}
+
+// profileRecordings returns recordings of reads of the profiles with the ids.
+func profileRecordings(ids ...string) []Recording {
+	recordings := make([]Recording, len(ids))
+	for i, id := range ids {
+		recordings[i] = Recording{Resource: "profiles", Method: "GET",
+			URL: "/api/v1/profiles/" + id}
+	}
+	return recordings
+}
+
+// Ensures that responses which only differ in ignored headers and volatile fields
+// match, across several workers, and that the report summarizes each route.
+func TestCompareMatching(t *testing.T) {
+	assert := assert.New(t)
+	recorder := &recordingT{}
+
+	report := Compare(recorder, newProfileAPI(false), newProfileAPI(true),
+		profileRecordings("1", "2", "404", "4", "5"), CompareOptions{
+			IgnoreHeaders: []string{"X-Served-By"},
+			IgnoreFields:  []string{"updated_at"},
+			Workers:       3,
+		})
+
+	assert.Empty(recorder.errors)
+	assert.Equal(5, report.Total)
+	assert.Equal(5, report.Matched)
+	assert.Equal(1.0, report.MatchRate)
+	assert.Equal([]RouteComparison{{Route: "profiles:read", Total: 5, Matched: 5,
+		MatchRate: 1}}, report.Routes)
+	assert.Empty(report.Mismatches)
+}
+
+// Ensures that differing statuses, headers, and bodies are reported, that the test
+// fails above the threshold but not below it, and that the report is written as JSON.
+func TestCompareMismatches(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "compare-test")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "report.json")
+	recordings := profileRecordings("1", "2", "3", "4")
+
+	recorder := &recordingT{}
+	report := Compare(recorder, newProfileAPI(false), newProfileAPI(true), recordings,
+		CompareOptions{IgnoreFields: []string{"result.updated_at"}, ReportFile: file})
+
+	assert.Equal(0, report.Matched)
+	if assert.Len(report.Mismatches, 4) {
+		assert.Equal([]string{`header X-Served-By: first "v1", second "v2"`},
+			report.Mismatches[0].Diff)
+		assert.Equal([]string{
+			`header X-Served-By: first "v1", second "v2"`,
+			`body.result.name: first "profile 3", second "renamed"`,
+		}, report.Mismatches[2].Diff)
+		assert.Equal("profiles:read", report.Mismatches[2].Route)
+	}
+	if assert.Len(recorder.errors, 1) {
+		assert.True(strings.HasPrefix(recorder.errors[0], "4 of 4 requests differed"))
+	}
+	var written CompareReport
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &written))
+	assert.Equal(*report, written)
+
+	recorder = &recordingT{}
+	report = Compare(recorder, newProfileAPI(false), newProfileAPI(true), recordings,
+		CompareOptions{IgnoreHeaders: []string{"x-served-by"},
+			IgnoreFields: []string{"updated_at"}, Threshold: 0.25})
+	assert.Equal(0.75, report.MatchRate)
+	assert.Empty(recorder.errors)
+
+	// 1-0.7 exceeds 0.3 in floating point, but 3 of 10 requests is within it.
+	recorder = &recordingT{}
+	report = Compare(recorder, newProfileAPI(false), newProfileAPI(true),
+		profileRecordings("3", "3", "3", "1", "2", "4", "5", "6", "7", "8"),
+		CompareOptions{IgnoreHeaders: []string{"x-served-by"},
+			IgnoreFields: []string{"updated_at"}, Threshold: 0.3})
+	assert.Equal(7, report.Matched)
+	assert.Empty(recorder.errors)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+
+	gcontext "github.com/gorilla/context"
+)
+
+const (
+	// ConsistencyTokenHeader is the header carrying consistency tokens. Responses carry
+	// the token set by the handler using RequestContext#SetConsistencyToken, and
+	// requests present it so reads reflect the write which produced it.
+	ConsistencyTokenHeader = "X-Consistency-Token"
+
+	// consistencyTokenKey is the name of the query string variable carrying the
+	// consistency token in links to the next page of results.
+	consistencyTokenKey = "consistency"
+)
+
+// responseConsistencyTokenKey is the request context key of the consistency token set
+// by the handler.
+type responseConsistencyTokenKey struct{}
+
+// consistencyToken returns the consistency token presented by the request, either in
+// the ConsistencyTokenHeader or, for links to the next page of results, the query
+// string.
+func consistencyToken(r *http.Request) string {
+	if token := r.Header.Get(ConsistencyTokenHeader); token != "" {
+		return token
+	}
+	return r.URL.Query().Get(consistencyTokenKey)
+}
+
+// responseConsistencyToken returns the consistency token set by the handler for the
+// request, if any.
+func responseConsistencyToken(r *http.Request) (string, bool) {
+	token, ok := gcontext.GetOk(r, responseConsistencyTokenKey{})
+	if !ok {
+		return "", false
+	}
+	return token.(string), true
+}
+
+// setConsistencyTokenHeader sets the ConsistencyTokenHeader if the handler set a
+// consistency token for the request.
+func setConsistencyTokenHeader(w http.ResponseWriter, ctx RequestContext) {
+	req, ok := ctx.Request()
+	if !ok {
+		return
+	}
+	if token, ok := responseConsistencyToken(req); ok && token != "" {
+		w.Header().Set(ConsistencyTokenHeader, token)
+	}
+}
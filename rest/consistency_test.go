@@ -0,0 +1,156 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replicatedNotes is an eventually consistent store of notes whose replica only
+// reflects writes once they're replicated.
+type replicatedNotes struct {
+	mu      sync.Mutex
+	primary []string
+	replica []string
+}
+
+// read returns the notes from the primary if the consistency token is for a write
+// the replica doesn't reflect, otherwise from the replica.
+func (n *replicatedNotes) read(token string) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if written, err := strconv.Atoi(token); err == nil && written > len(n.replica) {
+		return n.primary
+	}
+	return n.replica
+}
+
+// noteHandler is a ResourceHandler for notes kept in replicatedNotes which issues a
+// consistency token for each note it creates.
+type noteHandler struct {
+	BaseResourceHandler
+	notes *replicatedNotes
+}
+
+func (n noteHandler) ResourceName() string {
+	return "notes"
+}
+
+func (n noteHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	n.notes.mu.Lock()
+	defer n.notes.mu.Unlock()
+	n.notes.primary = append(n.notes.primary, data["text"].(string))
+	id := len(n.notes.primary)
+	ctx.SetConsistencyToken(strconv.Itoa(id))
+	return Payload{"id": strconv.Itoa(id), "text": data["text"]}, nil
+}
+
+func (n noteHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	notes := n.notes.read(ctx.ConsistencyToken())
+	i, err := strconv.Atoi(id)
+	if err != nil || i < 1 || i > len(notes) {
+		return nil, ResourceNotFound("No such note")
+	}
+	return Payload{"id": id, "text": notes[i-1]}, nil
+}
+
+func (n noteHandler) ReadResourceList(ctx RequestContext, limit int, cursor string,
+	version string) ([]Resource, string, error) {
+	notes := n.notes.read(ctx.ConsistencyToken())
+	start, _ := strconv.Atoi(cursor)
+	resources := []Resource{}
+	for i := start; i < len(notes) && len(resources) < limit; i++ {
+		resources = append(resources, Payload{"id": strconv.Itoa(i + 1), "text": notes[i]})
+	}
+	next := ""
+	if start+len(resources) < len(notes) {
+		next = strconv.Itoa(start + len(resources))
+	}
+	return resources, next, nil
+}
+
+// serveNote sends a request with the consistency token, if any, to the API.
+func serveNote(api API, method, url, token, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.RequestURI = req.URL.RequestURI()
+	if token != "" {
+		req.Header.Set(ConsistencyTokenHeader, token)
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that a consistency token set when creating a resource is returned in the
+// response header and, when presented, lets the following read see the write.
+func TestConsistencyTokenCreateThenRead(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(noteHandler{notes: &replicatedNotes{}})
+
+	created := serveNote(api, "POST", "http://foo.com/api/v1/notes", "", `{"text":"hello"}`)
+	assert.Equal(http.StatusCreated, created.Code)
+	token := created.Header().Get(ConsistencyTokenHeader)
+	assert.Equal("1", token)
+
+	stale := serveNote(api, "GET", "http://foo.com/api/v1/notes/1", "", "")
+	assert.Equal(http.StatusNotFound, stale.Code)
+	assert.Equal("", stale.Header().Get(ConsistencyTokenHeader))
+
+	fresh := serveNote(api, "GET", "http://foo.com/api/v1/notes/1", token, "")
+	assert.Equal(http.StatusOK, fresh.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","result":{"id":"1","text":"hello"},"status":200}`,
+		fresh.Body.String())
+}
+
+// Ensures that the consistency token presented when listing resources is carried in
+// the link to the next page, so continuations read consistently.
+func TestConsistencyTokenNextURL(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(noteHandler{notes: &replicatedNotes{}})
+	token := ""
+	for _, text := range []string{"a", "b", "c"} {
+		token = serveNote(api, "POST", "http://foo.com/api/v1/notes", "",
+			`{"text":"`+text+`"}`).Header().Get(ConsistencyTokenHeader)
+	}
+
+	first := serveNote(api, "GET", "http://foo.com/api/v1/notes?limit=2", token, "")
+	var page map[string]interface{}
+	assert.Nil(json.Unmarshal(first.Body.Bytes(), &page))
+	assert.Len(page["results"], 2)
+	next, err := url.Parse(page["next"].(string))
+	assert.Nil(err)
+	assert.Equal("3", next.Query().Get("consistency"))
+
+	second := serveNote(api, "GET", next.String(), "", "")
+	page = nil
+	assert.Nil(json.Unmarshal(second.Body.Bytes(), &page))
+	assert.Equal([]interface{}{map[string]interface{}{"id": "3", "text": "c"}}, page["results"])
+	assert.Nil(page["next"])
+}
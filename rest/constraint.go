@@ -0,0 +1,191 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// maxConcurrentConstraints is the most Unique and Exists checks run at once for a
+// request, so large list updates don't flood the handler's datastore.
+const maxConcurrentConstraints = 8
+
+// ConstraintFunc answers whether a field's value satisfies a constraint only the
+// ResourceHandler can check, such as whether a username is taken. It returns an error
+// if it can't answer, e.g. because its database is unavailable.
+type ConstraintFunc func(ctx RequestContext, value interface{}) (bool, error)
+
+// constraintCheck is a ConstraintFunc to call with a field's value.
+type constraintCheck struct {
+	path    string
+	code    string
+	message string
+	check   ConstraintFunc
+	value   interface{}
+}
+
+// constraintResult is the answer of a constraintCheck.
+type constraintResult struct {
+	index int
+	ok    bool
+	err   error
+}
+
+// constraintChecks returns the Unique and Exists checks of the inbound Rules for the
+// version which apply to the fields of the Payload, or each of a slice of them, found
+// at the path. Fields without a value aren't checked.
+func constraintChecks(data interface{}, rules Rules, version, path string) []constraintCheck {
+	if rules == nil {
+		return nil
+	}
+	checks := []constraintCheck{}
+	switch value := data.(type) {
+	case []Payload:
+		for i, payload := range value {
+			checks = append(checks, constraintChecks(payload, rules, version,
+				fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return checks
+	case []interface{}:
+		for i, item := range value {
+			checks = append(checks, constraintChecks(item, rules, version,
+				fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return checks
+	case Payload:
+		data = map[string]interface{}(value)
+	}
+
+	fields, ok := data.(map[string]interface{})
+	if !ok {
+		return checks
+	}
+	for _, rule := range inboundRulesFor(rules, version).Contents() {
+		value, ok := fields[rule.Name()]
+		if !ok || value == nil {
+			continue
+		}
+		fieldPath := joinFieldPath(path, rule.Name())
+		if rule.Unique != nil {
+			checks = append(checks, constraintCheck{fieldPath, FieldNotUniqueCode,
+				fmt.Sprintf("%s is already taken", fieldPath), rule.Unique, value})
+		}
+		if rule.Exists != nil {
+			checks = append(checks, constraintCheck{fieldPath, FieldDoesNotExistCode,
+				fmt.Sprintf("%s refers to a resource which does not exist", fieldPath),
+				rule.Exists, value})
+		}
+		if rule.Rules != nil {
+			checks = append(checks, constraintChecks(value, rule.Rules, version, fieldPath)...)
+		}
+	}
+	return checks
+}
+
+// checkConstraints runs the Unique and Exists checks of the Rules which apply to the
+// Payload, or slice of them, with up to maxConcurrentConstraints at once. Checks which
+// haven't started when the request's deadline passes are skipped. Returns FieldErrors
+// for the values which fail them, the error of the first check which couldn't answer,
+// or a 504 if the request's deadline passes first.
+func checkConstraints(ctx RequestContext, data interface{}, rules Rules,
+	version string) error {
+
+	checks := constraintChecks(data, rules, version, "")
+	if len(checks) == 0 {
+		return nil
+	}
+
+	pending := make(chan int, len(checks))
+	for i := range checks {
+		pending <- i
+	}
+	close(pending)
+	workers := maxConcurrentConstraints
+	if len(checks) < workers {
+		workers = len(checks)
+	}
+	results := make(chan constraintResult, len(checks))
+	for i := 0; i < workers; i++ {
+		go func() {
+			for index := range pending {
+				if err := ctx.Err(); err != nil {
+					results <- constraintResult{index: index, err: err}
+					continue
+				}
+				results <- runConstraintCheck(ctx, index, checks[index])
+			}
+		}()
+	}
+
+	answers := make([]constraintResult, len(checks))
+	for range checks {
+		select {
+		case result := <-results:
+			answers[result.index] = result
+		case <-ctx.Done():
+			return Error{
+				reason: "Request deadline passed while checking constraints",
+				status: http.StatusGatewayTimeout,
+				code:   DeadlineExpiredCode,
+			}
+		}
+	}
+
+	failed := FieldErrors{}
+	for i, answer := range answers {
+		if answer.err != nil {
+			return answer.err
+		}
+		if !answer.ok {
+			failed = append(failed, FieldError{
+				Field:   checks[i].path,
+				Code:    checks[i].code,
+				Message: checks[i].message,
+			})
+		}
+	}
+	if len(failed) > 0 {
+		failed.sort()
+		return failed
+	}
+	return nil
+}
+
+// runConstraintCheck runs the check, recovering if it panics.
+func runConstraintCheck(ctx RequestContext, index int,
+	check constraintCheck) (result constraintResult) {
+
+	result.index = index
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result.err = fmt.Errorf("Constraint check for %s panicked: %v", check.path,
+				recovered)
+		}
+	}()
+	result.ok, result.err = check.check(ctx, check.value)
+	return result
+}
+
+// inputError returns the error for a request whose input failed validation, which is
+// a 422 for FieldErrors and the error itself otherwise.
+func inputError(err error) error {
+	if _, ok := err.(FieldErrors); ok {
+		return invalidInput(err)
+	}
+	return err
+}
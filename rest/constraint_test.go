@@ -0,0 +1,272 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memberHandler is a ResourceHandler for members whose usernames must be unique and
+// whose teams must exist, as recorded in a Store.
+type memberHandler struct {
+	BaseResourceHandler
+	store   Store
+	created *int
+	unique  ConstraintFunc
+	exists  ConstraintFunc
+}
+
+func (m memberHandler) ResourceName() string {
+	return "members"
+}
+
+func (m memberHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	*m.created++
+	return data, nil
+}
+
+func (m memberHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+	resources := make([]Resource, len(data))
+	for i, payload := range data {
+		resources[i] = payload
+	}
+	return resources, nil
+}
+
+func (m memberHandler) Rules() Rules {
+	unique, exists := m.unique, m.exists
+	if unique == nil {
+		unique = func(ctx RequestContext, value interface{}) (bool, error) {
+			taken, err := m.inStore("usernames")(ctx, value)
+			return !taken, err
+		}
+	}
+	if exists == nil {
+		exists = m.inStore("teams")
+	}
+	return NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "username", Type: String, Required: true, MinLength: 3,
+			Unique: unique},
+		&Rule{FieldAlias: "team_id", Type: String, Exists: exists},
+	)
+}
+
+// inStore returns a ConstraintFunc reporting whether the value is a key in the
+// namespace of the handler's Store.
+func (m memberHandler) inStore(namespace string) ConstraintFunc {
+	return func(ctx RequestContext, value interface{}) (bool, error) {
+		_, ok, err := m.store.Get(namespace, value.(string))
+		return ok, err
+	}
+}
+
+// newMemberAPI returns an API serving members whose Store has the username "taken"
+// and the team "red".
+func newMemberAPI(config *Configuration, handler memberHandler,
+	options ...ResourceOption) (API, *int) {
+
+	store := NewMemoryStore()
+	store.Set("usernames", "taken", []byte("1"), 0)
+	store.Set("teams", "red", []byte("1"), 0)
+	created := 0
+	handler.store = store
+	handler.created = &created
+	api := NewAPI(config)
+	api.RegisterResourceHandler(handler, options...)
+	return api, &created
+}
+
+// serveMembers sends the body to the members endpoint with the method.
+func serveMembers(api API, method, path, body string) (*httptest.ResponseRecorder,
+	map[string]interface{}) {
+
+	req, _ := http.NewRequest(method, "http://example.com/api/v1/members"+path,
+		bytes.NewBufferString(body))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	var decoded map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &decoded)
+	return resp, decoded
+}
+
+// Ensures that values failing Unique and Exists receive a 422 with their codes
+// without invoking the handler, including for each item of an update list.
+func TestConstraintsRejectValues(t *testing.T) {
+	assert := assert.New(t)
+	api, created := newMemberAPI(&Configuration{}, memberHandler{})
+
+	resp, decoded := serveMembers(api, "POST", "",
+		`{"username": "taken", "team_id": "blue"}`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code, resp.Body.String())
+	assert.Equal(0, *created)
+	assert.Equal([]interface{}{
+		map[string]interface{}{"field": "team_id", "code": FieldDoesNotExistCode,
+			"message": "team_id refers to a resource which does not exist"},
+		map[string]interface{}{"field": "username", "code": FieldNotUniqueCode,
+			"message": "username is already taken"},
+	}, decoded["errors"])
+
+	// Constraints only run once static validation passes.
+	resp, decoded = serveMembers(api, "POST", "", `{"username": "ab", "team_id": "blue"}`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code)
+	assert.Len(decoded["errors"], 1)
+
+	resp, _ = serveMembers(api, "POST", "", `{"username": "fresh", "team_id": "red"}`)
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal(1, *created)
+
+	resp, decoded = serveMembers(api, "PUT", "",
+		`[{"username": "fresh"}, {"username": "taken"}]`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code)
+	assert.Equal([]interface{}{
+		map[string]interface{}{"field": "[1].username", "code": FieldNotUniqueCode,
+			"message": "[1].username is already taken"},
+	}, decoded["errors"])
+}
+
+// Ensures that independent checks run concurrently.
+func TestConstraintsRunConcurrently(t *testing.T) {
+	assert := assert.New(t)
+	unique, exists := make(chan bool), make(chan bool)
+	// Each check waits for the other to start, which only succeeds if they overlap.
+	await := func(started, other chan bool) ConstraintFunc {
+		return func(ctx RequestContext, value interface{}) (bool, error) {
+			close(started)
+			select {
+			case <-other:
+				return true, nil
+			case <-time.After(time.Second):
+				return false, errors.New("Checks ran sequentially")
+			}
+		}
+	}
+	api, created := newMemberAPI(&Configuration{}, memberHandler{
+		unique: await(unique, exists),
+		exists: await(exists, unique),
+	})
+
+	resp, _ := serveMembers(api, "POST", "", `{"username": "fresh", "team_id": "red"}`)
+	assert.Equal(http.StatusCreated, resp.Code, resp.Body.String())
+	assert.Equal(1, *created)
+}
+
+// Ensures that no more than maxConcurrentConstraints checks run at once for a request,
+// however many items a list update has.
+func TestConstraintsConcurrencyLimit(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	running, peak, calls := 0, 0, 0
+	handler := memberHandler{unique: func(ctx RequestContext, value interface{}) (bool,
+		error) {
+		mu.Lock()
+		running++
+		calls++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return true, nil
+	}}
+	api, _ := newMemberAPI(&Configuration{}, handler)
+
+	items := make([]string, 100)
+	for i := range items {
+		items[i] = `{"username": "fresh"}`
+	}
+	resp, _ := serveMembers(api, "PUT", "", "["+strings.Join(items, ",")+"]")
+	assert.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	assert.Equal(100, calls)
+	assert.True(peak > 1, "Checks didn't run concurrently")
+	assert.True(peak <= maxConcurrentConstraints, "%d checks ran at once", peak)
+}
+
+// Ensures that checks which fail to answer map to a 500, or whatever an ErrorMapper
+// translates their error to, rather than a 422.
+func TestConstraintsCheckErrors(t *testing.T) {
+	assert := assert.New(t)
+	unavailable := errors.New("teams database unavailable")
+	api, created := newMemberAPI(&Configuration{}, memberHandler{
+		exists: func(ctx RequestContext, value interface{}) (bool, error) {
+			return false, unavailable
+		}})
+
+	resp, _ := serveMembers(api, "POST", "", `{"username": "fresh", "team_id": "red"}`)
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+
+	api.RegisterErrorMapper(func(err error) (Error, bool) {
+		if err == unavailable {
+			return ServiceUnavailable(err.Error()), true
+		}
+		return Error{}, false
+	})
+	resp, _ = serveMembers(api, "POST", "", `{"username": "fresh", "team_id": "red"}`)
+	assert.Equal(http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(0, *created)
+}
+
+// Ensures that checks are abandoned with a 504 once the request's deadline passes.
+func TestConstraintsDeadline(t *testing.T) {
+	assert := assert.New(t)
+	clock := &fakeClock{now: time.Now()}
+	api, created := newMemberAPI(&Configuration{
+		DeadlineBudget: &DeadlineBudget{Timeout: 20 * time.Millisecond, now: clock.time},
+	}, memberHandler{exists: func(ctx RequestContext, value interface{}) (bool, error) {
+		time.Sleep(time.Second)
+		return true, nil
+	}})
+
+	resp, decoded := serveMembers(api, "POST", "", `{"username": "fresh", "team_id": "red"}`)
+	assert.Equal(http.StatusGatewayTimeout, resp.Code)
+	assert.Equal(DeadlineExpiredCode, decoded["code"])
+	assert.Equal(0, *created)
+}
+
+// Ensures that the _validate endpoint skips checks unless they're opted into.
+func TestConstraintsPayloadValidation(t *testing.T) {
+	assert := assert.New(t)
+	body := `{"username": "taken", "team_id": "red"}`
+
+	api, _ := newMemberAPI(&Configuration{}, memberHandler{}, PayloadValidation{})
+	resp, decoded := serveMembers(api, "POST", "/_validate", body)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(true, decoded["result"].(map[string]interface{})["valid"])
+
+	api, _ = newMemberAPI(&Configuration{}, memberHandler{},
+		PayloadValidation{CheckConstraints: true})
+	resp, decoded = serveMembers(api, "POST", "/_validate", body)
+	assert.Equal(http.StatusOK, resp.Code)
+	result := decoded["result"].(map[string]interface{})
+	assert.Equal(false, result["valid"])
+	assert.Equal(FieldNotUniqueCode,
+		result["errors"].([]interface{})[0].(map[string]interface{})["code"])
+}
@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 
 	"code.google.com/p/go.net/context"
 	gcontext "github.com/gorilla/context"
@@ -47,12 +48,20 @@ const (
 	statusKey
 	errorKey
 	resultKey
+	rawBodyKey
+	nextCursorKey
 )
 
 // RequestContext contains the context information for the current HTTP request. It's a wrapper
 // around Google's Context (http://godoc.org/code.google.com/p/go.net/context), which provides
 // facilities for sending request-scoped values, cancelation signals, and deadlines
 // across API boundaries to all the goroutines involved in handling a request.
+//
+// A RequestContext is safe for concurrent use, so handlers may pass it to goroutines
+// they start. Values, including the status, error, and result, are immutable once set
+// since setting one derives a new RequestContext, and messages are shared by a
+// RequestContext and those derived from it. Accessors returning maps or slices return
+// copies.
 type RequestContext interface {
 	context.Context
 
@@ -113,6 +122,14 @@ type RequestContext interface {
 	// Limit returns the maximum number of results that should be fetched.
 	Limit() int
 
+	// Filters returns the QueryFilters specified using filter query string variables,
+	// e.g. filter[name]=bob or filter[age][gt]=30, ordered by field.
+	Filters() []QueryFilter
+
+	// Sort returns the SortFields specified using the "sort" query string variable,
+	// e.g. sort=name,-age, in order of precedence.
+	Sort() []SortField
+
 	// Messages returns all of the messages set by the request handler to be included in
 	// the response.
 	Messages() []string
@@ -120,8 +137,43 @@ type RequestContext interface {
 	// AddMessage adds a message to the request messages to be included in the response.
 	AddMessage(string)
 
-	// Header returns the header key-value pairs for the request.
+	// Header returns the header key-value pairs for the request. The returned Header is
+	// a copy which may be modified.
 	Header() http.Header
+
+	// OutgoingHeaders returns the request's values for the Configuration's
+	// PropagatedHeaders, which should be forwarded on downstream requests. A generated
+	// request ID is included if the request didn't have one. The returned Header is a
+	// copy which may be modified.
+	OutgoingHeaders() http.Header
+
+	// Replayed returns true if the request is being replayed from a Recording using
+	// Replay, in which case handlers may want to avoid real side effects.
+	Replayed() bool
+
+	// WrapHTTPClient returns a copy of the http.Client, or the default client if nil,
+	// which adds the OutgoingHeaders to every request it sends unless the request
+	// already sets them.
+	WrapHTTPClient(*http.Client) *http.Client
+
+	// RawBody returns the request body exactly as it was received, before any decoding,
+	// normalization, or Rules were applied. This is useful for verifying signatures.
+	// Returns nil if the body has not been read.
+	RawBody() []byte
+
+	// setRawBody sets the raw request body.
+	setRawBody([]byte) RequestContext
+
+	// ConsistencyToken returns the consistency token presented by the request using the
+	// ConsistencyTokenHeader, or in a link to the next page of results, defaulting to an
+	// empty string. Read handlers may use it to route to the primary or wait for
+	// replication. Its meaning is up to the handlers which set it.
+	ConsistencyToken() string
+
+	// SetConsistencyToken sets the consistency token returned in the response's
+	// ConsistencyTokenHeader, e.g. by a mutating handler so clients can present it to
+	// read their writes. It's also carried in the link to the next page of results.
+	SetConsistencyToken(string)
 }
 
 // gorillaRequestContext is an implementation of the RequestContext interface. It wraps
@@ -130,14 +182,35 @@ type RequestContext interface {
 type gorillaRequestContext struct {
 	context.Context
 	req      *http.Request
+	messages *messageLog
+}
+
+// messageLog holds the messages to include in a response. It's safe for concurrent use.
+type messageLog struct {
+	mu       sync.Mutex
 	messages []string
 }
 
+// add appends the message.
+func (m *messageLog) add(message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+}
+
+// list returns a copy of the messages.
+func (m *messageLog) list() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.messages...)
+}
+
 // NewContext returns a RequestContext populated with parameters from the request path and
-// query string.
+// query string. If the parent is nil, the RequestContext has the request's deadline
+// under the Configuration's DeadlineBudget, if any.
 func NewContext(parent context.Context, req *http.Request) RequestContext {
 	if parent == nil {
-		parent = context.Background()
+		parent = requestParent(req)
 	}
 
 	for key, value := range req.URL.Query() {
@@ -162,7 +235,7 @@ func NewContext(parent context.Context, req *http.Request) RequestContext {
 	// parameters with the same name as query string values. Figure out a
 	// better way to handle this.
 
-	return &gorillaRequestContext{parent, req, []string{}}
+	return &gorillaRequestContext{parent, req, &messageLog{}}
 }
 
 // WithValue returns a new RequestContext with the provided key-value pair and this context
@@ -176,6 +249,13 @@ func (ctx *gorillaRequestContext) WithValue(key, value interface{}) RequestConte
 	panic("Unable to set value on context: no request")
 }
 
+// withCancel returns a copy of the RequestContext which is canceled when the returned
+// function is called or this context is done. The copy has its own messages.
+func (ctx *gorillaRequestContext) withCancel() (*gorillaRequestContext, context.CancelFunc) {
+	parent, cancel := context.WithCancel(ctx)
+	return &gorillaRequestContext{parent, ctx.req, &messageLog{}}, cancel
+}
+
 // Value returns Gorilla's context package's value for this Context's request
 // and key. It delegates to the parent Context if there is no such value.
 func (ctx *gorillaRequestContext) Value(key interface{}) interface{} {
@@ -256,22 +336,86 @@ func (ctx *gorillaRequestContext) setResult(result interface{}) RequestContext {
 // Cursor returns the current result cursor for the request, defaulting to an empty
 // string if one hasn't been set.
 func (ctx *gorillaRequestContext) Cursor() string {
+	// A cursor set by the handler takes precedence over the one in the query string.
+	if cursor, ok := ctx.Value(nextCursorKey).(string); ok {
+		return cursor
+	}
 	return ctx.ValueWithDefault(cursorKey, "").(string)
 }
 
 // setCursor sets the current result cursor for the request.
 func (ctx *gorillaRequestContext) setCursor(cursor string) RequestContext {
-	return ctx.WithValue(cursorKey, cursor)
+	return ctx.WithValue(nextCursorKey, cursor)
 }
 
-// Header returns the header key-value pairs for the request.
+// Header returns the header key-value pairs for the request. The returned Header is a
+// copy which may be modified.
 func (ctx *gorillaRequestContext) Header() http.Header {
 	req, ok := ctx.Request()
 	if !ok {
 		return http.Header{}
 	}
 
-	return req.Header
+	header := make(http.Header, len(req.Header))
+	for name, values := range req.Header {
+		header[name] = append([]string{}, values...)
+	}
+	return header
+}
+
+// OutgoingHeaders returns the request's values for the Configuration's
+// PropagatedHeaders, which should be forwarded on downstream requests. The returned
+// Header is a copy which may be modified.
+func (ctx *gorillaRequestContext) OutgoingHeaders() http.Header {
+	req, ok := ctx.Request()
+	if !ok {
+		return http.Header{}
+	}
+	return outgoingHeaders(req)
+}
+
+// WrapHTTPClient returns a copy of the http.Client, or the default client if nil,
+// which adds the OutgoingHeaders to every request it sends unless the request already
+// sets them.
+func (ctx *gorillaRequestContext) WrapHTTPClient(client *http.Client) *http.Client {
+	return wrapHTTPClient(client, ctx.OutgoingHeaders())
+}
+
+// Replayed returns true if the request is being replayed from a Recording using
+// Replay.
+func (ctx *gorillaRequestContext) Replayed() bool {
+	req, ok := ctx.Request()
+	return ok && IsReplay(req)
+}
+
+// RawBody returns the request body exactly as it was received, before any decoding,
+// normalization, or Rules were applied. Returns nil if the body has not been read.
+func (ctx *gorillaRequestContext) RawBody() []byte {
+	body, _ := ctx.Value(rawBodyKey).([]byte)
+	return body
+}
+
+// setRawBody sets the raw request body.
+func (ctx *gorillaRequestContext) setRawBody(body []byte) RequestContext {
+	return ctx.WithValue(rawBodyKey, body)
+}
+
+// ConsistencyToken returns the consistency token presented by the request, defaulting
+// to an empty string.
+func (ctx *gorillaRequestContext) ConsistencyToken() string {
+	req, ok := ctx.Request()
+	if !ok {
+		return ""
+	}
+	return consistencyToken(req)
+}
+
+// SetConsistencyToken sets the consistency token returned in the response. It's shared
+// by every RequestContext for the request.
+func (ctx *gorillaRequestContext) SetConsistencyToken(token string) {
+	if req, ok := ctx.Request(); ok {
+		gcontext.Set(req, responseConsistencyTokenKey{}, token)
+	}
 }
 
 // Request returns the *http.Request associated with context using NewContext, if any.
@@ -293,9 +437,30 @@ func (ctx *gorillaRequestContext) Limit() int {
 	return limit
 }
 
+// Filters returns the QueryFilters specified using filter query string variables,
+// e.g. filter[name]=bob or filter[age][gt]=30, ordered by field.
+func (ctx *gorillaRequestContext) Filters() []QueryFilter {
+	req, ok := ctx.Request()
+	if !ok {
+		return []QueryFilter{}
+	}
+	return parseFilters(req.URL.Query())
+}
+
+// Sort returns the SortFields specified using the "sort" query string variable,
+// e.g. sort=name,-age, in order of precedence.
+func (ctx *gorillaRequestContext) Sort() []SortField {
+	req, ok := ctx.Request()
+	if !ok {
+		return []SortField{}
+	}
+	return parseSort(req.URL.Query())
+}
+
 // NextURL returns the URL to use to request the next page of results using the current
-// cursor. If there is no cursor for this request or the URL fails to be built, an empty
-// string is returned with the error set.
+// cursor. The consistency token set for the response, or else the one presented by the
+// request, is carried in the URL. If there is no cursor for this request or the URL
+// fails to be built, an empty string is returned with the error set.
 func (ctx *gorillaRequestContext) NextURL() (string, error) {
 	cursor := ctx.Cursor()
 	if cursor == "" {
@@ -307,8 +472,25 @@ func (ctx *gorillaRequestContext) NextURL() (string, error) {
 		return "", fmt.Errorf("Unable to build next url: no request")
 	}
 
-	var scheme string
-	scheme = r.URL.Scheme
+	u, err := requestURL(r)
+	if err != nil {
+		return "", fmt.Errorf("Unable to build next url: %s", err)
+	}
+
+	q := u.Query()
+	q.Set("next", cursor)
+	if token, ok := responseConsistencyToken(r); ok && token != "" {
+		q.Set(consistencyTokenKey, token)
+	} else if token := consistencyToken(r); token != "" {
+		q.Set(consistencyTokenKey, token)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// requestURL returns the absolute URL of the request.
+func requestURL(r *http.Request) (*url.URL, error) {
+	scheme := r.URL.Scheme
 	if scheme == "" {
 		scheme = "http"
 	}
@@ -316,19 +498,15 @@ func (ctx *gorillaRequestContext) NextURL() (string, error) {
 	urlStr := fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
 	u, err := url.Parse(urlStr)
 	if err != nil {
-		return "", fmt.Errorf("Unable to build next url: %s", urlStr)
+		return nil, fmt.Errorf("invalid url %s", urlStr)
 	}
-
-	q := u.Query()
-	q.Set("next", cursor)
-	u.RawQuery = q.Encode()
-	return u.String(), nil
+	return u, nil
 }
 
 // Messages returns all of the messages set by the request handler to be included in
 // the response.
 func (ctx *gorillaRequestContext) Messages() []string {
-	messages := ctx.messages
+	messages := ctx.messages.list()
 	if err := ctx.Error(); err != nil {
 		messages = append(messages, err.Error())
 	}
@@ -337,5 +515,5 @@ func (ctx *gorillaRequestContext) Messages() []string {
 
 // AddMessage adds a message to the request messages to be included in the response.
 func (ctx *gorillaRequestContext) AddMessage(message string) {
-	ctx.messages = append(ctx.messages, message)
+	ctx.messages.add(message)
 }
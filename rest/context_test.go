@@ -19,6 +19,8 @@ package rest
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -97,3 +99,107 @@ func TestHeader(t *testing.T) {
 
 	assert.Equal(req.Header, ctx.Header())
 }
+
+// Ensures that Header returns a copy of the request Header.
+func TestHeaderCopy(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Set("X-Foo", "bar")
+	ctx := NewContext(nil, req)
+
+	ctx.Header().Set("X-Foo", "baz")
+	assert.Equal("bar", req.Header.Get("X-Foo"))
+}
+
+// Ensures that every RequestContext accessor and mutator is safe to call from many
+// goroutines at once and that messages added concurrently are all kept. Run with
+// -race to detect violations.
+func TestRequestContextConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET",
+		"http://example.com/api/v1/foo?limit=5&next=abc&format=json&filter[name]=bob&sort=-age", nil)
+	req.RequestURI = req.URL.RequestURI()
+	req.Header.Set("X-Foo", "bar")
+	ctx := NewContext(nil, req).setCursor("def")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				derived := ctx.WithValue("key", i).setStatus(201).setError(fmt.Errorf("err")).
+					setResult(i).setCursor("ghi").setRawBody([]byte("body"))
+				derived.AddMessage(fmt.Sprintf("%d-%d", i, j))
+				NewContext(nil, req)
+
+				ctx.Value("key")
+				ctx.ValueWithDefault("missing", 1)
+				ctx.Request()
+				ctx.NextURL()
+				ctx.ResponseFormat()
+				ctx.ResourceID()
+				ctx.Version()
+				derived.Status()
+				derived.Error()
+				derived.Result()
+				derived.Cursor()
+				derived.RawBody()
+				ctx.Limit()
+				ctx.Filters()
+				ctx.Sort()
+				ctx.Messages()
+				ctx.Header().Set("X-Foo", "baz")
+				ctx.OutgoingHeaders().Set("X-Foo", "baz")
+				ctx.Replayed()
+				ctx.WrapHTTPClient(nil)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(ctx.Messages(), 400)
+	assert.Equal("bar", ctx.Header().Get("X-Foo"))
+	assert.Equal(5, ctx.Limit())
+	assert.Equal(http.StatusOK, ctx.Status())
+}
+
+// Ensures that handlers may use the RequestContext from goroutines they start.
+func TestRequestContextConcurrentHandler(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(concurrentHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/concurrent/1", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"messages":["fetched","fetched","fetched","fetched"]`)
+}
+
+// concurrentHandler is a ResourceHandler which reads resources using several
+// goroutines sharing the RequestContext.
+type concurrentHandler struct {
+	BaseResourceHandler
+}
+
+func (c concurrentHandler) ResourceName() string {
+	return "concurrent"
+}
+
+func (c concurrentHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx.ResourceID()
+			ctx.Header()
+			ctx.AddMessage("fetched")
+		}()
+	}
+	wg.Wait()
+	return id, nil
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// anyOrigin is the AllowedOrigins entry which allows every origin.
+const anyOrigin = "*"
+
+// CORSPolicy determines which cross-origin requests browsers may make to a resource.
+// The Configuration's CORS policy applies to every resource, and a CORSPolicy passed
+// as a ResourceOption replaces it for that resource. Resources without a policy allow
+// no cross-origin requests, so a resource can deny them while others allow them by
+// registering with an empty CORSPolicy.
+//
+// Preflight requests are answered from the resource's policy without authentication.
+// Allowing credentials with any origin is rejected at registration since browsers
+// would refuse the responses.
+type CORSPolicy struct {
+	// AllowedOrigins are the origins, e.g. https://app.example.com, allowed to make
+	// requests. "*" allows any origin.
+	AllowedOrigins []string
+
+	// AllowedMethods are the methods allowed in cross-origin requests. Defaults to
+	// every method the resource serves at the requested URI.
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers allowed in cross-origin requests beyond
+	// those browsers always allow.
+	AllowedHeaders []string
+
+	// ExposedHeaders are the response headers browsers expose to scripts beyond those
+	// they always expose.
+	ExposedHeaders []string
+
+	// AllowCredentials indicates if requests may include cookies or HTTP
+	// authentication. Defaults to false.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache preflight responses. Defaults to none.
+	MaxAge time.Duration
+}
+
+// apply sets the CORSPolicy on the resource.
+func (c CORSPolicy) apply(opts *resourceOptions) {
+	opts.cors = &c
+}
+
+// validate returns an error if the policy's settings conflict.
+func (c *CORSPolicy) validate() error {
+	if c.AllowCredentials && c.allowsAnyOrigin() {
+		return fmt.Errorf("CORS policy can't allow credentials from any origin")
+	}
+	return nil
+}
+
+// allowsAnyOrigin returns true if the policy allows every origin.
+func (c *CORSPolicy) allowsAnyOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == anyOrigin {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsOrigin returns true if the policy allows requests from the origin.
+func (c *CORSPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == anyOrigin || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// setOriginHeaders sets the headers allowing the response to be read by the origin.
+func (c *CORSPolicy) setOriginHeaders(header http.Header, origin string) {
+	if c.allowsAnyOrigin() {
+		header.Set("Access-Control-Allow-Origin", anyOrigin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+	if c.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// effectiveCORSPolicy returns the CORSPolicy for a resource registered with the
+// options, or nil if it allows no cross-origin requests. It panics if the policy's
+// settings conflict.
+func (r *muxAPI) effectiveCORSPolicy(resource string, opts *resourceOptions) *CORSPolicy {
+	policy := r.config.CORS
+	if opts.cors != nil {
+		policy = opts.cors
+	}
+	if policy == nil {
+		return nil
+	}
+	if err := policy.validate(); err != nil {
+		panic(fmt.Sprintf("Invalid CORS policy for %s: %s", resource, err))
+	}
+	return policy
+}
+
+// newCORSMiddleware returns a RequestMiddleware which allows cross-origin requests
+// permitted by the CORSPolicy to be read.
+func newCORSMiddleware(policy *CORSPolicy) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		if policy == nil {
+			return wrapped
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			if origin := r.Header.Get("Origin"); origin != "" && policy.allowsOrigin(origin) {
+				policy.setOriginHeaders(w.Header(), origin)
+				if len(policy.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers",
+						strings.Join(policy.ExposedHeaders, ", "))
+				}
+			}
+			wrapped(w, r)
+		}
+	}
+}
+
+// preflightRoutes returns OPTIONS routes answering preflight requests for each of the
+// routes' URIs using the CORSPolicy. Returns none if the policy is nil, in which case
+// preflight requests aren't routed.
+func preflightRoutes(policy *CORSPolicy, routes []resourceRoute) []resourceRoute {
+	if policy == nil {
+		return nil
+	}
+
+	uris := []string{}
+	methods := map[string][]string{}
+	for _, route := range routes {
+		if route.override != "" {
+			continue
+		}
+		if _, ok := methods[route.uri]; !ok {
+			uris = append(uris, route.uri)
+		}
+		methods[route.uri] = append(methods[route.uri], route.method)
+	}
+
+	preflights := make([]resourceRoute, len(uris))
+	for i, uri := range uris {
+		allowed := policy.AllowedMethods
+		if len(allowed) == 0 {
+			allowed = methods[uri]
+		}
+		preflights[i] = resourceRoute{
+			fmt.Sprintf("preflight%d", i), "preflight", "OPTIONS", uri, "",
+			handlePreflight(policy, allowed),
+		}
+	}
+	return preflights
+}
+
+// handlePreflight returns an http.HandlerFunc which answers preflight requests using
+// the CORSPolicy and allowed methods. Requests the policy doesn't allow receive a 403
+// without CORS headers.
+func handlePreflight(policy *CORSPolicy, methods []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if origin == "" || !policy.allowsOrigin(origin) || !containsFold(methods, method) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		for _, requested := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			requested = strings.TrimSpace(requested)
+			if requested != "" && !containsFold(policy.AllowedHeaders, requested) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		policy.setOriginHeaders(w.Header(), origin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(policy.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers",
+				strings.Join(policy.AllowedHeaders, ", "))
+		}
+		if policy.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age",
+				strconv.Itoa(int(policy.MaxAge/time.Second)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// containsFold returns true if the value is in the slice, ignoring case.
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// namedHandler is a ResourceHandler for an arbitrary resource name.
+type namedHandler struct {
+	BaseResourceHandler
+	name string
+}
+
+func (n namedHandler) ResourceName() string {
+	return n.name
+}
+
+func (n namedHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &TestResource{Foo: id}, nil
+}
+
+// serveCORS sends a request from the origin to the API and returns the response.
+func serveCORS(api API, method, uri, origin string, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "http://foo.com"+uri, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// newCORSAPI returns an API with public, dashboard, and internal resources whose CORS
+// policies differ.
+func newCORSAPI() API {
+	api := NewAPI(&Configuration{CORS: &CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedHeaders:   []string{"Authorization"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}})
+	api.RegisterResourceHandler(namedHandler{name: "widgets"},
+		CORSPolicy{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}})
+	api.RegisterResourceHandler(namedHandler{name: "dashboards"})
+	api.RegisterResourceHandler(namedHandler{name: "internal"}, CORSPolicy{})
+	return api
+}
+
+// Ensures that preflight requests are answered from each resource's effective policy.
+func TestCORSPreflight(t *testing.T) {
+	assert := assert.New(t)
+	api := newCORSAPI()
+	preflight := http.Header{"Access-Control-Request-Method": {"PUT"},
+		"Access-Control-Request-Headers": {"authorization"}}
+
+	resp := serveCORS(api, "OPTIONS", "/api/v1/dashboards/1", "https://app.example.com",
+		preflight)
+	assert.Equal(http.StatusNoContent, resp.Code)
+	assert.Equal("https://app.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("true", resp.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal("GET, PUT, DELETE", resp.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal("Authorization", resp.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal("3600", resp.Header().Get("Access-Control-Max-Age"))
+
+	resp = serveCORS(api, "OPTIONS", "/api/v1/dashboards/1", "https://evil.com", preflight)
+	assert.Equal(http.StatusForbidden, resp.Code)
+	assert.Equal("", resp.Header().Get("Access-Control-Allow-Origin"))
+
+	resp = serveCORS(api, "OPTIONS", "/api/v1/widgets", "https://evil.com", preflight)
+	assert.Equal(http.StatusForbidden, resp.Code, "Method not allowed by the policy")
+
+	resp = serveCORS(api, "OPTIONS", "/api/v1/widgets", "https://evil.com",
+		http.Header{"Access-Control-Request-Method": {"GET"}})
+	assert.Equal(http.StatusNoContent, resp.Code)
+	assert.Equal("*", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("", resp.Header().Get("Access-Control-Allow-Credentials"))
+
+	resp = serveCORS(api, "OPTIONS", "/api/v1/internal/1", "https://app.example.com",
+		preflight)
+	assert.Equal(http.StatusForbidden, resp.Code)
+}
+
+// Ensures that responses to cross-origin requests only allow origins permitted by the
+// resource's policy.
+func TestCORSRequests(t *testing.T) {
+	assert := assert.New(t)
+	api := newCORSAPI()
+
+	resp := serveCORS(api, "GET", "/api/v1/dashboards/1", "https://app.example.com", nil)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("https://app.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("Origin", resp.Header().Get("Vary"))
+
+	resp = serveCORS(api, "GET", "/api/v1/widgets/1", "https://evil.com", nil)
+	assert.Equal("*", resp.Header().Get("Access-Control-Allow-Origin"))
+
+	resp = serveCORS(api, "GET", "/api/v1/internal/1", "https://app.example.com", nil)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("", resp.Header().Get("Access-Control-Allow-Origin"))
+}
+
+// Ensures that resources are denied cross-origin requests without any policy and that
+// conflicting policies are rejected at registration.
+func TestCORSDefaultsAndConflicts(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(namedHandler{name: "widgets"})
+
+	resp := serveCORS(api, "OPTIONS", "/api/v1/widgets", "https://app.example.com",
+		http.Header{"Access-Control-Request-Method": {"GET"}})
+	assert.Equal("", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.NotEqual(http.StatusNoContent, resp.Code)
+
+	assert.Panics(func() {
+		api.RegisterResourceHandler(namedHandler{name: "gadgets"},
+			CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	})
+	assert.Panics(func() {
+		NewAPI(&Configuration{CORS: &CORSPolicy{AllowedOrigins: []string{"*"},
+			AllowCredentials: true}}).RegisterResourceHandler(namedHandler{name: "gadgets"})
+	})
+}
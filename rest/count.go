@@ -0,0 +1,91 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TotalCountHeader is the response header carrying the number of resources
+	// matching a count request.
+	TotalCountHeader = "X-Total-Count"
+
+	// countOnlyKey is the query parameter which requests only the count from the read
+	// list endpoint.
+	countOnlyKey = "count_only"
+
+	// countPath is appended to the read list URI to form the count URI.
+	countPath = "/count"
+)
+
+// ResourceCounter is implemented by ResourceHandlers which can count their resources
+// without reading them. This is mapped to GET and HEAD /api/:version/resourceName/count
+// and to the read list endpoint with ?count_only=true. Handlers which don't implement
+// it don't have a count endpoint, and their read list endpoint responds to
+// ?count_only=true with a 404 like it.
+type ResourceCounter interface {
+	// CountResources returns the number of resources matching the Filters accessed
+	// through the RequestContext.
+	CountResources(RequestContext, string) (int64, error)
+}
+
+// countURI returns the URI of the count endpoint for the ResourceHandler.
+func countURI(h ResourceHandler) string {
+	return strings.TrimSuffix(h.ReadListURI(), "/") + countPath
+}
+
+// countOnly returns true if the request asks for only the count of resources.
+func countOnly(r *http.Request) bool {
+	countOnly, _ := strconv.ParseBool(r.URL.Query().Get(countOnlyKey))
+	return countOnly
+}
+
+// handleCount returns a HandlerFunc which will pass the request's Filters to the
+// provided count function and then serialize and dispatch a response containing only
+// the count. The count is also sent in the X-Total-Count header.
+func (h requestHandler) handleCount(handler ResourceHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(nil, r)
+		counter, ok := unwrapHandler(handler).(ResourceCounter)
+		if !ok {
+			h.sendResponse(w, ctx.setError(ResourceNotFound(
+				fmt.Sprintf("Counting is not supported for %s", handler.ResourceName()))))
+			return
+		}
+
+		count, err := counter.CountResources(ctx, ctx.Version())
+		if err != nil {
+			h.sendResponse(w, ctx.setError(err))
+			return
+		}
+
+		format := ctx.ResponseFormat()
+		serializer, err := h.responseSerializer(format)
+		if err != nil {
+			// sendResponse reports the unimplemented format.
+			h.sendResponse(w, ctx)
+			return
+		}
+		w.Header().Set(TotalCountHeader, strconv.FormatInt(count, 10))
+		sendResponse(w, response{Payload: Payload{"count": count}, Status: http.StatusOK},
+			serializer, h.Configuration().ResponseDigest)
+	}
+}
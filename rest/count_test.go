@@ -0,0 +1,71 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that the count endpoint and count_only flag return only the number of
+// resources matching the filters, in the body and the X-Total-Count header.
+func TestCountResources(t *testing.T) {
+	assert := assert.New(t)
+	api, _ := newWidgetAPI(t, InMemoryOptions{})
+	for i, name := range []string{"gear", "cog", "axle", "bolt", "spring"} {
+		serveInMemory(api, "POST", "/api/v1/widgets",
+			fmt.Sprintf(`{"name":"%s","size":%d}`, name, i%3))
+	}
+
+	resp, _ := serveInMemory(api, "GET", "/api/v1/widgets/count", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(`{"count":5}`, resp.Body.String())
+	assert.Equal("5", resp.Header().Get(TotalCountHeader))
+
+	resp, _ = serveInMemory(api, "GET", "/api/v1/widgets/count?filter[size][gte]=1", "")
+	assert.Equal(`{"count":3}`, resp.Body.String())
+	assert.Equal("3", resp.Header().Get(TotalCountHeader))
+
+	resp, _ = serveInMemory(api, "HEAD", "/api/v1/widgets/count?filter[size]=0", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("2", resp.Header().Get(TotalCountHeader))
+
+	resp, _ = serveInMemory(api, "GET", "/api/v1/widgets?count_only=true&filter[name]=cog", "")
+	assert.Equal(`{"count":1}`, resp.Body.String())
+
+	resp, _ = serveInMemory(api, "GET", "/api/v1/widgets/count?filter[color]=red", "")
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.Equal("", resp.Header().Get(TotalCountHeader))
+}
+
+// Ensures that handlers which don't implement ResourceCounter have no count endpoint
+// and respond to the count_only flag with a 404 like the missing endpoint.
+func TestCountResourcesNotSupported(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(&blockingHandler{})
+
+	resp := serve(api, "HEAD", "http://foo.com/api/v1/foo/count")
+	assert.Equal(http.StatusNotFound, resp.Code)
+
+	resp = serve(api, "GET", "http://foo.com/api/v1/foo?count_only=true")
+	assert.Equal(http.StatusNotFound, resp.Code)
+	assert.Contains(resp.Body.String(), "Counting is not supported for foo")
+}
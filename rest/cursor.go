@@ -0,0 +1,170 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// StaleCursorCode is the error code included in responses to requests whose
+	// pagination cursor can no longer be resumed.
+	StaleCursorCode = "stale_cursor"
+
+	// defaultStaleCursorStatus is the status returned for stale cursors if the
+	// Configuration doesn't specify one.
+	defaultStaleCursorStatus = http.StatusGone
+
+	// cursorSeparator separates the version tag from the position in an encoded
+	// cursor.
+	cursorSeparator = ":"
+
+	// signatureSeparator separates the position from its signature in a signed
+	// cursor.
+	signatureSeparator = "."
+)
+
+// CursorVersioner can be implemented by a ResourceHandler to have the framework
+// manage opaque, versioned pagination cursors. The cursor returned by
+// ReadResourceList is encoded along with the CursorVersion before being sent to the
+// client, and cursors received from clients are decoded before being passed to
+// ReadResourceList. If a received cursor was encoded with a different version, e.g.
+// because the sort order changed, the request is rejected as stale before the
+// handler is invoked.
+type CursorVersioner interface {
+	// CursorVersion returns the tag identifying the current cursor format.
+	CursorVersion() string
+}
+
+// EncodeCursor returns an opaque cursor embedding the version tag and position.
+func EncodeCursor(version, position string) string {
+	return base64.URLEncoding.EncodeToString([]byte(version + cursorSeparator + position))
+}
+
+// DecodeCursor returns the version tag and position embedded in a cursor created
+// with EncodeCursor. Returns an error if the cursor is malformed.
+func DecodeCursor(cursor string) (string, string, error) {
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", fmt.Errorf("Malformed cursor: %s", cursor)
+	}
+	parts := strings.SplitN(string(decoded), cursorSeparator, 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("Malformed cursor: %s", cursor)
+	}
+	return parts[0], parts[1], nil
+}
+
+// SignCursor returns an opaque cursor embedding the position along with an HMAC-SHA256
+// signature using the key, so clients can't forge or alter positions.
+func SignCursor(key []byte, position string) string {
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(position))
+	return encoded + signatureSeparator +
+		base64.RawURLEncoding.EncodeToString(cursorSignature(key, encoded))
+}
+
+// VerifyCursor returns the position embedded in a cursor created with SignCursor using
+// the key. Returns an error if the cursor is malformed or its signature doesn't match.
+func VerifyCursor(key []byte, cursor string) (string, error) {
+	parts := strings.Split(cursor, signatureSeparator)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("Malformed cursor: %s", cursor)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, cursorSignature(key, parts[0])) {
+		return "", fmt.Errorf("Invalid cursor signature: %s", cursor)
+	}
+	position, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("Malformed cursor: %s", cursor)
+	}
+	return string(position), nil
+}
+
+// cursorSignature returns the HMAC-SHA256 of the encoded position using the key.
+func cursorSignature(key []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// StaleCursor returns a Error for a pagination cursor which references data or a sort
+// order which no longer exists. The response includes the "stale_cursor" code and a
+// URL from which to restart pagination. It's returned with a 410 Gone unless the
+// Configuration specifies a different StaleCursorStatus.
+func StaleCursor(reason string) Error {
+	return Error{reason: reason, status: defaultStaleCursorStatus, code: StaleCursorCode}
+}
+
+// staleCursorStatus returns the status to respond to stale cursors with.
+func staleCursorStatus(config *Configuration) int {
+	if config == nil || config.StaleCursorStatus == 0 {
+		return defaultStaleCursorStatus
+	}
+	return config.StaleCursorStatus
+}
+
+// staleCursorError returns the error with its status set to the configured stale
+// cursor status if it's a StaleCursor Error.
+func staleCursorError(err error, config *Configuration) error {
+	restError, ok := err.(Error)
+	if !ok || restError.Code() != StaleCursorCode {
+		return err
+	}
+	restError.status = staleCursorStatus(config)
+	return restError
+}
+
+// decodeVersionedCursor returns the position embedded in the request cursor for the
+// CursorVersioner. Returns a StaleCursor Error if the cursor is malformed or its
+// version doesn't match the handler's current version.
+func decodeVersionedCursor(versioner CursorVersioner, cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+	version, position, err := DecodeCursor(cursor)
+	if err != nil {
+		return "", StaleCursor(err.Error())
+	}
+	if version != versioner.CursorVersion() {
+		return "", StaleCursor("Cursor is stale, restart pagination")
+	}
+	return position, nil
+}
+
+// restartURL returns the URL of the request without its pagination cursor.
+func restartURL(ctx RequestContext) (string, error) {
+	r, ok := ctx.Request()
+	if !ok {
+		return "", fmt.Errorf("Unable to build restart url: no request")
+	}
+
+	u, err := requestURL(r)
+	if err != nil {
+		return "", fmt.Errorf("Unable to build restart url: %s", err)
+	}
+
+	q := u.Query()
+	q.Del(cursorKey)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// versionedCursorHandler is a ResourceHandler which implements CursorVersioner.
+type versionedCursorHandler struct {
+	BaseResourceHandler
+	cursor string
+	err    error
+	called bool
+}
+
+func (v *versionedCursorHandler) ResourceName() string {
+	return "foo"
+}
+
+func (v *versionedCursorHandler) CursorVersion() string {
+	return "by-name"
+}
+
+func (v *versionedCursorHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	v.called = true
+	v.cursor = cursor
+	if v.err != nil {
+		return nil, "", v.err
+	}
+	return []Resource{&TestResource{Foo: "hello"}}, "bob", nil
+}
+
+// Ensures that DecodeCursor returns the version and position encoded by EncodeCursor
+// and rejects malformed cursors.
+func TestEncodeDecodeCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	version, position, err := DecodeCursor(EncodeCursor("v2", "a:b"))
+	assert.Nil(err)
+	assert.Equal("v2", version)
+	assert.Equal("a:b", position)
+
+	_, _, err = DecodeCursor("!!!")
+	assert.NotNil(err)
+
+	_, _, err = DecodeCursor(EncodeCursor("", "")[:2])
+	assert.NotNil(err)
+}
+
+// Ensures that the read list handler decodes versioned cursors before invoking the
+// handler and encodes the returned cursor.
+func TestHandleReadListVersionedCursor(t *testing.T) {
+	assert := assert.New(t)
+	handler := &versionedCursorHandler{}
+	api := NewAPI(&Configuration{})
+
+	api.RegisterResourceHandler(handler)
+	readListHandler, _ := api.(*muxAPI).getRouteHandler("foo:readList")
+
+	req, _ := http.NewRequest("GET",
+		"http://foo.com/api/v0.1/foo?next="+EncodeCursor("by-name", "alice"), nil)
+	req.RequestURI = req.URL.RequestURI()
+	resp := httptest.NewRecorder()
+
+	readListHandler.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusOK, resp.Code, "Incorrect response code")
+	assert.Equal("alice", handler.cursor)
+	assert.Contains(resp.Body.String(), `"next":"http://foo.com/api/v0.1/foo?next=`+
+		url.QueryEscape(EncodeCursor("by-name", "bob")))
+}
+
+// Ensures that the read list handler rejects cursors with a stale version without
+// invoking the handler.
+func TestHandleReadListStaleCursor(t *testing.T) {
+	assert := assert.New(t)
+	handler := &versionedCursorHandler{}
+	api := NewAPI(&Configuration{})
+
+	api.RegisterResourceHandler(handler)
+	readListHandler, _ := api.(*muxAPI).getRouteHandler("foo:readList")
+
+	req, _ := http.NewRequest("GET",
+		"http://foo.com/api/v0.1/foo?limit=5&next="+EncodeCursor("by-date", "alice"), nil)
+	req.RequestURI = req.URL.RequestURI()
+	resp := httptest.NewRecorder()
+
+	readListHandler.ServeHTTP(resp, req)
+
+	assert.False(handler.called, "Handler was invoked")
+	assert.Equal(http.StatusGone, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"code":"stale_cursor","messages":["Cursor is stale, restart pagination"],"reason":"Gone","restart":"http://foo.com/api/v0.1/foo?limit=5","status":410}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+}
+
+// Ensures that a StaleCursor returned by the handler uses the configured status.
+func TestHandleReadListStaleCursorFromHandler(t *testing.T) {
+	assert := assert.New(t)
+	handler := &versionedCursorHandler{err: StaleCursor("Row was deleted")}
+	api := NewAPI(&Configuration{StaleCursorStatus: http.StatusBadRequest})
+
+	api.RegisterResourceHandler(handler)
+	readListHandler, _ := api.(*muxAPI).getRouteHandler("foo:readList")
+
+	req, _ := http.NewRequest("GET",
+		"http://foo.com/api/v0.1/foo?next="+EncodeCursor("by-name", "alice"), nil)
+	req.RequestURI = req.URL.RequestURI()
+	resp := httptest.NewRecorder()
+
+	readListHandler.ServeHTTP(resp, req)
+
+	assert.True(handler.called, "Handler was not invoked")
+	assert.Equal(http.StatusBadRequest, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"code":"stale_cursor","messages":["Row was deleted"],"reason":"Bad Request","restart":"http://foo.com/api/v0.1/foo","status":400}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+}
+
+// Ensures that VerifyCursor returns the position signed by SignCursor and rejects
+// cursors which were altered or signed with a different key.
+func TestSignVerifyCursor(t *testing.T) {
+	assert := assert.New(t)
+	key := []byte("secret")
+	cursor := SignCursor(key, "id:42")
+
+	position, err := VerifyCursor(key, cursor)
+	assert.Nil(err)
+	assert.Equal("id:42", position)
+
+	_, err = VerifyCursor([]byte("other"), cursor)
+	assert.NotNil(err)
+
+	forged := SignCursor(key, "id:43")
+	_, err = VerifyCursor(key, forged[:strings.Index(forged, ".")]+cursor[strings.Index(cursor, "."):])
+	assert.NotNil(err)
+
+	_, err = VerifyCursor(key, "not a cursor")
+	assert.NotNil(err)
+}
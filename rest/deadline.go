@@ -0,0 +1,163 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	gcontext "github.com/gorilla/context"
+)
+
+const (
+	// DeadlineHeader is the default header carrying a request's deadline as
+	// milliseconds since the Unix epoch.
+	DeadlineHeader = "X-Request-Deadline"
+
+	// DeadlineExpiredCode is the error code of responses to requests which arrived
+	// with no deadline budget remaining.
+	DeadlineExpiredCode = "deadline_expired"
+
+	// DeadlineExpiredCounter counts requests rejected because they arrived with no
+	// deadline budget remaining.
+	DeadlineExpiredCounter = "deadline_expired"
+)
+
+// deadlineContextKey is the request context key under which the context carrying a
+// request's deadline is recorded.
+type deadlineContextKey struct{}
+
+// DeadlineBudget propagates request deadlines across services so a timeout at the edge
+// shrinks as a request is passed along rather than resetting at every hop. A resource
+// request's deadline is the earlier of the one in its deadline header, extended by the
+// ClockSkew, and the local Timeout. It's the deadline of the request's RequestContext,
+// and it's sent in the deadline header on requests made using
+// RequestContext#WrapHTTPClient. Requests arriving with less than the MinBudget
+// remaining receive a 504 without being handled.
+type DeadlineBudget struct {
+	// Header is the header carrying deadlines. Defaults to X-Request-Deadline.
+	Header string
+
+	// Timeout is the longest a request may take. Defaults to none, in which case only
+	// deadlines received in the header apply.
+	Timeout time.Duration
+
+	// ClockSkew is how far the clocks of the API's callers may be ahead of its own.
+	// Deadlines received in the header are extended by it, but deadlines are sent
+	// downstream without it so skew allowances don't accumulate across hops.
+	ClockSkew time.Duration
+
+	// MinBudget is the least time remaining for which a request is worth handling.
+	// Defaults to none, in which case only requests whose deadline has passed are
+	// rejected.
+	MinBudget time.Duration
+
+	// now returns the current time. Defaults to time.Now.
+	now func() time.Time
+}
+
+// header returns the header carrying deadlines.
+func (d *DeadlineBudget) header() string {
+	if d.Header != "" {
+		return d.Header
+	}
+	return DeadlineHeader
+}
+
+// time returns the current time.
+func (d *DeadlineBudget) time() time.Time {
+	if d.now != nil {
+		return d.now()
+	}
+	return time.Now()
+}
+
+// deadlines returns the deadline for the request, the deadline to send downstream,
+// and whether the request has one.
+func (d *DeadlineBudget) deadlines(r *http.Request, now time.Time) (time.Time, time.Time,
+	bool) {
+
+	var local, propagated time.Time
+	if d.Timeout > 0 {
+		local = now.Add(d.Timeout)
+		propagated = local
+	}
+	if millis, err := strconv.ParseInt(r.Header.Get(d.header()), 10, 64); err == nil {
+		received := time.Unix(0, millis*int64(time.Millisecond))
+		if local.IsZero() || received.Add(d.ClockSkew).Before(local) {
+			local = received.Add(d.ClockSkew)
+		}
+		if propagated.IsZero() || received.Before(propagated) {
+			propagated = received
+		}
+	}
+	return local, propagated, !local.IsZero()
+}
+
+// newDeadlineMiddleware returns a RequestMiddleware which applies the DeadlineBudget to
+// requests for the resource. Requests are passed through untouched if it's nil.
+func newDeadlineMiddleware(api *muxAPI, resource string, budget *DeadlineBudget) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		if budget == nil {
+			return wrapped
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			now := budget.time()
+			deadline, propagated, ok := budget.deadlines(r, now)
+			if !ok {
+				wrapped(w, r)
+				return
+			}
+			if remaining := deadline.Sub(now); remaining <= 0 || remaining < budget.MinBudget {
+				api.metrics.incr(DeadlineExpiredCounter, resource)
+				api.debugf(resource, "Deadline expired for %s: %s %s (504)", resource,
+					r.Method, r.URL.Path)
+				reason := "Request deadline has passed"
+				if remaining > 0 {
+					reason = fmt.Sprintf("Request deadline budget of %s is below the minimum of %s",
+						remaining, budget.MinBudget)
+				}
+				ctx := NewContext(nil, r).setError(Error{
+					reason: reason,
+					status: http.StatusGatewayTimeout,
+					code:   DeadlineExpiredCode,
+				})
+				api.handler.sendResponse(w, ctx)
+				return
+			}
+
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			defer cancel()
+			gcontext.Set(r, deadlineContextKey{}, ctx)
+			setOutgoingHeader(r, budget.header(),
+				strconv.FormatInt(propagated.UnixNano()/int64(time.Millisecond), 10))
+			wrapped(w, r)
+		}
+	}
+}
+
+// requestParent returns the context carrying the request's deadline, if any, or the
+// background context.
+func requestParent(r *http.Request) context.Context {
+	if ctx, ok := gcontext.Get(r, deadlineContextKey{}).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// inProcessTransport is an http.RoundTripper which serves requests using an API
+// without a network.
+type inProcessTransport struct {
+	api API
+}
+
+func (i inProcessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := httptest.NewRecorder()
+	i.api.ServeHTTP(resp, req)
+	return resp.Result(), nil
+}
+
+// fakeClock is a clock shared by the hops of a chain.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) time() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// hopHandler is a ResourceHandler which takes a second of work and then calls the next
+// hop, returning the deadline of each hop from it onward.
+type hopHandler struct {
+	BaseResourceHandler
+	clock *fakeClock
+	next  API
+}
+
+func (h hopHandler) ResourceName() string {
+	return "hops"
+}
+
+func (h hopHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	h.clock.advance(time.Second)
+	deadline, _ := ctx.Deadline()
+	hop := deadline.Sub(h.clock.time()).String()
+	if h.next == nil {
+		return hop, nil
+	}
+
+	client := ctx.WrapHTTPClient(&http.Client{Transport: inProcessTransport{h.next}})
+	resp, err := client.Get("http://next/api/v1/hops/1")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var envelope struct {
+		Result string
+		Code   string
+	}
+	json.NewDecoder(resp.Body).Decode(&envelope)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Sprintf("%s %d %s", hop, resp.StatusCode, envelope.Code), nil
+	}
+	return hop + " " + envelope.Result, nil
+}
+
+// newHopChain returns the edge API of a chain of hops with the DeadlineBudgets, the
+// first being the edge's.
+func newHopChain(clock *fakeClock, budgets ...*DeadlineBudget) API {
+	var next API
+	for i := len(budgets) - 1; i >= 0; i-- {
+		budgets[i].now = clock.time
+		api := NewAPI(&Configuration{DeadlineBudget: budgets[i]})
+		api.RegisterResourceHandler(hopHandler{clock: clock, next: next})
+		next = api
+	}
+	return next
+}
+
+// Ensures that an edge timeout shrinks as it propagates through a three-hop chain
+// rather than resetting at each hop, even when later hops have longer timeouts.
+func TestDeadlineBudgetPropagates(t *testing.T) {
+	assert := assert.New(t)
+	// Deadlines are sent with millisecond precision.
+	clock := &fakeClock{now: time.Now().Truncate(time.Millisecond)}
+	edge := newHopChain(clock,
+		&DeadlineBudget{Timeout: 5 * time.Second},
+		&DeadlineBudget{Timeout: 30 * time.Second},
+		&DeadlineBudget{Timeout: 30 * time.Second, ClockSkew: 100 * time.Millisecond},
+	)
+
+	resp := serve(edge, "GET", "http://edge/api/v1/hops/1")
+	assert.Equal(http.StatusOK, resp.Code)
+	var envelope struct{ Result string }
+	json.Unmarshal(resp.Body.Bytes(), &envelope)
+	assert.Equal("4s 3s 2.1s", envelope.Result)
+}
+
+// Ensures that a hop which receives an expired budget, or one below its minimum,
+// responds with a 504 and a distinct code without handling the request.
+func TestDeadlineBudgetExpired(t *testing.T) {
+	assert := assert.New(t)
+	clock := &fakeClock{now: time.Now().Truncate(time.Millisecond)}
+	edge := newHopChain(clock,
+		&DeadlineBudget{Timeout: 2 * time.Second},
+		&DeadlineBudget{},
+		&DeadlineBudget{},
+	)
+
+	resp := serve(edge, "GET", "http://edge/api/v1/hops/1")
+	var envelope struct{ Result string }
+	json.Unmarshal(resp.Body.Bytes(), &envelope)
+	assert.Equal(fmt.Sprintf("1s 0s %d %s", http.StatusGatewayTimeout, DeadlineExpiredCode),
+		envelope.Result)
+
+	budget := &DeadlineBudget{MinBudget: time.Second}
+	api := newHopChain(clock, budget)
+	req, _ := http.NewRequest("GET", "http://edge/api/v1/hops/1", nil)
+	req.Header.Set(DeadlineHeader, fmt.Sprint(clock.time().Add(500*time.Millisecond).UnixNano()/
+		int64(time.Millisecond)))
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	assert.Equal(http.StatusGatewayTimeout, rec.Code)
+	assert.True(strings.Contains(rec.Body.String(), "below the minimum"))
+	assert.Equal(uint64(1), api.Metrics().Counter(DeadlineExpiredCounter, "hops"))
+}
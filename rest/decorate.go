@@ -0,0 +1,179 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+// CreateFunc is the signature of ResourceHandler#CreateResource.
+type CreateFunc func(RequestContext, Payload, string) (Resource, error)
+
+// ReadListFunc is the signature of ResourceHandler#ReadResourceList.
+type ReadListFunc func(RequestContext, int, string, string) ([]Resource, string, error)
+
+// ReadFunc is the signature of ResourceHandler#ReadResource.
+type ReadFunc func(RequestContext, string, string) (Resource, error)
+
+// UpdateListFunc is the signature of ResourceHandler#UpdateResourceList.
+type UpdateListFunc func(RequestContext, []Payload, string) ([]Resource, error)
+
+// UpdateFunc is the signature of ResourceHandler#UpdateResource.
+type UpdateFunc func(RequestContext, string, Payload, string) (Resource, error)
+
+// DeleteFunc is the signature of ResourceHandler#DeleteResource.
+type DeleteFunc func(RequestContext, string, string) (Resource, error)
+
+// decoratedHandler is a ResourceHandler which replaces some of the wrapped
+// ResourceHandler's methods with decorated versions and delegates the rest.
+type decoratedHandler struct {
+	ResourceHandler
+	create     CreateFunc
+	readList   ReadListFunc
+	read       ReadFunc
+	updateList UpdateListFunc
+	update     UpdateFunc
+	del        DeleteFunc
+}
+
+// unwrap returns the wrapped ResourceHandler.
+func (d decoratedHandler) unwrap() ResourceHandler {
+	return d.ResourceHandler
+}
+
+// readDecorated indicates if the ResourceHandler, or one it wraps, has its
+// ReadResource decorated with WrapRead.
+func readDecorated(h ResourceHandler) bool {
+	for {
+		if decorated, ok := h.(decoratedHandler); ok && decorated.read != nil {
+			return true
+		}
+		wrapper, ok := h.(handlerWrapper)
+		if !ok {
+			return false
+		}
+		h = wrapper.unwrap()
+	}
+}
+
+// WrapCreate returns a ResourceHandler whose CreateResource is the wrapped
+// ResourceHandler's decorated with the function, e.g. to check invariants in tests.
+// Every other method is delegated to the wrapped ResourceHandler untouched.
+//
+// Optional interfaces the wrapped ResourceHandler implements, such as
+// ResourceCounter or ExampleProvider, are still detected and used when the returned
+// ResourceHandler is registered with an API, but their methods aren't decorated, e.g.
+// a ResourceCounter's CountResources isn't affected by a decorated ReadResourceList.
+// The exception is ResourceResolver, which is ignored when ReadResource is decorated
+// so reads always pass through the decorated function. Type assertions on the
+// returned ResourceHandler outside the framework won't find them. The same applies to WrapReadList, WrapRead, WrapUpdateList,
+// WrapUpdate, and WrapDelete, which may be combined.
+func WrapCreate(h ResourceHandler, mw func(CreateFunc) CreateFunc) ResourceHandler {
+	return decoratedHandler{ResourceHandler: h, create: mw(h.CreateResource)}
+}
+
+// WrapReadList returns a ResourceHandler whose ReadResourceList is the wrapped
+// ResourceHandler's decorated with the function. See WrapCreate.
+func WrapReadList(h ResourceHandler, mw func(ReadListFunc) ReadListFunc) ResourceHandler {
+	return decoratedHandler{ResourceHandler: h, readList: mw(h.ReadResourceList)}
+}
+
+// WrapRead returns a ResourceHandler whose ReadResource is the wrapped
+// ResourceHandler's decorated with the function, e.g. to cache reads. See WrapCreate.
+func WrapRead(h ResourceHandler, mw func(ReadFunc) ReadFunc) ResourceHandler {
+	return decoratedHandler{ResourceHandler: h, read: mw(h.ReadResource)}
+}
+
+// WrapUpdateList returns a ResourceHandler whose UpdateResourceList is the wrapped
+// ResourceHandler's decorated with the function. See WrapCreate.
+func WrapUpdateList(h ResourceHandler, mw func(UpdateListFunc) UpdateListFunc) ResourceHandler {
+	return decoratedHandler{ResourceHandler: h, updateList: mw(h.UpdateResourceList)}
+}
+
+// WrapUpdate returns a ResourceHandler whose UpdateResource is the wrapped
+// ResourceHandler's decorated with the function. See WrapCreate.
+func WrapUpdate(h ResourceHandler, mw func(UpdateFunc) UpdateFunc) ResourceHandler {
+	return decoratedHandler{ResourceHandler: h, update: mw(h.UpdateResource)}
+}
+
+// WrapDelete returns a ResourceHandler whose DeleteResource is the wrapped
+// ResourceHandler's decorated with the function. See WrapCreate.
+func WrapDelete(h ResourceHandler, mw func(DeleteFunc) DeleteFunc) ResourceHandler {
+	return decoratedHandler{ResourceHandler: h, del: mw(h.DeleteResource)}
+}
+
+// CreateResource invokes the decorated CreateResource if there is one, or the
+// wrapped ResourceHandler's otherwise.
+func (d decoratedHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+
+	if d.create != nil {
+		return d.create(ctx, data, version)
+	}
+	return d.ResourceHandler.CreateResource(ctx, data, version)
+}
+
+// ReadResourceList invokes the decorated ReadResourceList if there is one, or the
+// wrapped ResourceHandler's otherwise.
+func (d decoratedHandler) ReadResourceList(ctx RequestContext, limit int, cursor,
+	version string) ([]Resource, string, error) {
+
+	if d.readList != nil {
+		return d.readList(ctx, limit, cursor, version)
+	}
+	return d.ResourceHandler.ReadResourceList(ctx, limit, cursor, version)
+}
+
+// ReadResource invokes the decorated ReadResource if there is one, or the wrapped
+// ResourceHandler's otherwise.
+func (d decoratedHandler) ReadResource(ctx RequestContext, id,
+	version string) (Resource, error) {
+
+	if d.read != nil {
+		return d.read(ctx, id, version)
+	}
+	return d.ResourceHandler.ReadResource(ctx, id, version)
+}
+
+// UpdateResourceList invokes the decorated UpdateResourceList if there is one, or
+// the wrapped ResourceHandler's otherwise.
+func (d decoratedHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+
+	if d.updateList != nil {
+		return d.updateList(ctx, data, version)
+	}
+	return d.ResourceHandler.UpdateResourceList(ctx, data, version)
+}
+
+// UpdateResource invokes the decorated UpdateResource if there is one, or the
+// wrapped ResourceHandler's otherwise.
+func (d decoratedHandler) UpdateResource(ctx RequestContext, id string, data Payload,
+	version string) (Resource, error) {
+
+	if d.update != nil {
+		return d.update(ctx, id, data, version)
+	}
+	return d.ResourceHandler.UpdateResource(ctx, id, data, version)
+}
+
+// DeleteResource invokes the decorated DeleteResource if there is one, or the
+// wrapped ResourceHandler's otherwise.
+func (d decoratedHandler) DeleteResource(ctx RequestContext, id,
+	version string) (Resource, error) {
+
+	if d.del != nil {
+		return d.del(ctx, id, version)
+	}
+	return d.ResourceHandler.DeleteResource(ctx, id, version)
+}
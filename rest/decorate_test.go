@@ -0,0 +1,177 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ticketHandler is a ResourceHandler for tickets which requires an X-Agent header,
+// has Rules, and counts its resources.
+type ticketHandler struct {
+	BaseResourceHandler
+}
+
+func (t ticketHandler) ResourceName() string {
+	return "tickets"
+}
+
+func (t ticketHandler) Authenticate(r *http.Request) error {
+	if r.Header.Get("X-Agent") == "" {
+		return UnauthorizedRequest("X-Agent is required")
+	}
+	return nil
+}
+
+func (t ticketHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "title", Type: String, Required: true, InputOnly: true},
+	)
+}
+
+func (t ticketHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return Payload{"title": data["title"]}, nil
+}
+
+func (t ticketHandler) ReadResource(ctx RequestContext, id,
+	version string) (Resource, error) {
+	return Payload{"id": id}, nil
+}
+
+func (t ticketHandler) DeleteResource(ctx RequestContext, id,
+	version string) (Resource, error) {
+	return Payload{"id": id}, nil
+}
+
+func (t ticketHandler) CountResources(ctx RequestContext, version string) (int64, error) {
+	return 7, nil
+}
+
+// serveTicket sends the request for tickets to the API as an agent.
+func serveTicket(api API, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "http://example.com/api/v1/tickets"+path,
+		bytes.NewBufferString(body))
+	req.Header.Set("X-Agent", "ada")
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that WrapCreate decorates only CreateResource, leaving authentication,
+// Rules, and optional interfaces of the wrapped handler in effect.
+func TestWrapCreate(t *testing.T) {
+	assert := assert.New(t)
+	titles := []interface{}{}
+	h := WrapCreate(ticketHandler{}, func(next CreateFunc) CreateFunc {
+		return func(ctx RequestContext, data Payload, version string) (Resource, error) {
+			titles = append(titles, data["title"])
+			return next(ctx, data, version)
+		}
+	})
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(h)
+
+	resp := serveTicket(api, "POST", "", `{"title": "Broken", "priority": 1}`)
+	assert.Equal(http.StatusCreated, resp.Code, resp.Body.String())
+	assert.Equal([]interface{}{"Broken"}, titles)
+
+	// Rules still apply before the decorated method is invoked.
+	resp = serveTicket(api, "POST", "", `{}`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code)
+	assert.Len(titles, 1)
+
+	// Authentication is still the wrapped handler's.
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/tickets",
+		bytes.NewBufferString(`{"title": "Broken"}`))
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+	assert.Len(titles, 1)
+
+	// The ResourceCounter is still detected.
+	resp = serveTicket(api, "GET", "/count", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("7", resp.Header().Get(TotalCountHeader))
+	assert.Nil(h.Rules().Validate())
+}
+
+// Ensures that method decorators can be combined and others are delegated untouched.
+func TestWrapCombined(t *testing.T) {
+	assert := assert.New(t)
+	calls := []string{}
+	trace := func(name string) func(ReadFunc) ReadFunc {
+		return func(next ReadFunc) ReadFunc {
+			return func(ctx RequestContext, id, version string) (Resource, error) {
+				calls = append(calls, name)
+				return next(ctx, id, version)
+			}
+		}
+	}
+	var h ResourceHandler = ticketHandler{}
+	h = WrapRead(h, trace("inner"))
+	h = WrapRead(h, trace("outer"))
+	h = WrapDelete(h, func(next DeleteFunc) DeleteFunc {
+		return func(ctx RequestContext, id, version string) (Resource, error) {
+			return nil, ResourceConflict(fmt.Sprintf("Ticket %s is open", id))
+		}
+	})
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(h)
+
+	resp := serveTicket(api, "GET", "/42", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal([]string{"outer", "inner"}, calls)
+
+	resp = serveTicket(api, "DELETE", "/42", "")
+	assert.Equal(http.StatusConflict, resp.Code)
+
+	resp = serveTicket(api, "POST", "", `{"title": "Broken"}`)
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal("tickets", h.ResourceName())
+	_, ok := unwrapHandler(h).(ResourceCounter)
+	assert.True(ok)
+}
+
+// Ensures that a ResourceResolver is read in one phase when its ReadResource is
+// decorated, so the decorated function isn't bypassed.
+func TestWrapReadResolver(t *testing.T) {
+	assert := assert.New(t)
+	handler := &blobHandler{resolved: new(int), materialized: new(int), deleted: new(int)}
+	decorated := WrapRead(*handler, func(next ReadFunc) ReadFunc {
+		return func(ctx RequestContext, id, version string) (Resource, error) {
+			return Payload{"id": id, "decorated": true}, nil
+		}
+	})
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(decorated)
+
+	resp := serveConditional(api, "GET", "http://foo.com/api/v1/blobs/1", "", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"decorated":true`)
+	assert.Equal(0, *handler.resolved)
+	assert.Equal(0, *handler.materialized)
+
+	_, ok := resolverFor(WrapDelete(*handler, func(next DeleteFunc) DeleteFunc { return next }))
+	assert.True(ok)
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Digest algorithms which can be used to verify request bodies and sign response
+// bodies (see RFC 3230).
+const (
+	DigestSHA256 = "SHA-256"
+	DigestSHA512 = "SHA-512"
+	DigestMD5    = "MD5"
+)
+
+const (
+	// DigestMismatchCode is the error code included in responses to requests whose
+	// body doesn't match the provided digest.
+	DigestMismatchCode = "digest_mismatch"
+
+	// UnsupportedDigestCode is the error code included in responses to requests
+	// which only provide digests using unsupported algorithms.
+	UnsupportedDigestCode = "unsupported_digest"
+
+	// digestHeader is the RFC 3230 instance digest header.
+	digestHeader = "Digest"
+
+	// contentMD5Header is the legacy RFC 1864 MD5 digest header.
+	contentMD5Header = "Content-MD5"
+)
+
+// digestHashes maps digest algorithms to their hash constructors.
+var digestHashes = map[string]func() hash.Hash{
+	DigestSHA256: sha256.New,
+	DigestSHA512: sha512.New,
+	DigestMD5:    md5.New,
+}
+
+// computeDigest returns the base64-encoded digest of the body using the algorithm,
+// which must be supported.
+func computeDigest(algorithm string, body []byte) string {
+	h := digestHashes[algorithm]()
+	h.Write(body)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// digestAllowed returns true if the algorithm is supported and in the allowed list.
+// All supported algorithms are allowed if the list is empty.
+func digestAllowed(algorithm string, allowed []string) bool {
+	if _, ok := digestHashes[algorithm]; !ok {
+		return false
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.ToUpper(a) == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyDigest verifies the body against the Digest and Content-MD5 request headers.
+// Digests using unsupported algorithms are ignored, but if none of the provided
+// digests are supported, an "unsupported_digest" 400 Error is returned. If any
+// supported digest doesn't match, a "digest_mismatch" 400 Error is returned. Requests
+// without digest headers are not verified.
+func verifyDigest(body []byte, header http.Header, allowed []string) error {
+	digests := map[string]string{}
+	for _, value := range header[digestHeader] {
+		for _, instance := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(instance), "=", 2)
+			if len(parts) != 2 {
+				return BadRequest(fmt.Sprintf("Malformed Digest: %s", instance)).
+					WithCode(DigestMismatchCode)
+			}
+			digests[strings.ToUpper(parts[0])] = parts[1]
+		}
+	}
+	if contentMD5 := header.Get(contentMD5Header); contentMD5 != "" {
+		digests[DigestMD5] = contentMD5
+	}
+	if len(digests) == 0 {
+		return nil
+	}
+
+	algorithms := make([]string, 0, len(digests))
+	for algorithm := range digests {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+
+	verified := false
+	for _, algorithm := range algorithms {
+		if !digestAllowed(algorithm, allowed) {
+			continue
+		}
+		if computeDigest(algorithm, body) != digests[algorithm] {
+			return BadRequest(fmt.Sprintf("Body does not match %s digest", algorithm)).
+				WithCode(DigestMismatchCode)
+		}
+		verified = true
+	}
+
+	if !verified {
+		return BadRequest(fmt.Sprintf("Unsupported digest algorithm: %s",
+			strings.Join(algorithms, ", "))).WithCode(UnsupportedDigestCode)
+	}
+	return nil
+}
+
+// setDigest sets the digest of the response body on the response headers using the
+// algorithm. MD5 digests are sent using the legacy Content-MD5 header. This is a
+// no-op if the algorithm is empty or unsupported.
+func setDigest(header http.Header, algorithm string, body []byte) {
+	algorithm = strings.ToUpper(algorithm)
+	if _, ok := digestHashes[algorithm]; !ok {
+		return
+	}
+	digest := computeDigest(algorithm, body)
+	if algorithm == DigestMD5 {
+		header.Set(contentMD5Header, digest)
+		return
+	}
+	header.Set(digestHeader, fmt.Sprintf("%s=%s", algorithm, digest))
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that verifyDigest accepts matching digests in each supported algorithm and
+// ignores requests without digests.
+func TestVerifyDigest(t *testing.T) {
+	assert := assert.New(t)
+	body := []byte(`{"foo": "bar"}`)
+
+	assert.Nil(verifyDigest(body, http.Header{}, nil))
+	assert.Nil(verifyDigest(body, http.Header{
+		"Digest": {"sha-256=" + computeDigest(DigestSHA256, body)},
+	}, nil))
+	assert.Nil(verifyDigest(body, http.Header{
+		"Digest": {"SHA-512=" + computeDigest(DigestSHA512, body) + ", UNIXsum=30637"},
+	}, nil))
+	assert.Nil(verifyDigest(body, http.Header{
+		"Content-Md5": {computeDigest(DigestMD5, body)},
+	}, nil))
+}
+
+// Ensures that verifyDigest rejects mismatched digests and digests using only
+// unsupported or disallowed algorithms.
+func TestVerifyDigestFailures(t *testing.T) {
+	assert := assert.New(t)
+	body := []byte(`{"foo": "bar"}`)
+
+	assert.Equal(
+		BadRequest("Body does not match SHA-256 digest").WithCode(DigestMismatchCode),
+		verifyDigest(body, http.Header{
+			"Digest": {"SHA-256=" + computeDigest(DigestSHA256, []byte("corrupt"))},
+		}, nil),
+	)
+	assert.Equal(
+		BadRequest("Unsupported digest algorithm: SHA, UNIXSUM").WithCode(UnsupportedDigestCode),
+		verifyDigest(body, http.Header{"Digest": {"SHA=abc,UNIXsum=30637"}}, nil),
+	)
+	assert.Equal(
+		BadRequest("Unsupported digest algorithm: MD5").WithCode(UnsupportedDigestCode),
+		verifyDigest(body, http.Header{
+			"Content-Md5": {computeDigest(DigestMD5, body)},
+		}, []string{DigestSHA256}),
+	)
+}
+
+// Ensures that request digests are verified over the body exactly as received, before
+// charset transcoding, and that response digests are computed over the serialized
+// response body.
+func TestHandleCreateDigest(t *testing.T) {
+	assert := assert.New(t)
+	handler := &normalizingHandler{}
+	api := NewAPI(&Configuration{
+		VerifyDigest:     true,
+		AcceptedCharsets: []string{UTF16LE},
+		ResponseDigest:   DigestSHA256,
+	})
+
+	api.RegisterResourceHandler(handler)
+	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+
+	body := encodeUTF16(charsetFixture, false, true)
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v0.1/foo", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-16le")
+	req.Header.Set("Digest", "SHA-256="+computeDigest(DigestSHA256, body))
+	resp := httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusCreated, resp.Code, "Incorrect response code")
+	assert.Equal("SHA-256="+computeDigest(DigestSHA256, resp.Body.Bytes()),
+		resp.Header().Get("Digest"))
+
+	// A digest of the transcoded body doesn't match what was received.
+	req, _ = http.NewRequest("POST", "http://foo.com/api/v0.1/foo", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-16le")
+	req.Header.Set("Digest", "SHA-256="+computeDigest(DigestSHA256, []byte(charsetFixture)))
+	resp = httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusBadRequest, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"code":"digest_mismatch","messages":["Body does not match SHA-256 digest"],"reason":"Bad Request","status":400}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+	assert.Equal("SHA-256="+computeDigest(DigestSHA256, resp.Body.Bytes()),
+		resp.Header().Get("Digest"))
+}
+
+// Ensures that an MD5 response digest is sent using the Content-MD5 header.
+func TestSetDigestContentMD5(t *testing.T) {
+	assert := assert.New(t)
+	header := http.Header{}
+
+	setDigest(header, "md5", []byte("foo"))
+	setDigest(header, "crc32", []byte("foo"))
+
+	assert.Equal("rL0Y20zC+Fzt72VPzMSk2A==", header.Get("Content-MD5"))
+	assert.Equal("", header.Get("Digest"))
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package rest
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -33,6 +34,7 @@ import (
 type endpoint map[string]interface{}
 type field map[string]interface{}
 type handlerDoc map[string]string
+type errorDoc map[string]interface{}
 
 // templateRenderer is a template which can be rendered as a string.
 type templateRenderer interface {
@@ -111,10 +113,10 @@ type docGenerator struct {
 }
 
 // newDocGenerator creates a new docGenerator instance which relies on mustache templating.
-func newDocGenerator() *docGenerator {
+func newDocGenerator(config *Configuration) *docGenerator {
 	return &docGenerator{
 		&mustacheParser{},
-		&defaultContextGenerator{},
+		&defaultContextGenerator{config},
 		&fsDocWriter{},
 	}
 }
@@ -133,13 +135,16 @@ func (d *docGenerator) generateDocs(api API) error {
 		return err
 	}
 
-	handlers := api.ResourceHandlers()
+	handlers := api.documentedResourceHandlers()
 	docs := map[string][]handlerDoc{}
 	versions := versions(handlers)
 
 	for _, version := range versions {
 		versionDocs := make([]handlerDoc, 0, len(handlers))
 		for _, handler := range handlers {
+			if !servesVersion(handler, version) {
+				continue
+			}
 			doc, err := d.generateHandlerDoc(handler, version, dir)
 			if err != nil {
 				api.Configuration().Logger.Println(err)
@@ -212,7 +217,9 @@ func (d *docGenerator) generateHandlerDoc(handler ResourceHandler, version,
 }
 
 // defaultContextGenerator is an implementation of the docContextGenerator interface.
-type defaultContextGenerator struct{}
+type defaultContextGenerator struct {
+	config *Configuration
+}
 
 // generate creates a template context for the provided ResourceHandler.
 func (d *defaultContextGenerator) generate(handler ResourceHandler, version string) (
@@ -239,6 +246,7 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 			"outputFields":    outputFields,
 			"exampleRequest":  buildExampleRequest(handler.Rules(), false, version),
 			"exampleResponse": buildExampleResponse(handler.Rules(), false, version),
+			"inboundSchema":   d.inboundSchemaDoc(handler, ExampleCreate, version),
 			"index":           index,
 		})
 	}
@@ -246,19 +254,22 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 
 	if handler.ReadListDocumentation() != "" {
 		endpoints = append(endpoints, endpoint{
-			"uri":             formatURI(handler.ReadListURI(), version),
-			"method":          "GET",
-			"label":           "info",
-			"description":     handler.ReadListDocumentation(),
-			"hasInput":        false,
-			"outputFields":    outputFields,
-			"exampleResponse": buildExampleResponse(handler.Rules(), true, version),
-			"index":           index,
+			"uri":               formatURI(handler.ReadListURI(), version),
+			"method":            "GET",
+			"label":             "info",
+			"description":       handler.ReadListDocumentation(),
+			"hasInput":          false,
+			"outputFields":      outputFields,
+			"exampleResponse":   buildExampleResponse(handler.Rules(), true, version),
+			"hasErrorResponses": true,
+			"errorResponses":    d.paginationErrors(),
+			"index":             index,
 		})
 	}
 	index++
 
 	if handler.ReadDocumentation() != "" {
+		keyDocs := lookupKeyDocs(handler, version)
 		endpoints = append(endpoints, endpoint{
 			"uri":             formatURI(handler.ReadURI(), version),
 			"method":          "GET",
@@ -267,6 +278,8 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 			"hasInput":        false,
 			"outputFields":    outputFields,
 			"exampleResponse": buildExampleResponse(handler.Rules(), false, version),
+			"hasLookupKeys":   len(keyDocs) > 0,
+			"lookupKeys":      keyDocs,
 			"index":           index,
 		})
 	}
@@ -283,6 +296,7 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 			"outputFields":    outputFields,
 			"exampleRequest":  buildExampleRequest(handler.Rules(), true, version),
 			"exampleResponse": buildExampleResponse(handler.Rules(), true, version),
+			"inboundSchema":   d.inboundSchemaDoc(handler, ExampleUpdateList, version),
 			"index":           index,
 		})
 	}
@@ -299,6 +313,7 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 			"outputFields":    outputFields,
 			"exampleRequest":  buildExampleRequest(handler.Rules(), false, version),
 			"exampleResponse": buildExampleResponse(handler.Rules(), false, version),
+			"inboundSchema":   d.inboundSchemaDoc(handler, ExampleUpdate, version),
 			"index":           index,
 		})
 	}
@@ -323,6 +338,19 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 		return nil, nil
 	}
 
+	verbs := []string{ExampleCreate, ExampleReadList, ExampleRead, ExampleUpdateList,
+		ExampleUpdate, ExampleDelete}
+	for _, endpoint := range endpoints {
+		examples, err := d.exampleDocs(handler, verbs[endpoint["index"].(int)], version)
+		if err != nil {
+			return nil, err
+		}
+		if len(examples) > 0 {
+			endpoint["hasExamples"] = true
+			endpoint["examples"] = examples
+		}
+	}
+
 	name := handlerTypeName(handler)
 	context := map[string]interface{}{
 		"resource":       name,
@@ -335,6 +363,98 @@ func (d *defaultContextGenerator) generate(handler ResourceHandler, version stri
 	return context, nil
 }
 
+// exampleDocs returns the documentation for the handler's examples of the endpoint
+// for the version, with responses rendered by the API's serialization.
+func (d *defaultContextGenerator) exampleDocs(handler ResourceHandler, verb,
+	version string) ([]map[string]interface{}, error) {
+
+	provider, ok := unwrapHandler(handler).(ExampleProvider)
+	if !ok {
+		return nil, nil
+	}
+
+	docs := []map[string]interface{}{}
+	for _, example := range provider.Examples() {
+		if example.Verb != verb || normalizeVersion(example.Version) != normalizeVersion(version) {
+			continue
+		}
+		status, body, err := renderExample(d.config, handler, example)
+		if err != nil {
+			return nil, err
+		}
+		var response bytes.Buffer
+		if err := json.Indent(&response, body, "", "    "); err != nil {
+			response.Reset()
+			response.Write(body)
+		}
+
+		doc := map[string]interface{}{
+			"description": example.Description,
+			"status":      status,
+			"response":    response.String(),
+		}
+		var request interface{}
+		if example.Payloads != nil {
+			request = example.Payloads
+		} else if example.Payload != nil {
+			request = example.Payload
+		}
+		if request != nil {
+			encoded, err := json.MarshalIndent(request, "", "    ")
+			if err != nil {
+				return nil, err
+			}
+			doc["hasRequest"] = true
+			doc["request"] = string(encoded)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// inboundSchemaDoc returns the indented JSON of the effective schema of request bodies
+// sent to the verb's endpoint for the version.
+func (d *defaultContextGenerator) inboundSchemaDoc(handler ResourceHandler, verb,
+	version string) string {
+
+	schema := newInboundSchema(d.config, handler.Rules(), verb, version)
+	encoded, err := json.MarshalIndent(schema, "", "    ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(encoded)
+}
+
+// paginationErrors returns descriptions of the error responses common to all
+// paginated endpoints.
+func (d *defaultContextGenerator) paginationErrors() []errorDoc {
+	return []errorDoc{
+		errorDoc{
+			"status": staleCursorStatus(d.config),
+			"code":   StaleCursorCode,
+			"description": "The next cursor is no longer valid, e.g. because the data it " +
+				"references was deleted. Restart pagination from the restart URL.",
+		},
+	}
+}
+
+// lookupKeyDocs returns the documentation for the alternate keys the handler's
+// resources can be read by, if any.
+func lookupKeyDocs(handler ResourceHandler, version string) []map[string]string {
+	keys := lookupKeys(handler)
+	if keys == nil {
+		return nil
+	}
+	docs := []map[string]string{}
+	for _, key := range keys.alternates() {
+		docs = append(docs, map[string]string{
+			"key": key,
+			"uri": formatURI(keys.keyURI(handler.ReadURI(), key), version),
+		})
+	}
+	return docs
+}
+
 // formatURI returns the specified URI replacing templated variable names with their
 // human-readable documentation equivalent. It also replaces the version regex with
 // the actual version string.
@@ -374,6 +494,9 @@ func getInputFields(rules Rules) []field {
 			"type":        ruleTypeName(rule, Inbound),
 			"description": rule.DocString,
 		}
+		if constraints := ruleConstraints(rule); len(constraints) > 0 {
+			field["constraints"] = constraints
+		}
 
 		fields = append(fields, field)
 	}
@@ -381,6 +504,46 @@ func getInputFields(rules Rules) []field {
 	return fields
 }
 
+// ruleConstraints returns human-readable descriptions of the string hygiene and length
+// constraints applied to input values by the Rule.
+func ruleConstraints(r *Rule) []string {
+	constraints := []string{}
+	if r.ValidateUTF8 || r.NormalizeUnicode {
+		constraints = append(constraints, "Must be valid UTF-8")
+	}
+	if r.NormalizeUnicode {
+		constraints = append(constraints, "Normalized to Unicode NFC")
+	}
+	if r.StripControlChars {
+		constraints = append(constraints, "Control characters are removed")
+	}
+
+	unit := "bytes"
+	if r.RuneLength {
+		unit = "characters"
+	}
+	if r.MinLength > 0 {
+		constraints = append(constraints, fmt.Sprintf("Minimum length %d %s", r.MinLength, unit))
+	}
+	if r.MaxLength > 0 {
+		constraints = append(constraints, fmt.Sprintf("Maximum length %d %s", r.MaxLength, unit))
+	}
+	if r.Minimum != "" {
+		constraints = append(constraints, fmt.Sprintf("Minimum value %s", r.Minimum))
+	}
+	if r.Maximum != "" {
+		constraints = append(constraints, fmt.Sprintf("Maximum value %s", r.Maximum))
+	}
+	if r.Unique != nil {
+		constraints = append(constraints, "Must be unique")
+	}
+	if r.Exists != nil {
+		constraints = append(constraints, "Must refer to an existing resource")
+	}
+
+	return constraints
+}
+
 // getInputFields returns output field descriptions.
 func getOutputFields(rules Rules) []field {
 	rules = rules.Filter(Outbound)
@@ -529,7 +692,7 @@ func getNestedExampleValue(r *Rule, version string) interface{} {
 }
 
 // versions returns a slice containing all versions specified by the provided
-// ResourceHandlers.
+// ResourceHandlers' Rules or ForVersions.
 func versions(handlers []ResourceHandler) []string {
 	versionMap := map[string]bool{}
 	for _, handler := range handlers {
@@ -538,6 +701,11 @@ func versions(handlers []ResourceHandler) []string {
 				versionMap[version] = true
 			}
 		}
+		if versioned, ok := handler.(versionedHandler); ok {
+			for _, version := range versioned.servesVersions() {
+				versionMap[version] = true
+			}
+		}
 	}
 
 	versions := make([]string, 0, len(versionMap))
@@ -549,10 +549,18 @@ func TestGenerateHappyPath(t *testing.T) {
 				"description":     "Retrieves a list of foos",
 				"exampleResponse": "[\n    {\n        \"bar\": 0,\n        \"baz\": [],\n        \"foo\": \"foo\",\n        \"qux\": \"2014-09-05T15:45:36Z\"\n    }\n]",
 				"hasInput":        false,
-				"index":           1,
-				"label":           "info",
-				"method":          "GET",
-				"uri":             "/api/v1/foo",
+				"errorResponses": []errorDoc{
+					errorDoc{
+						"status": 410,
+						"code":   "stale_cursor",
+						"description": "The next cursor is no longer valid, e.g. because the data it " +
+							"references was deleted. Restart pagination from the restart URL.",
+					},
+				},
+				"index":  1,
+				"label":  "info",
+				"method": "GET",
+				"uri":    "/api/v1/foo",
 				"outputFields": []field{
 					field{
 						"description": "foo",
@@ -730,6 +738,7 @@ func TestGenerateHappyPath(t *testing.T) {
 			assert.Equal(expected["exampleRequest"], endpoint["exampleRequest"])
 			assert.Equal(expected["exampleResponse"], endpoint["exampleResponse"])
 			assert.Equal(expected["hasInput"], endpoint["hasInput"])
+			assert.Equal(expected["errorResponses"], endpoint["errorResponses"])
 			assert.Equal(expected["index"], endpoint["index"])
 			assert.Equal(expected["inputFields"], endpoint["inputFields"])
 			assert.Equal(expected["outputFields"], endpoint["outputFields"])
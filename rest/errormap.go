@@ -0,0 +1,168 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	netcontext "code.google.com/p/go.net/context"
+)
+
+const (
+	// NotFoundCode is the error code of responses to requests for resources a
+	// database query found no rows for.
+	NotFoundCode = "not_found"
+
+	// DeadlineExceededCode is the error code of responses to requests whose database
+	// operations exceeded their deadline.
+	DeadlineExceededCode = "deadline_exceeded"
+
+	// ConstraintViolationCode is the default error code of responses to requests which
+	// violated a database constraint.
+	ConstraintViolationCode = "constraint_violation"
+)
+
+// ErrorMapper translates an error returned by a ResourceHandler which isn't an Error,
+// e.g. one from a database driver, into an Error. It returns false if it doesn't
+// handle the error. ErrorMappers are registered on the API using
+// RegisterErrorMapper and are consulted in order until one handles the error. Errors no
+// ErrorMapper handles receive a 500 as before.
+type ErrorMapper func(error) (Error, bool)
+
+// mapError returns the Error the first registered ErrorMapper translates the error
+// to. Returns false if the error is already an Error or no ErrorMapper handles it.
+func (r *muxAPI) mapError(err error) (Error, bool) {
+	if _, ok := err.(Error); ok {
+		return Error{}, false
+	}
+	r.mu.RLock()
+	mappers := r.errorMappers
+	r.mu.RUnlock()
+	for _, mapper := range mappers {
+		if mapped, ok := mapper(err); ok {
+			return mapped, true
+		}
+	}
+	return Error{}, false
+}
+
+// SQLErrorMapper is an ErrorMapper for errors returned by database/sql. Queries which
+// found no rows receive a 404 and operations which exceeded their context's deadline,
+// either a context.Context or a go.net Context, receive a 504. Wrapped errors are
+// unwrapped.
+func SQLErrorMapper(err error) (Error, bool) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ResourceNotFound("Resource not found").WithCode(NotFoundCode), true
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, netcontext.DeadlineExceeded):
+		return Error{reason: "The request timed out", status: http.StatusGatewayTimeout,
+			code: DeadlineExceededCode}, true
+	}
+	return Error{}, false
+}
+
+// ConstraintMatcher identifies errors reporting a database constraint violation and
+// the response they receive. An error matches if any error it wraps satisfies Match or
+// has a message containing Contains.
+type ConstraintMatcher struct {
+	// Contains matches errors whose message contains the string, e.g. "duplicate key".
+	Contains string
+
+	// Match matches errors it returns true for. It's typically used to inspect a
+	// driver's error type using errors.As.
+	Match func(error) bool
+
+	// Status is the status of the response, either http.StatusConflict or
+	// http.StatusUnprocessableEntity. Defaults to http.StatusConflict.
+	Status int
+
+	// Code is the error code of the response. Defaults to ConstraintViolationCode.
+	Code string
+
+	// Reason is the message of the response. Defaults to a generic one so driver
+	// messages aren't exposed to clients.
+	Reason string
+}
+
+// matches returns true if the error, or any error it wraps, satisfies the
+// ConstraintMatcher.
+func (c ConstraintMatcher) matches(err error) bool {
+	if c.Match != nil && c.Match(err) {
+		return true
+	}
+	if c.Contains == "" {
+		return false
+	}
+	for _, e := range unwrapAll(err) {
+		if strings.Contains(e.Error(), c.Contains) {
+			return true
+		}
+	}
+	return false
+}
+
+// error returns the Error for a violation matched by the ConstraintMatcher.
+func (c ConstraintMatcher) error() Error {
+	status := c.Status
+	if status == 0 {
+		status = http.StatusConflict
+	}
+	code := c.Code
+	if code == "" {
+		code = ConstraintViolationCode
+	}
+	reason := c.Reason
+	if reason == "" {
+		reason = "The request conflicts with an existing resource"
+		if status != http.StatusConflict {
+			reason = "The request violates a constraint"
+		}
+	}
+	return Error{reason: reason, status: status, code: code}
+}
+
+// ConstraintViolationMapper returns an ErrorMapper which translates errors matched by
+// the ConstraintMatchers, e.g. unique violations, into a 409 or 422. The first
+// matching ConstraintMatcher determines the response.
+func ConstraintViolationMapper(matchers ...ConstraintMatcher) ErrorMapper {
+	return func(err error) (Error, bool) {
+		for _, matcher := range matchers {
+			if matcher.matches(err) {
+				return matcher.error(), true
+			}
+		}
+		return Error{}, false
+	}
+}
+
+// unwrapAll returns the error and every error in its chain.
+func unwrapAll(err error) []error {
+	errs := []error{}
+	for err != nil {
+		errs = append(errs, err)
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		err = wrapper.Unwrap()
+	}
+	return errs
+}
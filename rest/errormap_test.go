@@ -0,0 +1,166 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	netcontext "code.google.com/p/go.net/context"
+	"github.com/stretchr/testify/assert"
+)
+
+// driverError is an error type like those returned by database drivers.
+type driverError struct {
+	code string
+}
+
+func (d *driverError) Error() string {
+	return "driver error " + d.code
+}
+
+// opaqueWrapper wraps an error without including its message.
+type opaqueWrapper struct {
+	err error
+}
+
+func (o opaqueWrapper) Error() string {
+	return "operation failed"
+}
+
+func (o opaqueWrapper) Unwrap() error {
+	return o.err
+}
+
+// failingHandler is a ResourceHandler whose reads return its error.
+type failingHandler struct {
+	BaseResourceHandler
+	err error
+}
+
+func (f failingHandler) ResourceName() string {
+	return "failures"
+}
+
+func (f failingHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	return nil, f.err
+}
+
+// readError sends a read request to an API with the ErrorMappers whose handler fails
+// with the error and returns the response status and error code.
+func readError(err error, mappers ...ErrorMapper) (int, interface{}) {
+	api := NewAPI(&Configuration{})
+	for _, mapper := range mappers {
+		api.RegisterErrorMapper(mapper)
+	}
+	api.RegisterResourceHandler(failingHandler{err: err})
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/failures/1", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	var body map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	return resp.Code, body["code"]
+}
+
+// deeplyWrapped wraps the error several levels deep using both fmt.Errorf and a
+// wrapper which hides its message.
+func deeplyWrapped(err error) error {
+	err = fmt.Errorf("query widgets: %w", err)
+	err = opaqueWrapper{err}
+	return fmt.Errorf("read widget 1: %w", fmt.Errorf("repository: %w", err))
+}
+
+// Ensures that SQLErrorMapper translates wrapped database/sql errors.
+func TestSQLErrorMapper(t *testing.T) {
+	assert := assert.New(t)
+
+	status, code := readError(deeplyWrapped(sql.ErrNoRows), SQLErrorMapper)
+	assert.Equal(http.StatusNotFound, status)
+	assert.Equal(NotFoundCode, code)
+
+	status, code = readError(deeplyWrapped(context.DeadlineExceeded), SQLErrorMapper)
+	assert.Equal(http.StatusGatewayTimeout, status)
+	assert.Equal(DeadlineExceededCode, code)
+
+	status, code = readError(deeplyWrapped(netcontext.DeadlineExceeded), SQLErrorMapper)
+	assert.Equal(http.StatusGatewayTimeout, status)
+	assert.Equal(DeadlineExceededCode, code)
+
+	status, code = readError(deeplyWrapped(errors.New("boom")), SQLErrorMapper)
+	assert.Equal(http.StatusInternalServerError, status)
+	assert.Nil(code)
+}
+
+// Ensures that ConstraintViolationMapper matches wrapped errors by message and by type
+// and that the first matching ConstraintMatcher determines the response.
+func TestConstraintViolationMapper(t *testing.T) {
+	assert := assert.New(t)
+	mapper := ConstraintViolationMapper(
+		ConstraintMatcher{Contains: "duplicate key", Code: "duplicate"},
+		ConstraintMatcher{
+			Match: func(err error) bool {
+				var driverErr *driverError
+				return errors.As(err, &driverErr) && driverErr.code == "23514"
+			},
+			Status: http.StatusUnprocessableEntity,
+			Code:   "check_violation",
+		},
+		ConstraintMatcher{Contains: "driver error"},
+	)
+
+	status, code := readError(deeplyWrapped(errors.New("pq: duplicate key value")), mapper)
+	assert.Equal(http.StatusConflict, status)
+	assert.Equal("duplicate", code)
+
+	status, code = readError(deeplyWrapped(&driverError{"23514"}), mapper)
+	assert.Equal(http.StatusUnprocessableEntity, status)
+	assert.Equal("check_violation", code)
+
+	status, code = readError(deeplyWrapped(&driverError{"23503"}), mapper)
+	assert.Equal(http.StatusConflict, status)
+	assert.Equal(ConstraintViolationCode, code)
+
+	status, _ = readError(deeplyWrapped(errors.New("connection refused")), mapper)
+	assert.Equal(http.StatusInternalServerError, status)
+}
+
+// Ensures that ErrorMappers are consulted in registration order and aren't consulted
+// for errors which are already an Error.
+func TestErrorMapperOrder(t *testing.T) {
+	assert := assert.New(t)
+	first := func(err error) (Error, bool) {
+		return ResourceConflict("first").WithCode("first"), true
+	}
+
+	_, code := readError(sql.ErrNoRows, first, SQLErrorMapper)
+	assert.Equal("first", code)
+
+	_, code = readError(sql.ErrNoRows, SQLErrorMapper, first)
+	assert.Equal(NotFoundCode, code)
+
+	status, code := readError(BadRequest("bad"), first)
+	assert.Equal(http.StatusBadRequest, status)
+	assert.Nil(code)
+}
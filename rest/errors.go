@@ -16,12 +16,22 @@ limitations under the License.
 
 package rest
 
-import "net/http"
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// statusTooManyRequests is the HTTP status for rate limited requests (RFC 6585).
+const statusTooManyRequests = 429
 
 // Error is an implementation of the error interface representing an HTTP error.
 type Error struct {
 	reason string
 	status int
+	code   string
+	fields FieldErrors
 }
 
 // Error returns the Error message.
@@ -30,42 +40,189 @@ func (r Error) Error() string { return r.reason }
 // Status returns the HTTP status code.
 func (r Error) Status() int { return r.status }
 
+// Code returns the machine-readable error code, if any. When set, it's included in
+// the response body under "code".
+func (r Error) Code() string { return r.code }
+
+// WithCode returns a copy of the Error with the provided machine-readable error code.
+func (r Error) WithCode(code string) Error {
+	r.code = code
+	return r
+}
+
+// FieldErrors returns the errors for individual request fields, if any. When set,
+// they're included in the response body under "errors".
+func (r Error) FieldErrors() FieldErrors { return r.fields }
+
+// WithFieldErrors returns a copy of the Error with the provided field errors.
+func (r Error) WithFieldErrors(fields FieldErrors) Error {
+	r.fields = fields
+	return r
+}
+
 // ResourceNotFound returns a Error for a 404 Not Found error.
 func ResourceNotFound(reason string) Error {
-	return Error{reason, http.StatusNotFound}
+	return Error{reason: reason, status: http.StatusNotFound}
 }
 
 // ResourceNotPermitted returns a Error for a 403 Forbidden error.
 func ResourceNotPermitted(reason string) Error {
-	return Error{reason, http.StatusForbidden}
+	return Error{reason: reason, status: http.StatusForbidden}
 }
 
 // ResourceConflict returns a Error for a 409 Conflict error.
 func ResourceConflict(reason string) Error {
-	return Error{reason, http.StatusConflict}
+	return Error{reason: reason, status: http.StatusConflict}
 }
 
 // BadRequest returns a Error for a 400 Bad Request error.
 func BadRequest(reason string) Error {
-	return Error{reason, http.StatusBadRequest}
+	return Error{reason: reason, status: http.StatusBadRequest}
 }
 
 // UnprocessableRequest returns a Error for a 422 Unprocessable Entity error.
 func UnprocessableRequest(reason string) Error {
-	return Error{reason, 422}
+	return Error{reason: reason, status: 422}
+}
+
+// UnsupportedMediaType returns a Error for a 415 Unsupported Media Type error.
+func UnsupportedMediaType(reason string) Error {
+	return Error{reason: reason, status: http.StatusUnsupportedMediaType}
 }
 
 // UnauthorizedRequest returns a Error for a 401 Unauthorized error.
 func UnauthorizedRequest(reason string) Error {
-	return Error{reason, http.StatusUnauthorized}
+	return Error{reason: reason, status: http.StatusUnauthorized}
 }
 
 // NotImplemented returns a Error for a 501 Not Implemented error.
 func NotImplemented(reason string) Error {
-	return Error{reason, http.StatusNotImplemented}
+	return Error{reason: reason, status: http.StatusNotImplemented}
 }
 
 // InternalServerError returns a Error for a 500 Internal Server error.
 func InternalServerError(reason string) Error {
-	return Error{reason, http.StatusInternalServerError}
+	return Error{reason: reason, status: http.StatusInternalServerError}
+}
+
+// PreconditionFailed returns a Error for a 412 Precondition Failed error.
+func PreconditionFailed(reason string) Error {
+	return Error{reason: reason, status: http.StatusPreconditionFailed}
+}
+
+// TooManyRequests returns a Error for a 429 Too Many Requests error.
+func TooManyRequests(reason string) Error {
+	return Error{reason: reason, status: statusTooManyRequests}
+}
+
+// ServiceUnavailable returns a Error for a 503 Service Unavailable error.
+func ServiceUnavailable(reason string) Error {
+	return Error{reason: reason, status: http.StatusServiceUnavailable}
+}
+
+// Codes of FieldErrors produced by Rules.
+const (
+	// FieldRequiredCode is the code of a required field which is missing.
+	FieldRequiredCode = "required"
+
+	// FieldTypeCode is the code of a field which can't be coerced to its Rule's Type.
+	// Its "type" param is the name of the Type.
+	FieldTypeCode = "invalid_type"
+
+	// FieldTooShortCode is the code of a string field shorter than its Rule's
+	// MinLength. Its "min" and "unit" params describe the minimum.
+	FieldTooShortCode = "too_short"
+
+	// FieldTooLongCode is the code of a string field longer than its Rule's MaxLength.
+	// Its "max" and "unit" params describe the maximum.
+	FieldTooLongCode = "too_long"
+
+	// FieldTooSmallCode is the code of a numeric field less than its Rule's Minimum.
+	// Its "min" param is the minimum.
+	FieldTooSmallCode = "too_small"
+
+	// FieldTooLargeCode is the code of a numeric field greater than its Rule's
+	// Maximum. Its "max" param is the maximum.
+	FieldTooLargeCode = "too_large"
+
+	// FieldInvalidNumberCode is the code of a numeric field whose Rule has a Minimum
+	// or Maximum but whose value can't be compared to them, e.g. because its literal
+	// or exponent is too long.
+	FieldInvalidNumberCode = "invalid_number"
+
+	// FieldNotUniqueCode is the code of a field whose value its Rule's Unique
+	// reported is already in use.
+	FieldNotUniqueCode = "not_unique"
+
+	// FieldDoesNotExistCode is the code of a field whose value its Rule's Exists
+	// reported refers to a resource which doesn't exist.
+	FieldDoesNotExistCode = "does_not_exist"
+)
+
+// FieldError describes a request field which failed validation. The Field, Code, and
+// Params are stable and intended for programmatic use, while the Message is for
+// people and may change.
+type FieldError struct {
+	// Field is the path of the field, e.g. "address.city" or "items[2].name".
+	Field string `json:"field"`
+
+	// Code identifies the failed constraint.
+	Code string `json:"code"`
+
+	// Params are the constraint's parameters, if any.
+	Params map[string]interface{} `json:"params,omitempty"`
+
+	// Message describes the failure.
+	Message string `json:"message"`
+}
+
+// FieldErrors is every FieldError for a request, sorted by field path and then code
+// so their order doesn't depend on the order fields were validated in.
+type FieldErrors []FieldError
+
+// Error returns the message of the FieldError if there's one, or the messages joined
+// otherwise.
+func (f FieldErrors) Error() string {
+	messages := make([]string, len(f))
+	for i, err := range f {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// sort sorts the FieldErrors by field path and then code. List indexes in paths are
+// compared numerically, so "[2]" sorts before "[10]".
+func (f FieldErrors) sort() {
+	sort.SliceStable(f, func(i, j int) bool {
+		if f[i].Field != f[j].Field {
+			return fieldPathLess(f[i].Field, f[j].Field)
+		}
+		return f[i].Code < f[j].Code
+	})
+}
+
+// fieldPathLess indicates if the field path a sorts before b. Paths are compared
+// byte by byte except for the indexes in brackets, which are compared by value.
+func fieldPathLess(a, b string) bool {
+	for a != "" && b != "" {
+		if a[0] == '[' && b[0] == '[' {
+			endA, endB := strings.IndexByte(a, ']'), strings.IndexByte(b, ']')
+			if endA > 0 && endB > 0 {
+				indexA, errA := strconv.Atoi(a[1:endA])
+				indexB, errB := strconv.Atoi(b[1:endB])
+				if errA == nil && errB == nil {
+					if indexA != indexB {
+						return indexA < indexB
+					}
+					a, b = a[endA+1:], b[endB+1:]
+					continue
+				}
+			}
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
 }
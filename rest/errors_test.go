@@ -47,6 +47,10 @@ func TestErrors(t *testing.T) {
 	assert.Equal("foo", err.Error())
 	assert.Equal(422, err.Status())
 
+	err = UnsupportedMediaType("foo")
+	assert.Equal("foo", err.Error())
+	assert.Equal(http.StatusUnsupportedMediaType, err.Status())
+
 	err = UnauthorizedRequest("foo")
 	assert.Equal("foo", err.Error())
 	assert.Equal(http.StatusUnauthorized, err.Status())
@@ -62,4 +66,49 @@ func TestErrors(t *testing.T) {
 	err = InternalServerError("foo")
 	assert.Equal("foo", err.Error())
 	assert.Equal(http.StatusInternalServerError, err.Status())
+
+	err = ServiceUnavailable("foo")
+	assert.Equal("foo", err.Error())
+	assert.Equal(http.StatusServiceUnavailable, err.Status())
+}
+
+// Ensures that WithCode sets the error code without modifying the original Error.
+func TestErrorWithCode(t *testing.T) {
+	assert := assert.New(t)
+	err := ServiceUnavailable("foo")
+
+	coded := err.WithCode("maintenance")
+
+	assert.Equal("", err.Code())
+	assert.Equal("maintenance", coded.Code())
+	assert.Equal("foo", coded.Error())
+	assert.Equal(http.StatusServiceUnavailable, coded.Status())
+}
+
+// Ensures that FieldErrors are sorted by field path with list indexes compared
+// numerically, and then by code.
+func TestFieldErrorsSort(t *testing.T) {
+	assert := assert.New(t)
+	errs := FieldErrors{
+		{Field: "[10].size", Code: FieldTypeCode},
+		{Field: "items[10]", Code: FieldTypeCode},
+		{Field: "[2].size", Code: FieldTypeCode},
+		{Field: "items[2].name", Code: FieldRequiredCode},
+		{Field: "[2].name", Code: FieldTypeCode},
+		{Field: "items[2]", Code: FieldTypeCode},
+		{Field: "[2].name", Code: FieldRequiredCode},
+		{Field: "items", Code: FieldTypeCode},
+	}
+
+	errs.sort()
+
+	fields := []string{}
+	for _, err := range errs {
+		fields = append(fields, err.Field+" "+err.Code)
+	}
+	assert.Equal([]string{
+		"[2].name invalid_type", "[2].name required", "[2].size invalid_type",
+		"[10].size invalid_type", "items invalid_type", "items[2] invalid_type",
+		"items[2].name required", "items[10] invalid_type",
+	}, fields)
 }
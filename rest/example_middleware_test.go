@@ -90,7 +90,8 @@ func Example_middleware() {
 	api := NewAPI(NewConfiguration())
 
 	// Call RegisterResourceHandler to wire up MiddlewareHandler and apply middleware.
-	api.RegisterResourceHandler(MiddlewareHandler{}, HandlerMiddleware)
+	// Middleware is passed as a ResourceOption by converting it to RequestMiddleware.
+	api.RegisterResourceHandler(MiddlewareHandler{}, RequestMiddleware(HandlerMiddleware))
 
 	// Middleware provided to Start and StartTLS are invoked for every request handled
 	// by the API.
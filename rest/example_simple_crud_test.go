@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
+	"sort"
 	"strconv"
 )
 
@@ -69,18 +70,81 @@ func (f FooHandler) ReadResource(ctx RequestContext, id string,
 	return nil, ResourceNotFound(fmt.Sprintf("No resource with id %s", id))
 }
 
+// foos stands in for a database table of FooResources.
+var foos = []*FooResource{{ID: 1, Foobar: "hello"}, {ID: 2, Foobar: "world"}}
+
+// fooQuery describes how the foo list can be queried. The framework parses and
+// validates filters and sorts, clamps the limit, and signs cursors, so fetch only sees
+// valid ListRequests.
+var fooQuery = &ListQuery{
+	Filters:      []FilterField{{Name: "foobar", Type: String}},
+	Sorts:        []string{"id", "foobar"},
+	DefaultSort:  []SortField{{Field: "id"}},
+	CursorFields: []string{"id"},
+	Fetch:        fetchFoos,
+	Position: func(r Resource, field string) string {
+		return fooField(r.(*FooResource), field)
+	},
+}
+
+// fooField returns the foo's field as a string which orders like the field.
+func fooField(foo *FooResource, field string) string {
+	if field == "foobar" {
+		return foo.Foobar
+	}
+	return fmt.Sprintf("%020d", foo.ID)
+}
+
+// fooAfter indicates if the foo is ordered after the position, which holds values
+// of the sort fields.
+func fooAfter(foo *FooResource, position []string, sort []SortField) bool {
+	for i, field := range sort {
+		if value := fooField(foo, field.Field); value != position[i] {
+			return (value > position[i]) != field.Descending
+		}
+	}
+	return false
+}
+
+// fetchFoos returns a page of foos for the ListRequest. Typically, this would build a
+// database query from the request's filters, sort, and position, e.g.
+// ORDER BY foobar, id with WHERE (foobar, id) > (?, ?) for the page after a cursor.
+func fetchFoos(ctx RequestContext, req ListRequest) ([]Resource, error) {
+	sorted := append([]*FooResource{}, foos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		position := make([]string, len(req.Sort))
+		for k, field := range req.Sort {
+			position[k] = fooField(sorted[i], field.Field)
+		}
+		return fooAfter(sorted[j], position, req.Sort)
+	})
+	resources := make([]Resource, 0, req.Limit)
+	for _, foo := range sorted {
+		if len(resources) == req.Limit {
+			break
+		}
+		if req.After != nil && !fooAfter(foo, req.After, req.Sort) {
+			continue
+		}
+		matches := true
+		for _, filter := range req.Filters {
+			matches = matches && foo.Foobar == filter.Value
+		}
+		if matches {
+			resources = append(resources, foo)
+		}
+	}
+	return resources, nil
+}
+
 // ReadResourceList is the logic that corresponds to reading multiple resources, perhaps
 // with specified query parameters accessed through the RequestContext. This is
-// mapped to GET /api/:version/foo. Typically, this would make some sort of database query
-// to fetch the resources. It returns the slice of results, a cursor (or empty) string,
-// and error (or nil).
+// mapped to GET /api/:version/foo. ListQuery implements the standard filtering,
+// sorting, and pagination behavior, e.g. GET /api/v1/foo?filter[foobar]=hello&limit=1.
+// It returns the slice of results, a cursor (or empty) string, and error (or nil).
 func (f FooHandler) ReadResourceList(ctx RequestContext, limit int,
 	cursor string, version string) ([]Resource, string, error) {
-	// Make a database call here.
-	resources := make([]Resource, 0, limit)
-	resources = append(resources, &FooResource{ID: 1, Foobar: "hello"})
-	resources = append(resources, &FooResource{ID: 2, Foobar: "world"})
-	return resources, "", nil
+	return fooQuery.Read(ctx, limit, cursor)
 }
 
 // UpdateResource is the logic that corresponds to updating an existing resource at
@@ -0,0 +1,276 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+)
+
+// Example verbs, which are the names of the endpoints examples apply to.
+const (
+	ExampleCreate     = "create"
+	ExampleReadList   = "readList"
+	ExampleRead       = "read"
+	ExampleUpdateList = "updateList"
+	ExampleUpdate     = "update"
+	ExampleDelete     = "delete"
+)
+
+// defaultExamplesDirectory is where ValidateExamples keeps golden files if the
+// Configuration doesn't specify a directory.
+const defaultExamplesDirectory = "testdata/examples"
+
+// UpdateExamplesEnv is the environment variable which makes ValidateExamples
+// regenerate golden files rather than compare against them when it's set to a
+// non-empty value, e.g. REST_UPDATE_EXAMPLES=1 go test.
+const UpdateExamplesEnv = "REST_UPDATE_EXAMPLES"
+
+// Example is a documented request to one of a resource's endpoints along with the
+// result the handler returns for it. The response shown for the example is produced by
+// serializing the result exactly as a request would, so Rules, per-version field
+// visibility, and the response envelope are always reflected accurately.
+type Example struct {
+	// Verb is the endpoint the example applies to, e.g. ExampleRead.
+	Verb string
+
+	// Version is the API version of the example request.
+	Version string
+
+	// Description describes the example.
+	Description string
+
+	// ID is the resource ID for read, update, and delete examples. Defaults to "1".
+	ID string
+
+	// Payload is the request body for create and update examples.
+	Payload Payload
+
+	// Payloads is the request body for update list examples.
+	Payloads []Payload
+
+	// Resource is the result of create, read, update, and delete examples.
+	Resource Resource
+
+	// Resources is the result of read list and update list examples.
+	Resources []Resource
+
+	// Error is returned by the handler instead of a result to illustrate an error
+	// response, e.g. a ResourceNotFound. Payloads which violate Rules also produce an
+	// error response without an Error.
+	Error error
+}
+
+// ExampleProvider is implemented by ResourceHandlers which document examples of their
+// endpoints. Examples are included in the generated documentation and can be kept
+// accurate using ValidateExamples.
+type ExampleProvider interface {
+	// Examples returns the handler's examples.
+	Examples() []Example
+}
+
+// TestingT is the subset of *testing.T used by ValidateExamples and Compare.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// ValidateExamples renders every example of the API's ResourceHandlers and reports an
+// error if the response doesn't match the example's golden file. The golden files are
+// kept in the Configuration's ExamplesDirectory, which defaults to testdata/examples,
+// and are regenerated instead when the UpdateExamplesEnv environment variable is set.
+func ValidateExamples(t TestingT, api API) {
+	dir := api.Configuration().ExamplesDirectory
+	if dir == "" {
+		dir = defaultExamplesDirectory
+	}
+	update := os.Getenv(UpdateExamplesEnv) != ""
+
+	for _, handler := range api.ResourceHandlers() {
+		provider, ok := unwrapHandler(handler).(ExampleProvider)
+		if !ok {
+			continue
+		}
+		for i, example := range provider.Examples() {
+			file := filepath.Join(dir, exampleFileName(handler, example, i))
+			_, body, err := renderExample(api.Configuration(), handler, example)
+			if err != nil {
+				t.Errorf("Failed to render example %s: %s", file, err)
+				continue
+			}
+
+			if update {
+				if err := os.MkdirAll(dir, 0777); err != nil {
+					t.Errorf("Failed to create %s: %s", dir, err)
+					return
+				}
+				if err := ioutil.WriteFile(file, body, 0644); err != nil {
+					t.Errorf("Failed to write %s: %s", file, err)
+				}
+				continue
+			}
+
+			golden, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Errorf("Missing golden file for example, run with %s=1: %s",
+					UpdateExamplesEnv, err)
+				continue
+			}
+			if !bytes.Equal(golden, body) {
+				t.Errorf("Example %s doesn't match its golden file:\nexpected: %s\nactual:   %s",
+					file, golden, body)
+			}
+		}
+	}
+}
+
+// exampleFileName returns the name of the golden file for the handler's example at
+// the index.
+func exampleFileName(handler ResourceHandler, example Example, index int) string {
+	return fmt.Sprintf("%s_v%s_%s_%d.json", fileNamePrefix(handler.ResourceName()),
+		normalizeVersion(example.Version), example.Verb, index)
+}
+
+// renderExample sends the example's request to the ResourceHandler's endpoint with its
+// result substituted, using the Configuration's request and response processing and
+// the ResourceHandler's registration options which shape responses, and returns the
+// response status and body.
+func renderExample(config *Configuration, handler ResourceHandler, example Example) (
+	int, []byte, error) {
+
+	exampleConfig := *config
+	exampleConfig.Debug = false
+	exampleConfig.GenerateDocs = false
+	exampleConfig.StatsURI = ""
+	exampleConfig.RuntimeFlags = nil
+	exampleConfig.Maintenance = nil
+	exampleConfig.OnMaintenanceChange = nil
+	exampleConfig.SupportedVersions = nil
+	api := NewAPI(&exampleConfig).(*muxAPI)
+	api.RegisterResourceHandler(exampleHandler{handler, example}, exampleOptions(handler)...)
+
+	route := api.router.Get(handler.ResourceName() + ":" + example.Verb)
+	if route == nil {
+		return 0, nil, fmt.Errorf("Unknown example verb %s", example.Verb)
+	}
+	id := example.ID
+	if id == "" {
+		id = "1"
+	}
+	url, err := route.URL(versionKey, example.Version, resourceIDKey, id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var body []byte
+	switch {
+	case example.Payloads != nil:
+		body, err = json.Marshal(example.Payloads)
+	case example.Payload != nil:
+		body, err = json.Marshal(example.Payload)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	methods, _ := route.GetMethods()
+	req, err := http.NewRequest(methods[0], url.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp.Code, resp.Body.Bytes(), nil
+}
+
+// exampleOptions returns the ResourceOptions the registered ResourceHandler was wrapped
+// with which shape its responses, its StrictOutput and ForVersions, so examples are
+// rendered as requests to it would be.
+func exampleOptions(handler ResourceHandler) []ResourceOption {
+	options := []ResourceOption{}
+	for {
+		switch wrapped := handler.(type) {
+		case strictHandler:
+			options = append(options, *wrapped.strict)
+		case versionedHandler:
+			options = append(options, ForVersions(wrapped.servesVersions()...))
+		}
+		wrapper, ok := handler.(handlerWrapper)
+		if !ok {
+			return options
+		}
+		handler = wrapper.unwrap()
+	}
+}
+
+// exampleHandler is a ResourceHandler which returns an Example's result.
+type exampleHandler struct {
+	ResourceHandler
+	example Example
+}
+
+// Authenticate allows every example request.
+func (e exampleHandler) Authenticate(r *http.Request) error {
+	return nil
+}
+
+// CreateResource returns the example's result.
+func (e exampleHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+
+	return e.example.Resource, e.example.Error
+}
+
+// ReadResourceList returns the example's results.
+func (e exampleHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+
+	return e.example.Resources, "", e.example.Error
+}
+
+// ReadResource returns the example's result.
+func (e exampleHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	return e.example.Resource, e.example.Error
+}
+
+// UpdateResourceList returns the example's results.
+func (e exampleHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+
+	return e.example.Resources, e.example.Error
+}
+
+// UpdateResource returns the example's result.
+func (e exampleHandler) UpdateResource(ctx RequestContext, id string, data Payload,
+	version string) (Resource, error) {
+
+	return e.example.Resource, e.example.Error
+}
+
+// DeleteResource returns the example's result.
+func (e exampleHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	return e.example.Resource, e.example.Error
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gadget is the resource type documented by the example tests.
+type gadget struct {
+	Name  string `json:"name"`
+	Price int    `json:"price"`
+}
+
+// gadgetHandler is a ResourceHandler which documents examples of its endpoints.
+type gadgetHandler struct {
+	BaseResourceHandler
+	examples []Example
+}
+
+func (g *gadgetHandler) ResourceName() string {
+	return "gadgets"
+}
+
+func (g *gadgetHandler) Rules() Rules {
+	return NewRules((*gadget)(nil),
+		&Rule{Field: "Name", FieldAlias: "name", Type: String, Required: true},
+		&Rule{Field: "Price", FieldAlias: "price", Type: Int, Versions: []string{"2"}},
+	)
+}
+
+func (g *gadgetHandler) CreateDocumentation() string {
+	return "Creates a gadget"
+}
+
+func (g *gadgetHandler) ReadDocumentation() string {
+	return "Retrieves a gadget"
+}
+
+func (g *gadgetHandler) Examples() []Example {
+	return g.examples
+}
+
+// recordingT is a TestingT which records the errors reported.
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+// newGadgetExamples returns examples of successful and failed gadget requests.
+func newGadgetExamples() []Example {
+	return []Example{
+		{Verb: ExampleRead, Version: "1", Description: "A gadget",
+			Resource: &gadget{Name: "widget", Price: 5}},
+		{Verb: ExampleRead, Version: "2", Description: "A gadget with its price",
+			Resource: &gadget{Name: "widget", Price: 5}},
+		{Verb: ExampleRead, Version: "1", Description: "A missing gadget",
+			Error: ResourceNotFound("No gadget with id 1")},
+		{Verb: ExampleCreate, Version: "1", Description: "A gadget without a name",
+			Payload: Payload{"price": 5}},
+	}
+}
+
+// Ensures that examples are rendered through the API's serialization, including Rules
+// and versions, and that both successful and error responses can be expressed.
+func TestRenderExample(t *testing.T) {
+	assert := assert.New(t)
+	handler := &resourceHandlerProxy{&gadgetHandler{}}
+	examples := newGadgetExamples()
+	expected := []struct {
+		status int
+		body   string
+	}{
+		{200, `{"messages":[],"reason":"OK","result":{"name":"widget"},"status":200}`},
+		{200, `{"messages":[],"reason":"OK","result":{"name":"widget","price":5},"status":200}`},
+		{404, `{"messages":["No gadget with id 1"],"reason":"Not Found","status":404}`},
+		{422, `{"errors":[{"field":"name","code":"required","message":"Missing required ` +
+			`field 'name'"}],"messages":["Missing required field 'name'"],` +
+			`"reason":"Unprocessable Entity","status":422}`},
+	}
+
+	for i, example := range examples {
+		status, body, err := renderExample(&Configuration{}, handler, example)
+		assert.Nil(err)
+		assert.Equal(expected[i].status, status, example.Description)
+		assert.Equal(expected[i].body, string(body), example.Description)
+	}
+
+	_, _, err := renderExample(&Configuration{}, handler, Example{Verb: "patch"})
+	assert.NotNil(err)
+}
+
+// Ensures that examples are rendered with the StrictOutput and ForVersions the
+// ResourceHandler was registered with.
+func TestRenderExampleRegistrationOptions(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(&gadgetHandler{}, StrictOutput{Mode: OutputEnforce},
+		ForVersions("2"))
+	handler := api.ResourceHandlers()[0]
+
+	status, _, err := renderExample(api.Configuration(), handler, Example{Verb: ExampleRead,
+		Version: "1", Resource: &gadget{Name: "widget"}})
+	assert.Nil(err)
+	assert.Equal(http.StatusNotFound, status)
+
+	status, body, err := renderExample(api.Configuration(), handler, Example{
+		Verb: ExampleRead, Version: "2", Resource: Payload{"name": "widget", "secret": 1}})
+	assert.Nil(err)
+	assert.Equal(http.StatusInternalServerError, status)
+	assert.NotContains(string(body), "secret")
+}
+
+// Ensures that ValidateExamples writes golden files with UpdateExamplesEnv set and
+// otherwise reports examples which are missing or don't match their golden files.
+func TestValidateExamples(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "examples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := &gadgetHandler{examples: newGadgetExamples()}
+	api := NewAPI(&Configuration{ExamplesDirectory: dir})
+	api.RegisterResourceHandler(handler)
+
+	recorder := &recordingT{}
+	ValidateExamples(recorder, api)
+	assert.Len(recorder.errors, 4)
+
+	os.Setenv(UpdateExamplesEnv, "1")
+	recorder = &recordingT{}
+	ValidateExamples(recorder, api)
+	os.Unsetenv(UpdateExamplesEnv)
+	assert.Len(recorder.errors, 0)
+	golden, err := ioutil.ReadFile(filepath.Join(dir, "gadgets_v2_read_1.json"))
+	assert.Nil(err)
+	assert.Equal(`{"messages":[],"reason":"OK","result":{"name":"widget","price":5},"status":200}`,
+		string(golden))
+
+	recorder = &recordingT{}
+	ValidateExamples(recorder, api)
+	assert.Len(recorder.errors, 0)
+
+	handler.examples[1].Resource = &gadget{Name: "widget", Price: 6}
+	recorder = &recordingT{}
+	ValidateExamples(recorder, api)
+	if assert.Len(recorder.errors, 1) {
+		assert.Contains(recorder.errors[0], "gadgets_v2_read_1.json doesn't match")
+	}
+}
+
+// Ensures that examples are included in the documentation of their endpoint and
+// version.
+func TestGenerateExamples(t *testing.T) {
+	assert := assert.New(t)
+	generator := &defaultContextGenerator{&Configuration{}}
+	handler := &resourceHandlerProxy{&gadgetHandler{examples: newGadgetExamples()}}
+
+	context, err := generator.generate(handler, "1")
+	assert.Nil(err)
+	endpoints := context["endpoints"].([]endpoint)
+	for _, endpoint := range endpoints {
+		switch endpoint["method"] {
+		case "POST":
+			examples := endpoint["examples"].([]map[string]interface{})
+			if assert.Len(examples, 1) {
+				assert.Equal(422, examples[0]["status"])
+				assert.Equal("{\n    \"price\": 5\n}", examples[0]["request"])
+			}
+		case "PUT", "DELETE":
+			assert.Nil(endpoint["examples"])
+		}
+		if endpoint["uri"] == "/api/v1/gadgets/:resource_id" && endpoint["method"] == "GET" {
+			examples := endpoint["examples"].([]map[string]interface{})
+			if assert.Len(examples, 2) {
+				assert.Equal("A gadget", examples[0]["description"])
+				assert.Equal("{\n    \"messages\": [],\n    \"reason\": \"OK\",\n"+
+					"    \"result\": {\n        \"name\": \"widget\"\n    },\n    \"status\": 200\n}",
+					examples[0]["response"])
+				assert.Equal(404, examples[1]["status"])
+			}
+		}
+	}
+}
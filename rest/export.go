@@ -0,0 +1,718 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	gcontext "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// ExportsCounter counts export jobs created for each resource.
+	ExportsCounter = "exports"
+
+	// ExportsFailedCounter counts export jobs for each resource which failed.
+	ExportsFailedCounter = "exports_failed"
+
+	// ExportFormatNDJSON exports one JSON object per line.
+	ExportFormatNDJSON = "ndjson"
+
+	// ExportFormatCSV exports a header row of field names followed by a row per
+	// resource. The columns are the fields declared by the resource's outbound Rules
+	// for the version or, without Rules, those of the first resource, and jobs listing
+	// a resource with other fields fail. Cells starting with =, +, -, @, a tab, or a
+	// carriage return are prefixed with ' so spreadsheets don't evaluate them as
+	// formulas.
+	ExportFormatCSV = "csv"
+
+	// Export job states.
+	ExportPending   = "pending"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+
+	// ExportFailedCode is the error code of export jobs which failed for a reason
+	// other than an Error with a code.
+	ExportFailedCode = "export_failed"
+
+	// exportPath is appended to a resource's read list URI to form the URI creating
+	// export jobs.
+	exportPath = "/_export"
+
+	// exportJobVar is the path variable holding an export job's ID.
+	exportJobVar = "job"
+
+	// exportQueueSize is the number of export jobs per resource which may be waiting
+	// to run before requests for more receive a 503.
+	exportQueueSize = 16
+
+	// exportSweepInterval is how often blobs of expired export jobs are deleted.
+	exportSweepInterval = time.Minute
+
+	// expiringExportsPrefix prefixes the Store key of the list of a resource's
+	// completed jobs whose files are deleted once they expire.
+	expiringExportsPrefix = "expiring:"
+
+	// maxExportRequestBytes is the maximum size of bodies creating export jobs.
+	maxExportRequestBytes = 4096
+
+	defaultExportRetention = 24 * time.Hour
+	defaultExportPageSize  = 100
+	defaultExportURLTTL    = 15 * time.Minute
+)
+
+// exportContentTypes are the content types of downloads in each export format.
+var exportContentTypes = map[string]string{
+	ExportFormatNDJSON: "application/x-ndjson",
+	ExportFormatCSV:    "text/csv; charset=utf-8",
+}
+
+// DataExport is a ResourceOption which lets principals export every resource matching
+// the read list filters as a downloadable file. POST
+// /api/:version/resource/_export with the filters in the query string and an optional
+// body such as {"format": "csv"} responds with a 202 and an ExportJob. The job reads
+// the resource list through the ResourceHandler page by page in the background,
+// applying outbound Rules, and writes it to the BlobStore. GET
+// /api/:version/resource/_export/{job} reports its progress and, once it completes, a
+// download URL, and GET /api/:version/resource/_export/{job}/download streams the file
+// with range support. Jobs are only visible to the principal which created them and
+// expire after the Retention. Jobs are kept in the Configuration's Store and run on the
+// instance which created them, so jobs waiting to run when it shuts down are never
+// run. Versioned resources must specify DataExport for every version or none.
+type DataExport struct {
+	// Blobs holds the exported files. It's required.
+	Blobs BlobStore
+
+	// Principal returns the principal making the request, which owns the jobs it
+	// creates. Requests without one receive a 403. It's required.
+	Principal func(*http.Request) string
+
+	// Retention is how long jobs and their files are kept after they're created.
+	// Defaults to 24 hours.
+	Retention time.Duration
+
+	// PageSize is the limit passed to ReadResourceList for each page. Defaults to 100.
+	PageSize int
+
+	// URLTTL is how long download URLs signed by a BlobURLSigner are valid. Defaults
+	// to 15 minutes.
+	URLTTL time.Duration
+}
+
+// apply sets the DataExport on the resource.
+func (e DataExport) apply(opts *resourceOptions) {
+	opts.export = &e
+}
+
+// retention returns how long jobs are kept.
+func (e *DataExport) retention() time.Duration {
+	if e.Retention <= 0 {
+		return defaultExportRetention
+	}
+	return e.Retention
+}
+
+// pageSize returns the limit of each page read.
+func (e *DataExport) pageSize() int {
+	if e.PageSize <= 0 {
+		return defaultExportPageSize
+	}
+	return e.PageSize
+}
+
+// urlTTL returns how long signed download URLs are valid.
+func (e *DataExport) urlTTL() time.Duration {
+	if e.URLTTL <= 0 {
+		return defaultExportURLTTL
+	}
+	return e.URLTTL
+}
+
+// ExportJob is the state of a resource export.
+type ExportJob struct {
+	ID       string `json:"id"`
+	Resource string `json:"resource"`
+	Format   string `json:"format"`
+
+	// Status is ExportPending, ExportRunning, ExportCompleted, or ExportFailed.
+	Status string `json:"status"`
+
+	// RowsExported is the number of resources written so far.
+	RowsExported int64 `json:"rows_exported"`
+
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+
+	// DownloadURL is where the file can be downloaded once the job completes.
+	DownloadURL string `json:"download_url,omitempty"`
+
+	// Error describes why the job failed, if it did.
+	Error *ExportError `json:"error,omitempty"`
+}
+
+// ExportError describes why an export job failed.
+type ExportError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// exportRecord is an ExportJob as it's kept in the Store.
+type exportRecord struct {
+	Job   ExportJob `json:"job"`
+	Owner string    `json:"owner"`
+}
+
+// exportKey returns the Store key and blob key of the resource's job.
+func exportKey(resource, id string) string {
+	return resource + ":" + id
+}
+
+// exportRun is an export job waiting to run.
+type exportRun struct {
+	record  exportRecord
+	export  *DataExport
+	handler ResourceHandler
+	req     *http.Request
+	version string
+}
+
+// exporter runs the export jobs of a resource one at a time in a background task and
+// deletes their files once they expire. Completed jobs are listed in the Store, so
+// files are deleted even if the instance which wrote them restarts.
+type exporter struct {
+	api      *muxAPI
+	resource string
+	runs     chan *exportRun
+	mu       sync.Mutex
+	blobs    []BlobStore
+}
+
+// expiringExport is a completed export job whose file is deleted once it expires.
+type expiringExport struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// exporter returns the exporter for the resource, starting its background task if
+// this is the first.
+func (r *muxAPI) exporter(resource string) *exporter {
+	if e, ok := r.exporters[resource]; ok {
+		return e
+	}
+	e := &exporter{api: r, resource: resource, runs: make(chan *exportRun, exportQueueSize)}
+	r.exporters[resource] = e
+	r.tasks.start("export:"+resource, restartPolicy{maxRestarts: -1, backoff: time.Second},
+		e.run)
+	return e
+}
+
+// run runs export jobs as they're queued until the Context is canceled.
+func (e *exporter) run(ctx context.Context) error {
+	sweep := time.NewTicker(exportSweepInterval)
+	defer sweep.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case run := <-e.runs:
+			e.export(ctx, run)
+		case now := <-sweep.C:
+			e.sweep(now)
+		}
+	}
+}
+
+// addBlobs adds the BlobStore to those the resource's files are deleted from.
+func (e *exporter) addBlobs(blobs BlobStore) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.blobs = append(e.blobs, blobs)
+}
+
+// sweep deletes the files of completed jobs which have expired from every BlobStore
+// of the resource and rewrites the list of those remaining. Jobs completing while the
+// list is rewritten may be dropped from it, leaving their files behind.
+func (e *exporter) sweep(now time.Time) {
+	store := e.api.store()
+	listKey := expiringExportsPrefix + e.resource
+	values, err := store.Range(ExportNamespace, listKey)
+	if err != nil {
+		e.api.config.Logf("Failed to list expiring exports of %s: %s", e.resource, err)
+		return
+	}
+	e.mu.Lock()
+	blobs := e.blobs
+	e.mu.Unlock()
+
+	remaining := [][]byte{}
+	for _, value := range values {
+		var expiring expiringExport
+		if err := json.Unmarshal(value, &expiring); err != nil {
+			continue
+		}
+		if now.Before(expiring.Expires) || !deleteBlob(blobs, expiring.Key, e.api.config.Logf) {
+			remaining = append(remaining, value)
+		}
+	}
+	if len(remaining) == len(values) {
+		return
+	}
+	if err := store.Delete(ExportNamespace, listKey); err != nil {
+		e.api.config.Logf("Failed to update expiring exports of %s: %s", e.resource, err)
+		return
+	}
+	for _, value := range remaining {
+		if err := store.Append(ExportNamespace, listKey, value); err != nil {
+			e.api.config.Logf("Failed to update expiring exports of %s: %s", e.resource, err)
+		}
+	}
+}
+
+// deleteBlob deletes the blob with the key from each BlobStore and returns true if it
+// was deleted from all of them, logging failures.
+func deleteBlob(blobs []BlobStore, key string, logf func(string, ...interface{})) bool {
+	deleted := true
+	for _, store := range blobs {
+		if err := store.Delete(key); err != nil {
+			logf("Failed to delete expired export %s: %s", key, err)
+			deleted = false
+		}
+	}
+	return deleted
+}
+
+// save stores the job's record until it expires.
+func (e *exporter) save(record exportRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	ttl := record.Job.ExpiresAt.Sub(time.Now())
+	if ttl <= 0 {
+		return nil
+	}
+	return e.api.store().Set(ExportNamespace, exportKey(record.Job.Resource, record.Job.ID),
+		data, ttl)
+}
+
+// export runs the job, writing every resource the handler lists to a blob which is
+// only committed if they're all written. Failures, including panics, mark the job
+// failed.
+func (e *exporter) export(ctx context.Context, run *exportRun) {
+	job := &run.record.Job
+	key := exportKey(job.Resource, job.ID)
+	defer gcontext.Clear(run.req)
+
+	job.Status = ExportRunning
+	e.saveProgress(run.record)
+	err := e.write(ctx, run, key)
+	now := time.Now()
+	job.CompletedAt = &now
+	if err != nil {
+		job.Status = ExportFailed
+		job.Error = &ExportError{Code: ExportFailedCode, Message: err.Error()}
+		if restErr, ok := err.(Error); ok && restErr.Code() != "" {
+			job.Error.Code = restErr.Code()
+		}
+		e.api.metrics.incr(ExportsFailedCounter, job.Resource)
+		e.api.config.Logf("Export %s failed: %s", key, err)
+	} else {
+		job.Status = ExportCompleted
+		data, _ := json.Marshal(expiringExport{key, job.ExpiresAt})
+		if err := e.api.store().Append(ExportNamespace, expiringExportsPrefix+job.Resource,
+			data); err != nil {
+			e.api.config.Logf("Failed to record expiring export %s: %s", key, err)
+		}
+	}
+	e.saveProgress(run.record)
+}
+
+// saveProgress stores the job's record, logging failures since the job continues
+// regardless.
+func (e *exporter) saveProgress(record exportRecord) {
+	if err := e.save(record); err != nil {
+		e.api.config.Logf("Failed to save export %s: %s",
+			exportKey(record.Job.Resource, record.Job.ID), err)
+	}
+}
+
+// write writes every resource the handler lists to the blob with the key, committing
+// it only if they're all written.
+func (e *exporter) write(ctx context.Context, run *exportRun, key string) (err error) {
+	writer, err := run.export.Blobs.Create(key)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("Export panicked: %v", recovered)
+		}
+		if err != nil {
+			writer.Abort()
+		}
+	}()
+
+	buffered := bufio.NewWriter(writer)
+	rules := run.handler.Rules()
+	encode := newExportEncoder(run.record.Job.Format, buffered, csvColumns(rules, run.version))
+	requestCtx := NewContext(ctx, run.req)
+	cursor := ""
+	for {
+		if ctx.Err() != nil {
+			return fmt.Errorf("Export canceled by shutdown")
+		}
+		resources, next, err := run.handler.ReadResourceList(requestCtx,
+			run.export.pageSize(), cursor, run.version)
+		if err != nil {
+			return err
+		}
+		for idx, resource := range resources {
+			resources[idx] = applyOutboundRules(resource, rules, run.version)
+		}
+		if resources, err = e.api.handler.enforceOutputList(run.handler, resources, rules,
+			run.version); err != nil {
+			return err
+		}
+		for _, resource := range resources {
+			if err := encode(resource); err != nil {
+				return err
+			}
+		}
+		run.record.Job.RowsExported += int64(len(resources))
+		e.saveProgress(run.record)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	return writer.Commit()
+}
+
+// newExportEncoder returns a function writing resources to the Writer in the format,
+// using the columns for CSV.
+func newExportEncoder(format string, w io.Writer, columns []string) func(Resource) error {
+	if format == ExportFormatCSV {
+		return newCSVEncoder(w, columns)
+	}
+	return func(resource Resource) error {
+		data, err := json.Marshal(resource)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+}
+
+// csvColumns returns the sorted names of the fields declared by the outbound Rules for
+// the version, or nil if there are none.
+func csvColumns(rules Rules, version string) []string {
+	var columns []string
+	for _, rule := range rules.Filter(false).ForVersion(version).Contents() {
+		if rule.isResourceRule() {
+			columns = append(columns, rule.Name())
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// newCSVEncoder returns a function writing resources to the Writer as CSV rows. The
+// columns are the provided ones or, if there are none, the fields of the first
+// resource in sorted order. Resources with fields not among them fail with an error.
+// Nested values are written as JSON and cells are escaped using csvCell.
+func newCSVEncoder(w io.Writer, columns []string) func(Resource) error {
+	writer := csv.NewWriter(w)
+	var known map[string]bool
+	return func(resource Resource) error {
+		data, err := json.Marshal(resource)
+		if err != nil {
+			return err
+		}
+		var fields map[string]interface{}
+		if err := unmarshalJSON(data, &fields, true); err != nil || fields == nil {
+			return fmt.Errorf("Only objects can be exported as CSV")
+		}
+		if known == nil {
+			if len(columns) == 0 {
+				for field := range fields {
+					columns = append(columns, field)
+				}
+				sort.Strings(columns)
+			}
+			known = make(map[string]bool, len(columns))
+			header := make([]string, len(columns))
+			for i, column := range columns {
+				known[column] = true
+				header[i] = csvCell(column)
+			}
+			if err := writer.Write(header); err != nil {
+				return err
+			}
+		}
+		for field := range fields {
+			if !known[field] {
+				return fmt.Errorf("Field '%s' isn't among the CSV columns", field)
+			}
+		}
+
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			switch value := fields[column].(type) {
+			case nil:
+			case string:
+				row[i] = csvCell(value)
+			case json.Number:
+				row[i] = value.String()
+			default:
+				encoded, _ := json.Marshal(value)
+				row[i] = string(encoded)
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+}
+
+// csvCell returns the string prefixed with ' if it starts with a character which
+// makes spreadsheets evaluate it as a formula.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// exportURI returns the URI creating export jobs for the ResourceHandler.
+func exportURI(h ResourceHandler) string {
+	return strings.TrimSuffix(h.ReadListURI(), "/") + exportPath
+}
+
+// exportRoutes returns the routes with the routes serving export jobs added after the
+// read list route, before the read route which would otherwise match them.
+func (r *muxAPI) exportRoutes(h ResourceHandler, export *DataExport, routes []resourceRoute,
+	middleware []RequestMiddleware) []resourceRoute {
+
+	resource := h.ResourceName()
+	if export.Blobs == nil || export.Principal == nil {
+		panic(fmt.Sprintf("DataExport for %s must specify Blobs and Principal", resource))
+	}
+	e := r.exporter(resource)
+	e.addBlobs(export.Blobs)
+	jobURI := exportURI(h) + "/{" + exportJobVar + "}"
+	withExport := make([]resourceRoute, 0, len(routes)+3)
+	for _, route := range routes {
+		withExport = append(withExport, route)
+		if route.name == "readList" {
+			withExport = append(withExport,
+				resourceRoute{"export", "export", "POST", exportURI(h), "",
+					applyMiddleware(e.handleCreate(h, export), middleware)},
+				resourceRoute{"exportStatus", "export status", "GET", jobURI, "",
+					applyMiddleware(e.handleStatus(h, export), middleware)},
+				resourceRoute{"exportDownload", "export download", "GET", jobURI + "/download",
+					"", applyMiddleware(e.handleDownload(h, export), middleware)},
+			)
+		}
+	}
+	return withExport
+}
+
+// handleCreate returns a HandlerFunc which queues an export job for the request's
+// principal and responds with a 202 and the ExportJob.
+func (e *exporter) handleCreate(handler ResourceHandler, export *DataExport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(nil, r)
+		resource := handler.ResourceName()
+		principal := export.Principal(r)
+		if principal == "" {
+			e.api.handler.sendResponse(w, ctx.setError(ResourceNotPermitted(
+				"Exports require an identified principal")))
+			return
+		}
+
+		var body struct {
+			Format string `json:"format"`
+		}
+		raw, err := ioutil.ReadAll(io.LimitReader(r.Body, maxExportRequestBytes))
+		if err == nil && len(strings.TrimSpace(string(raw))) > 0 {
+			err = json.Unmarshal(raw, &body)
+		}
+		if err != nil {
+			e.api.handler.sendResponse(w, ctx.setError(BadRequest(
+				"Export requests must be a JSON object")))
+			return
+		}
+		if body.Format == "" {
+			body.Format = ExportFormatNDJSON
+		}
+		if _, ok := exportContentTypes[body.Format]; !ok {
+			e.api.handler.sendResponse(w, ctx.setError(BadRequest(
+				fmt.Sprintf("Unsupported export format %s", body.Format))))
+			return
+		}
+
+		id, err := newUUID()
+		if err != nil {
+			e.api.handler.sendResponse(w, ctx.setError(err))
+			return
+		}
+		now := time.Now()
+		run := &exportRun{
+			record: exportRecord{Owner: principal, Job: ExportJob{
+				ID:        id,
+				Resource:  resource,
+				Format:    body.Format,
+				Status:    ExportPending,
+				CreatedAt: now,
+				ExpiresAt: now.Add(export.retention()),
+			}},
+			export:  export,
+			handler: handler,
+			req:     exportRequest(r),
+			version: ctx.Version(),
+		}
+		if err := e.save(run.record); err != nil {
+			gcontext.Clear(run.req)
+			e.api.handler.sendResponse(w, ctx.setError(err))
+			return
+		}
+		job := run.record.Job
+		select {
+		case e.runs <- run:
+		default:
+			gcontext.Clear(run.req)
+			e.api.store().Delete(ExportNamespace, exportKey(resource, job.ID))
+			e.api.handler.sendResponse(w, ctx.setError(ServiceUnavailable(
+				"Too many exports are queued, try again later")))
+			return
+		}
+
+		e.api.metrics.incr(ExportsCounter, resource)
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+job.ID)
+		ctx = ctx.setResult(job)
+		ctx = ctx.setStatus(http.StatusAccepted)
+		e.api.handler.sendResponse(w, ctx)
+	}
+}
+
+// exportRequest returns a copy of the request, with the same query and the values set
+// on it so far, used to read the resource list once the request has completed.
+func exportRequest(r *http.Request) *http.Request {
+	req, _ := http.NewRequest("GET", r.URL.String(), nil)
+	req.Host = r.Host
+	req.RemoteAddr = r.RemoteAddr
+	for key, values := range r.Header {
+		req.Header[key] = append([]string{}, values...)
+	}
+	for key, value := range gcontext.GetAll(r) {
+		gcontext.Set(req, key, value)
+	}
+	return req
+}
+
+// job returns the job the request is for if it belongs to the request's principal.
+// Returns a 404 if it doesn't exist, has expired, or belongs to another principal.
+func (e *exporter) job(r *http.Request, resource string, export *DataExport) (ExportJob,
+	error) {
+
+	notFound := ResourceNotFound("Export not found")
+	principal := export.Principal(r)
+	data, ok, err := e.api.store().Get(ExportNamespace,
+		exportKey(resource, mux.Vars(r)[exportJobVar]))
+	if err != nil {
+		return ExportJob{}, err
+	}
+	var record exportRecord
+	if !ok || json.Unmarshal(data, &record) != nil || principal == "" ||
+		record.Owner != principal {
+		return ExportJob{}, notFound
+	}
+	return record.Job, nil
+}
+
+// handleStatus returns a HandlerFunc which responds with the ExportJob, including its
+// download URL if it has completed.
+func (e *exporter) handleStatus(handler ResourceHandler, export *DataExport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(nil, r)
+		job, err := e.job(r, handler.ResourceName(), export)
+		if err == nil && job.Status == ExportCompleted {
+			job.DownloadURL = strings.TrimSuffix(r.URL.Path, "/") + "/download"
+			if signer, ok := export.Blobs.(BlobURLSigner); ok {
+				job.DownloadURL, err = signer.SignedURL(exportKey(job.Resource, job.ID),
+					export.urlTTL())
+			}
+		}
+		ctx = ctx.setResult(job)
+		ctx = ctx.setError(err)
+		ctx = ctx.setStatus(http.StatusOK)
+		e.api.handler.sendResponse(w, ctx)
+	}
+}
+
+// handleDownload returns a HandlerFunc which streams the completed job's file,
+// supporting range requests. Jobs which haven't completed receive a 409.
+func (e *exporter) handleDownload(handler ResourceHandler, export *DataExport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(nil, r)
+		job, err := e.job(r, handler.ResourceName(), export)
+		if err != nil {
+			e.api.handler.sendResponse(w, ctx.setError(err))
+			return
+		}
+		if job.Status != ExportCompleted {
+			e.api.handler.sendResponse(w, ctx.setError(ResourceConflict(
+				fmt.Sprintf("Export is %s", job.Status))))
+			return
+		}
+		blob, ok, err := export.Blobs.Open(exportKey(job.Resource, job.ID))
+		if err == nil && !ok {
+			err = ResourceNotFound("Export has expired")
+		}
+		if err != nil {
+			e.api.handler.sendResponse(w, ctx.setError(err))
+			return
+		}
+		defer blob.Close()
+
+		name := fmt.Sprintf("%s-%s.%s", job.Resource, job.ID, job.Format)
+		w.Header().Set("Content-Type", exportContentTypes[job.Format])
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		http.ServeContent(w, r, name, *job.CompletedAt, blob)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordHandler lists records a page at a time, failing at the page with the index
+// failAt if it's positive.
+type recordHandler struct {
+	BaseResourceHandler
+	records []Resource
+	failAt  int
+	filters chan []QueryFilter
+}
+
+// ResourceName returns "records".
+func (h recordHandler) ResourceName() string {
+	return "records"
+}
+
+// ReadResourceList returns the page of records after the cursor.
+func (h recordHandler) ReadResourceList(ctx RequestContext, limit int, cursor string,
+	version string) ([]Resource, string, error) {
+
+	if h.filters != nil && cursor == "" {
+		h.filters <- ctx.Filters()
+	}
+	start, _ := strconv.Atoi(cursor)
+	if h.failAt > 0 && start >= h.failAt*limit {
+		return nil, "", InternalServerError("Database unavailable").WithCode("db_down")
+	}
+	end := start + limit
+	if end >= len(h.records) {
+		return h.records[start:], "", nil
+	}
+	return h.records[start:end], strconv.Itoa(end), nil
+}
+
+// newRecords returns n records.
+func newRecords(n int) []Resource {
+	records := make([]Resource, n)
+	for i := range records {
+		records[i] = map[string]interface{}{"id": i, "name": "record " + strconv.Itoa(i)}
+	}
+	return records
+}
+
+// newExportAPI returns an API exporting the handler's records in pages of two to a
+// FileBlobStore in the directory, with the principal taken from the X-User header.
+func newExportAPI(t *testing.T, handler recordHandler) (API, string) {
+	dir, err := ioutil.TempDir("", "export-test")
+	assert.Nil(t, err)
+	blobs, err := NewFileBlobStore(dir)
+	assert.Nil(t, err)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, DataExport{
+		Blobs:     blobs,
+		Principal: func(r *http.Request) string { return r.Header.Get("X-User") },
+		PageSize:  2,
+	})
+	return api, dir
+}
+
+// serveExport sends a request on behalf of the user.
+func serveExport(api API, user, method, url, body string,
+	header ...string) *httptest.ResponseRecorder {
+
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// awaitExport polls the job until it finishes and returns its last state.
+func awaitExport(api API, user, location string) ExportJob {
+	var job ExportJob
+	for i := 0; i < 200; i++ {
+		resp := serveExport(api, user, "GET", "http://foo.com"+location, "")
+		var body struct {
+			Result ExportJob `json:"result"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		job = body.Result
+		if job.Status == ExportCompleted || job.Status == ExportFailed {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return job
+}
+
+// Ensures that an export job reads every page of the list with the request's filters,
+// reports its progress, and serves the NDJSON file with range support once complete.
+func TestDataExportNDJSON(t *testing.T) {
+	assert := assert.New(t)
+	filters := make(chan []QueryFilter, 1)
+	api, dir := newExportAPI(t, recordHandler{records: newRecords(5), filters: filters})
+	defer os.RemoveAll(dir)
+
+	resp := serveExport(api, "alice", "POST",
+		"http://foo.com/api/v1/records/_export?filter[name]=record", "")
+	assert.Equal(http.StatusAccepted, resp.Code)
+	location := resp.Header().Get("Location")
+	assert.True(strings.HasPrefix(location, "/api/v1/records/_export/"))
+
+	job := awaitExport(api, "alice", location)
+	assert.Equal(ExportCompleted, job.Status)
+	assert.Equal(int64(5), job.RowsExported)
+	assert.Equal(location+"/download", job.DownloadURL)
+	assert.Equal([]QueryFilter{{Field: "name", Operator: FilterEqual, Value: "record"}},
+		<-filters)
+	assert.Equal(uint64(1), api.Metrics().Counter(ExportsCounter, "records"))
+
+	resp = serveExport(api, "alice", "GET", "http://foo.com"+job.DownloadURL, "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("application/x-ndjson", resp.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(resp.Body.String()), "\n")
+	if assert.Len(lines, 5) {
+		assert.Equal(`{"id":0,"name":"record 0"}`, lines[0])
+	}
+
+	resp = serveExport(api, "alice", "GET", "http://foo.com"+job.DownloadURL, "",
+		"Range", "bytes=0-5")
+	assert.Equal(http.StatusPartialContent, resp.Code)
+	assert.Equal(`{"id":`, resp.Body.String())
+}
+
+// Ensures that records are exported as CSV with a header row of their fields.
+func TestDataExportCSV(t *testing.T) {
+	assert := assert.New(t)
+	api, dir := newExportAPI(t, recordHandler{records: newRecords(3)})
+	defer os.RemoveAll(dir)
+
+	resp := serveExport(api, "alice", "POST", "http://foo.com/api/v1/records/_export",
+		`{"format": "csv"}`)
+	assert.Equal(http.StatusAccepted, resp.Code)
+	job := awaitExport(api, "alice", resp.Header().Get("Location"))
+	assert.Equal(ExportCompleted, job.Status)
+
+	resp = serveExport(api, "alice", "GET", "http://foo.com"+job.DownloadURL, "")
+	assert.Equal("text/csv; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Equal("id,name\n0,record 0\n1,record 1\n2,record 2\n", resp.Body.String())
+
+	resp = serveExport(api, "alice", "POST", "http://foo.com/api/v1/records/_export",
+		`{"format": "xml"}`)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+// Ensures that export jobs are only visible to the principal which created them and
+// that requests without a principal can't create them.
+func TestDataExportOwnership(t *testing.T) {
+	assert := assert.New(t)
+	api, dir := newExportAPI(t, recordHandler{records: newRecords(1)})
+	defer os.RemoveAll(dir)
+
+	resp := serveExport(api, "", "POST", "http://foo.com/api/v1/records/_export", "")
+	assert.Equal(http.StatusForbidden, resp.Code)
+
+	resp = serveExport(api, "alice", "POST", "http://foo.com/api/v1/records/_export", "")
+	location := resp.Header().Get("Location")
+	assert.Equal(ExportCompleted, awaitExport(api, "alice", location).Status)
+
+	resp = serveExport(api, "mallory", "GET", "http://foo.com"+location, "")
+	assert.Equal(http.StatusNotFound, resp.Code)
+	resp = serveExport(api, "mallory", "GET", "http://foo.com"+location+"/download", "")
+	assert.Equal(http.StatusNotFound, resp.Code)
+}
+
+// Ensures that a failure mid-export marks the job failed with a structured error and
+// leaves no file behind.
+func TestDataExportFailure(t *testing.T) {
+	assert := assert.New(t)
+	api, dir := newExportAPI(t, recordHandler{records: newRecords(5), failAt: 1})
+	defer os.RemoveAll(dir)
+
+	resp := serveExport(api, "alice", "POST", "http://foo.com/api/v1/records/_export", "")
+	location := resp.Header().Get("Location")
+	job := awaitExport(api, "alice", location)
+	assert.Equal(ExportFailed, job.Status)
+	assert.Equal(int64(2), job.RowsExported)
+	assert.Equal(&ExportError{Code: "db_down", Message: "Database unavailable"}, job.Error)
+	assert.Empty(job.DownloadURL)
+	assert.Equal(uint64(1), api.Metrics().Counter(ExportsFailedCounter, "records"))
+
+	resp = serveExport(api, "alice", "GET", "http://foo.com"+location+"/download", "")
+	assert.Equal(http.StatusConflict, resp.Code)
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(err)
+	assert.Empty(files)
+}
+
+// Ensures that CSV columns come from the Rules when there are any, that resources with
+// fields outside the columns fail, and that cells which spreadsheets would evaluate
+// as formulas are escaped.
+func TestCSVEncoder(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "name", FieldAlias: "name"},
+		&Rule{Field: "id", FieldAlias: "id"},
+		&Rule{Field: "secret", InputOnly: true},
+	)
+	assert.Equal([]string{"id", "name"}, csvColumns(rules, "1"))
+	assert.Nil(csvColumns(NewRules((*TestResource)(nil)), "1"))
+
+	var buf strings.Builder
+	encode := newCSVEncoder(&buf, []string{"id", "name"})
+	assert.Nil(encode(Payload{"name": "=HYPERLINK(\"http://evil\")"}))
+	assert.Nil(encode(Payload{"id": json.Number("-1"), "name": "@SUM(A1)"}))
+	assert.Nil(encode(Payload{"id": 2, "name": "-2+3"}))
+	assert.NotNil(encode(Payload{"id": 3, "extra": "x"}))
+	assert.Equal("id,name\n,\"'=HYPERLINK(\"\"http://evil\"\")\"\n-1,'@SUM(A1)\n2,'-2+3\n",
+		buf.String())
+
+	buf.Reset()
+	encode = newCSVEncoder(&buf, nil)
+	assert.Nil(encode(Payload{"id": 1}))
+	assert.NotNil(encode(Payload{"id": 2, "name": "late"}))
+	assert.Equal("id\n1\n", buf.String())
+}
+
+// Ensures that the files of completed jobs are deleted once they expire by an API
+// sharing the Store, e.g. after the instance which wrote them restarts.
+func TestDataExportSweepAfterRestart(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "export-test")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	blobs, _ := NewFileBlobStore(dir)
+	store := NewMemoryStore()
+	newAPI := func() API {
+		api := NewAPI(&Configuration{Store: store})
+		api.RegisterResourceHandler(recordHandler{records: newRecords(3)}, DataExport{
+			Blobs:     blobs,
+			Principal: func(r *http.Request) string { return r.Header.Get("X-User") },
+		})
+		return api
+	}
+
+	api := newAPI()
+	resp := serveExport(api, "alice", "POST", "http://foo.com/api/v1/records/_export", "")
+	job := awaitExport(api, "alice", resp.Header().Get("Location"))
+	assert.Equal(ExportCompleted, job.Status)
+	assert.Nil(api.Shutdown(time.Second))
+
+	restarted := newAPI().(*muxAPI)
+	exporter := restarted.exporters["records"]
+	exporter.sweep(time.Now())
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(files, 1)
+
+	exporter.sweep(job.ExpiresAt)
+	files, _ = ioutil.ReadDir(dir)
+	assert.Empty(files)
+	expiring, err := store.Range(ExportNamespace, expiringExportsPrefix+"records")
+	assert.Nil(err)
+	assert.Empty(expiring)
+}
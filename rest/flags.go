@@ -0,0 +1,543 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// FlagDebug is the runtime flag overriding the Configuration's Debug, e.g. "true".
+	FlagDebug = "debug"
+
+	// FlagLogLevel is the runtime flag setting the API's log level, either
+	// LogLevelInfo or LogLevelDebug. Suffixing it with a resource name, e.g.
+	// "log_level:widgets", sets the level of messages about that resource only.
+	FlagLogLevel = "log_level"
+
+	// FlagSlowRequestThreshold is the runtime flag overriding the Configuration's
+	// SlowRequestThreshold, e.g. "250ms". "0" disables slow request logging.
+	FlagSlowRequestThreshold = "slow_request_threshold"
+
+	// FlagCaptureSampleRate is the runtime flag overriding the SampleRate of every
+	// resource's BodyCapture, e.g. "0.5".
+	FlagCaptureSampleRate = "capture_sample_rate"
+
+	// LogLevelInfo logs messages regardless of debug mode but not debug messages.
+	LogLevelInfo = "info"
+
+	// LogLevelDebug logs debug messages in addition to LogLevelInfo messages.
+	LogLevelDebug = "debug"
+
+	// SlowRequestsCounter counts requests to each resource which took longer than the
+	// slow request threshold.
+	SlowRequestsCounter = "slow_requests"
+
+	// programmaticPrincipal is the principal logged for flags set using
+	// API#SetRuntimeFlag.
+	programmaticPrincipal = "api"
+)
+
+// RuntimeFlagsEndpoint serves the API's runtime flags so they can be adjusted without
+// redeploying, e.g. during an incident. GET requests to the URI list the flags which
+// are set, PUT requests to the URI followed by a flag name, e.g. /_flags/debug, set it
+// from a body such as {"value": "true", "ttl_seconds": 600}, and DELETE requests reset
+// it. Only the Flag constants may be set, so the endpoint can't change any other
+// Configuration. The endpoint remains available during maintenance.
+type RuntimeFlagsEndpoint struct {
+	// URI is the URI at which the flags are served, e.g. /_flags.
+	URI string
+
+	// Authenticate returns the principal making the request, which is logged with
+	// every change, or an error if it may not adjust flags. It's required.
+	Authenticate func(*http.Request) (string, error)
+}
+
+// RuntimeFlag is a runtime flag which has been set.
+type RuntimeFlag struct {
+	Name  string
+	Value string
+
+	// SetBy is the principal which set the flag.
+	SetBy string
+
+	// Expires is when the flag reverts, or zero if it lasts until it's reset.
+	Expires time.Time
+
+	// parsed is the Value as the type the flag is read as.
+	parsed interface{}
+}
+
+// stats returns the RuntimeFlag as it should be reported by the stats endpoint.
+func (f RuntimeFlag) stats() map[string]interface{} {
+	stats := map[string]interface{}{"value": f.Value, "set_by": f.SetBy}
+	if !f.Expires.IsZero() {
+		stats["expires"] = f.Expires.UTC().Format(time.RFC3339)
+	}
+	return stats
+}
+
+// runtimeFlags holds the runtime flags set on an API. Flags are read on the hot path
+// without locking from an immutable map which is replaced whenever one changes.
+type runtimeFlags struct {
+	mu         sync.Mutex
+	current    atomic.Value
+	timers     map[string]flagTimer
+	generation uint64
+	logf       func(string, ...interface{})
+	now        func() time.Time
+}
+
+// flagTimer reverts a flag when its TTL expires. Its generation identifies the set
+// which scheduled it, so a timer firing after the flag is set again is ignored.
+type flagTimer struct {
+	timer      *time.Timer
+	generation uint64
+}
+
+// newRuntimeFlags returns runtimeFlags with none set which log changes using the
+// function.
+func newRuntimeFlags(logf func(string, ...interface{})) *runtimeFlags {
+	f := &runtimeFlags{timers: map[string]flagTimer{}, logf: logf, now: time.Now}
+	f.current.Store(map[string]RuntimeFlag{})
+	return f
+}
+
+// lookup returns the parsed value of the flag, or false if it isn't set. It's safe to
+// call on nil runtimeFlags, e.g. for a Configuration no API was created with.
+func (f *runtimeFlags) lookup(name string) (interface{}, bool) {
+	if f == nil {
+		return nil, false
+	}
+	flag, ok := f.current.Load().(map[string]RuntimeFlag)[name]
+	return flag.parsed, ok
+}
+
+// list returns the flags which are set, ordered by name.
+func (f *runtimeFlags) list() []RuntimeFlag {
+	current := f.current.Load().(map[string]RuntimeFlag)
+	flags := make([]RuntimeFlag, 0, len(current))
+	for _, flag := range current {
+		flags = append(flags, flag)
+	}
+	for i := 1; i < len(flags); i++ {
+		for j := i; j > 0 && flags[j].Name < flags[j-1].Name; j-- {
+			flags[j], flags[j-1] = flags[j-1], flags[j]
+		}
+	}
+	return flags
+}
+
+// stats returns the flags which are set as they should be reported by the stats
+// endpoint.
+func (f *runtimeFlags) stats() map[string]interface{} {
+	stats := map[string]interface{}{}
+	for name, flag := range f.current.Load().(map[string]RuntimeFlag) {
+		stats[name] = flag.stats()
+	}
+	return stats
+}
+
+// set sets the flag, replacing any previous value, and schedules it to revert after
+// the TTL if it's positive.
+func (f *runtimeFlags) set(flag RuntimeFlag, ttl time.Duration) RuntimeFlag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if scheduled, ok := f.timers[flag.Name]; ok {
+		scheduled.timer.Stop()
+		delete(f.timers, flag.Name)
+	}
+
+	until := "until reset"
+	if ttl > 0 {
+		flag.Expires = f.now().Add(ttl)
+		until = "for " + ttl.String()
+		f.generation++
+		generation := f.generation
+		f.timers[flag.Name] = flagTimer{
+			timer:      time.AfterFunc(ttl, func() { f.revert(flag.Name, generation) }),
+			generation: generation,
+		}
+	}
+	f.replace(flag.Name, &flag)
+	f.logf("Runtime flag %s set to %q by %s %s", flag.Name, flag.Value, flag.SetBy, until)
+	return flag
+}
+
+// reset unsets the flag so its configured value applies again.
+func (f *runtimeFlags) reset(name, principal string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if scheduled, ok := f.timers[name]; ok {
+		scheduled.timer.Stop()
+		delete(f.timers, name)
+	}
+	if _, ok := f.current.Load().(map[string]RuntimeFlag)[name]; !ok {
+		return
+	}
+	f.replace(name, nil)
+	f.logf("Runtime flag %s reset by %s", name, principal)
+}
+
+// revert unsets the flag when its TTL expires unless it has been set again since the
+// timer of the generation was scheduled.
+func (f *runtimeFlags) revert(name string, generation uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if scheduled, ok := f.timers[name]; !ok || scheduled.generation != generation {
+		return
+	}
+	delete(f.timers, name)
+	f.replace(name, nil)
+	f.logf("Runtime flag %s reverted after its TTL expired", name)
+}
+
+// replace stores a copy of the current flags with the named one replaced, or removed
+// if it's nil. The caller must hold the lock.
+func (f *runtimeFlags) replace(name string, flag *RuntimeFlag) {
+	current := f.current.Load().(map[string]RuntimeFlag)
+	next := make(map[string]RuntimeFlag, len(current)+1)
+	for k, v := range current {
+		next[k] = v
+	}
+	if flag == nil {
+		delete(next, name)
+	} else {
+		next[name] = *flag
+	}
+	f.current.Store(next)
+}
+
+// logLevelFlag returns the name of the log level flag for the resource, or the
+// global one if it's empty.
+func logLevelFlag(resource string) string {
+	if resource == "" {
+		return FlagLogLevel
+	}
+	return FlagLogLevel + ":" + resource
+}
+
+// checkRuntimeFlag returns an error if the named flag isn't one which may be set at
+// runtime.
+func (r *muxAPI) checkRuntimeFlag(name string) error {
+	switch name {
+	case FlagDebug, FlagLogLevel, FlagSlowRequestThreshold, FlagCaptureSampleRate:
+		return nil
+	}
+	resource := strings.TrimPrefix(name, FlagLogLevel+":")
+	if resource == name || resource == "" {
+		return fmt.Errorf("Unknown runtime flag %s", name)
+	}
+	if !r.hasResource(resource) {
+		return fmt.Errorf("Runtime flag %s names an unknown resource", name)
+	}
+	return nil
+}
+
+// parseRuntimeFlag returns the value of the named flag as the type it's read as.
+// Returns an error if the flag isn't one which may be set at runtime or the value is
+// invalid for it.
+func (r *muxAPI) parseRuntimeFlag(name, value string) (interface{}, error) {
+	if err := r.checkRuntimeFlag(name); err != nil {
+		return nil, err
+	}
+	switch name {
+	case FlagDebug:
+		debug, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Runtime flag %s must be a boolean", name)
+		}
+		return debug, nil
+	case FlagSlowRequestThreshold:
+		threshold, err := time.ParseDuration(value)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("Runtime flag %s must be a non-negative duration", name)
+		}
+		return threshold, nil
+	case FlagCaptureSampleRate:
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("Runtime flag %s must be a number between 0 and 1", name)
+		}
+		return rate, nil
+	}
+	if value != LogLevelInfo && value != LogLevelDebug {
+		return nil, fmt.Errorf("Runtime flag %s must be %s or %s", name, LogLevelInfo,
+			LogLevelDebug)
+	}
+	return value, nil
+}
+
+// hasResource returns true if a ResourceHandler is registered for the resource.
+func (r *muxAPI) hasResource(resource string) bool {
+	for _, reg := range r.registrations {
+		if reg.handler.ResourceName() == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRuntimeFlag sets the named runtime flag, one of the Flag constants, to the value
+// until it's reset or, if the TTL is positive, the TTL elapses. The change applies to
+// requests immediately and is logged. Returns an error if the flag can't be set at
+// runtime or the value is invalid for it.
+func (r *muxAPI) SetRuntimeFlag(name, value string, ttl time.Duration) error {
+	_, err := r.setRuntimeFlag(name, value, ttl, programmaticPrincipal)
+	return err
+}
+
+// setRuntimeFlag sets the named runtime flag on behalf of the principal.
+func (r *muxAPI) setRuntimeFlag(name, value string, ttl time.Duration,
+	principal string) (RuntimeFlag, error) {
+
+	parsed, err := r.parseRuntimeFlag(name, value)
+	if err != nil {
+		return RuntimeFlag{}, err
+	}
+	return r.flags.set(RuntimeFlag{Name: name, Value: value, SetBy: principal,
+		parsed: parsed}, ttl), nil
+}
+
+// ResetRuntimeFlag unsets the named runtime flag so the configured value applies
+// again. Returns an error if the flag can't be set at runtime.
+func (r *muxAPI) ResetRuntimeFlag(name string) error {
+	return r.resetRuntimeFlag(name, programmaticPrincipal)
+}
+
+// resetRuntimeFlag unsets the named runtime flag on behalf of the principal.
+func (r *muxAPI) resetRuntimeFlag(name, principal string) error {
+	if err := r.checkRuntimeFlag(name); err != nil {
+		return err
+	}
+	r.flags.reset(name, principal)
+	return nil
+}
+
+// RuntimeFlags returns the runtime flags which are set, ordered by name.
+func (r *muxAPI) RuntimeFlags() []RuntimeFlag {
+	return r.flags.list()
+}
+
+// runtimeFlagRequest is the body of a request setting a runtime flag.
+type runtimeFlagRequest struct {
+	Value      *string `json:"value"`
+	TTLSeconds int     `json:"ttl_seconds"`
+}
+
+// runtimeFlagResponse is a RuntimeFlag as served by the RuntimeFlagsEndpoint.
+type runtimeFlagResponse struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	SetBy   string `json:"set_by"`
+	Expires string `json:"expires,omitempty"`
+}
+
+// newRuntimeFlagResponse returns the runtimeFlagResponse for the RuntimeFlag.
+func newRuntimeFlagResponse(flag RuntimeFlag) runtimeFlagResponse {
+	response := runtimeFlagResponse{Name: flag.Name, Value: flag.Value, SetBy: flag.SetBy}
+	if !flag.Expires.IsZero() {
+		response.Expires = flag.Expires.UTC().Format(time.RFC3339)
+	}
+	return response
+}
+
+// registerRuntimeFlagsEndpoint registers the routes serving the RuntimeFlagsEndpoint.
+// It panics if the endpoint has no URI or Authenticate function.
+func (r *muxAPI) registerRuntimeFlagsEndpoint(endpoint *RuntimeFlagsEndpoint) {
+	if endpoint.URI == "" || endpoint.Authenticate == nil {
+		panic("RuntimeFlagsEndpoint must specify a URI and Authenticate")
+	}
+	list := r.router.HandleFunc(endpoint.URI, r.handleRuntimeFlags(endpoint)).
+		Methods("GET").Name("flags:list")
+	r.addRoute(RouteInfo{Name: "flags:list", Kind: FlagsRoute, Method: "GET",
+		Path: endpoint.URI, CallSite: callSite(2)}, list)
+
+	path := strings.TrimSuffix(endpoint.URI, "/") + "/{name}"
+	for _, method := range []string{"PUT", "DELETE"} {
+		name := "flags:" + strings.ToLower(method)
+		route := r.router.HandleFunc(path, r.handleRuntimeFlags(endpoint)).Methods(method).
+			Name(name)
+		r.addRoute(RouteInfo{Name: name, Kind: FlagsRoute, Method: method, Path: path,
+			CallSite: callSite(2)}, route)
+	}
+}
+
+// handleRuntimeFlags returns an http.HandlerFunc which serves requests to the
+// RuntimeFlagsEndpoint. Unauthenticated requests receive a 401 and requests for flags
+// which can't be set at runtime or with invalid values receive a 400.
+func (r *muxAPI) handleRuntimeFlags(endpoint *RuntimeFlagsEndpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx := NewContext(nil, req)
+		principal, err := endpoint.Authenticate(req)
+		if err != nil {
+			markRejected(req, RejectedAuthentication, "")
+			r.handler.sendResponse(w, ctx.setError(UnauthorizedRequest(err.Error())))
+			return
+		}
+
+		var body interface{}
+		name := mux.Vars(req)["name"]
+		switch req.Method {
+		case "GET":
+			flags := []runtimeFlagResponse{}
+			for _, flag := range r.RuntimeFlags() {
+				flags = append(flags, newRuntimeFlagResponse(flag))
+			}
+			body = map[string]interface{}{"flags": flags}
+		case "PUT":
+			var flagReq runtimeFlagRequest
+			if err := json.NewDecoder(req.Body).Decode(&flagReq); err != nil ||
+				flagReq.Value == nil || flagReq.TTLSeconds < 0 {
+				r.handler.sendResponse(w, ctx.setError(BadRequest(
+					"Runtime flag requests require a value and a non-negative ttl_seconds")))
+				return
+			}
+			flag, err := r.setRuntimeFlag(name, *flagReq.Value,
+				time.Duration(flagReq.TTLSeconds)*time.Second, principal)
+			if err != nil {
+				r.handler.sendResponse(w, ctx.setError(BadRequest(err.Error())))
+				return
+			}
+			body = newRuntimeFlagResponse(flag)
+		case "DELETE":
+			if err := r.resetRuntimeFlag(name, principal); err != nil {
+				r.handler.sendResponse(w, ctx.setError(BadRequest(err.Error())))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		response, err := json.Marshal(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", jsonSerializer{}.ContentType())
+		w.Write(response)
+	}
+}
+
+// debugger is implemented by APIs whose debug mode and debug logging can be changed
+// by runtime flags.
+type debugger interface {
+	// debugMode returns true if the API is in debug mode.
+	debugMode() bool
+
+	// debugf prints the formatted string about the resource, or the API if it's
+	// empty, if debug messages about it are logged.
+	debugf(string, string, ...interface{})
+}
+
+// debugMode returns true if the API is in debug mode, either because of the
+// Configuration's Debug or the FlagDebug runtime flag.
+func (r *muxAPI) debugMode() bool {
+	if debug, ok := r.flags.lookup(FlagDebug); ok {
+		return debug.(bool)
+	}
+	return r.config.Debug
+}
+
+// debugLogging returns true if debug messages about the resource, or the API if it's
+// empty, are logged. The resource's log level takes precedence over the global one,
+// which takes precedence over debug mode.
+func (r *muxAPI) debugLogging(resource string) bool {
+	if resource != "" {
+		if level, ok := r.flags.lookup(logLevelFlag(resource)); ok {
+			return level == LogLevelDebug
+		}
+	}
+	if level, ok := r.flags.lookup(FlagLogLevel); ok {
+		return level == LogLevelDebug
+	}
+	return r.debugMode()
+}
+
+// debugf prints the formatted string about the resource, or the API if it's empty, to
+// the Configuration Logger if debug messages about it are logged.
+func (r *muxAPI) debugf(resource, format string, v ...interface{}) {
+	if r.debugLogging(resource) {
+		r.config.Logger.Printf(format, v...)
+	}
+}
+
+// debugMode returns true if the requestHandler's API is in debug mode, or its
+// Configuration's Debug if the API isn't a debugger.
+func (h requestHandler) debugMode() bool {
+	if debugger, ok := h.API.(debugger); ok {
+		return debugger.debugMode()
+	}
+	return h.Configuration().Debug
+}
+
+// debugf prints the formatted string about the resource using the requestHandler's
+// API, if it's a debugger.
+func (h requestHandler) debugf(resource, format string, v ...interface{}) {
+	if debugger, ok := h.API.(debugger); ok {
+		debugger.debugf(resource, format, v...)
+	}
+}
+
+// slowRequestThreshold returns the duration after which requests are logged as slow,
+// or zero if they aren't.
+func (r *muxAPI) slowRequestThreshold() time.Duration {
+	if threshold, ok := r.flags.lookup(FlagSlowRequestThreshold); ok {
+		return threshold.(time.Duration)
+	}
+	return r.config.SlowRequestThreshold
+}
+
+// captureSampleRate returns the fraction of requests captured by a BodyCapture with
+// the configured rate.
+func (r *muxAPI) captureSampleRate(configured float64) float64 {
+	if rate, ok := r.flags.lookup(FlagCaptureSampleRate); ok {
+		return rate.(float64)
+	}
+	return configured
+}
+
+// newSlowRequestMiddleware returns a RequestMiddleware which counts and logs requests
+// to the resource which take longer than the slow request threshold.
+func newSlowRequestMiddleware(api *muxAPI, resource string) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			threshold := api.slowRequestThreshold()
+			if threshold <= 0 {
+				wrapped(w, r)
+				return
+			}
+			start := time.Now()
+			recorder := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			wrapped(recorder, r)
+			if elapsed := time.Since(start); elapsed > threshold {
+				api.metrics.incr(SlowRequestsCounter, resource)
+				api.config.Logf("Slow request for %s: %s %s (%d) took %s", resource, r.Method,
+					r.URL.Path, recorder.status, elapsed)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flagLogs is a writer for logs which may be written by flag timers, signalling
+// reverted when a flag reverts.
+type flagLogs struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	reverted chan bool
+}
+
+// Write appends the log line, signalling if it records a revert.
+func (l *flagLogs) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bytes.Contains(p, []byte("reverted after its TTL expired")) {
+		select {
+		case l.reverted <- true:
+		default:
+		}
+	}
+	return l.buf.Write(p)
+}
+
+// String returns the logs written so far.
+func (l *flagLogs) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// newFlagsAPI returns an API serving runtime flags at /_flags to requests bearing the
+// admin token, along with the logs it writes.
+func newFlagsAPI() (API, *flagLogs) {
+	logs := &flagLogs{reverted: make(chan bool, 1)}
+	api := NewAPI(&Configuration{
+		Logger: log.New(logs, "", 0),
+		RuntimeFlags: &RuntimeFlagsEndpoint{
+			URI: "/_flags",
+			Authenticate: func(r *http.Request) (string, error) {
+				if r.Header.Get("Authorization") != "admin-token" {
+					return "", errors.New("Not an admin")
+				}
+				return "alice", nil
+			},
+		},
+	})
+	api.RegisterResourceHandler(balanceHandler{balances: []Resource{balance{5}}})
+	return api, logs
+}
+
+// serveFlags sends an admin request with the body to the runtime flags endpoint.
+func serveFlags(api API, method, url, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "admin-token")
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that only allowlisted runtime flags can be set and that their values are
+// validated.
+func TestSetRuntimeFlagAllowlist(t *testing.T) {
+	assert := assert.New(t)
+	api, _ := newFlagsAPI()
+
+	assert.Error(api.SetRuntimeFlag("StatsURI", "/stats", 0))
+	assert.Error(api.SetRuntimeFlag("log_level:", LogLevelDebug, 0))
+	assert.Error(api.SetRuntimeFlag("log_level:widgets", LogLevelDebug, 0))
+	assert.Error(api.SetRuntimeFlag(FlagDebug, "maybe", 0))
+	assert.Error(api.SetRuntimeFlag(FlagLogLevel, "trace", 0))
+	assert.Error(api.SetRuntimeFlag(FlagSlowRequestThreshold, "-1s", 0))
+	assert.Error(api.SetRuntimeFlag(FlagCaptureSampleRate, "1.5", 0))
+	assert.Error(api.ResetRuntimeFlag("StatsURI"))
+	assert.Empty(api.RuntimeFlags())
+
+	assert.Nil(api.SetRuntimeFlag("log_level:balances", LogLevelDebug, 0))
+	assert.Nil(api.SetRuntimeFlag(FlagCaptureSampleRate, "0.25", 0))
+	flags := api.RuntimeFlags()
+	if assert.Len(flags, 2) {
+		assert.Equal(FlagCaptureSampleRate, flags[0].Name)
+		assert.Equal("log_level:balances", flags[1].Name)
+		assert.Equal("api", flags[1].SetBy)
+	}
+	assert.Equal(0.25, api.(*muxAPI).captureSampleRate(0))
+}
+
+// Ensures that the debug and log level flags override the Configuration's Debug, with
+// a resource's level taking precedence, and that resetting them restores it.
+func TestRuntimeFlagDebugLogging(t *testing.T) {
+	assert := assert.New(t)
+	flagsAPI, logs := newFlagsAPI()
+	api := flagsAPI.(*muxAPI)
+
+	api.debugf("", "hidden")
+	assert.Nil(api.SetRuntimeFlag(FlagDebug, "true", 0))
+	assert.True(api.debugMode())
+	api.debugf("", "shown")
+	assert.Nil(api.SetRuntimeFlag(FlagLogLevel, LogLevelInfo, 0))
+	assert.Nil(api.SetRuntimeFlag("log_level:balances", LogLevelDebug, 0))
+	api.debugf("", "quiet")
+	api.debugf("balances", "balances only")
+
+	assert.Nil(api.ResetRuntimeFlag(FlagDebug))
+	assert.Nil(api.ResetRuntimeFlag(FlagLogLevel))
+	assert.Nil(api.ResetRuntimeFlag("log_level:balances"))
+	assert.False(api.debugMode())
+	api.debugf("balances", "hidden again")
+
+	out := logs.String()
+	assert.NotContains(out, "hidden")
+	assert.NotContains(out, "quiet")
+	assert.Contains(out, "shown")
+	assert.Contains(out, "balances only")
+	assert.Contains(out, `Runtime flag debug set to "true" by api until reset`)
+	assert.Contains(out, "Runtime flag debug reset by api")
+}
+
+// Ensures that APIs created with the same Configuration keep their own runtime flags
+// without modifying it.
+func TestRuntimeFlagsPerAPI(t *testing.T) {
+	assert := assert.New(t)
+	config := &Configuration{Logger: log.New(&flagLogs{}, "", 0)}
+	first, second := NewAPI(config), NewAPI(config)
+
+	assert.Nil(first.SetRuntimeFlag(FlagDebug, "true", 0))
+	assert.True(first.(*muxAPI).debugMode())
+	assert.False(second.(*muxAPI).debugMode())
+	assert.Empty(second.RuntimeFlags())
+	assert.False(config.Debug)
+}
+
+// Ensures that a flag set with a TTL is reported in the stats and reverts once the
+// TTL elapses, while setting it again cancels the earlier revert.
+func TestRuntimeFlagTTL(t *testing.T) {
+	assert := assert.New(t)
+	api, logs := newFlagsAPI()
+
+	assert.Nil(api.SetRuntimeFlag(FlagDebug, "true", time.Hour))
+	flags := api.Stats()["flags"].(map[string]interface{})
+	debug := flags[FlagDebug].(map[string]interface{})
+	assert.Equal("true", debug["value"])
+	assert.Equal("api", debug["set_by"])
+	assert.NotEmpty(debug["expires"])
+
+	assert.Nil(api.SetRuntimeFlag(FlagDebug, "true", 10*time.Millisecond))
+	select {
+	case <-logs.reverted:
+	case <-time.After(time.Second):
+		t.Fatal("Flag wasn't reverted")
+	}
+	assert.Empty(api.RuntimeFlags())
+	assert.False(api.(*muxAPI).debugMode())
+	assert.Contains(logs.String(), "Runtime flag debug reverted after its TTL expired")
+}
+
+// Ensures that the runtime flags endpoint requires authentication, rejects flags
+// outside the allowlist, and logs changes with the principal who made them.
+func TestRuntimeFlagsEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	api, logs := newFlagsAPI()
+
+	req, _ := http.NewRequest("PUT", "http://foo.com/_flags/debug",
+		strings.NewReader(`{"value": "true"}`))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+	assert.Empty(api.RuntimeFlags())
+
+	resp = serveFlags(api, "PUT", "http://foo.com/_flags/Debug", `{"value": "true"}`)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	resp = serveFlags(api, "PUT", "http://foo.com/_flags/debug", `{"ttl_seconds": 60}`)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+
+	resp = serveFlags(api, "PUT", "http://foo.com/_flags/debug",
+		`{"value": "true", "ttl_seconds": 60}`)
+	assert.Equal(http.StatusOK, resp.Code)
+	var flag map[string]interface{}
+	assert.Nil(json.Unmarshal(resp.Body.Bytes(), &flag))
+	assert.Equal("true", flag["value"])
+	assert.Equal("alice", flag["set_by"])
+	assert.NotEmpty(flag["expires"])
+
+	resp = serveFlags(api, "GET", "http://foo.com/_flags", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	var list map[string][]map[string]interface{}
+	assert.Nil(json.Unmarshal(resp.Body.Bytes(), &list))
+	if assert.Len(list["flags"], 1) {
+		assert.Equal(FlagDebug, list["flags"][0]["name"])
+	}
+
+	api.EnterMaintenance("Migrating", nil)
+	resp = serveFlags(api, "DELETE", "http://foo.com/_flags/debug", "")
+	assert.Equal(http.StatusNoContent, resp.Code)
+	assert.Empty(api.RuntimeFlags())
+	assert.Contains(logs.String(), `Runtime flag debug set to "true" by alice for 1m0s`)
+	assert.Contains(logs.String(), "Runtime flag debug reset by alice")
+}
+
+// Ensures that the slow request threshold flag counts and logs requests to resources
+// which exceed it.
+func TestRuntimeFlagSlowRequests(t *testing.T) {
+	assert := assert.New(t)
+	api, logs := newFlagsAPI()
+
+	serve(api, "GET", "http://foo.com/api/v1/balances/1")
+	assert.Equal(uint64(0), api.Metrics().Counter(SlowRequestsCounter, "balances"))
+
+	assert.Nil(api.SetRuntimeFlag(FlagSlowRequestThreshold, "1ns", 0))
+	resp := serve(api, "GET", "http://foo.com/api/v1/balances/1")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(uint64(1), api.Metrics().Counter(SlowRequestsCounter, "balances"))
+	assert.Contains(logs.String(), "Slow request for balances: GET /api/v1/balances/1 (200)")
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+
+	gcontext "github.com/gorilla/context"
+)
+
+// FeatureGate is a ResourceOption which makes a resource's endpoints available only
+// when Enabled returns true for the request. This allows endpoints to be shipped dark
+// and enabled per environment or per tenant. By default, a gated-off request receives
+// a 404 as if the resource did not exist.
+type FeatureGate struct {
+	// Enabled reports whether the resource is available for the request. It's
+	// evaluated at most once per request.
+	Enabled func(RequestContext) bool
+
+	// Indicates if the gate should be evaluated after the request is authenticated.
+	// Defaults to false, meaning the gate is evaluated before authentication.
+	AfterAuthentication bool
+
+	// Indicates if a gated-off request should receive a 403 Forbidden rather than a
+	// 404 Not Found. Defaults to false.
+	Forbidden bool
+}
+
+// EnabledWhen returns a ResourceOption which gates a resource's endpoints behind the
+// provided predicate. The predicate is evaluated before authentication and a false
+// result yields a 404. Use FeatureGate directly for more control.
+func EnabledWhen(enabled func(RequestContext) bool) ResourceOption {
+	return FeatureGate{Enabled: enabled}
+}
+
+// apply sets the FeatureGate on the resource.
+func (g FeatureGate) apply(opts *resourceOptions) {
+	opts.gate = &g
+}
+
+// disabledOption is a ResourceOption which statically disables a resource.
+type disabledOption struct{}
+
+// Disabled returns a ResourceOption which registers a resource's routes but serves a
+// 503 for every request to them. This is useful for pre-announcing endpoints.
+// Disabled resources are excluded from generated documentation.
+func Disabled() ResourceOption {
+	return disabledOption{}
+}
+
+// apply marks the resource as disabled.
+func (d disabledOption) apply(opts *resourceOptions) {
+	opts.disabled = true
+}
+
+// gateDecisionKey is the request context key under which a FeatureGate decision is
+// cached.
+type gateDecisionKey struct {
+	gate *FeatureGate
+}
+
+// newDisabledMiddleware returns a RequestMiddleware which rejects every request to
+// the resource with a 503.
+func newDisabledMiddleware(api *muxAPI, resource string) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			api.metrics.incr(GatedCounter, resource)
+			api.debugf(resource, "Resource %s is disabled: %s %s (503)",
+				resource, r.Method, r.URL.Path)
+			ctx := NewContext(nil, r)
+			ctx = ctx.setError(ServiceUnavailable(
+				fmt.Sprintf("Resource %s is not yet available", resource)))
+			api.handler.sendResponse(w, ctx)
+		}
+	}
+}
+
+// newGateMiddleware returns a RequestMiddleware which rejects requests to the resource
+// for which the FeatureGate is not enabled.
+func newGateMiddleware(api *muxAPI, resource string, gate *FeatureGate) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := NewContext(nil, r)
+			if gate.enabled(ctx, r) {
+				wrapped(w, r)
+				return
+			}
+
+			api.metrics.incr(GatedCounter, resource)
+			status := http.StatusNotFound
+			if gate.Forbidden {
+				status = http.StatusForbidden
+			}
+			api.debugf(resource, "Feature gate closed for %s: %s %s (%d)",
+				resource, r.Method, r.URL.Path, status)
+
+			if !gate.Forbidden {
+				// Respond as the router would for an unknown route to hide the
+				// resource's existence.
+				http.NotFound(w, r)
+				return
+			}
+			ctx = ctx.setError(ResourceNotPermitted(
+				fmt.Sprintf("Resource %s is not permitted", resource)))
+			api.handler.sendResponse(w, ctx)
+		}
+	}
+}
+
+// enabled evaluates the FeatureGate for the request, caching the decision on the
+// request so the predicate is evaluated at most once.
+func (g *FeatureGate) enabled(ctx RequestContext, r *http.Request) bool {
+	key := gateDecisionKey{g}
+	if decision, ok := gcontext.GetOk(r, key); ok {
+		return decision.(bool)
+	}
+
+	decision := g.Enabled == nil || g.Enabled(ctx)
+	gcontext.Set(r, key, decision)
+	return decision
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that a gated-off resource responds with a 404 without invoking
+// authentication or the handler and increments the gated counter.
+func TestEnabledWhenClosed(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+
+	handler.On("ResourceName").Return("foo")
+
+	api.RegisterResourceHandler(handler, EnabledWhen(func(ctx RequestContext) bool {
+		return false
+	}))
+	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
+	resp := httptest.NewRecorder()
+
+	readHandler.ServeHTTP(resp, req)
+
+	handler.Mock.AssertExpectations(t)
+	assert.Equal(http.StatusNotFound, resp.Code, "Incorrect response code")
+	assert.Equal("404 page not found\n", resp.Body.String(), "Incorrect response string")
+	assert.Equal(uint64(1), api.Metrics().Counter(GatedCounter, "foo"))
+}
+
+// Ensures that an enabled gate passes requests through to the handler.
+func TestEnabledWhenOpen(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+
+	handler.On("ResourceName").Return("foo")
+	handler.On("Authenticate").Return(nil)
+	handler.On("Rules").Return(&rules{})
+	handler.On("ReadResource").Return(&TestResource{Foo: "hello"}, nil)
+
+	api.RegisterResourceHandler(handler, EnabledWhen(func(ctx RequestContext) bool {
+		return ctx.Header().Get("X-Tenant") == "beta"
+	}))
+	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
+	req.Header.Set("X-Tenant", "beta")
+	resp := httptest.NewRecorder()
+
+	readHandler.ServeHTTP(resp, req)
+
+	handler.Mock.AssertExpectations(t)
+	assert.Equal(http.StatusOK, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":[],"reason":"OK","result":{"foo":"hello"},"status":200}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+	assert.Equal(uint64(0), api.Metrics().Counter(GatedCounter, "foo"))
+}
+
+// Ensures that a FeatureGate configured as Forbidden responds with a 403.
+func TestFeatureGateForbidden(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+
+	handler.On("ResourceName").Return("foo")
+
+	api.RegisterResourceHandler(handler, FeatureGate{
+		Enabled:   func(ctx RequestContext) bool { return false },
+		Forbidden: true,
+	})
+	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
+	resp := httptest.NewRecorder()
+
+	readHandler.ServeHTTP(resp, req)
+
+	handler.Mock.AssertExpectations(t)
+	assert.Equal(http.StatusForbidden, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":["Resource foo is not permitted"],"reason":"Forbidden","status":403}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+}
+
+// Ensures that a FeatureGate configured to run after authentication does not hide
+// authentication failures.
+func TestFeatureGateAfterAuthentication(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+	evaluated := false
+
+	handler.On("ResourceName").Return("foo")
+	handler.On("Authenticate").Return(fmt.Errorf("Not authorized"))
+
+	api.RegisterResourceHandler(handler, FeatureGate{
+		Enabled: func(ctx RequestContext) bool {
+			evaluated = true
+			return false
+		},
+		AfterAuthentication: true,
+	})
+	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
+	resp := httptest.NewRecorder()
+
+	readHandler.ServeHTTP(resp, req)
+
+	handler.Mock.AssertExpectations(t)
+	assert.Equal(http.StatusUnauthorized, resp.Code, "Incorrect response code")
+	assert.False(evaluated, "Gate was evaluated before authentication")
+}
+
+// Ensures that the gate decision is cached on the request.
+func TestFeatureGateDecisionCached(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+	calls := 0
+
+	handler.On("ResourceName").Return("foo")
+
+	api.RegisterResourceHandler(handler, EnabledWhen(func(ctx RequestContext) bool {
+		calls++
+		return false
+	}))
+	readHandler, _ := api.(*muxAPI).getRouteHandler("foo:read")
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v0.1/foo/1", nil)
+	readHandler.ServeHTTP(httptest.NewRecorder(), req)
+	readHandler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(1, calls, "Gate was not evaluated exactly once")
+	assert.Equal(uint64(2), api.Metrics().Counter(GatedCounter, "foo"))
+}
+
+// Ensures that a disabled resource responds with a 503 and is excluded from
+// documentation.
+func TestDisabled(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+
+	handler.On("ResourceName").Return("foo")
+
+	api.RegisterResourceHandler(handler, Disabled())
+	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v0.1/foo", nil)
+	resp := httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	handler.Mock.AssertExpectations(t)
+	assert.Equal(http.StatusServiceUnavailable, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":["Resource foo is not yet available"],"reason":"Service Unavailable","status":503}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+	assert.Equal(uint64(1), api.Metrics().Counter(GatedCounter, "foo"))
+	assert.Len(api.ResourceHandlers(), 1)
+	assert.Len(api.documentedResourceHandlers(), 0)
+}
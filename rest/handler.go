@@ -17,12 +17,12 @@ limitations under the License.
 package rest
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"reflect"
 )
 
 // Resource represents a domain model.
@@ -135,20 +135,35 @@ func (h requestHandler) handleCreate(handler ResourceHandler) http.HandlerFunc {
 		version := ctx.Version()
 		rules := handler.Rules()
 
-		data, err := decodePayload(payloadString(r.Body))
+		ctx = ctx.setRawBody(payloadString(r.Body))
+		body, err := h.requestBody(ctx.RawBody(), r.Header)
+		if err != nil {
+			// Body failed digest verification or its charset is not supported.
+			h.sendResponse(w, ctx.setError(err))
+			return
+		}
+
+		data, err := decodePayload(body, h.Configuration().UseNumber)
 		if err != nil {
 			// Payload decoding failed.
 			ctx = ctx.setError(err)
 			ctx = ctx.setStatus(http.StatusInternalServerError)
+		} else if err := h.validateStrings(body, rules, version); err != nil {
+			// Payload contains invalid UTF-8.
+			ctx = ctx.setError(err)
 		} else {
-			data, err := applyInboundRules(data, rules, version)
+			data, err := applyInboundRules(h.sanitizePayload(data), rules, version)
 			if err != nil {
-				// Type coercion failed.
-				ctx = ctx.setError(UnprocessableRequest(err.Error()))
+				// Type coercion or validation failed.
+				ctx = ctx.setError(invalidInput(err))
+			} else if err := checkConstraints(ctx, data, rules, version); err != nil {
+				// Uniqueness or existence checks failed.
+				ctx = ctx.setError(inputError(err))
 			} else {
 				resource, err := handler.CreateResource(ctx, data, ctx.Version())
 				if err == nil {
 					resource = applyOutboundRules(resource, rules, version)
+					resource, err = h.enforceOutput(handler, resource, rules, version)
 				}
 				ctx = ctx.setResult(resource)
 				ctx = ctx.setStatus(http.StatusCreated)
@@ -167,23 +182,42 @@ func (h requestHandler) handleCreate(handler ResourceHandler) http.HandlerFunc {
 // serialization mechanism used is specified by the "format" query parameter.
 func (h requestHandler) handleReadList(handler ResourceHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if countOnly(r) {
+			h.handleCount(handler)(w, r)
+			return
+		}
+
 		ctx := NewContext(nil, r)
 		version := ctx.Version()
 		rules := handler.Rules()
 
-		resources, cursor, err := handler.ReadResourceList(
-			ctx, ctx.Limit(), ctx.Cursor(), version)
+		cursor := ctx.Cursor()
+		versioner, versioned := unwrapHandler(handler).(CursorVersioner)
+		if versioned {
+			var err error
+			if cursor, err = decodeVersionedCursor(versioner, cursor); err != nil {
+				// Cursor is stale, so don't invoke the handler.
+				h.sendResponse(w, ctx.setError(staleCursorError(err, h.Configuration())))
+				return
+			}
+		}
+
+		resources, cursor, err := handler.ReadResourceList(ctx, ctx.Limit(), cursor, version)
+		if versioned && cursor != "" {
+			cursor = EncodeCursor(versioner.CursorVersion(), cursor)
+		}
 
 		if err == nil {
 			// Apply rules to results.
 			for idx, resource := range resources {
 				resources[idx] = applyOutboundRules(resource, rules, version)
 			}
+			resources, err = h.enforceOutputList(handler, resources, rules, version)
 		}
 
 		ctx = ctx.setResult(resources)
 		ctx = ctx.setCursor(cursor)
-		ctx = ctx.setError(err)
+		ctx = ctx.setError(staleCursorError(err, h.Configuration()))
 		ctx = ctx.setStatus(http.StatusOK)
 
 		h.sendResponse(w, ctx)
@@ -192,9 +226,15 @@ func (h requestHandler) handleReadList(handler ResourceHandler) http.HandlerFunc
 
 // handleRead returns a HandlerFunc which will pass the resource id to the provided
 // read function and then serialize and dispatch the response. The serialization
-// mechanism used is specified by the "format" query parameter.
+// mechanism used is specified by the "format" query parameter. Resources of
+// ResourceResolvers are read in two phases.
 func (h requestHandler) handleRead(handler ResourceHandler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if resolver, ok := resolverFor(handler); ok {
+			h.handleResolvedRead(w, r, handler, resolver)
+			return
+		}
+
 		ctx := NewContext(nil, r)
 		version := ctx.Version()
 		rules := handler.Rules()
@@ -202,6 +242,7 @@ func (h requestHandler) handleRead(handler ResourceHandler) http.HandlerFunc {
 		resource, err := handler.ReadResource(ctx, ctx.ResourceID(), version)
 		if err == nil {
 			resource = applyOutboundRules(resource, rules, version)
+			resource, err = h.enforceOutput(handler, resource, rules, version)
 		}
 
 		ctx = ctx.setResult(resource)
@@ -222,26 +263,36 @@ func (h requestHandler) handleUpdateList(handler ResourceHandler) http.HandlerFu
 		version := ctx.Version()
 		rules := handler.Rules()
 
-		payloadStr := payloadString(r.Body)
+		ctx = ctx.setRawBody(payloadString(r.Body))
+		payloadStr, err := h.requestBody(ctx.RawBody(), r.Header)
+		if err != nil {
+			// Body failed digest verification or its charset is not supported.
+			h.sendResponse(w, ctx.setError(err))
+			return
+		}
+
 		var data []Payload
-		var err error
-		data, err = decodePayloadSlice(payloadStr)
+		data, err = decodePayloadSlice(payloadStr, h.Configuration().UseNumber)
 		if err != nil {
 			var p Payload
-			p, err = decodePayload(payloadStr)
+			p, err = decodePayload(payloadStr, h.Configuration().UseNumber)
 			data = []Payload{p}
 		}
 
 		if err != nil {
 			// Payload decoding failed.
 			ctx = ctx.setError(BadRequest(err.Error()))
+		} else if err := h.validateStrings(payloadStr, rules, version); err != nil {
+			// Payload contains invalid UTF-8.
+			ctx = ctx.setError(err)
 		} else {
-			for i := range data {
-				data[i], err = applyInboundRules(data[i], rules, version)
-			}
+			err = applyInboundRulesList(h.sanitizePayload, data, rules, version)
 			if err != nil {
-				// Type coercion failed.
-				ctx = ctx.setError(UnprocessableRequest(err.Error()))
+				// Type coercion or validation failed.
+				ctx = ctx.setError(invalidInput(err))
+			} else if err := checkConstraints(ctx, data, rules, version); err != nil {
+				// Uniqueness or existence checks failed.
+				ctx = ctx.setError(inputError(err))
 			} else {
 				resources, err := handler.UpdateResourceList(ctx, data, version)
 				if err == nil {
@@ -249,6 +300,7 @@ func (h requestHandler) handleUpdateList(handler ResourceHandler) http.HandlerFu
 					for idx, resource := range resources {
 						resources[idx] = applyOutboundRules(resource, rules, version)
 					}
+					resources, err = h.enforceOutputList(handler, resources, rules, version)
 				}
 
 				ctx = ctx.setResult(resources)
@@ -271,21 +323,38 @@ func (h requestHandler) handleUpdate(handler ResourceHandler) http.HandlerFunc {
 		version := ctx.Version()
 		rules := handler.Rules()
 
-		data, err := decodePayload(payloadString(r.Body))
+		ctx = ctx.setRawBody(payloadString(r.Body))
+		body, err := h.requestBody(ctx.RawBody(), r.Header)
+		if err != nil {
+			// Body failed digest verification or its charset is not supported.
+			h.sendResponse(w, ctx.setError(err))
+			return
+		}
+
+		data, err := decodePayload(body, h.Configuration().UseNumber)
 		if err != nil {
 			// Payload decoding failed.
 			ctx = ctx.setError(err)
 			ctx = ctx.setStatus(http.StatusInternalServerError)
+		} else if err := h.validateStrings(body, rules, version); err != nil {
+			// Payload contains invalid UTF-8.
+			ctx = ctx.setError(err)
 		} else {
-			data, err := applyInboundRules(data, rules, version)
+			data, err := applyInboundRules(h.sanitizePayload(data), rules, version)
 			if err != nil {
-				// Type coercion failed.
-				ctx = ctx.setError(UnprocessableRequest(err.Error()))
+				// Type coercion or validation failed.
+				ctx = ctx.setError(invalidInput(err))
+			} else if err := checkWritePrecondition(ctx, handler); err != nil {
+				ctx = ctx.setError(err)
+			} else if err := checkConstraints(ctx, data, rules, version); err != nil {
+				// Uniqueness or existence checks failed.
+				ctx = ctx.setError(inputError(err))
 			} else {
 				resource, err := handler.UpdateResource(
 					ctx, ctx.ResourceID(), data, version)
 				if err == nil {
 					resource = applyOutboundRules(resource, rules, version)
+					resource, err = h.enforceOutput(handler, resource, rules, version)
 				}
 
 				ctx = ctx.setResult(resource)
@@ -307,9 +376,15 @@ func (h requestHandler) handleDelete(handler ResourceHandler) http.HandlerFunc {
 		version := ctx.Version()
 		rules := handler.Rules()
 
+		if err := checkWritePrecondition(ctx, handler); err != nil {
+			h.sendResponse(w, ctx.setError(err))
+			return
+		}
+
 		resource, err := handler.DeleteResource(ctx, ctx.ResourceID(), version)
 		if err == nil {
 			resource = applyOutboundRules(resource, rules, version)
+			resource, err = h.enforceOutput(handler, resource, rules, version)
 		}
 
 		ctx = ctx.setResult(resource)
@@ -320,9 +395,45 @@ func (h requestHandler) handleDelete(handler ResourceHandler) http.HandlerFunc {
 	}
 }
 
+// requestBody verifies the raw request body against any digest headers, if enabled by
+// the API Configuration, and then converts it to UTF-8 from the charset specified by
+// the Content-Type header, provided it's accepted by the Configuration. Any leading
+// byte order mark is stripped. Returns a 400 Error if digest verification fails and a
+// 415 Error if the charset isn't accepted.
+func (h requestHandler) requestBody(body []byte, header http.Header) ([]byte, error) {
+	config := h.Configuration()
+	if config.VerifyDigest {
+		if err := verifyDigest(body, header, config.DigestAlgorithms); err != nil {
+			return nil, err
+		}
+	}
+	return transcodeBody(body, header.Get("Content-Type"), config.AcceptedCharsets)
+}
+
+// validateStrings verifies that the raw request body contains valid UTF-8 where
+// required by the API Configuration or Rules. Returns a 400 Error if not.
+func (h requestHandler) validateStrings(body []byte, rules Rules, version string) error {
+	config := h.Configuration()
+	return validateUTF8(body, rules, version, config.ValidateUTF8 || config.NormalizeUnicode)
+}
+
+// sanitizePayload applies the string normalization and control character stripping
+// specified by the API Configuration to the Payload.
+func (h requestHandler) sanitizePayload(payload Payload) Payload {
+	config := h.Configuration()
+	return sanitizePayload(payload, config.StripControlChars, config.NormalizeUnicode)
+}
+
 // sendResponse writes a success or error response to the provided http.ResponseWriter
-// based on the contents of the RequestContext.
+// based on the contents of the RequestContext. Errors which aren't an Error are
+// translated by the registered ErrorMappers first, except PassthroughErrors, which are
+// written as-is.
 func (h requestHandler) sendResponse(w http.ResponseWriter, ctx RequestContext) {
+	if passthrough, ok := ctx.Error().(*PassthroughError); ok {
+		h.sendPassthrough(w, ctx, passthrough)
+		return
+	}
+
 	format := ctx.ResponseFormat()
 	serializer, err := h.responseSerializer(format)
 	if err != nil {
@@ -331,36 +442,127 @@ func (h requestHandler) sendResponse(w http.ResponseWriter, ctx RequestContext)
 		ctx = ctx.setError(NotImplemented(fmt.Sprintf("Format not implemented: %s", format)))
 	}
 
-	sendResponse(w, NewResponse(ctx), serializer)
+	if err := ctx.Error(); err != nil {
+		if mapped, ok := h.mapError(err); ok {
+			ctx = ctx.setError(mapped)
+		}
+		if stage, ok := rejectionStage(ctx.Error()); ok {
+			if req, ok := ctx.Request(); ok {
+				markRejected(req, stage, ctx.Error().(Error).Code())
+			}
+		}
+	}
+
+	if h.debugMode() {
+		setRetryAttemptsHeader(w, ctx)
+	}
+	setConsistencyTokenHeader(w, ctx)
+
+	sendResponse(w, NewResponse(ctx), serializer, h.Configuration().ResponseDigest)
 }
 
-// sendResponse writes a response to the http.ResponseWriter.
-func sendResponse(w http.ResponseWriter, r response, serializer ResponseSerializer) {
-	status := r.Status
+// sendResponse writes a response to the http.ResponseWriter. If a digest algorithm is
+// provided, the digest of the response body is included in the response headers.
+func sendResponse(w http.ResponseWriter, r response, serializer ResponseSerializer,
+	digestAlgorithm string) {
+	statusCode := r.Status
 	contentType := serializer.ContentType()
 	response, err := serializer.Serialize(r.Payload)
 	if err != nil {
-		log.Printf("Response serialization failed: %s", err)
-		status = http.StatusInternalServerError
-		contentType = "text/plain"
-		response = []byte(err.Error())
+		// Nothing has been written yet, so the failure can be reported as a 500.
+		log.Printf("Response serialization failed for %s: %s",
+			serializationFailure(r.Payload, serializer), err)
+		statusCode = http.StatusInternalServerError
+		response, err = serializer.Serialize(Payload{
+			status:   statusCode,
+			reason:   http.StatusText(statusCode),
+			messages: r.Payload[messages],
+			code:     SerializationFailedCode,
+		})
+		if err != nil {
+			contentType = "text/plain; charset=utf-8"
+			response = []byte(err.Error())
+		}
 	}
 
 	w.Header().Set("Content-Type", contentType)
-	w.WriteHeader(status)
+	if digestAlgorithm != "" {
+		setDigest(w.Header(), digestAlgorithm, response)
+	}
+	w.WriteHeader(statusCode)
 	w.Write(response)
 }
 
+// applyInboundRulesList applies inbound Rules to each sanitized Payload in place. If
+// any fail, FieldErrors for every Payload are returned with paths prefixed by the
+// Payload's index.
+func applyInboundRulesList(sanitize func(Payload) Payload, data []Payload, rules Rules,
+	version string) error {
+
+	all := FieldErrors{}
+	for i := range data {
+		payload, err := applyInboundRules(sanitize(data[i]), rules, version)
+		if err == nil {
+			data[i] = payload
+			continue
+		}
+		fields, ok := err.(FieldErrors)
+		if !ok {
+			return err
+		}
+		for _, field := range fields {
+			field.Field = fmt.Sprintf("[%d].%s", i, field.Field)
+			all = append(all, field)
+		}
+	}
+	if len(all) > 0 {
+		all.sort()
+		return all
+	}
+	return nil
+}
+
+// invalidInput returns a 422 Error for the error applying inbound Rules, including
+// its FieldErrors.
+func invalidInput(err error) Error {
+	invalid := UnprocessableRequest(err.Error())
+	if fields, ok := err.(FieldErrors); ok {
+		invalid = invalid.WithFieldErrors(fields)
+	}
+	return invalid
+}
+
+// serializationFailure describes the value in the Payload which failed to serialize,
+// naming its type and, for lists, the index of the first failing item.
+func serializationFailure(payload Payload, serializer ResponseSerializer) string {
+	if resources, ok := payload[results]; ok && resources != nil {
+		value := reflect.ValueOf(resources)
+		if value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len(); i++ {
+				item := value.Index(i).Interface()
+				if _, err := serializer.Serialize(Payload{result: item}); err != nil {
+					return fmt.Sprintf("item %d of type %T in results", i, item)
+				}
+			}
+		}
+		return fmt.Sprintf("results of type %T", resources)
+	}
+	if resource, ok := payload[result]; ok {
+		return fmt.Sprintf("result of type %T", resource)
+	}
+	return "response"
+}
+
 // decodePayload unmarshals the JSON payload and returns the resulting map. If the
 // content is empty, an empty map is returned. If decoding fails, nil is returned
-// with an error.
-func decodePayload(payload []byte) (Payload, error) {
+// with an error. Numbers are decoded as json.Number if useNumber is true.
+func decodePayload(payload []byte, useNumber bool) (Payload, error) {
 	if len(payload) == 0 {
 		return map[string]interface{}{}, nil
 	}
 
 	var data Payload
-	if err := json.Unmarshal(payload, &data); err != nil {
+	if err := unmarshalJSON(payload, &data, useNumber); err != nil {
 		return nil, err
 	}
 
@@ -369,14 +571,14 @@ func decodePayload(payload []byte) (Payload, error) {
 
 // decodePayloadSlice unmarshals the JSON payload and returns the resulting slice.
 // If the content is empty, an empty list is returned. If decoding fails, nil is
-// returned with an error.
-func decodePayloadSlice(payload []byte) ([]Payload, error) {
+// returned with an error. Numbers are decoded as json.Number if useNumber is true.
+func decodePayloadSlice(payload []byte, useNumber bool) ([]Payload, error) {
 	if len(payload) == 0 {
 		return []Payload{}, nil
 	}
 
 	var data []Payload
-	if err := json.Unmarshal(payload, &data); err != nil {
+	if err := unmarshalJSON(payload, &data, useNumber); err != nil {
 		return nil, err
 	}
 
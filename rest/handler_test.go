@@ -18,6 +18,12 @@ package rest
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -28,7 +34,7 @@ func TestDecodePayloadEmpty(t *testing.T) {
 	assert := assert.New(t)
 	payload := bytes.NewBufferString("")
 
-	decoded, err := decodePayload(payload.Bytes())
+	decoded, err := decodePayload(payload.Bytes(), false)
 
 	assert.Equal(Payload{}, decoded)
 	assert.Nil(err)
@@ -40,7 +46,7 @@ func TestDecodePayloadBadJSON(t *testing.T) {
 	body := `{"foo": "bar", "baz": 1`
 	payload := bytes.NewBufferString(body)
 
-	decoded, err := decodePayload(payload.Bytes())
+	decoded, err := decodePayload(payload.Bytes(), false)
 
 	assert.Nil(decoded)
 	assert.NotNil(err)
@@ -52,7 +58,7 @@ func TestDecodePayloadHappyPath(t *testing.T) {
 	body := `{"foo": "bar", "baz": 1}`
 	payload := bytes.NewBufferString(body)
 
-	decoded, err := decodePayload(payload.Bytes())
+	decoded, err := decodePayload(payload.Bytes(), false)
 
 	assert.Equal(Payload{"foo": "bar", "baz": float64(1)}, decoded)
 	assert.Nil(err)
@@ -63,7 +69,7 @@ func TestDecodePayloadSliceEmpty(t *testing.T) {
 	assert := assert.New(t)
 	payload := bytes.NewBufferString("")
 
-	decoded, err := decodePayloadSlice(payload.Bytes())
+	decoded, err := decodePayloadSlice(payload.Bytes(), false)
 
 	assert.Equal([]Payload{}, decoded)
 	assert.Nil(err)
@@ -75,7 +81,7 @@ func TestDecodePayloadSliceBadJSON(t *testing.T) {
 	body := `[{"foo": "bar", "baz": 1`
 	payload := bytes.NewBufferString(body)
 
-	decoded, err := decodePayloadSlice(payload.Bytes())
+	decoded, err := decodePayloadSlice(payload.Bytes(), false)
 
 	assert.Nil(decoded)
 	assert.NotNil(err)
@@ -87,8 +93,142 @@ func TestDecodePayloadSliceHappyPath(t *testing.T) {
 	body := `[{"foo": "bar", "baz": 1}]`
 	payload := bytes.NewBufferString(body)
 
-	decoded, err := decodePayloadSlice(payload.Bytes())
+	decoded, err := decodePayloadSlice(payload.Bytes(), false)
 
 	assert.Equal([]Payload{Payload{"foo": "bar", "baz": float64(1)}}, decoded)
 	assert.Nil(err)
 }
+
+// balance is a resource whose MarshalJSON fails for negative amounts.
+type balance struct {
+	Amount int
+}
+
+func (b balance) MarshalJSON() ([]byte, error) {
+	if b.Amount < 0 {
+		return nil, fmt.Errorf("negative balance %d", b.Amount)
+	}
+	return json.Marshal(map[string]int{"amount": b.Amount})
+}
+
+// balanceHandler is a ResourceHandler serving balances.
+type balanceHandler struct {
+	BaseResourceHandler
+	balances []Resource
+}
+
+func (b balanceHandler) ResourceName() string {
+	return "balances"
+}
+
+func (b balanceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return b.balances[0], nil
+}
+
+func (b balanceHandler) ReadResourceList(ctx RequestContext, limit int, cursor string,
+	version string) ([]Resource, string, error) {
+	return b.balances, "", nil
+}
+
+// serveLogged sends the request to the API and returns the response and log output.
+func serveLogged(api API, url string) (*httptest.ResponseRecorder, string) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+	req, _ := http.NewRequest("GET", url, nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp, logs.String()
+}
+
+// Ensures that a resource which fails to serialize results in a 500 error envelope
+// and a log entry naming its type, while other values serialize normally.
+func TestSendResponseSerializationFailed(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(balanceHandler{balances: []Resource{balance{-5}}})
+
+	resp, logs := serveLogged(api, "http://foo.com/api/v1/balances/1")
+
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+	assert.Equal("application/json; charset=utf-8", resp.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	assert.Nil(json.Unmarshal(resp.Body.Bytes(), &body))
+	assert.Equal(SerializationFailedCode, body["code"])
+	assert.NotContains(resp.Body.String(), "negative balance")
+	assert.Contains(logs, "result of type rest.balance: ")
+	assert.Contains(logs, "negative balance -5")
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(balanceHandler{balances: []Resource{balance{5}}})
+	resp, _ = serveLogged(api, "http://foo.com/api/v1/balances/1")
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"amount":5`)
+}
+
+// Ensures that the log entry for a list which fails to serialize names the index of
+// the failing item.
+func TestSendResponseSerializationFailedList(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(balanceHandler{
+		balances: []Resource{balance{1}, balance{2}, balance{-3}, balance{-4}},
+	})
+
+	resp, logs := serveLogged(api, "http://foo.com/api/v1/balances")
+
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+	assert.Contains(resp.Body.String(), SerializationFailedCode)
+	assert.Contains(logs, "item 2 of type rest.balance in results")
+}
+
+// requiredNameHandler is a ResourceHandler whose resources require a name.
+type requiredNameHandler struct {
+	BaseResourceHandler
+}
+
+func (r requiredNameHandler) ResourceName() string {
+	return "named"
+}
+
+func (r requiredNameHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil), &Rule{Field: "name", Required: true},
+		&Rule{Field: "size", Type: Int})
+}
+
+func (r requiredNameHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+	resources := make([]Resource, len(data))
+	for i, payload := range data {
+		resources[i] = payload
+	}
+	return resources, nil
+}
+
+// Ensures that invalid items in a list update are all reported under "errors" with
+// their index, sorted by path.
+func TestHandleUpdateListFieldErrors(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(requiredNameHandler{})
+
+	body := `[{"size":"big"},{"name":"ok","size":1},{"name":"bad","size":"small"}]`
+	req, _ := http.NewRequest("PUT", "http://foo.com/api/v1/named", bytes.NewBufferString(body))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(422, resp.Code)
+	var decoded struct {
+		Errors FieldErrors `json:"errors"`
+	}
+	assert.Nil(json.Unmarshal(resp.Body.Bytes(), &decoded))
+	if assert.Len(decoded.Errors, 3) {
+		assert.Equal(FieldError{Field: "[0].name", Code: FieldRequiredCode,
+			Message: "Missing required field 'name'"}, decoded.Errors[0])
+		assert.Equal("[0].size", decoded.Errors[1].Field)
+		assert.Equal("[2].size", decoded.Errors[2].Field)
+		assert.Equal(map[string]interface{}{"type": "int"}, decoded.Errors[2].Params)
+	}
+}
@@ -81,6 +81,18 @@ const handlerTemplate = `
             <div class="endpoint">
                 <h3><span class="label label-{{label}}">{{method}}</span> {{uri}}</h3>
                 <p>{{{description}}}</p>
+
+                {{#hasLookupKeys}}
+                <h4>Lookup Keys</h4>
+                <div class="list-group">
+                    {{#lookupKeys}}
+                    <div class="list-group-item field">
+                        <span style="width:220px;float:left;"><strong>{{key}}</strong></span>
+                        <p style="margin-left:220px;">GET {{uri}}</p>
+                    </div>
+                    {{/lookupKeys}}
+                </div>
+                {{/hasLookupKeys}}
                
                 <div class="row">
                     {{#hasInput}}
@@ -91,6 +103,7 @@ const handlerTemplate = `
                                 <a href="#request-fields-{{index}}" data-toggle="tab">Fields</a>
                             </li>
                             <li><a href="#request-example-{{index}}" data-toggle="tab">Example</a></li>
+                            <li><a href="#request-schema-{{index}}" data-toggle="tab">Schema</a></li>
                         </ul>
                         <div id="request-tab-content" class="tab-content">
                             <div class="tab-pane active" id="request-fields-{{index}}">
@@ -105,6 +118,9 @@ const handlerTemplate = `
                                         </span>
                                         <p style="margin-left:220px;">
                                             (<em>{{type}}</em>) {{description}}
+                                            {{#constraints}}
+                                            <span style="display:block;color:#999;">{{.}}</span>
+                                            {{/constraints}}
                                         </p>
                                     </div>
                                     {{/inputFields}}
@@ -118,6 +134,14 @@ const handlerTemplate = `
                                     </div>
                                 </div>
                             </div>
+                            <div class="tab-pane" id="request-schema-{{index}}">
+                                <div class="list-group">
+                                    <div class="dl-horizontal list-group-item"
+                                        style="border-top:none;">
+                                        <pre>{{inboundSchema}}</pre>
+                                    </div>
+                                </div>
+                            </div>
                         </div> 
                     </div>
                     {{/hasInput}}
@@ -157,6 +181,37 @@ const handlerTemplate = `
                     </div>
 
                 </div>
+
+                {{#hasExamples}}
+                <h4>Examples</h4>
+                <div class="list-group">
+                    {{#examples}}
+                    <div class="list-group-item field">
+                        <span style="width:220px;float:left;"><strong>{{status}}</strong></span>
+                        <p style="margin-left:220px;">{{description}}</p>
+                        {{#hasRequest}}
+                        <pre>{{request}}</pre>
+                        {{/hasRequest}}
+                        <pre>{{response}}</pre>
+                    </div>
+                    {{/examples}}
+                </div>
+                {{/hasExamples}}
+
+                {{#hasErrorResponses}}
+                <h4>Error Responses</h4>
+                <div class="list-group">
+                    {{#errorResponses}}
+                    <div class="list-group-item field">
+                        <span style="width:220px;float:left;">
+                            <strong>{{status}}</strong>
+                            <span style="display:block;color:#999;">{{code}}</span>
+                        </span>
+                        <p style="margin-left:220px;">{{description}}</p>
+                    </div>
+                    {{/errorResponses}}
+                </div>
+                {{/hasErrorResponses}}
                 {{/endpoints}}
 
             </div>
@@ -0,0 +1,202 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+const (
+	// HedgesFiredCounter counts hedged invocations started because the first
+	// invocation didn't return within the hedge delay.
+	HedgesFiredCounter = "hedges_fired"
+
+	// HedgesWonCounter counts hedged invocations which returned before the first
+	// invocation.
+	HedgesWonCounter = "hedges_won"
+
+	// defaultMaxHedges is the maximum number of concurrent hedged invocations per
+	// resource if Hedging doesn't specify one.
+	defaultMaxHedges = 10
+)
+
+// Hedging is a ResourceOption which reduces tail latency of ReadResource and
+// ReadResourceList. If the handler hasn't returned within the Delay, a second
+// invocation is started concurrently and the result of whichever returns first is
+// used. The other invocation's RequestContext is canceled and its result discarded,
+// so the response is written exactly once. Creates, updates, and deletes are never
+// hedged.
+//
+// Since a request may invoke the handler twice, only resources whose reads are free
+// of side effects should be hedged. Each invocation has its own messages, and only
+// those of the invocation used are included in the response. When combined with
+// Retries, each invocation retries independently.
+type Hedging struct {
+	// Delay is how long to wait for the first invocation before hedging it. Hedging
+	// is disabled if it's zero.
+	Delay time.Duration
+
+	// MaxConcurrent caps the number of hedged invocations in progress for the
+	// resource at a time. Requests beyond it aren't hedged. Defaults to 10.
+	MaxConcurrent int
+
+	// After returns a channel which receives once the duration has elapsed. It
+	// defaults to time.After, and tests may provide one which fires when they choose
+	// so hedging doesn't depend on timing.
+	After func(time.Duration) <-chan time.Time
+}
+
+// apply sets the Hedging on the resource.
+func (h Hedging) apply(opts *resourceOptions) {
+	opts.hedging = &h
+}
+
+// maxConcurrent returns the maximum number of concurrent hedged invocations.
+func (h *Hedging) maxConcurrent() int {
+	if h.MaxConcurrent <= 0 {
+		return defaultMaxHedges
+	}
+	return h.MaxConcurrent
+}
+
+// after returns a channel which receives once the duration has elapsed.
+func (h *Hedging) after(delay time.Duration) <-chan time.Time {
+	if h.After != nil {
+		return h.After(delay)
+	}
+	return time.After(delay)
+}
+
+// hedgingHandler is a ResourceHandler which hedges the ResourceHandler's reads as
+// configured by Hedging.
+type hedgingHandler struct {
+	ResourceHandler
+	hedging *Hedging
+	metrics Metrics
+	hedges  chan struct{}
+}
+
+// newHedgingHandler returns a hedgingHandler which hedges the ResourceHandler's reads.
+func newHedgingHandler(h ResourceHandler, hedging *Hedging, metrics Metrics) hedgingHandler {
+	return hedgingHandler{h, hedging, metrics, make(chan struct{}, hedging.maxConcurrent())}
+}
+
+// unwrap returns the wrapped ResourceHandler.
+func (h hedgingHandler) unwrap() ResourceHandler {
+	return h.ResourceHandler
+}
+
+// ReadResource invokes the wrapped ResourceHandler's ReadResource, hedging it if it
+// doesn't return within the delay.
+func (h hedgingHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	return h.hedge(ctx, func(ctx RequestContext) (interface{}, error) {
+		return h.ResourceHandler.ReadResource(ctx, id, version)
+	})
+}
+
+// resourceList is the result of ReadResourceList.
+type resourceList struct {
+	resources []Resource
+	cursor    string
+}
+
+// ReadResourceList invokes the wrapped ResourceHandler's ReadResourceList, hedging it
+// if it doesn't return within the delay.
+func (h hedgingHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+
+	result, err := h.hedge(ctx, func(ctx RequestContext) (interface{}, error) {
+		resources, next, err := h.ResourceHandler.ReadResourceList(ctx, limit, cursor, version)
+		return resourceList{resources, next}, err
+	})
+	list, _ := result.(resourceList)
+	return list.resources, list.cursor, err
+}
+
+// invocation is the outcome of one invocation of a hedged method.
+type invocation struct {
+	result    interface{}
+	err       error
+	recovered interface{}
+	ctx       *gorillaRequestContext
+	hedged    bool
+}
+
+// hedge invokes the function and, if it doesn't return within the delay and the cap
+// on concurrent hedges allows it, invokes it again. The outcome of the first to
+// return is used and the other is canceled. A panic in the invocation used is
+// re-raised.
+func (h hedgingHandler) hedge(ctx RequestContext,
+	f func(RequestContext) (interface{}, error)) (interface{}, error) {
+
+	parent, ok := ctx.(*gorillaRequestContext)
+	if !ok || h.hedging.Delay <= 0 {
+		return f(ctx)
+	}
+
+	// Buffered so the invocation not used never blocks.
+	outcomes := make(chan invocation, 2)
+	cancels := []context.CancelFunc{}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	invoke := func(hedged bool, done func()) {
+		attempt, cancel := parent.withCancel()
+		cancels = append(cancels, cancel)
+		go func() {
+			outcome := invocation{ctx: attempt, hedged: hedged}
+			defer func() {
+				outcome.recovered = recover()
+				done()
+				outcomes <- outcome
+			}()
+			outcome.result, outcome.err = f(attempt)
+		}()
+	}
+
+	invoke(false, func() {})
+	var outcome invocation
+	select {
+	case outcome = <-outcomes:
+	case <-h.hedging.after(h.hedging.Delay):
+		select {
+		case h.hedges <- struct{}{}:
+			h.metrics.incr(HedgesFiredCounter, h.ResourceName())
+			invoke(true, func() { <-h.hedges })
+		default:
+			// Too many hedges are in progress.
+		}
+		outcome = <-outcomes
+	}
+
+	if outcome.hedged {
+		h.metrics.incr(HedgesWonCounter, h.ResourceName())
+	}
+	for _, message := range outcome.ctx.Messages() {
+		parent.AddMessage(message)
+	}
+	if outcome.recovered != nil {
+		panic(outcome.recovered)
+	}
+	return outcome.result, outcome.err
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// slowHandler is a ResourceHandler whose reads block on a per-invocation channel
+// until released or canceled.
+type slowHandler struct {
+	BaseResourceHandler
+	mu        sync.Mutex
+	calls     int
+	started   chan int
+	release   map[int]chan struct{}
+	cancelled chan int
+}
+
+func newSlowHandler() *slowHandler {
+	return &slowHandler{
+		started:   make(chan int, 10),
+		release:   map[int]chan struct{}{1: make(chan struct{}), 2: make(chan struct{})},
+		cancelled: make(chan int, 10),
+	}
+}
+
+func (s *slowHandler) ResourceName() string {
+	return "slow"
+}
+
+func (s *slowHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	s.mu.Lock()
+	s.calls++
+	call := s.calls
+	release, ok := s.release[call]
+	s.mu.Unlock()
+
+	ctx.AddMessage(id)
+	s.started <- call
+	if ok {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			s.cancelled <- call
+			return nil, ctx.Err()
+		}
+	}
+	return map[string]interface{}{"call": call}, nil
+}
+
+// fired returns an After hook whose channel receives once the test sends on the
+// returned channel.
+func fired() (func(time.Duration) <-chan time.Time, chan time.Time) {
+	trigger := make(chan time.Time, 1)
+	return func(time.Duration) <-chan time.Time { return trigger }, trigger
+}
+
+// Ensures that a read which doesn't return within the delay is hedged, the hedge's
+// result is used, and the first invocation is canceled.
+func TestHedgingHedgeWins(t *testing.T) {
+	assert := assert.New(t)
+	handler := newSlowHandler()
+	after, trigger := fired()
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, Hedging{Delay: time.Second, After: after})
+
+	go func() {
+		<-handler.started
+		trigger <- time.Now()
+		<-handler.started
+		close(handler.release[2])
+	}()
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/slow/1", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"call":2`)
+	assert.Equal(1, <-handler.cancelled)
+	assert.Equal(uint64(1), api.Metrics().Counter(HedgesFiredCounter, "slow"))
+	assert.Equal(uint64(1), api.Metrics().Counter(HedgesWonCounter, "slow"))
+}
+
+// Ensures that a read which returns within the delay isn't hedged.
+func TestHedgingNotNeeded(t *testing.T) {
+	assert := assert.New(t)
+	handler := newSlowHandler()
+	after, _ := fired()
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, Hedging{Delay: time.Second, After: after})
+	close(handler.release[1])
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/slow/1", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"call":1`)
+	assert.Equal(1, handler.calls)
+	assert.Equal(uint64(0), api.Metrics().Counter(HedgesFiredCounter, "slow"))
+}
+
+// Ensures that the first invocation's result is used if it returns after the hedge
+// starts but before it, and the hedge is canceled.
+func TestHedgingFirstWins(t *testing.T) {
+	assert := assert.New(t)
+	handler := newSlowHandler()
+	after, trigger := fired()
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, Hedging{Delay: time.Second, After: after})
+
+	go func() {
+		<-handler.started
+		trigger <- time.Now()
+		<-handler.started
+		close(handler.release[1])
+	}()
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/slow/1", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"call":1`)
+	assert.Contains(resp.Body.String(), `"messages":["1"]`)
+	assert.Equal(2, <-handler.cancelled)
+	assert.Equal(uint64(1), api.Metrics().Counter(HedgesFiredCounter, "slow"))
+	assert.Equal(uint64(0), api.Metrics().Counter(HedgesWonCounter, "slow"))
+}
+
+// Ensures that reads aren't hedged once MaxConcurrent hedges are in progress.
+func TestHedgingMaxConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	handler := newSlowHandler()
+	handler.release[3] = make(chan struct{})
+	api := NewAPI(&Configuration{})
+	trigger := make(chan time.Time)
+	close(trigger)
+	api.RegisterResourceHandler(handler, Hedging{
+		Delay:         time.Second,
+		MaxConcurrent: 1,
+		After:         func(time.Duration) <-chan time.Time { return trigger },
+	})
+
+	// The first request's invocations block until released, holding its hedge.
+	done := make(chan struct{})
+	go func() {
+		req, _ := http.NewRequest("GET", "http://foo.com/api/v1/slow/1", nil)
+		api.ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	<-handler.started
+	<-handler.started
+
+	// The second request's invocation returns once released, without being hedged.
+	go func() {
+		<-handler.started
+		close(handler.release[3])
+	}()
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/slow/2", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"call":3`)
+	assert.Equal(uint64(1), api.Metrics().Counter(HedgesFiredCounter, "slow"))
+
+	close(handler.release[1])
+	<-done
+	assert.Equal(3, handler.calls)
+}
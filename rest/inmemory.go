@@ -0,0 +1,826 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IDStrategy determines how an InMemoryResourceHandler assigns IDs to resources which
+// are created without one.
+type IDStrategy int
+
+// ID strategies.
+const (
+	// AutoIncrement assigns sequential IDs starting at 1. The ID field may be an
+	// integer or a string.
+	AutoIncrement IDStrategy = iota
+
+	// UUID assigns random version 4 UUIDs. The ID field must be a string.
+	UUID
+)
+
+const (
+	// defaultIDField is the name of the struct field used for resource IDs if the
+	// InMemoryOptions don't specify one.
+	defaultIDField = "ID"
+
+	// inMemoryCursorVersion is the CursorVersion of the offset cursors returned by
+	// InMemoryResourceHandler.
+	inMemoryCursorVersion = "offset"
+)
+
+// InMemoryOptions configures an InMemoryResourceHandler.
+type InMemoryOptions struct {
+	// IDField is the name of the struct field holding the resource ID. Defaults to
+	// "ID".
+	IDField string
+
+	// IDStrategy determines how IDs are assigned to created resources. Defaults to
+	// AutoIncrement.
+	IDStrategy IDStrategy
+
+	// Rules are the resource Rules to apply to requests and responses. Inbound Rules
+	// discard unspecified fields, so include a Rule for the ID field if resources are
+	// updated in bulk.
+	Rules Rules
+
+	// PersistPath is the path of a JSON file to which resources are written after each
+	// change and from which they're loaded on construction. Resources are only kept in
+	// memory if it's empty.
+	PersistPath string
+}
+
+// InMemoryResourceHandler is a ResourceHandler which stores resources of a struct type
+// in memory. It implements the full set of CRUD operations and is safe for concurrent
+// use, making it suitable for prototyping an API before its storage exists and for
+// tests.
+//
+// Payloads are decoded into the resource type using its JSON encoding, so the keys of
+// the Payload must match the JSON field names. Update merges the Payload into the
+// existing resource. ReadResourceList honors the limit and cursor, and supports
+// filtering and sorting on the resource's JSON fields via RequestContext#Filters and
+// RequestContext#Sort.
+type InMemoryResourceHandler struct {
+	BaseResourceHandler
+	name         string
+	resourceType reflect.Type
+	idField      reflect.StructField
+	idName       string
+	fields       map[string]bool
+	options      InMemoryOptions
+	mu           sync.RWMutex
+	resources    map[string]interface{}
+	order        []string
+	nextID       int64
+}
+
+// NewInMemoryResourceHandler returns an InMemoryResourceHandler for the named resource
+// which stores values of the prototype's type. The prototype must be a struct or a
+// pointer to a struct. An error is returned if the prototype's ID field is invalid for
+// the IDStrategy or the PersistPath can't be loaded.
+func NewInMemoryResourceHandler(name string, prototype interface{},
+	options InMemoryOptions) (*InMemoryResourceHandler, error) {
+
+	resourceType := reflect.TypeOf(prototype)
+	if resourceType != nil && resourceType.Kind() == reflect.Ptr {
+		resourceType = resourceType.Elem()
+	}
+	if resourceType == nil || resourceType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Invalid resource type: must be struct, got %v", resourceType)
+	}
+
+	if options.IDField == "" {
+		options.IDField = defaultIDField
+	}
+	idField, ok := resourceType.FieldByName(options.IDField)
+	if !ok {
+		return nil, fmt.Errorf("Invalid ID field: %s has no field '%s'",
+			resourceType, options.IDField)
+	}
+	if !validIDKind(idField.Type.Kind(), options.IDStrategy) {
+		return nil, fmt.Errorf("Invalid ID field: '%s' is type %s", options.IDField,
+			idField.Type)
+	}
+
+	h := &InMemoryResourceHandler{
+		name:         name,
+		resourceType: resourceType,
+		idField:      idField,
+		idName:       jsonFieldName(idField),
+		fields:       jsonFieldNames(resourceType),
+		options:      options,
+		resources:    map[string]interface{}{},
+		order:        []string{},
+	}
+
+	if err := h.load(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// ResourceName returns the name of the resource.
+func (h *InMemoryResourceHandler) ResourceName() string {
+	return h.name
+}
+
+// Rules returns the Rules provided in the InMemoryOptions.
+func (h *InMemoryResourceHandler) Rules() Rules {
+	if h.options.Rules == nil {
+		return &rules{}
+	}
+	return h.options.Rules
+}
+
+// CursorVersion returns the version of the offset cursors used for pagination.
+func (h *InMemoryResourceHandler) CursorVersion() string {
+	return inMemoryCursorVersion
+}
+
+// CreateResource stores a new resource decoded from the Payload. If the Payload doesn't
+// include an ID, one is assigned using the IDStrategy. Returns a 409 if a resource with
+// the ID already exists.
+func (h *InMemoryResourceHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+
+	resource := reflect.New(h.resourceType)
+	if err := decodeResource(data, resource.Interface()); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	id := h.resourceID(resource)
+	if id == "" {
+		var err error
+		if id, err = h.assignID(resource); err != nil {
+			return nil, err
+		}
+	} else if _, ok := h.resources[id]; ok {
+		return nil, ResourceConflict(fmt.Sprintf("Resource with id %s already exists", id))
+	}
+
+	err := h.commit(func() {
+		h.trackID(id)
+		h.resources[id] = resource.Interface()
+		h.order = append(h.order, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h.copyResource(resource.Interface()), nil
+}
+
+// ReadResource returns the resource with the ID or a 404 if it doesn't exist.
+func (h *InMemoryResourceHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	resource, ok := h.resources[id]
+	if !ok {
+		return nil, ResourceNotFound(fmt.Sprintf("No resource with id %s", id))
+	}
+	return h.copyResource(resource), nil
+}
+
+// ReadResourceList returns up to limit resources, starting at the offset encoded in the
+// cursor, which match the request's Filters and are ordered by its Sort. Resources are
+// otherwise ordered by creation. A limit of zero or less returns all resources. The
+// returned cursor is empty if there are no more resources.
+func (h *InMemoryResourceHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+
+	offset := 0
+	if cursor != "" {
+		var err error
+		offset, err = strconv.Atoi(cursor)
+		if err != nil || offset < 0 {
+			return nil, "", BadRequest(fmt.Sprintf("Invalid cursor: %s", cursor))
+		}
+	}
+
+	filters := ctx.Filters()
+	sortFields := ctx.Sort()
+	if err := h.validateQuery(filters, sortFields); err != nil {
+		return nil, "", err
+	}
+
+	h.mu.RLock()
+	matches := make([]listEntry, 0, len(h.order))
+	for _, id := range h.order {
+		resource := h.resources[id]
+		fields, err := resourceFields(resource)
+		if err != nil {
+			h.mu.RUnlock()
+			return nil, "", err
+		}
+		if matchesFilters(fields, filters) {
+			matches = append(matches, listEntry{h.copyResource(resource), fields})
+		}
+	}
+	h.mu.RUnlock()
+
+	sort.Stable(listSorter{matches, sortFields})
+
+	if offset > len(matches) {
+		offset = len(matches)
+	}
+	end := len(matches)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	results := make([]Resource, 0, end-offset)
+	for _, entry := range matches[offset:end] {
+		results = append(results, entry.resource)
+	}
+
+	next := ""
+	if end < len(matches) {
+		next = strconv.Itoa(end)
+	}
+	return results, next, nil
+}
+
+// CountResources returns the number of resources matching the request's Filters.
+func (h *InMemoryResourceHandler) CountResources(ctx RequestContext,
+	version string) (int64, error) {
+
+	filters := ctx.Filters()
+	if err := h.validateQuery(filters, nil); err != nil {
+		return 0, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var count int64
+	for _, id := range h.order {
+		fields, err := resourceFields(h.resources[id])
+		if err != nil {
+			return 0, err
+		}
+		if matchesFilters(fields, filters) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UpdateResource merges the Payload into the resource with the ID. The ID itself can't
+// be changed. Returns a 404 if the resource doesn't exist.
+func (h *InMemoryResourceHandler) UpdateResource(ctx RequestContext, id string,
+	data Payload, version string) (Resource, error) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	updated, err := h.merge(id, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.commit(func() { h.resources[id] = updated }); err != nil {
+		return nil, err
+	}
+	return h.copyResource(updated), nil
+}
+
+// UpdateResourceList merges each Payload into the resource identified by its ID field.
+// Either every resource is updated or, if any Payload lacks an ID or references a
+// resource which doesn't exist, none are.
+func (h *InMemoryResourceHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ids := make([]string, len(data))
+	updates := make([]interface{}, len(data))
+	for i, payload := range data {
+		value, ok := payload[h.idName]
+		if !ok || value == nil {
+			return nil, BadRequest(fmt.Sprintf("Missing %s for resource", h.idName))
+		}
+		ids[i] = payloadID(value)
+
+		updated, err := h.merge(ids[i], payload)
+		if err != nil {
+			return nil, err
+		}
+		updates[i] = updated
+	}
+
+	err := h.commit(func() {
+		for i, id := range ids {
+			h.resources[id] = updates[i]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Resource, len(updates))
+	for i, updated := range updates {
+		results[i] = h.copyResource(updated)
+	}
+	return results, nil
+}
+
+// DeleteResource removes the resource with the ID and returns it. Returns a 404 if it
+// doesn't exist.
+func (h *InMemoryResourceHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resource, ok := h.resources[id]
+	if !ok {
+		return nil, ResourceNotFound(fmt.Sprintf("No resource with id %s", id))
+	}
+
+	err := h.commit(func() {
+		delete(h.resources, id)
+		for i, existing := range h.order {
+			if existing == id {
+				h.order = append(h.order[:i], h.order[i+1:]...)
+				break
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return h.copyResource(resource), nil
+}
+
+// Len returns the number of stored resources.
+func (h *InMemoryResourceHandler) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.order)
+}
+
+// merge returns a copy of the resource with the ID with the Payload decoded into it,
+// preserving the ID. Must be called with the lock held.
+func (h *InMemoryResourceHandler) merge(id string, data Payload) (interface{}, error) {
+	resource, ok := h.resources[id]
+	if !ok {
+		return nil, ResourceNotFound(fmt.Sprintf("No resource with id %s", id))
+	}
+
+	updated := reflect.ValueOf(h.copyResource(resource))
+	if err := decodeResource(data, updated.Interface()); err != nil {
+		return nil, err
+	}
+	updated.Elem().FieldByIndex(h.idField.Index).Set(
+		reflect.ValueOf(resource).Elem().FieldByIndex(h.idField.Index))
+	return updated.Interface(), nil
+}
+
+// commit applies the change and, if a PersistPath is configured, writes the resources
+// to it. If writing fails, the change is reverted. Must be called with the lock held.
+func (h *InMemoryResourceHandler) commit(change func()) error {
+	if h.options.PersistPath == "" {
+		change()
+		return nil
+	}
+
+	resources := make(map[string]interface{}, len(h.resources))
+	for id, resource := range h.resources {
+		resources[id] = resource
+	}
+	order := append([]string{}, h.order...)
+	nextID := h.nextID
+
+	change()
+
+	if err := h.save(); err != nil {
+		h.resources, h.order, h.nextID = resources, order, nextID
+		return InternalServerError(fmt.Sprintf("Failed to persist %s: %s", h.name, err))
+	}
+	return nil
+}
+
+// save writes the resources, ordered by creation, to the PersistPath. The file is
+// replaced atomically so a failed write doesn't corrupt it.
+func (h *InMemoryResourceHandler) save() error {
+	resources := make([]interface{}, len(h.order))
+	for i, id := range h.order {
+		resources[i] = h.resources[id]
+	}
+
+	data, err := json.MarshalIndent(resources, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir, file := filepath.Split(h.options.PersistPath)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, file)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), h.options.PersistPath)
+}
+
+// load reads the resources stored at the PersistPath, if any.
+func (h *InMemoryResourceHandler) load() error {
+	if h.options.PersistPath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(h.options.PersistPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	resources := reflect.New(reflect.SliceOf(reflect.PtrTo(h.resourceType)))
+	if err := json.Unmarshal(data, resources.Interface()); err != nil {
+		return fmt.Errorf("Unable to load %s: %s", h.options.PersistPath, err)
+	}
+
+	for i := 0; i < resources.Elem().Len(); i++ {
+		resource := resources.Elem().Index(i)
+		id := h.resourceID(resource)
+		if id == "" {
+			return fmt.Errorf("Unable to load %s: resource %d has no ID",
+				h.options.PersistPath, i)
+		}
+		h.trackID(id)
+		h.resources[id] = resource.Interface()
+		h.order = append(h.order, id)
+	}
+	return nil
+}
+
+// resourceID returns the ID of the resource pointer as a string or an empty string if
+// it's the zero value.
+func (h *InMemoryResourceHandler) resourceID(resource reflect.Value) string {
+	id := resource.Elem().FieldByIndex(h.idField.Index)
+	if id.Interface() == reflect.Zero(id.Type()).Interface() {
+		return ""
+	}
+	return fmt.Sprint(id.Interface())
+}
+
+// assignID sets the next ID on the resource pointer using the IDStrategy and returns
+// it. Must be called with the lock held.
+func (h *InMemoryResourceHandler) assignID(resource reflect.Value) (string, error) {
+	field := resource.Elem().FieldByIndex(h.idField.Index)
+
+	if h.options.IDStrategy == UUID {
+		id, err := newUUID()
+		if err != nil {
+			return "", InternalServerError(fmt.Sprintf("Failed to generate id: %s", err))
+		}
+		field.SetString(id)
+		return id, nil
+	}
+
+	for {
+		h.nextID++
+		id := strconv.FormatInt(h.nextID, 10)
+		if _, ok := h.resources[id]; ok {
+			continue
+		}
+		if field.Kind() == reflect.String {
+			field.SetString(id)
+		} else {
+			field.SetInt(h.nextID)
+		}
+		return id, nil
+	}
+}
+
+// trackID advances the auto-increment counter past the ID if it's numeric so assigned
+// IDs don't collide with client-provided ones.
+func (h *InMemoryResourceHandler) trackID(id string) {
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil && n > h.nextID {
+		h.nextID = n
+	}
+}
+
+// copyResource returns a pointer to a deep copy of the stored resource so callers,
+// such as outbound Rules, can't modify the stored value or the maps, slices, and
+// pointers it contains.
+func (h *InMemoryResourceHandler) copyResource(resource interface{}) interface{} {
+	copied := reflect.New(h.resourceType)
+	copied.Elem().Set(deepCopy(reflect.ValueOf(resource).Elem()))
+	return copied.Interface()
+}
+
+// deepCopy returns a copy of the value which shares no maps, slices, or pointers with
+// it. Unexported struct fields are copied shallowly since they can't be set.
+func deepCopy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type().Elem())
+		copied.Elem().Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeMap(value.Type())
+		for _, key := range value.MapKeys() {
+			copied.SetMapIndex(key, deepCopy(value.MapIndex(key)))
+		}
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(deepCopy(value.Index(i)))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(value.Type()).Elem()
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(deepCopy(value.Index(i)))
+		}
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if field := copied.Field(i); field.CanSet() {
+				field.Set(deepCopy(value.Field(i)))
+			}
+		}
+		return copied
+	}
+	return value
+}
+
+// validateQuery returns a 400 if any of the Filters or SortFields reference unknown
+// fields or a Filter uses an unsupported operator.
+func (h *InMemoryResourceHandler) validateQuery(filters []QueryFilter,
+	sortFields []SortField) error {
+
+	for _, filter := range filters {
+		if !h.fields[filter.Field] {
+			return BadRequest(fmt.Sprintf("Unknown filter field: %s", filter.Field))
+		}
+		if _, ok := filterOperators[filter.Operator]; !ok {
+			return BadRequest(fmt.Sprintf("Unsupported filter operator: %s",
+				filter.Operator))
+		}
+	}
+	for _, field := range sortFields {
+		if !h.fields[field.Field] {
+			return BadRequest(fmt.Sprintf("Unknown sort field: %s", field.Field))
+		}
+	}
+	return nil
+}
+
+// filterOperators maps supported filter operators to a test of the result of comparing
+// a field value to the filter value.
+var filterOperators = map[string]func(int) bool{
+	FilterEqual:              func(c int) bool { return c == 0 },
+	FilterNotEqual:           func(c int) bool { return c != 0 },
+	FilterLessThan:           func(c int) bool { return c < 0 },
+	FilterLessThanOrEqual:    func(c int) bool { return c <= 0 },
+	FilterGreaterThan:        func(c int) bool { return c > 0 },
+	FilterGreaterThanOrEqual: func(c int) bool { return c >= 0 },
+}
+
+// matchesFilters returns true if the resource's JSON fields satisfy every Filter.
+func matchesFilters(fields map[string]interface{}, filters []QueryFilter) bool {
+	for _, filter := range filters {
+		var value interface{} = filter.Value
+		if _, ok := fields[filter.Field].(float64); ok {
+			if f, err := strconv.ParseFloat(filter.Value, 64); err == nil {
+				value = f
+			}
+		}
+		if !filterOperators[filter.Operator](compareJSON(fields[filter.Field], value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareJSON compares two decoded JSON values, returning a negative number if a sorts
+// before b, zero if they're equal, and a positive number otherwise. Numbers are
+// compared numerically, null sorts first, and everything else is compared by its
+// string form.
+func compareJSON(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	af, aNumber := a.(float64)
+	bf, bNumber := b.(float64)
+	if aNumber && bNumber {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	switch {
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// listEntry is a resource matching a read list request along with its JSON fields.
+type listEntry struct {
+	resource Resource
+	fields   map[string]interface{}
+}
+
+// listSorter implements sort.Interface, ordering listEntries by the SortFields.
+type listSorter struct {
+	entries []listEntry
+	fields  []SortField
+}
+
+// Len returns the number of entries.
+func (l listSorter) Len() int {
+	return len(l.entries)
+}
+
+// Swap swaps the entries at the given indexes.
+func (l listSorter) Swap(i, j int) {
+	l.entries[i], l.entries[j] = l.entries[j], l.entries[i]
+}
+
+// Less returns true if the entry at i sorts before the one at j.
+func (l listSorter) Less(i, j int) bool {
+	for _, field := range l.fields {
+		c := compareJSON(l.entries[i].fields[field.Field], l.entries[j].fields[field.Field])
+		if c == 0 {
+			continue
+		}
+		if field.Descending {
+			return c > 0
+		}
+		return c < 0
+	}
+	return false
+}
+
+// decodeResource decodes the Payload into the resource pointer using its JSON encoding.
+// Returns a 400 if the Payload doesn't fit the resource type.
+func decodeResource(data Payload, resource interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return BadRequest(fmt.Sprintf("Invalid payload: %s", err))
+	}
+	if err := json.Unmarshal(encoded, resource); err != nil {
+		return BadRequest(fmt.Sprintf("Invalid payload: %s", err))
+	}
+	return nil
+}
+
+// resourceFields returns the resource's JSON fields.
+func resourceFields(resource interface{}) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// payloadID returns the ID value from a Payload as a string. JSON numbers are
+// formatted without exponents so they match stored integer IDs.
+func payloadID(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// jsonFieldName returns the name of the struct field in its JSON encoding.
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+// jsonFieldNames returns the set of top-level field names in the JSON encoding of the
+// struct type, including those promoted from embedded structs.
+func jsonFieldNames(structType reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			for name := range jsonFieldNames(field.Type) {
+				names[name] = true
+			}
+			continue
+		}
+		names[jsonFieldName(field)] = true
+	}
+	return names
+}
+
+// validIDKind returns true if a field of the kind can hold IDs assigned using the
+// IDStrategy.
+func validIDKind(kind reflect.Kind, strategy IDStrategy) bool {
+	switch kind {
+	case reflect.String:
+		return true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strategy == AutoIncrement
+	default:
+		return false
+	}
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,310 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// widget is the resource type stored by the InMemoryResourceHandler tests.
+type widget struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// serveInMemory sends the request to the API and returns the response along with the
+// decoded response body.
+func serveInMemory(api API, method, uri, body string) (*httptest.ResponseRecorder,
+	map[string]interface{}) {
+
+	req, _ := http.NewRequest(method, "http://foo.com"+uri, bytes.NewBufferString(body))
+	req.RequestURI = req.URL.RequestURI()
+	resp := httptest.NewRecorder()
+	api.(*muxAPI).ServeHTTP(resp, req)
+
+	decoded := map[string]interface{}{}
+	json.Unmarshal(resp.Body.Bytes(), &decoded)
+	return resp, decoded
+}
+
+// newWidgetAPI returns an API with an InMemoryResourceHandler for widgets registered.
+func newWidgetAPI(t *testing.T, options InMemoryOptions) (API, *InMemoryResourceHandler) {
+	handler, err := NewInMemoryResourceHandler("widgets", widget{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler)
+	return api, handler
+}
+
+// Ensures that NewInMemoryResourceHandler rejects prototypes which aren't structs and
+// ID fields which are missing or can't hold IDs from the IDStrategy.
+func TestNewInMemoryResourceHandlerInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewInMemoryResourceHandler("widgets", "foo", InMemoryOptions{})
+	assert.NotNil(err)
+
+	_, err = NewInMemoryResourceHandler("widgets", &widget{},
+		InMemoryOptions{IDField: "Key"})
+	assert.NotNil(err)
+
+	_, err = NewInMemoryResourceHandler("widgets", &widget{},
+		InMemoryOptions{IDStrategy: UUID})
+	assert.NotNil(err)
+}
+
+// Ensures that InMemoryResourceHandler supports creating, reading, updating, and
+// deleting resources with auto-incremented IDs.
+func TestInMemoryResourceHandlerCRUD(t *testing.T) {
+	assert := assert.New(t)
+	api, handler := newWidgetAPI(t, InMemoryOptions{})
+
+	resp, body := serveInMemory(api, "POST", "/api/v1/widgets", `{"name":"gear","size":3}`)
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal(map[string]interface{}{"id": 1.0, "name": "gear", "size": 3.0},
+		body["result"])
+
+	resp, body = serveInMemory(api, "POST", "/api/v1/widgets", `{"id":1,"name":"cog"}`)
+	assert.Equal(http.StatusConflict, resp.Code)
+
+	resp, body = serveInMemory(api, "PUT", "/api/v1/widgets/1", `{"id":7,"size":4}`)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(map[string]interface{}{"id": 1.0, "name": "gear", "size": 4.0},
+		body["result"])
+
+	resp, body = serveInMemory(api, "GET", "/api/v1/widgets/1", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(map[string]interface{}{"id": 1.0, "name": "gear", "size": 4.0},
+		body["result"])
+
+	resp, _ = serveInMemory(api, "DELETE", "/api/v1/widgets/1", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(0, handler.Len())
+
+	resp, _ = serveInMemory(api, "GET", "/api/v1/widgets/1", "")
+	assert.Equal(http.StatusNotFound, resp.Code)
+}
+
+// Ensures that InMemoryResourceHandler assigns UUIDs to string ID fields when
+// configured to.
+func TestInMemoryResourceHandlerUUID(t *testing.T) {
+	assert := assert.New(t)
+	type note struct {
+		Key  string `json:"key"`
+		Text string `json:"text"`
+	}
+	handler, err := NewInMemoryResourceHandler("notes", note{},
+		InMemoryOptions{IDField: "Key", IDStrategy: UUID})
+	assert.Nil(err)
+
+	created, err := handler.CreateResource(nil, Payload{"text": "hi"}, "1")
+	assert.Nil(err)
+	key := created.(*note).Key
+	assert.Regexp("^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$",
+		key)
+
+	read, err := handler.ReadResource(nil, key, "1")
+	assert.Nil(err)
+	assert.Equal(&note{Key: key, Text: "hi"}, read)
+}
+
+// Ensures that the read list endpoint filters and sorts using the query string and
+// paginates through the results using the limit and returned cursors.
+func TestInMemoryResourceHandlerReadList(t *testing.T) {
+	assert := assert.New(t)
+	api, _ := newWidgetAPI(t, InMemoryOptions{})
+
+	for i, name := range []string{"gear", "cog", "axle", "bolt", "spring"} {
+		serveInMemory(api, "POST", "/api/v1/widgets",
+			fmt.Sprintf(`{"name":"%s","size":%d}`, name, i%3))
+	}
+
+	names := []interface{}{}
+	uri := "/api/v1/widgets?filter[size][gte]=1&sort=-size,name&limit=2"
+	for uri != "" {
+		resp, body := serveInMemory(api, "GET", uri, "")
+		assert.Equal(http.StatusOK, resp.Code)
+		for _, result := range body["results"].([]interface{}) {
+			names = append(names, result.(map[string]interface{})["name"])
+		}
+		uri = ""
+		if next, ok := body["next"].(string); ok {
+			uri = next[len("http://foo.com"):]
+		}
+	}
+	assert.Equal([]interface{}{"axle", "cog", "spring"}, names)
+
+	_, body := serveInMemory(api, "GET", "/api/v1/widgets?filter[name]=bolt", "")
+	assert.Len(body["results"], 1)
+
+	resp, _ := serveInMemory(api, "GET", "/api/v1/widgets?filter[color]=red", "")
+	assert.Equal(http.StatusBadRequest, resp.Code)
+
+	resp, _ = serveInMemory(api, "GET", "/api/v1/widgets?filter[size][like]=1", "")
+	assert.Equal(http.StatusBadRequest, resp.Code)
+
+	resp, _ = serveInMemory(api, "GET", "/api/v1/widgets?sort=color", "")
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+// Ensures that InMemoryResourceHandler Rules are applied to requests like any other
+// handler's.
+func TestInMemoryResourceHandlerRules(t *testing.T) {
+	assert := assert.New(t)
+	api, handler := newWidgetAPI(t, InMemoryOptions{
+		Rules: NewRules((*widget)(nil),
+			&Rule{Field: "ID", FieldAlias: "id", Type: Int},
+			&Rule{Field: "Name", FieldAlias: "name", Type: String, Required: true},
+		),
+	})
+
+	resp, _ := serveInMemory(api, "POST", "/api/v1/widgets", `{"size":3}`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code)
+	assert.Equal(0, handler.Len())
+
+	resp, body := serveInMemory(api, "POST", "/api/v1/widgets", `{"name":"gear","size":3}`)
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal(map[string]interface{}{"id": 1.0, "name": "gear"}, body["result"])
+}
+
+// Ensures that UpdateResourceList updates every resource or, if any is missing, none.
+func TestInMemoryResourceHandlerUpdateList(t *testing.T) {
+	assert := assert.New(t)
+	handler, _ := NewInMemoryResourceHandler("widgets", widget{}, InMemoryOptions{})
+	handler.CreateResource(nil, Payload{"name": "gear"}, "1")
+	handler.CreateResource(nil, Payload{"name": "cog"}, "1")
+
+	_, err := handler.UpdateResourceList(nil,
+		[]Payload{{"id": 1, "size": 5}, {"id": 3, "size": 5}}, "1")
+	assert.Equal(ResourceNotFound("No resource with id 3"), err)
+
+	_, err = handler.UpdateResourceList(nil, []Payload{{"size": 5}}, "1")
+	assert.Equal(BadRequest("Missing id for resource"), err)
+
+	updated, err := handler.UpdateResourceList(nil,
+		[]Payload{{"id": 1, "size": 5}, {"id": 2e0, "size": 6}}, "1")
+	assert.Nil(err)
+	assert.Equal([]Resource{
+		&widget{ID: 1, Name: "gear", Size: 5},
+		&widget{ID: 2, Name: "cog", Size: 6},
+	}, updated)
+}
+
+// gizmo is a resource type with nested maps, slices, and pointers.
+type gizmo struct {
+	ID     int               `json:"id"`
+	Labels map[string]string `json:"labels"`
+	Parts  []string          `json:"parts"`
+	Spec   *gizmoSpec        `json:"spec"`
+}
+
+// gizmoSpec is nested in a gizmo.
+type gizmoSpec struct {
+	Sizes []int `json:"sizes"`
+}
+
+// Ensures that stored resources share no maps, slices, or pointers with the resources
+// returned, and that a failed list update leaves nested values unchanged.
+func TestInMemoryResourceHandlerDeepCopies(t *testing.T) {
+	assert := assert.New(t)
+	handler, _ := NewInMemoryResourceHandler("gizmos", gizmo{}, InMemoryOptions{})
+	created, _ := handler.CreateResource(nil, Payload{"labels": map[string]interface{}{"a": "1"},
+		"parts": []interface{}{"x"}, "spec": map[string]interface{}{"sizes": []interface{}{1}}}, "1")
+
+	created.(*gizmo).Labels["a"] = "changed"
+	created.(*gizmo).Parts[0] = "changed"
+	created.(*gizmo).Spec.Sizes[0] = 99
+	read, _ := handler.ReadResource(nil, "1", "1")
+	assert.Equal(&gizmo{ID: 1, Labels: map[string]string{"a": "1"}, Parts: []string{"x"},
+		Spec: &gizmoSpec{Sizes: []int{1}}}, read)
+
+	_, err := handler.UpdateResourceList(nil, []Payload{
+		{"id": 1, "labels": map[string]interface{}{"b": "2"}}, {"id": 2}}, "1")
+	assert.NotNil(err)
+	read, _ = handler.ReadResource(nil, "1", "1")
+	assert.Equal(map[string]string{"a": "1"}, read.(*gizmo).Labels)
+
+	deleted, _ := handler.DeleteResource(nil, "1", "1")
+	deleted.(*gizmo).Labels["a"] = "changed"
+	assert.Equal(map[string]string{"a": "1"}, read.(*gizmo).Labels)
+}
+
+// Ensures that InMemoryResourceHandler writes resources to the PersistPath and loads
+// them on construction, continuing the ID sequence.
+func TestInMemoryResourceHandlerPersistence(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "inmemory")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	options := InMemoryOptions{PersistPath: filepath.Join(dir, "widgets.json")}
+
+	handler, err := NewInMemoryResourceHandler("widgets", widget{}, options)
+	assert.Nil(err)
+	handler.CreateResource(nil, Payload{"name": "gear"}, "1")
+	handler.CreateResource(nil, Payload{"name": "cog"}, "1")
+	handler.DeleteResource(nil, "1", "1")
+
+	handler, err = NewInMemoryResourceHandler("widgets", widget{}, options)
+	assert.Nil(err)
+	assert.Equal(1, handler.Len())
+	created, _ := handler.CreateResource(nil, Payload{"name": "axle"}, "1")
+	assert.Equal(&widget{ID: 3, Name: "axle"}, created)
+
+	// A failed write leaves the stored resources unchanged.
+	os.RemoveAll(dir)
+	_, err = handler.CreateResource(nil, Payload{"name": "bolt"}, "1")
+	assert.NotNil(err)
+	assert.Equal(2, handler.Len())
+}
+
+// Ensures that InMemoryResourceHandler is safe for concurrent use.
+func TestInMemoryResourceHandlerConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	api, handler := newWidgetAPI(t, InMemoryOptions{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			serveInMemory(api, "POST", "/api/v1/widgets", `{"name":"gear"}`)
+		}()
+		go func() {
+			defer wg.Done()
+			serveInMemory(api, "GET", "/api/v1/widgets?sort=-id", "")
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(20, handler.Len())
+	_, body := serveInMemory(api, "GET", "/api/v1/widgets/20", "")
+	assert.Equal(20.0, body["result"].(map[string]interface{})["id"])
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// KeyStyle determines how alternate lookup keys are expressed in item URIs.
+type KeyStyle int
+
+// Lookup key styles.
+const (
+	// KeyRoutes adds a sub-route for the keys, e.g. /api/v1/foo/by-slug/hello-world.
+	KeyRoutes KeyStyle = iota
+
+	// KeyPrefix accepts a key prefix in the ID segment of the read URI, e.g.
+	// /api/v1/foo/slug:hello-world.
+	KeyPrefix
+)
+
+const (
+	// IDKey can be included in LookupKeys to position lookups by resource ID in the
+	// precedence order. With KeyPrefix, an "id:" prefix explicitly selects an ID.
+	IDKey = "id"
+
+	// keyPrefixSeparator separates the key from the value with KeyPrefix.
+	keyPrefixSeparator = ":"
+
+	// lookupKeyVar is the name of the URL path variable for the key with KeyRoutes.
+	lookupKeyVar = "lookup_key"
+)
+
+// KeyReader is implemented by ResourceHandlers registered with LookupKeys to read
+// resources by an alternate key.
+type KeyReader interface {
+	// ReadResourceByKey returns the resource whose key has the value.
+	ReadResourceByKey(ctx RequestContext, key, value, version string) (Resource, error)
+}
+
+// LookupKeys is a ResourceOption which allows reading a resource by named alternate
+// keys, such as a slug, in addition to its ID. Lookups by key are dispatched to the
+// ResourceHandler's ReadResourceByKey, which it must implement, while plain IDs are
+// still passed to ReadResource.
+//
+// With Fallback, a plain value is resolved by trying each key in the order of Keys
+// until a lookup doesn't return a 404, so a slug which looks like an ID resolves
+// deterministically. The ID is tried first unless IDKey is included in Keys.
+type LookupKeys struct {
+	// Keys are the names of the alternate keys in order of precedence.
+	Keys []string
+
+	// Style determines how keys are expressed in URIs. Defaults to KeyRoutes.
+	Style KeyStyle
+
+	// Indicates if plain values which aren't found by ID should be looked up by each
+	// key in order of precedence. Defaults to false.
+	Fallback bool
+}
+
+// apply sets the LookupKeys on the resource.
+func (l LookupKeys) apply(opts *resourceOptions) {
+	opts.lookupKeys = &l
+}
+
+// has returns true if the key is one of the alternate keys.
+func (l *LookupKeys) has(key string) bool {
+	for _, k := range l.Keys {
+		if k == key && k != IDKey {
+			return true
+		}
+	}
+	return false
+}
+
+// precedence returns the keys, including IDKey, in the order plain values are
+// resolved.
+func (l *LookupKeys) precedence() []string {
+	for _, key := range l.Keys {
+		if key == IDKey {
+			return l.Keys
+		}
+	}
+	return append([]string{IDKey}, l.Keys...)
+}
+
+// alternates returns the keys other than IDKey.
+func (l *LookupKeys) alternates() []string {
+	keys := make([]string, 0, len(l.Keys))
+	for _, key := range l.Keys {
+		if key != IDKey {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// keyURI returns the read URI for the key.
+func (l *LookupKeys) keyURI(readURI, key string) string {
+	idVar := "{" + resourceIDKey + "}"
+	if l.Style == KeyPrefix {
+		return strings.Replace(readURI, idVar, key+keyPrefixSeparator+idVar, 1)
+	}
+	return strings.Replace(readURI, idVar, "by-"+key+"/"+idVar, 1)
+}
+
+// routeURI returns the URI of the route serving every key with KeyRoutes.
+func (l *LookupKeys) routeURI(readURI string) string {
+	keys := l.alternates()
+	for i, key := range keys {
+		keys[i] = regexp.QuoteMeta(key)
+	}
+	return l.keyURI(readURI, fmt.Sprintf("{%s:%s}", lookupKeyVar, strings.Join(keys, "|")))
+}
+
+// keyedHandler is a ResourceHandler which dispatches reads by alternate key to the
+// KeyReader.
+type keyedHandler struct {
+	ResourceHandler
+	keys   *LookupKeys
+	reader KeyReader
+}
+
+// newKeyedHandler returns a keyedHandler wrapping the ResourceHandler. It panics if
+// the ResourceHandler doesn't implement KeyReader or its read URI can't express
+// keys.
+func newKeyedHandler(h ResourceHandler, keys *LookupKeys) keyedHandler {
+	reader, ok := unwrapHandler(h).(KeyReader)
+	if !ok {
+		panic(fmt.Sprintf("Handler for %s must implement ReadResourceByKey to use LookupKeys",
+			h.ResourceName()))
+	}
+	if len(keys.alternates()) == 0 {
+		panic(fmt.Sprintf("LookupKeys for %s must specify at least one key", h.ResourceName()))
+	}
+	if !strings.Contains(h.ReadURI(), "{"+resourceIDKey+"}") {
+		panic(fmt.Sprintf("Read URI %s for %s must contain {%s} to use LookupKeys",
+			h.ReadURI(), h.ResourceName(), resourceIDKey))
+	}
+	return keyedHandler{h, keys, reader}
+}
+
+// unwrap returns the wrapped ResourceHandler.
+func (k keyedHandler) unwrap() ResourceHandler {
+	return k.ResourceHandler
+}
+
+// ReadResource reads the resource by the key from the request's sub-route or ID
+// prefix, if any. Otherwise the ID is resolved by the wrapped ResourceHandler's
+// ReadResource or, with Fallback, by each key in order of precedence.
+func (k keyedHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	if r, ok := ctx.Request(); ok {
+		if key := mux.Vars(r)[lookupKeyVar]; key != "" {
+			return k.reader.ReadResourceByKey(ctx, key, id, version)
+		}
+	}
+
+	if k.keys.Style == KeyPrefix {
+		parts := strings.SplitN(id, keyPrefixSeparator, 2)
+		if len(parts) == 2 && parts[0] == IDKey {
+			return k.ResourceHandler.ReadResource(ctx, parts[1], version)
+		}
+		if len(parts) == 2 && k.keys.has(parts[0]) {
+			return k.reader.ReadResourceByKey(ctx, parts[0], parts[1], version)
+		}
+	}
+
+	if !k.keys.Fallback {
+		return k.ResourceHandler.ReadResource(ctx, id, version)
+	}
+
+	for _, key := range k.keys.precedence() {
+		var resource Resource
+		var err error
+		if key == IDKey {
+			resource, err = k.ResourceHandler.ReadResource(ctx, id, version)
+		} else {
+			resource, err = k.reader.ReadResourceByKey(ctx, key, id, version)
+		}
+		if restErr, ok := err.(Error); !ok || restErr.Status() != http.StatusNotFound {
+			return resource, err
+		}
+	}
+	return nil, ResourceNotFound(fmt.Sprintf("No resource with id or key %s", id))
+}
+
+// lookupKeys returns the LookupKeys the ResourceHandler was registered with, if any.
+func lookupKeys(h ResourceHandler) *LookupKeys {
+	for {
+		if keyed, ok := h.(keyedHandler); ok {
+			return keyed.keys
+		}
+		wrapper, ok := h.(handlerWrapper)
+		if !ok {
+			return nil
+		}
+		h = wrapper.unwrap()
+	}
+}
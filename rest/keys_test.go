@@ -0,0 +1,168 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// keyedTestHandler is a ResourceHandler with resources identified by ID and by slug.
+type keyedTestHandler struct {
+	BaseResourceHandler
+	byID   map[string]string
+	bySlug map[string]string
+}
+
+func newKeyedTestHandler() keyedTestHandler {
+	return keyedTestHandler{
+		byID:   map[string]string{"42": "answer", "7": "seven"},
+		bySlug: map[string]string{"hello-world": "greeting", "42": "slug-answer"},
+	}
+}
+
+func (k keyedTestHandler) ResourceName() string {
+	return "foo"
+}
+
+func (k keyedTestHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	if resource, ok := k.byID[id]; ok {
+		return "id:" + resource, nil
+	}
+	return nil, ResourceNotFound(fmt.Sprintf("No resource with id %s", id))
+}
+
+func (k keyedTestHandler) ReadResourceByKey(ctx RequestContext, key, value,
+	version string) (Resource, error) {
+	if resource, ok := k.bySlug[value]; ok && key == "slug" {
+		return key + ":" + resource, nil
+	}
+	return nil, ResourceNotFound(fmt.Sprintf("No resource with %s %s", key, value))
+}
+
+// readKeyed sends a read request for the path to the API and returns the status and
+// result.
+func readKeyed(api API, path string) (int, interface{}) {
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/foo/"+path, nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	body := map[string]interface{}{}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	return resp.Code, body["result"]
+}
+
+// Ensures that KeyRoutes adds a sub-route dispatching to ReadResourceByKey while
+// plain IDs are read using ReadResource.
+func TestLookupKeysRoutes(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(newKeyedTestHandler(), LookupKeys{Keys: []string{"slug"}})
+
+	status, result := readKeyed(api, "by-slug/hello-world")
+	assert.Equal(http.StatusOK, status)
+	assert.Equal("slug:greeting", result)
+
+	status, result = readKeyed(api, "42")
+	assert.Equal(http.StatusOK, status)
+	assert.Equal("id:answer", result)
+
+	status, _ = readKeyed(api, "by-color/red")
+	assert.Equal(http.StatusNotFound, status)
+
+	status, _ = readKeyed(api, "hello-world")
+	assert.Equal(http.StatusNotFound, status)
+}
+
+// Ensures that KeyPrefix dispatches prefixed IDs to ReadResourceByKey, strips an
+// explicit ID prefix, and passes other values to ReadResource unchanged.
+func TestLookupKeysPrefix(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(newKeyedTestHandler(),
+		LookupKeys{Keys: []string{"slug"}, Style: KeyPrefix})
+
+	_, result := readKeyed(api, "slug:42")
+	assert.Equal("slug:slug-answer", result)
+
+	_, result = readKeyed(api, "id:42")
+	assert.Equal("id:answer", result)
+
+	_, result = readKeyed(api, "42")
+	assert.Equal("id:answer", result)
+
+	status, _ := readKeyed(api, "color:red")
+	assert.Equal(http.StatusNotFound, status)
+}
+
+// Ensures that with Fallback, plain values resolve by the declared precedence.
+func TestLookupKeysFallbackPrecedence(t *testing.T) {
+	assert := assert.New(t)
+
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(newKeyedTestHandler(),
+		LookupKeys{Keys: []string{"slug"}, Fallback: true})
+	_, result := readKeyed(api, "42")
+	assert.Equal("id:answer", result)
+	_, result = readKeyed(api, "hello-world")
+	assert.Equal("slug:greeting", result)
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(newKeyedTestHandler(),
+		LookupKeys{Keys: []string{"slug", IDKey}, Fallback: true})
+	_, result = readKeyed(api, "42")
+	assert.Equal("slug:slug-answer", result)
+	_, result = readKeyed(api, "7")
+	assert.Equal("id:seven", result)
+
+	status, _ := readKeyed(api, "missing")
+	assert.Equal(http.StatusNotFound, status)
+}
+
+// Ensures that registration panics if the handler doesn't implement KeyReader or no
+// keys are given.
+func TestLookupKeysRegistrationPanics(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+
+	assert.Panics(func() {
+		api.RegisterResourceHandler(versionTestHandler{}, LookupKeys{Keys: []string{"slug"}})
+	})
+	assert.Panics(func() {
+		api.RegisterResourceHandler(newKeyedTestHandler(), LookupKeys{Keys: []string{IDKey}})
+	})
+}
+
+// Ensures that documentation lists the URIs for each alternate key.
+func TestLookupKeyDocs(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(newKeyedTestHandler(),
+		LookupKeys{Keys: []string{"slug", "sku"}, Style: KeyPrefix})
+
+	assert.Equal([]map[string]string{
+		{"key": "slug", "uri": "/api/v1/foo/slug::resource_id"},
+		{"key": "sku", "uri": "/api/v1/foo/sku::resource_id"},
+	}, lookupKeyDocs(api.ResourceHandlers()[0], "1"))
+	assert.Nil(lookupKeyDocs(versionTestHandler{}, "1"))
+}
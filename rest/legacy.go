@@ -0,0 +1,130 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// LegacyRequestsCounter counts requests served by handlers mounted using
+// API#MountLegacyHandler, keyed by method and path.
+const LegacyRequestsCounter = "legacy_requests"
+
+// legacyPlaceholder replaces path variables when checking legacy routes for conflicts.
+const legacyPlaceholder = "legacy"
+
+// pathVars matches the variables in a route path, e.g. {id} or {id:[0-9]+}.
+var pathVars = regexp.MustCompile(`{[^}]*}`)
+
+// MountLegacyHandler mounts a plain http.Handler, e.g. from a gorilla/mux service being
+// migrated, at the method and path on the API's router. The path uses gorilla/mux
+// syntax and its variables are available to the handler through mux.Vars as before.
+// Requests pass through the API's shutdown and maintenance checks, propagated header
+// handling, and the provided middleware, and are counted in the Metrics and logged in
+// debug mode, but the handler writes its own response without the envelope. If
+// X-Request-Id is a propagated header, the request's generated ID is also set on the
+// request. Returns an error if the route conflicts with one generated for a resource.
+func (r *muxAPI) MountLegacyHandler(method, path string, h http.Handler,
+	middleware ...RequestMiddleware) error {
+
+	method = strings.ToUpper(method)
+	if name, ok := r.resourceRouteConflict(method, path); ok {
+		return fmt.Errorf("Legacy route %s %s conflicts with %s", method, path, name)
+	}
+
+	name := method + " " + path
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(RequestIDHeader) == "" {
+			if id := outgoingHeaders(req).Get(RequestIDHeader); id != "" {
+				req.Header.Set(RequestIDHeader, id)
+			}
+		}
+
+		start := time.Now()
+		recorder := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(recorder, req)
+		r.metrics.incr(LegacyRequestsCounter, name)
+		r.debugf("", "Legacy %s %s (%d) %s", req.Method, req.URL.Path, recorder.status,
+			time.Since(start))
+	}
+
+	middleware = append(middleware, newPropagationMiddleware(r.config.PropagatedHeaders))
+	route := r.router.HandleFunc(path, applyMiddleware(handler, middleware)).Methods(method).
+		Name("legacy:" + name)
+	r.addRoute(RouteInfo{Name: "legacy:" + name, Kind: LegacyRoute, Method: method, Path: path,
+		CallSite: callSite(1)}, route)
+	r.debugf("", "Mounted legacy handler at %s %s", method, path)
+	return nil
+}
+
+// resourceRouteConflict returns the name of the resource route which serves the same
+// requests as the method and path, if any.
+func (r *muxAPI) resourceRouteConflict(method, path string) (string, bool) {
+	shape := pathVars.ReplaceAllString(path, "{}")
+	conflict := ""
+	r.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		name := route.GetName()
+		template, err := route.GetPathTemplate()
+		if conflict != "" || err != nil || strings.HasPrefix(name, "legacy:") ||
+			!strings.Contains(name, ":") {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+		if !containsFold(methods, method) {
+			return nil
+		}
+		if pathVars.ReplaceAllString(template, "{}") == shape {
+			conflict = name
+		}
+		return nil
+	})
+	if conflict != "" {
+		return conflict, true
+	}
+
+	// Also catch concrete paths which a resource route's variables would match.
+	req, err := http.NewRequest(method, pathVars.ReplaceAllString(path, legacyPlaceholder), nil)
+	if err != nil {
+		return "", false
+	}
+	var match mux.RouteMatch
+	if r.router.Match(req, &match) && match.MatchErr == nil && match.Route != nil {
+		name := match.Route.GetName()
+		if strings.Contains(name, ":") && !strings.HasPrefix(name, "legacy:") {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// statusWriter is an http.ResponseWriter which records the status written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status and writes it.
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
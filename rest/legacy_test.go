@@ -0,0 +1,82 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// legacyHandler is a plain http.Handler which writes the path variables and request ID
+// it receives without the response envelope.
+var legacyHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "%s %s", mux.Vars(r)["id"], r.Header.Get(RequestIDHeader))
+})
+
+// Ensures that MountLegacyHandler serves the handler's own response with its path
+// variables, assigns request IDs, applies middleware, and counts requests.
+func TestMountLegacyHandler(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{PropagatedHeaders: []string{RequestIDHeader}})
+	called := false
+	middleware := func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			wrapped(w, r)
+		}
+	}
+	err := api.MountLegacyHandler("get", "/legacy/{id}", legacyHandler, middleware)
+	assert.Nil(err)
+
+	req, _ := http.NewRequest("GET", "http://foo.com/legacy/42", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusAccepted, resp.Code)
+	assert.Equal("42 abc", resp.Body.String())
+	assert.True(called)
+	assert.Equal(uint64(1), api.Metrics().Counter(LegacyRequestsCounter, "GET /legacy/{id}"))
+
+	req, _ = http.NewRequest("GET", "http://foo.com/legacy/43", nil)
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Len(resp.Body.String(), len("43 ")+36)
+
+	req, _ = http.NewRequest("POST", "http://foo.com/legacy/43", nil)
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusMethodNotAllowed, resp.Code)
+}
+
+// Ensures that MountLegacyHandler returns an error for routes which conflict with a
+// resource's routes and allows those which don't.
+func TestMountLegacyHandlerConflicts(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(namedHandler{name: "widgets"})
+
+	assert.NotNil(api.MountLegacyHandler("GET", "/api/v{version}/widgets/{widget}", legacyHandler))
+	assert.NotNil(api.MountLegacyHandler("DELETE", "/api/v1/widgets/special", legacyHandler))
+	assert.Nil(api.MountLegacyHandler("GET", "/legacy/widgets/{id}", legacyHandler))
+	assert.Nil(api.MountLegacyHandler("PATCH", "/api/v1/widgets/{id}", legacyHandler))
+}
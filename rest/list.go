@@ -0,0 +1,408 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Codes of FieldErrors produced by ListQuery.
+const (
+	// FilterUnknownCode is the code of a filter on a field which can't be filtered.
+	FilterUnknownCode = "unknown_filter"
+
+	// FilterOperatorCode is the code of a filter using an operator the field doesn't
+	// support. Its "operators" param lists the supported operators.
+	FilterOperatorCode = "invalid_operator"
+
+	// SortUnknownCode is the code of a sort on a field which can't be sorted by.
+	SortUnknownCode = "unknown_sort"
+
+	// defaultMaxListLimit is the maximum page size if ListQuery doesn't specify one.
+	defaultMaxListLimit = 100
+)
+
+// Pagination consistency levels reported in CapabilitiesDocuments.
+const (
+	// PaginationSnapshot means every page of a list reflects the snapshot captured
+	// for its first page, so rows don't shift between pages.
+	PaginationSnapshot = "snapshot"
+
+	// PaginationLive means each page reflects the data when it's read, so concurrent
+	// writes may be missed or seen on later pages.
+	PaginationLive = "live"
+)
+
+// listCursorKey signs cursors for ListQuerys which don't specify a Key.
+var listCursorKey = []byte(newReplayToken())
+
+// FilterField describes a field a list can be filtered by.
+type FilterField struct {
+	// Name is the field name used in filter query string variables, e.g. "age" for
+	// filter[age][gt]=30.
+	Name string
+
+	// Type is the Type filter values are coerced to. Defaults to Unspecified, in which
+	// case values are strings.
+	Type Type
+
+	// Operators are the filter operators allowed on the field. Defaults to
+	// FilterEqual.
+	Operators []string
+}
+
+// ListFilter is a validated filter with its value coerced to the field's Type.
+type ListFilter struct {
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+// ListRequest is a validated, normalized request for a page of a list.
+type ListRequest struct {
+	// Filters are the conditions results must satisfy, ordered by field and operator.
+	Filters []ListFilter
+
+	// Sort is the order of results. It's the requested order or the DefaultSort,
+	// followed by any CursorFields not already in it so the order is total.
+	Sort []SortField
+
+	// Limit is the page size, clamped to the ListQuery's MaxLimit.
+	Limit int
+
+	// After holds the values of the last row of the previous page for each of the
+	// Sort fields, in order, or is nil for the first page. Results should start with
+	// the first row ordered after those values, e.g. WHERE (score, id) < (?, ?) for a
+	// descending sort, so pages don't depend on that row still existing.
+	After []string
+
+	// SnapshotToken is the token the ListQuery's Snapshot captured for the first page,
+	// e.g. a transaction timestamp, or empty if it doesn't have one. Every page of the
+	// list carries the same token, so results should reflect the data as of it.
+	SnapshotToken string
+
+	// captured is when the snapshot was captured for the first page.
+	captured time.Time
+}
+
+// ListQuery implements the standard behavior of a ReadResourceList endpoint from a
+// description of how the list may be queried. It validates and normalizes the
+// request's filters, sort, limit, and cursor into a ListRequest for Fetch and
+// returns the page with a signed cursor to the next one. ReadResourceList can be
+// implemented by calling Read:
+//
+//	func (f FooHandler) ReadResourceList(ctx RequestContext, limit int, cursor string,
+//		version string) ([]Resource, string, error) {
+//		return fooQuery.Read(ctx, limit, cursor)
+//	}
+//
+// Invalid filters and sorts receive a 400 with FieldErrors, altered cursors receive a
+// 400, and cursors issued for different filters or sort receive a StaleCursor error.
+type ListQuery struct {
+	// Filters are the fields which can be filtered by.
+	Filters []FilterField
+
+	// Sorts are the fields which can be sorted by.
+	Sorts []string
+
+	// DefaultSort is the order of results when the request doesn't specify one.
+	DefaultSort []SortField
+
+	// CursorFields are the fields which identify a row's position, e.g. a unique ID.
+	// They're always sorted by last so the order is total.
+	CursorFields []string
+
+	// MaxLimit is the largest page size. Defaults to 100.
+	MaxLimit int
+
+	// Key signs cursors so clients can't alter them. Defaults to a key generated when
+	// the process starts, in which case cursors are only valid on the instance which
+	// issued them, so API instances behind a load balancer must share a Key.
+	Key []byte
+
+	// Fetch returns up to the ListRequest's Limit rows. If it returns Limit rows, a
+	// cursor to the next page is returned.
+	Fetch func(RequestContext, ListRequest) ([]Resource, error)
+
+	// Position returns the value of the row's field, which is one of the ListRequest's
+	// Sort fields. It's called for each of them to build the cursor to the next page.
+	Position func(Resource, string) string
+
+	// Snapshot returns a token identifying the current state of the data, such as a
+	// transaction timestamp or sequence number, when the first page is read. It's
+	// carried in cursors and passed to Fetch for every page in the ListRequest's
+	// SnapshotToken, so pages can be served from the same snapshot while rows are
+	// written concurrently. How the token is honored is up to Fetch. Pages aren't
+	// snapshot-consistent if it's nil.
+	Snapshot func(RequestContext) (string, error)
+
+	// SnapshotTTL is how long after the first page a snapshot may be read from.
+	// Cursors carrying older snapshots receive a StaleCursor error. Defaults to no
+	// expiry.
+	SnapshotTTL time.Duration
+
+	now func() time.Time
+}
+
+// listCursor is the position encoded in a ListQuery cursor.
+type listCursor struct {
+	Query    string   `json:"q"`
+	After    []string `json:"a"`
+	Snapshot string   `json:"s,omitempty"`
+	Captured int64    `json:"t,omitempty"`
+}
+
+// Read returns the page of rows for the request, limit, and cursor passed to
+// ReadResourceList, and the cursor to the next page, if any.
+func (q *ListQuery) Read(ctx RequestContext, limit int, cursor string) ([]Resource, string,
+	error) {
+
+	request, err := q.Request(ctx, limit, cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	rows, err := q.Fetch(ctx, request)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(rows) < request.Limit || len(rows) == 0 || q.Position == nil {
+		return rows, "", nil
+	}
+	if len(rows) > request.Limit {
+		rows = rows[:request.Limit]
+	}
+	last := rows[len(rows)-1]
+	after := make([]string, len(request.Sort))
+	for i, field := range request.Sort {
+		after[i] = q.Position(last, field.Field)
+	}
+	return rows, q.encodeCursor(request, after), nil
+}
+
+// Request returns the validated, normalized ListRequest for the request, limit, and
+// cursor passed to ReadResourceList.
+func (q *ListQuery) Request(ctx RequestContext, limit int, cursor string) (ListRequest, error) {
+	filters, errs := q.filters(ctx.Filters())
+	sort, sortErrs := q.sort(ctx.Sort())
+	errs = append(errs, sortErrs...)
+	if len(errs) > 0 {
+		errs.sort()
+		return ListRequest{}, BadRequest("Invalid list query").WithFieldErrors(errs)
+	}
+
+	request := ListRequest{Filters: filters, Sort: sort, Limit: q.clamp(limit)}
+	if cursor == "" {
+		if q.Snapshot != nil {
+			token, err := q.Snapshot(ctx)
+			if err != nil {
+				return ListRequest{}, err
+			}
+			request.SnapshotToken = token
+			request.captured = q.clock()
+		}
+		return request, nil
+	}
+	position, err := VerifyCursor(q.key(), cursor)
+	if err != nil {
+		return ListRequest{}, BadRequest(err.Error())
+	}
+	var decoded listCursor
+	if err := json.Unmarshal([]byte(position), &decoded); err != nil {
+		return ListRequest{}, BadRequest(fmt.Sprintf("Malformed cursor: %s", cursor))
+	}
+	if decoded.Query != request.fingerprint() {
+		return ListRequest{}, StaleCursor("Cursor is for a different query, restart pagination")
+	}
+	if len(decoded.After) != len(request.Sort) {
+		return ListRequest{}, BadRequest(fmt.Sprintf("Malformed cursor: %s", cursor))
+	}
+	if q.Snapshot != nil && decoded.Captured > 0 {
+		request.captured = time.Unix(0, decoded.Captured)
+		if q.SnapshotTTL > 0 && q.clock().Sub(request.captured) > q.SnapshotTTL {
+			return ListRequest{}, StaleCursor("Snapshot has expired, restart pagination")
+		}
+	}
+	request.After = decoded.After
+	request.SnapshotToken = decoded.Snapshot
+	return request, nil
+}
+
+// clock returns the current time.
+func (q *ListQuery) clock() time.Time {
+	if q.now != nil {
+		return q.now()
+	}
+	return time.Now()
+}
+
+// consistency returns the pagination consistency level of the ListQuery.
+func (q *ListQuery) consistency() string {
+	if q.Snapshot != nil {
+		return PaginationSnapshot
+	}
+	return PaginationLive
+}
+
+// ValidFilters returns the request's filters validated and coerced, e.g. for counting
+// with ResourceCounter. Returns a 400 with FieldErrors if any are invalid.
+func (q *ListQuery) ValidFilters(ctx RequestContext) ([]ListFilter, error) {
+	filters, errs := q.filters(ctx.Filters())
+	if len(errs) > 0 {
+		errs.sort()
+		return nil, BadRequest("Invalid list query").WithFieldErrors(errs)
+	}
+	return filters, nil
+}
+
+// filters returns the QueryFilters validated against the FilterFields and coerced.
+func (q *ListQuery) filters(requested []QueryFilter) ([]ListFilter, FieldErrors) {
+	filters := []ListFilter{}
+	errs := FieldErrors{}
+filterLoop:
+	for _, filter := range requested {
+		path := fmt.Sprintf("filter[%s]", filter.Field)
+		for _, field := range q.Filters {
+			if field.Name != filter.Field {
+				continue
+			}
+			operators := field.Operators
+			if len(operators) == 0 {
+				operators = []string{FilterEqual}
+			}
+			if !containsFold(operators, filter.Operator) {
+				errs = append(errs, FieldError{
+					Field:   path,
+					Code:    FilterOperatorCode,
+					Params:  map[string]interface{}{"operators": operators},
+					Message: fmt.Sprintf("Field '%s' can't be filtered with '%s'", filter.Field, filter.Operator),
+				})
+				continue filterLoop
+			}
+			var value interface{} = filter.Value
+			if field.Type != Unspecified && field.Type != String {
+				coerced, err := coerceFromString(filter.Value, field.Type)
+				if err != nil {
+					errs = append(errs, FieldError{
+						Field:   path,
+						Code:    FieldTypeCode,
+						Params:  map[string]interface{}{"type": typeToName[field.Type]},
+						Message: err.Error(),
+					})
+					continue filterLoop
+				}
+				value = coerced
+			}
+			filters = append(filters, ListFilter{filter.Field, filter.Operator, value})
+			continue filterLoop
+		}
+		errs = append(errs, FieldError{
+			Field:   path,
+			Code:    FilterUnknownCode,
+			Message: fmt.Sprintf("Field '%s' can't be filtered", filter.Field),
+		})
+	}
+	return filters, errs
+}
+
+// sort returns the requested SortFields validated against the Sorts, or the
+// DefaultSort, followed by the CursorFields not already sorted by. Fields are only
+// sorted by once, whatever their case.
+func (q *ListQuery) sort(requested []SortField) ([]SortField, FieldErrors) {
+	errs := FieldErrors{}
+	sort := []SortField{}
+	seen := map[string]bool{}
+	for _, field := range requested {
+		if !containsFold(q.Sorts, field.Field) {
+			errs = append(errs, FieldError{
+				Field:   "sort",
+				Code:    SortUnknownCode,
+				Params:  map[string]interface{}{"field": field.Field},
+				Message: fmt.Sprintf("Results can't be sorted by '%s'", field.Field),
+			})
+			continue
+		}
+		if key := strings.ToLower(field.Field); !seen[key] {
+			seen[key] = true
+			sort = append(sort, field)
+		}
+	}
+	if len(requested) == 0 {
+		for _, field := range q.DefaultSort {
+			seen[strings.ToLower(field.Field)] = true
+			sort = append(sort, field)
+		}
+	}
+	for _, field := range q.CursorFields {
+		if !seen[strings.ToLower(field)] {
+			sort = append(sort, SortField{Field: field})
+		}
+	}
+	return sort, errs
+}
+
+// maxLimit returns the largest page size.
+func (q *ListQuery) maxLimit() int {
+	if q.MaxLimit <= 0 {
+		return defaultMaxListLimit
+	}
+	return q.MaxLimit
+}
+
+// clamp returns the limit clamped between 1 and the MaxLimit.
+func (q *ListQuery) clamp(limit int) int {
+	max := q.maxLimit()
+	if limit < 1 {
+		return 1
+	}
+	if limit > max {
+		return max
+	}
+	return limit
+}
+
+// key returns the key used to sign cursors.
+func (q *ListQuery) key() []byte {
+	if len(q.Key) > 0 {
+		return q.Key
+	}
+	return listCursorKey
+}
+
+// encodeCursor returns the signed cursor to the page after the position, carrying the
+// request's snapshot if it has one.
+func (q *ListQuery) encodeCursor(request ListRequest, after []string) string {
+	cursor := listCursor{Query: request.fingerprint(), After: after}
+	if !request.captured.IsZero() {
+		cursor.Snapshot = request.SnapshotToken
+		cursor.Captured = request.captured.UnixNano()
+	}
+	position, _ := json.Marshal(cursor)
+	return SignCursor(q.key(), string(position))
+}
+
+// fingerprint returns a digest of the ListRequest's filters and sort, which a cursor
+// is only valid for.
+func (l ListRequest) fingerprint() string {
+	encoded, _ := json.Marshal([]interface{}{l.Filters, l.Sort})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
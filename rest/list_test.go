@@ -0,0 +1,497 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// listRow is a row of the test list.
+type listRow struct {
+	ID    int    `json:"id"`
+	Group string `json:"group"`
+	Score int    `json:"score"`
+}
+
+// listRows returns rows with duplicate groups and scores so sorts need tie-breakers.
+func listRows() []*listRow {
+	rows := make([]*listRow, 40)
+	for i := range rows {
+		rows[i] = &listRow{ID: i, Group: string(rune('a' + i%4)), Score: (i * 7) % 10}
+	}
+	return rows
+}
+
+// newListQuery returns a ListQuery fetching from the rows by applying the ListRequest.
+func newListQuery(rows []*listRow) *ListQuery {
+	return &ListQuery{
+		Filters: []FilterField{
+			{Name: "group"},
+			{Name: "score", Type: Int, Operators: []string{FilterEqual, FilterGreaterThan,
+				FilterLessThan}},
+		},
+		Sorts:        []string{"group", "score", "id"},
+		DefaultSort:  []SortField{{Field: "score", Descending: true}},
+		CursorFields: []string{"id"},
+		MaxLimit:     10,
+		Key:          []byte("secret"),
+		Fetch: func(ctx RequestContext, req ListRequest) ([]Resource, error) {
+			return fetchListRows(rows, req), nil
+		},
+		Position: func(r Resource, field string) string {
+			return listRowField(r.(*listRow), field)
+		},
+	}
+}
+
+// fetchListRows returns the page of rows for the ListRequest.
+func fetchListRows(rows []*listRow, req ListRequest) []Resource {
+	matched := []*listRow{}
+	for _, row := range rows {
+		matches := true
+		for _, filter := range req.Filters {
+			switch {
+			case filter.Field == "group":
+				matches = matches && row.Group == filter.Value
+			case filter.Operator == FilterGreaterThan:
+				matches = matches && row.Score > filter.Value.(int)
+			case filter.Operator == FilterLessThan:
+				matches = matches && row.Score < filter.Value.(int)
+			default:
+				matches = matches && row.Score == filter.Value.(int)
+			}
+		}
+		if matches {
+			matched = append(matched, row)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return listRowAfter(matched[j], listRowPosition(matched[i], req.Sort), req.Sort)
+	})
+
+	page := []Resource{}
+	for _, row := range matched {
+		if len(page) == req.Limit {
+			break
+		}
+		if req.After == nil || listRowAfter(row, req.After, req.Sort) {
+			page = append(page, row)
+		}
+	}
+	return page
+}
+
+// listRowPosition returns the row's values of the sort fields.
+func listRowPosition(row *listRow, sort []SortField) []string {
+	position := make([]string, len(sort))
+	for i, field := range sort {
+		position[i] = listRowField(row, field.Field)
+	}
+	return position
+}
+
+// listRowAfter indicates if the row is ordered after the position in the sort.
+func listRowAfter(row *listRow, position []string, sort []SortField) bool {
+	for i, field := range sort {
+		if value := listRowField(row, field.Field); value != position[i] {
+			return (value > position[i]) != field.Descending
+		}
+	}
+	return false
+}
+
+// listRowField returns the row's field as a sortable string.
+func listRowField(row *listRow, field string) string {
+	switch field {
+	case "group":
+		return row.Group
+	case "score":
+		return strconv.Itoa(row.Score)
+	}
+	return fmt.Sprintf("%03d", row.ID)
+}
+
+// listContext returns a RequestContext for a list request with the query string.
+func listContext(query url.Values) RequestContext {
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/rows?"+query.Encode(), nil)
+	return NewContext(nil, req)
+}
+
+// randomListQuery returns a random valid query string for newListQuery.
+func randomListQuery(rnd *rand.Rand) url.Values {
+	query := url.Values{}
+	if rnd.Intn(2) == 0 {
+		query.Set("filter[group]", string(rune('a'+rnd.Intn(5))))
+	}
+	if rnd.Intn(2) == 0 {
+		operator := []string{FilterEqual, FilterGreaterThan, FilterLessThan}[rnd.Intn(3)]
+		query.Set(fmt.Sprintf("filter[score][%s]", operator), strconv.Itoa(rnd.Intn(12)-1))
+	}
+	sorts := []string{}
+	for _, field := range []string{"group", "score", "id"} {
+		switch rnd.Intn(3) {
+		case 1:
+			sorts = append(sorts, field)
+		case 2:
+			sorts = append(sorts, "-"+field)
+		}
+	}
+	if len(sorts) > 0 {
+		rnd.Shuffle(len(sorts), func(i, j int) { sorts[i], sorts[j] = sorts[j], sorts[i] })
+		query.Set("sort", joinSorts(sorts))
+	}
+	return query
+}
+
+// joinSorts returns the sort fields as a sort query string value.
+func joinSorts(sorts []string) string {
+	joined := sorts[0]
+	for _, field := range sorts[1:] {
+		joined += "," + field
+	}
+	return joined
+}
+
+// Ensures that random valid queries never panic and that following cursors visits
+// every matching row exactly once in the order of an unpaginated read.
+func TestListQueryCursorsRoundTrip(t *testing.T) {
+	rows := listRows()
+	q := newListQuery(rows)
+
+	property := func(seed int64, limit int8) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		ctx := listContext(randomListQuery(rnd))
+		all, err := q.Request(ctx, len(rows), "")
+		if err != nil {
+			return false
+		}
+		all.Limit = len(rows)
+		expected := fetchListRows(rows, all)
+
+		visited := []Resource{}
+		cursor := ""
+		for pages := 0; pages <= len(rows); pages++ {
+			page, next, err := q.Read(ctx, int(limit), cursor)
+			if err != nil || len(page) > q.clamp(int(limit)) {
+				return false
+			}
+			visited = append(visited, page...)
+			if next == "" {
+				return assert.ObjectsAreEqual(expected, visited)
+			}
+			cursor = next
+		}
+		return false
+	}
+	assert.Nil(t, quick.Check(property, &quick.Config{MaxCount: 300}))
+}
+
+// Ensures that the ListRequest is normalized: the default sort applies, cursor fields
+// break ties, filter values are coerced, and the limit is clamped.
+func TestListQueryRequest(t *testing.T) {
+	assert := assert.New(t)
+	q := newListQuery(listRows())
+
+	req, err := q.Request(listContext(url.Values{"filter[score][gt]": {"3"}}), 500, "")
+	assert.Nil(err)
+	assert.Equal([]ListFilter{{"score", FilterGreaterThan, 3}}, req.Filters)
+	assert.Equal([]SortField{{"score", true}, {"id", false}}, req.Sort)
+	assert.Equal(10, req.Limit)
+	assert.Nil(req.After)
+
+	req, err = q.Request(listContext(url.Values{"sort": {"-id,group,-ID"}}), 0, "")
+	assert.Nil(err)
+	assert.Equal([]SortField{{"id", true}, {"group", false}}, req.Sort)
+	assert.Equal(1, req.Limit)
+}
+
+// Ensures that invalid filters and sorts are rejected with sorted FieldErrors.
+func TestListQueryInvalid(t *testing.T) {
+	assert := assert.New(t)
+	q := newListQuery(listRows())
+
+	_, err := q.Request(listContext(url.Values{
+		"filter[score][gte]": {"1"},
+		"filter[score][lt]":  {"high"},
+		"filter[name]":       {"bob"},
+		"sort":               {"name"},
+	}), 5, "")
+
+	if assert.IsType(Error{}, err) {
+		assert.Equal(http.StatusBadRequest, err.(Error).Status())
+		codes := []string{}
+		for _, fieldErr := range err.(Error).FieldErrors() {
+			codes = append(codes, fieldErr.Field+" "+fieldErr.Code)
+		}
+		assert.Equal([]string{
+			"filter[name] unknown_filter",
+			"filter[score] invalid_operator",
+			"filter[score] invalid_type",
+			"sort unknown_sort",
+		}, codes)
+	}
+}
+
+// Ensures that cursors carry the sort fields' values of the last row, so pagination
+// continues in place when that row is deleted.
+func TestListQueryCursorAfterDelete(t *testing.T) {
+	assert := assert.New(t)
+	rows := listRows()
+	q := newListQuery(rows)
+	ctx := listContext(url.Values{"sort": {"group,-score"}})
+
+	all, _, err := q.Read(ctx, 10, "")
+	assert.Nil(err)
+	first, cursor, err := q.Read(ctx, 5, "")
+	assert.Nil(err)
+	next, err := q.Request(ctx, 5, cursor)
+	assert.Nil(err)
+	last := first[4].(*listRow)
+	assert.Equal([]string{last.Group, strconv.Itoa(last.Score), fmt.Sprintf("%03d", last.ID)},
+		next.After)
+
+	for i, row := range rows {
+		if row == last {
+			q.Fetch = func(ctx RequestContext, req ListRequest) ([]Resource, error) {
+				remaining := append(append([]*listRow{}, rows[:i]...), rows[i+1:]...)
+				return fetchListRows(remaining, req), nil
+			}
+		}
+	}
+	second, _, err := q.Read(ctx, 5, cursor)
+	assert.Nil(err)
+	assert.Equal(all[5:], second)
+}
+
+// Ensures that altered cursors are rejected and cursors for a different query are
+// stale.
+func TestListQueryCursorRejected(t *testing.T) {
+	assert := assert.New(t)
+	q := newListQuery(listRows())
+	ctx := listContext(url.Values{"sort": {"group"}})
+
+	_, cursor, err := q.Read(ctx, 2, "")
+	assert.Nil(err)
+	assert.NotEqual("", cursor)
+
+	forged, _ := json.Marshal(listCursor{Query: "other", After: []string{"3"}})
+	_, _, err = q.Read(ctx, 2, SignCursor([]byte("guess"), string(forged)))
+	if assert.IsType(Error{}, err) {
+		assert.Equal(http.StatusBadRequest, err.(Error).Status())
+	}
+
+	_, _, err = q.Read(listContext(url.Values{"sort": {"-group"}}), 2, cursor)
+	if assert.IsType(Error{}, err) {
+		assert.Equal(StaleCursorCode, err.(Error).Code())
+	}
+}
+
+// Ensures that FooHandler's list endpoint, built on ListQuery, rejects invalid
+// queries and filters results.
+func TestFooHandlerListQuery(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(FooHandler{})
+
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/foo?filter[id]=1", nil)
+	req.Header.Set("Authorization", "secret")
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.Contains(resp.Body.String(), FilterUnknownCode)
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/foo?filter[foobar]=world", nil)
+	rows, _, err := fooQuery.Read(NewContext(nil, req), 10, "")
+	assert.Nil(err)
+	assert.Equal([]Resource{foos[1]}, rows)
+
+	req, _ = http.NewRequest("GET", "http://example.com/api/v1/foo?sort=-foobar", nil)
+	rows, cursor, err := fooQuery.Read(NewContext(nil, req), 1, "")
+	assert.Nil(err)
+	assert.Equal([]Resource{foos[1]}, rows)
+	rows, _, err = fooQuery.Read(NewContext(nil, req), 1, cursor)
+	assert.Nil(err)
+	assert.Equal([]Resource{foos[0]}, rows)
+}
+
+// busyList is a list whose rows are written concurrently with pagination. Each row is
+// stamped with the sequence number of the write which inserted it.
+type busyList struct {
+	seq  int
+	rows []*listRow
+	seqs map[int]int
+}
+
+// insert adds rows with the IDs in a new write.
+func (b *busyList) insert(ids ...int) {
+	b.seq++
+	for _, id := range ids {
+		b.rows = append(b.rows, &listRow{ID: id})
+		b.seqs[id] = b.seq
+	}
+}
+
+// query returns a ListQuery paging through the rows by ID, honoring snapshot tokens
+// by omitting rows written after them.
+func (b *busyList) query(snapshot bool) *ListQuery {
+	query := &ListQuery{
+		Sorts:        []string{"id"},
+		CursorFields: []string{"id"},
+		Key:          []byte("secret"),
+		Fetch: func(ctx RequestContext, req ListRequest) ([]Resource, error) {
+			visible := b.rows
+			if req.SnapshotToken != "" {
+				seq, err := strconv.Atoi(req.SnapshotToken)
+				if err != nil {
+					return nil, err
+				}
+				visible = []*listRow{}
+				for _, row := range b.rows {
+					if b.seqs[row.ID] <= seq {
+						visible = append(visible, row)
+					}
+				}
+			}
+			return fetchListRows(visible, req), nil
+		},
+		Position: func(r Resource, field string) string {
+			return listRowField(r.(*listRow), field)
+		},
+	}
+	if snapshot {
+		query.Snapshot = func(RequestContext) (string, error) {
+			return strconv.Itoa(b.seq), nil
+		}
+	}
+	return query
+}
+
+// readAllPages reads every page of the ListQuery, inserting rows with the IDs after
+// the first page, and returns the IDs read.
+func readAllPages(t *testing.T, list *busyList, query *ListQuery, inserted ...int) []int {
+	ids := []int{}
+	cursor := ""
+	for page := 0; page == 0 || cursor != ""; page++ {
+		rows, next, err := query.Read(listContext(url.Values{}), 3, cursor)
+		if !assert.Nil(t, err) {
+			return ids
+		}
+		for _, row := range rows {
+			ids = append(ids, row.(*listRow).ID)
+		}
+		if page == 0 {
+			list.insert(inserted...)
+		}
+		cursor = next
+	}
+	return ids
+}
+
+// Ensures that with a Snapshot, every page reflects the snapshot captured for the
+// first page while rows are inserted between pages, and that without one they don't.
+func TestListQuerySnapshot(t *testing.T) {
+	assert := assert.New(t)
+	for _, snapshot := range []bool{true, false} {
+		list := &busyList{seqs: map[int]int{}}
+		list.insert(0, 2, 4, 6, 8, 10, 12)
+		ids := readAllPages(t, list, list.query(snapshot), 1, 7, 9, 13)
+		if snapshot {
+			assert.Equal([]int{0, 2, 4, 6, 8, 10, 12}, ids)
+		} else {
+			// The rows inserted after the cursor shift into later pages.
+			assert.Equal([]int{0, 2, 4, 6, 7, 8, 9, 10, 12, 13}, ids)
+		}
+	}
+}
+
+// Ensures that the snapshot token is passed to Fetch for every page and carried in
+// cursors clients can't alter.
+func TestListQuerySnapshotToken(t *testing.T) {
+	assert := assert.New(t)
+	list := &busyList{seqs: map[int]int{}}
+	list.insert(0, 1, 2, 3, 4)
+	query := list.query(true)
+	ctx := listContext(url.Values{})
+
+	first, err := query.Request(ctx, 2, "")
+	assert.Nil(err)
+	assert.Equal("1", first.SnapshotToken)
+
+	list.insert(5)
+	_, cursor, err := query.Read(ctx, 2, "")
+	assert.Nil(err)
+	next, err := query.Request(ctx, 2, cursor)
+	assert.Nil(err)
+	assert.Equal("2", next.SnapshotToken)
+	assert.Equal([]string{"001"}, next.After)
+
+	_, err = query.Request(ctx, 2, SignCursor([]byte("other"), `{"q":"","a":["1"],"s":"9"}`))
+	assert.NotNil(err)
+}
+
+// Ensures that cursors carrying snapshots older than the SnapshotTTL are stale.
+func TestListQuerySnapshotExpired(t *testing.T) {
+	assert := assert.New(t)
+	list := &busyList{seqs: map[int]int{}}
+	list.insert(0, 1, 2, 3, 4)
+	now := time.Unix(1000, 0)
+	query := list.query(true)
+	query.SnapshotTTL = time.Minute
+	query.now = func() time.Time { return now }
+	ctx := listContext(url.Values{})
+
+	_, cursor, err := query.Read(ctx, 2, "")
+	assert.Nil(err)
+
+	now = now.Add(time.Minute)
+	_, cursor, err = query.Read(ctx, 2, cursor)
+	assert.Nil(err)
+
+	now = now.Add(time.Second)
+	_, _, err = query.Read(ctx, 2, cursor)
+	if assert.NotNil(err) {
+		assert.Equal(StaleCursorCode, err.(Error).Code())
+	}
+}
+
+// Ensures that the capabilities of a list with snapshots report its consistency and
+// the SnapshotTTL.
+func TestCapabilitiesPaginationSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	list := &busyList{seqs: map[int]int{}}
+	query := list.query(true)
+	query.SnapshotTTL = 5 * time.Minute
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(capabilityHandler{}, Capabilities{Query: query})
+
+	_, document := readCapabilities(api, "GET", "http://example.com/api/v1/gadgets/_capabilities")
+
+	assert.Equal(PaginationSnapshot, document.PaginationConsistency)
+	assert.Equal(int64(300), document.SnapshotTTLSeconds)
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// MaintenanceCode is the error code included in responses to requests rejected
+	// because the API is in maintenance mode.
+	MaintenanceCode = "maintenance"
+
+	// MaintenanceCounter counts requests rejected because the API is in maintenance
+	// mode. It's keyed by route name.
+	MaintenanceCounter = "maintenance"
+
+	// defaultMaintenanceMessage is the message returned during maintenance if one
+	// isn't provided.
+	defaultMaintenanceMessage = "The API is undergoing maintenance"
+)
+
+// MaintenanceWindow is a scheduled period during which the API is in maintenance
+// mode. Requests received between Start and End are rejected with a 503 unless their
+// route is allowed.
+type MaintenanceWindow struct {
+	// Start is when maintenance begins. A zero value means maintenance begins
+	// immediately.
+	Start time.Time
+
+	// End is when maintenance ends. It's used to populate the Retry-After header. A
+	// zero value means maintenance doesn't end until API#ExitMaintenance is called.
+	End time.Time
+
+	// Message is returned in rejected responses.
+	Message string
+
+	// Allow contains the names of routes which remain available during maintenance,
+	// e.g. "foo:read" or "foo:readList".
+	Allow []string
+}
+
+// MaintenanceState describes whether the API is in maintenance mode.
+type MaintenanceState struct {
+	// Active is true if the API is in maintenance mode.
+	Active bool
+
+	// Message is returned in rejected responses.
+	Message string
+
+	// Allow contains the names of routes which remain available.
+	Allow []string
+
+	// End is when maintenance is expected to end. It's zero if unknown.
+	End time.Time
+}
+
+// allows returns true if the named route remains available.
+func (s MaintenanceState) allows(route string) bool {
+	for _, allowed := range s.Allow {
+		if allowed == route {
+			return true
+		}
+	}
+	return false
+}
+
+// stats returns the MaintenanceState as it should be reported by the stats endpoint.
+func (s MaintenanceState) stats() map[string]interface{} {
+	stats := map[string]interface{}{"active": s.Active}
+	if !s.Active {
+		return stats
+	}
+	stats["message"] = s.Message
+	stats["allow"] = s.Allow
+	if !s.End.IsZero() {
+		stats["end"] = s.End.UTC().Format(time.RFC3339)
+	}
+	return stats
+}
+
+// maintenanceMode tracks the maintenance state of an API. Maintenance can be entered
+// and exited manually or scheduled with a MaintenanceWindow. It's safe for concurrent
+// use, so maintenance can be toggled while requests are in flight. Requests which
+// have already passed the maintenance check are allowed to complete.
+type maintenanceMode struct {
+	mu     sync.RWMutex
+	hookMu sync.Mutex
+	manual *MaintenanceState
+	window *MaintenanceWindow
+	active bool
+	hook   func(MaintenanceState)
+	now    func() time.Time
+}
+
+// newMaintenanceMode returns a maintenanceMode for the provided window, which may be
+// nil. The hook, if not nil, is invoked whenever maintenance begins or ends.
+func newMaintenanceMode(window *MaintenanceWindow, hook func(MaintenanceState)) *maintenanceMode {
+	return &maintenanceMode{window: window, hook: hook, now: time.Now}
+}
+
+// enter puts the API into maintenance mode until exit is called.
+func (m *maintenanceMode) enter(message string, allow []string) {
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	m.mu.Lock()
+	m.manual = &MaintenanceState{Active: true, Message: message, Allow: allow}
+	m.mu.Unlock()
+	m.state()
+}
+
+// exit takes the API out of maintenance mode. This also cancels any configured
+// MaintenanceWindow.
+func (m *maintenanceMode) exit() {
+	m.mu.Lock()
+	m.manual = nil
+	m.window = nil
+	m.mu.Unlock()
+	m.state()
+}
+
+// state returns the current MaintenanceState, invoking the hook if it has changed
+// since it was last observed. Transitions are serialized so the hook observes them in
+// order, which means the hook must not enter or exit maintenance itself.
+func (m *maintenanceMode) state() MaintenanceState {
+	m.mu.RLock()
+	state := m.current()
+	changed := state.Active != m.active
+	m.mu.RUnlock()
+	if !changed {
+		return state
+	}
+
+	m.hookMu.Lock()
+	defer m.hookMu.Unlock()
+	m.mu.Lock()
+	// Re-evaluate since the state may have changed while the lock was released.
+	state = m.current()
+	changed = state.Active != m.active
+	m.active = state.Active
+	m.mu.Unlock()
+
+	if changed && m.hook != nil {
+		m.hook(state)
+	}
+	return state
+}
+
+// current computes the MaintenanceState. The caller must hold the lock.
+func (m *maintenanceMode) current() MaintenanceState {
+	if m.manual != nil {
+		return *m.manual
+	}
+
+	window := m.window
+	if window == nil {
+		return MaintenanceState{}
+	}
+	now := m.now()
+	if now.Before(window.Start) || (!window.End.IsZero() && !now.Before(window.End)) {
+		return MaintenanceState{}
+	}
+
+	message := window.Message
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	return MaintenanceState{Active: true, Message: message, Allow: window.Allow, End: window.End}
+}
+
+// retryAfter returns the number of seconds until the MaintenanceState ends or an
+// empty string if the end is unknown.
+func (m *maintenanceMode) retryAfter(state MaintenanceState) string {
+	if state.End.IsZero() {
+		return ""
+	}
+	seconds := int64((state.End.Sub(m.now()) + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// rejectForMaintenance responds with a 503 and returns true if the API is in
+// maintenance mode and the request isn't for an allowed route, health check, or the
+// stats endpoint.
+func (r *muxAPI) rejectForMaintenance(w http.ResponseWriter, req *http.Request) bool {
+	state := r.maintenance.state()
+	if !state.Active || r.maintenanceExempt(req.URL.Path) {
+		return false
+	}
+
+	route := req.URL.Path
+	var match mux.RouteMatch
+	if r.router.Match(req, &match) && match.Route.GetName() != "" {
+		route = match.Route.GetName()
+	}
+	if state.allows(route) {
+		return false
+	}
+
+	r.metrics.incr(MaintenanceCounter, route)
+	r.debugf("", "Rejected during maintenance: %s %s (503)", req.Method, req.URL.Path)
+	if retryAfter := r.maintenance.retryAfter(state); retryAfter != "" {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+	ctx := NewContext(nil, req)
+	ctx = ctx.setError(ServiceUnavailable(state.Message).WithCode(MaintenanceCode))
+	r.handler.sendResponse(w, ctx)
+	return true
+}
+
+// maintenanceExempt returns true if the path is for a health check, the stats
+// endpoint, or the runtime flags endpoint, which remain available during maintenance.
+func (r *muxAPI) maintenanceExempt(path string) bool {
+	if r.config.StatsURI != "" && path == r.config.StatsURI {
+		return true
+	}
+	if flags := r.config.RuntimeFlags; flags != nil && (path == flags.URI ||
+		strings.HasPrefix(path, strings.TrimSuffix(flags.URI, "/")+"/")) {
+		return true
+	}
+	for _, uri := range r.config.HealthCheckURIs {
+		if path == uri {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingHandler is a ResourceHandler whose ReadResource blocks until released.
+type blockingHandler struct {
+	BaseResourceHandler
+	started chan bool
+	release chan bool
+}
+
+func (b *blockingHandler) ResourceName() string {
+	return "foo"
+}
+
+func (b *blockingHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	if b.started != nil {
+		b.started <- true
+		<-b.release
+	}
+	return &TestResource{Foo: id}, nil
+}
+
+func (b *blockingHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+	return []Resource{}, "", nil
+}
+
+// newMaintenanceAPI returns an API with a foo resource and a health check registered.
+func newMaintenanceAPI(config *Configuration, handler ResourceHandler) API {
+	config.HealthCheckURIs = []string{"/health"}
+	api := NewAPI(config)
+	api.RegisterResourceHandler(handler)
+	api.RegisterHandlerFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return api
+}
+
+// serve sends a request to the API and returns the response.
+func serve(api API, method, url string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that requests are rejected with a structured 503 during maintenance except
+// for allowed routes and health checks, and that state changes fire the hook.
+func TestEnterExitMaintenance(t *testing.T) {
+	assert := assert.New(t)
+	states := []MaintenanceState{}
+	api := newMaintenanceAPI(&Configuration{
+		OnMaintenanceChange: func(state MaintenanceState) {
+			states = append(states, state)
+		},
+	}, &blockingHandler{})
+
+	api.EnterMaintenance("Migrating", []string{"foo:read"})
+
+	resp := serve(api, "GET", "http://foo.com/api/v0.1/foo")
+	assert.Equal(http.StatusServiceUnavailable, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"code":"maintenance","messages":["Migrating"],"reason":"Service Unavailable","status":503}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+	assert.Equal("", resp.Header().Get("Retry-After"))
+
+	resp = serve(api, "GET", "http://foo.com/api/v0.1/foo/1")
+	assert.Equal(http.StatusOK, resp.Code, "Allowed route was rejected")
+
+	resp = serve(api, "GET", "http://foo.com/health")
+	assert.Equal(http.StatusOK, resp.Code, "Health check was rejected")
+
+	assert.True(api.Maintenance().Active)
+	assert.Equal(uint64(1), api.Metrics().Counter(MaintenanceCounter, "foo:readList"))
+
+	api.ExitMaintenance()
+
+	resp = serve(api, "GET", "http://foo.com/api/v0.1/foo")
+	assert.Equal(http.StatusOK, resp.Code, "Request was rejected after maintenance")
+	assert.False(api.Maintenance().Active)
+
+	if assert.Len(states, 2) {
+		assert.Equal(MaintenanceState{Active: true, Message: "Migrating",
+			Allow: []string{"foo:read"}}, states[0])
+		assert.False(states[1].Active)
+	}
+}
+
+// Ensures that a configured MaintenanceWindow is only in effect between its start and
+// end and that Retry-After reflects the end of the window.
+func TestMaintenanceWindow(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := start.Add(-time.Minute)
+	changes := 0
+	api := newMaintenanceAPI(&Configuration{
+		Maintenance: &MaintenanceWindow{
+			Start: start,
+			End:   start.Add(time.Hour),
+		},
+		OnMaintenanceChange: func(state MaintenanceState) { changes++ },
+	}, &blockingHandler{})
+	api.(*muxAPI).maintenance.now = func() time.Time { return now }
+
+	resp := serve(api, "GET", "http://foo.com/api/v0.1/foo")
+	assert.Equal(http.StatusOK, resp.Code, "Request was rejected before the window")
+
+	now = start.Add(30 * time.Minute)
+	resp = serve(api, "GET", "http://foo.com/api/v0.1/foo")
+	assert.Equal(http.StatusServiceUnavailable, resp.Code, "Incorrect response code")
+	assert.Equal("1800", resp.Header().Get("Retry-After"))
+	assert.Equal(
+		`{"code":"maintenance","messages":["The API is undergoing maintenance"],"reason":"Service Unavailable","status":503}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+
+	now = start.Add(time.Hour)
+	resp = serve(api, "GET", "http://foo.com/api/v0.1/foo")
+	assert.Equal(http.StatusOK, resp.Code, "Request was rejected after the window")
+	assert.Equal(2, changes)
+}
+
+// Ensures that the stats endpoint remains available during maintenance and reports
+// the maintenance state.
+func TestMaintenanceStats(t *testing.T) {
+	assert := assert.New(t)
+	api := newMaintenanceAPI(&Configuration{StatsURI: "/_stats"}, &blockingHandler{})
+
+	api.EnterMaintenance("", nil)
+	serve(api, "DELETE", "http://foo.com/api/v0.1/foo/1")
+	resp := serve(api, "GET", "http://foo.com/_stats")
+
+	assert.Equal(http.StatusOK, resp.Code, "Stats endpoint was rejected")
+	var stats map[string]interface{}
+	assert.Nil(json.Unmarshal(resp.Body.Bytes(), &stats))
+	assert.Equal(map[string]interface{}{
+		"active":  true,
+		"message": "The API is undergoing maintenance",
+		"allow":   nil,
+	}, stats["maintenance"])
+	assert.Equal(map[string]interface{}{
+		"maintenance": map[string]interface{}{"foo:delete": 1.0},
+	}, stats["counters"])
+}
+
+// Ensures that requests in flight when maintenance begins are allowed to complete.
+func TestMaintenanceInFlight(t *testing.T) {
+	assert := assert.New(t)
+	handler := &blockingHandler{started: make(chan bool), release: make(chan bool)}
+	api := newMaintenanceAPI(&Configuration{}, handler)
+	done := make(chan *httptest.ResponseRecorder)
+
+	go func() {
+		done <- serve(api, "GET", "http://foo.com/api/v0.1/foo/1")
+	}()
+	<-handler.started
+
+	api.EnterMaintenance("Migrating", nil)
+	close(handler.release)
+	resp := <-done
+
+	assert.Equal(http.StatusOK, resp.Code, "In-flight request was rejected")
+	assert.Equal(http.StatusServiceUnavailable,
+		serve(api, "GET", "http://foo.com/api/v0.1/foo/1").Code)
+}
+
+// Ensures that maintenance can be toggled concurrently with requests and that every
+// response is either served or rejected cleanly. The hook must observe alternating
+// states.
+func TestMaintenanceConcurrentToggle(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	states := []bool{}
+	api := newMaintenanceAPI(&Configuration{
+		OnMaintenanceChange: func(state MaintenanceState) {
+			mu.Lock()
+			states = append(states, state.Active)
+			mu.Unlock()
+		},
+	}, &blockingHandler{})
+	var wg sync.WaitGroup
+	codes := make(chan int, 400)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				codes <- serve(api, "GET", "http://foo.com/api/v0.1/foo/1").Code
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		api.EnterMaintenance("Migrating", nil)
+		api.Maintenance()
+		api.ExitMaintenance()
+	}
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		assert.Contains([]int{http.StatusOK, http.StatusServiceUnavailable}, code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, active := range states {
+		assert.Equal(i%2 == 0, active, "Hook observed out-of-order state")
+	}
+	assert.False(api.Maintenance().Active)
+}
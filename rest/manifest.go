@@ -0,0 +1,229 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ManifestSchemaVersion is the version of the Manifest format. It's incremented
+// whenever the format changes in a way which isn't backward compatible.
+const ManifestSchemaVersion = 1
+
+// Manifest describes the routes served by an API for configuring API gateways. It's
+// produced by API#ExportManifest and is ordered so it can be diffed between builds. It
+// carries YAML tags matching its JSON names so it can be decoded by YAML libraries.
+type Manifest struct {
+	SchemaVersion int             `json:"schema_version" yaml:"schema_version"`
+	Routes        []ManifestRoute `json:"routes" yaml:"routes"`
+}
+
+// ManifestRoute describes a route.
+type ManifestRoute struct {
+	// Name is the route's name, e.g. widgets:read.
+	Name string `json:"name" yaml:"name"`
+
+	Method string `json:"method" yaml:"method"`
+
+	// Path is the gorilla/mux path template of the route.
+	Path string `json:"path" yaml:"path"`
+
+	// MethodOverride is the X-HTTP-Method-Override header value the route requires,
+	// if any.
+	MethodOverride string `json:"method_override,omitempty" yaml:"method_override,omitempty"`
+
+	// Resource is the name of the resource the route serves, if any.
+	Resource string `json:"resource,omitempty" yaml:"resource,omitempty"`
+
+	// Versions are the API versions the route serves. Empty means every version.
+	Versions []string `json:"versions,omitempty" yaml:"versions,omitempty"`
+
+	// Authenticated indicates if requests must be authenticated, i.e. the resource
+	// enforces a ScopePolicy, whose scopes are attached by Authenticate, or caches
+	// Authenticate's decisions using an AuthCache. Authentication a ResourceHandler's
+	// Authenticate performs without either isn't visible to the API.
+	Authenticated bool `json:"authenticated" yaml:"authenticated"`
+
+	// RateLimited indicates if requests are subject to a RateLimit.
+	RateLimited bool `json:"rate_limited" yaml:"rate_limited"`
+
+	// TimeoutMillis is the DeadlineBudget's Timeout for requests in milliseconds, if
+	// there is one.
+	TimeoutMillis int64 `json:"timeout_millis,omitempty" yaml:"timeout_millis,omitempty"`
+
+	// Scope is the scope the resource's ScopePolicy requires for the route, if
+	// scopes are enforced.
+	Scope string `json:"scope,omitempty" yaml:"scope,omitempty"`
+
+	// CORS is the route's CORSPolicy, if it allows cross-origin requests.
+	CORS *ManifestCORS `json:"cors,omitempty" yaml:"cors,omitempty"`
+}
+
+// ManifestCORS describes a CORSPolicy.
+type ManifestCORS struct {
+	AllowedOrigins   []string `json:"allowed_origins" yaml:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty" yaml:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty" yaml:"allowed_headers,omitempty"`
+	ExposedHeaders   []string `json:"exposed_headers,omitempty" yaml:"exposed_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials" yaml:"allow_credentials"`
+	MaxAgeSeconds    int      `json:"max_age_seconds,omitempty" yaml:"max_age_seconds,omitempty"`
+}
+
+// ExportManifest returns a document describing every route registered with the API in
+// the format, either "json" or one with a ResponseSerializer registered using
+// RegisterResponseSerializer, e.g. a YAML serializer, which is passed the manifest
+// decoded from JSON. Routes bound using RegisterHandler and similar are opaque to the
+// API and aren't included, except for those mounted using MountLegacyHandler.
+func (r *muxAPI) ExportManifest(format string) ([]byte, error) {
+	encoded, err := json.MarshalIndent(r.manifest(), "", "  ")
+	if err != nil || format == "json" {
+		return encoded, err
+	}
+	serializer, err := r.responseSerializer(format)
+	if err != nil {
+		return nil, fmt.Errorf("Manifest format not implemented: %s", format)
+	}
+	var manifest Payload
+	if err := json.Unmarshal(encoded, &manifest); err != nil {
+		return nil, err
+	}
+	return serializer.Serialize(manifest)
+}
+
+// WriteManifest writes the API's manifest in the format to the file, e.g. so CI can
+// diff it against the gateway configuration.
+func WriteManifest(api API, format, file string) error {
+	manifest, err := api.ExportManifest(format)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, manifest, 0644)
+}
+
+// manifest returns the Manifest of the API's routes derived from its registrations.
+func (r *muxAPI) manifest() Manifest {
+	routes := []ManifestRoute{}
+	seen := map[string]int{}
+	for _, reg := range r.registrations {
+		resource := reg.handler.ResourceName()
+		versions := r.manifestVersions(reg)
+		cors := manifestCORS(r.effectiveCORSPolicy(resource, reg.options))
+		for _, route := range reg.routes {
+			name := resource + ":" + route.name
+			if i, ok := seen[name]; ok {
+				// Versioned resources are registered once per set of versions.
+				routes[i].Versions = mergeVersions(routes[i].Versions, versions)
+				continue
+			}
+			preflight := route.description == "preflight"
+			scope := ""
+			if policy := r.effectiveScopePolicy(reg.options); policy != nil && !preflight {
+				scope = policy.required(resource, route.name, route.method)
+			}
+			var timeout int64
+			if budget := r.config.DeadlineBudget; budget != nil && !preflight {
+				timeout = int64(budget.Timeout / time.Millisecond)
+			}
+			seen[name] = len(routes)
+			routes = append(routes, ManifestRoute{
+				Name:           name,
+				Method:         route.method,
+				Path:           route.uri,
+				MethodOverride: route.override,
+				Resource:       resource,
+				Versions:       versions,
+				Authenticated:  scope != "" || !preflight && r.effectiveAuthCache(reg.options) != nil,
+				RateLimited:    !preflight && reg.options.rateLimit != nil,
+				TimeoutMillis:  timeout,
+				Scope:          scope,
+				CORS:           cors,
+			})
+		}
+	}
+
+	r.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		name := route.GetName()
+		if !strings.HasPrefix(name, "legacy:") {
+			return nil
+		}
+		path, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			routes = append(routes, ManifestRoute{Name: name, Method: method, Path: path})
+		}
+		return nil
+	})
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		if routes[i].Method != routes[j].Method {
+			return routes[i].Method < routes[j].Method
+		}
+		return routes[i].Name < routes[j].Name
+	})
+	return Manifest{SchemaVersion: ManifestSchemaVersion, Routes: routes}
+}
+
+// manifestVersions returns the versions served by the registration, or nil if it
+// serves every version.
+func (r *muxAPI) manifestVersions(reg *registration) []string {
+	if reg.options.versions == nil {
+		return nil
+	}
+	versions := make([]string, len(reg.options.versions))
+	for i, version := range reg.options.versions {
+		versions[i] = normalizeVersion(version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// mergeVersions returns the sorted union of the versions.
+func mergeVersions(a, b []string) []string {
+	merged := append([]string{}, a...)
+	for _, version := range b {
+		if !containsFold(merged, version) {
+			merged = append(merged, version)
+		}
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+// manifestCORS returns the ManifestCORS describing the policy, or nil if it's nil.
+func manifestCORS(policy *CORSPolicy) *ManifestCORS {
+	if policy == nil {
+		return nil
+	}
+	return &ManifestCORS{
+		AllowedOrigins:   policy.AllowedOrigins,
+		AllowedMethods:   policy.AllowedMethods,
+		AllowedHeaders:   policy.AllowedHeaders,
+		ExposedHeaders:   policy.ExposedHeaders,
+		AllowCredentials: policy.AllowCredentials,
+		MaxAgeSeconds:    int(policy.MaxAge.Seconds()),
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v1"
+)
+
+// manifestAPI returns an API with a request timeout, a versioned, rate limited
+// resource allowing cross-origin requests, a resource enforcing scopes, a resource
+// caching authentication, and a legacy handler. YAML manifests are serialized using
+// YAMLSerializer.
+func manifestAPI() API {
+	api := NewAPI(&Configuration{DeadlineBudget: &DeadlineBudget{Timeout: 2 * time.Second}})
+	api.RegisterResponseSerializer("yaml", YAMLSerializer{})
+	limit := RateLimit{Store: NewMemoryLimiterStore(func(string) RateLimitTier {
+		return RateLimitTier{Limit: 10, Window: time.Minute}
+	})}
+	cors := CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: time.Hour}
+	api.RegisterResourceHandler(namedHandler{name: "widgets"}, ForVersions("1"), limit, cors)
+	api.RegisterResourceHandler(namedHandler{name: "widgets"}, ForVersions("2", "3"), limit, cors)
+	api.RegisterResourceHandler(namedHandler{name: "gadgets"}, ScopePolicy{})
+	api.RegisterResourceHandler(namedHandler{name: "gizmos"}, AuthCache{})
+	api.MountLegacyHandler("GET", "/legacy/{id}", http.NotFoundHandler())
+	return api
+}
+
+// manifestRoute returns the route with the name and method in the Manifest.
+func manifestRoute(manifest Manifest, name, method string) *ManifestRoute {
+	for i, route := range manifest.Routes {
+		if route.Name == name && route.Method == method {
+			return &manifest.Routes[i]
+		}
+	}
+	return nil
+}
+
+// Ensures that ExportManifest describes resource, preflight, and legacy routes and
+// merges the versions of versioned resources.
+func TestExportManifest(t *testing.T) {
+	assert := assert.New(t)
+	encoded, err := manifestAPI().ExportManifest("json")
+	assert.Nil(err)
+	var manifest Manifest
+	assert.Nil(json.Unmarshal(encoded, &manifest))
+	assert.Equal(ManifestSchemaVersion, manifest.SchemaVersion)
+
+	read := manifestRoute(manifest, "widgets:read", "GET")
+	if assert.NotNil(read) {
+		assert.Equal(ManifestRoute{
+			Name:          "widgets:read",
+			Method:        "GET",
+			Path:          "/api/v{version:[^/]+}/widgets/{resource_id}",
+			Resource:      "widgets",
+			Versions:      []string{"1", "2", "3"},
+			Authenticated: false,
+			RateLimited:   true,
+			TimeoutMillis: 2000,
+			CORS: &ManifestCORS{
+				AllowedOrigins: []string{"https://app.example.com"},
+				MaxAgeSeconds:  3600,
+			},
+		}, *read)
+	}
+
+	preflight := manifestRoute(manifest, "widgets:preflight0", "OPTIONS")
+	if assert.NotNil(preflight) {
+		assert.False(preflight.Authenticated)
+		assert.False(preflight.RateLimited)
+		assert.Equal(int64(0), preflight.TimeoutMillis)
+	}
+
+	scoped := manifestRoute(manifest, "gadgets:read", "GET")
+	if assert.NotNil(scoped) {
+		assert.True(scoped.Authenticated)
+		assert.Equal("gadgets:read", scoped.Scope)
+	}
+	cached := manifestRoute(manifest, "gizmos:read", "GET")
+	if assert.NotNil(cached) {
+		assert.True(cached.Authenticated)
+	}
+
+	override := manifestRoute(manifest, "gadgets:deleteOverride", "POST")
+	if assert.NotNil(override) {
+		assert.Equal("DELETE", override.MethodOverride)
+		assert.Nil(override.Versions)
+		assert.Nil(override.CORS)
+		assert.False(override.RateLimited)
+		assert.True(override.Authenticated)
+	}
+
+	legacy := manifestRoute(manifest, "legacy:GET /legacy/{id}", "GET")
+	if assert.NotNil(legacy) {
+		assert.Equal("/legacy/{id}", legacy.Path)
+		assert.False(legacy.Authenticated)
+	}
+
+	again, _ := manifestAPI().ExportManifest("json")
+	assert.Equal(encoded, again)
+
+	_, err = manifestAPI().ExportManifest("xml")
+	assert.NotNil(err)
+	_, err = NewAPI(&Configuration{}).ExportManifest("yaml")
+	assert.NotNil(err)
+}
+
+// Ensures that the manifest round-trips through the Manifest schema as JSON and as
+// YAML using a ResponseSerializer without unknown or lost fields.
+func TestManifestConformance(t *testing.T) {
+	assert := assert.New(t)
+	api := manifestAPI()
+	expected := api.(*muxAPI).manifest()
+
+	encoded, err := api.ExportManifest("json")
+	assert.Nil(err)
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	var fromJSON Manifest
+	assert.Nil(decoder.Decode(&fromJSON))
+	assert.Equal(expected, fromJSON)
+	reencoded, _ := json.MarshalIndent(fromJSON, "", "  ")
+	assert.Equal(encoded, reencoded)
+
+	encoded, err = api.ExportManifest("yaml")
+	assert.Nil(err)
+	var fromYAML Manifest
+	assert.Nil(yaml.Unmarshal(encoded, &fromYAML))
+	assert.Equal(expected, fromYAML)
+}
+
+// Ensures that WriteManifest writes the exported manifest to the file.
+func TestWriteManifest(t *testing.T) {
+	assert := assert.New(t)
+	dir, _ := ioutil.TempDir("", "manifest")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "routes.yaml")
+	api := manifestAPI()
+
+	assert.Nil(WriteManifest(api, "yaml", file))
+	written, _ := ioutil.ReadFile(file)
+	expected, _ := api.ExportManifest("yaml")
+	assert.Equal(expected, written)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import "sync"
+
+// Counter names for the metrics maintained by the framework.
+const (
+	// GatedCounter counts requests rejected because the resource is gated off or
+	// disabled.
+	GatedCounter = "gated"
+)
+
+// Metrics exposes the counters maintained by an API. Counters are keyed by name and
+// resource.
+type Metrics interface {
+	// Counter returns the current value of the named counter for the given resource.
+	Counter(name, resource string) uint64
+
+	// Counters returns a snapshot of all counters keyed by name and then resource.
+	Counters() map[string]map[string]uint64
+
+	// incr increments the named counter for the given resource.
+	incr(name, resource string)
+}
+
+// metricsKey identifies a counter.
+type metricsKey struct {
+	name     string
+	resource string
+}
+
+// counterMetrics is an implementation of the Metrics interface which holds counters
+// in memory. It is safe for concurrent use.
+type counterMetrics struct {
+	mu       sync.RWMutex
+	counters map[metricsKey]uint64
+}
+
+// newMetrics returns a new, empty Metrics.
+func newMetrics() Metrics {
+	return &counterMetrics{counters: map[metricsKey]uint64{}}
+}
+
+// Counter returns the current value of the named counter for the given resource.
+func (m *counterMetrics) Counter(name, resource string) uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.counters[metricsKey{name, resource}]
+}
+
+// Counters returns a snapshot of all counters keyed by name and then resource.
+func (m *counterMetrics) Counters() map[string]map[string]uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	snapshot := map[string]map[string]uint64{}
+	for key, value := range m.counters {
+		if _, ok := snapshot[key.name]; !ok {
+			snapshot[key.name] = map[string]uint64{}
+		}
+		snapshot[key.name][key.resource] = value
+	}
+	return snapshot
+}
+
+// incr increments the named counter for the given resource.
+func (m *counterMetrics) incr(name, resource string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[metricsKey{name, resource}]++
+}
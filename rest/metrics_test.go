@@ -0,0 +1,50 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that Counter returns the incremented value for the name and resource.
+func TestMetricsCounter(t *testing.T) {
+	assert := assert.New(t)
+	metrics := newMetrics()
+
+	assert.Equal(uint64(0), metrics.Counter(GatedCounter, "foo"))
+
+	metrics.incr(GatedCounter, "foo")
+	metrics.incr(GatedCounter, "foo")
+	metrics.incr(GatedCounter, "bar")
+
+	assert.Equal(uint64(2), metrics.Counter(GatedCounter, "foo"))
+	assert.Equal(uint64(1), metrics.Counter(GatedCounter, "bar"))
+}
+
+// Ensures that Counters returns a snapshot of all counters.
+func TestMetricsCounters(t *testing.T) {
+	assert := assert.New(t)
+	metrics := newMetrics()
+
+	metrics.incr(GatedCounter, "foo")
+	snapshot := metrics.Counters()
+	metrics.incr(GatedCounter, "foo")
+
+	assert.Equal(map[string]map[string]uint64{GatedCounter: {"foo": 1}}, snapshot)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// maxNumberLiteral is the length of the longest number literal which is compared
+	// exactly. Longer ones are rejected rather than parsed, since parsing is slow.
+	maxNumberLiteral = 256
+
+	// maxNumberExponent is the largest exponent magnitude of number literals which
+	// are compared exactly, far beyond the range of float64.
+	maxNumberExponent = 1000
+)
+
+// numberPattern matches JSON number literals, capturing the exponent.
+var numberPattern = regexp.MustCompile(`^-?(?:0|[1-9][0-9]*)(?:\.[0-9]+)?(?:[eE]([+-]?[0-9]+))?$`)
+
+// unmarshalJSON unmarshals the JSON data into the value like json.Unmarshal, decoding
+// numbers as json.Number if useNumber is true so their literals are preserved.
+func unmarshalJSON(data []byte, v interface{}, useNumber bool) error {
+	if !useNumber {
+		return json.Unmarshal(data, v)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid character after top-level value")
+	}
+	return nil
+}
+
+// numberValue returns the value as an exact rational number if it's a json.Number or
+// a numeric type, so values can be compared without rounding through float64.
+// Returns false for json.Numbers which aren't JSON number literals, such as
+// fractions, or whose literal or exponent is too long to parse cheaply.
+func numberValue(value interface{}) (*big.Rat, bool) {
+	if n, ok := value.(json.Number); ok {
+		return numberLiteralValue(string(n))
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Rat).SetUint64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		rat := new(big.Rat).SetFloat64(v.Float())
+		return rat, rat != nil
+	}
+	return nil, false
+}
+
+// numberLiteralValue returns the JSON number literal as an exact rational number, or
+// false if it isn't one or its literal or exponent is too long to parse cheaply.
+func numberLiteralValue(literal string) (*big.Rat, bool) {
+	if len(literal) > maxNumberLiteral {
+		return nil, false
+	}
+	match := numberPattern.FindStringSubmatch(literal)
+	if match == nil {
+		return nil, false
+	}
+	if exponent := match[1]; exponent != "" {
+		digits := exponent
+		if digits[0] == '+' || digits[0] == '-' {
+			digits = digits[1:]
+		}
+		for len(digits) > 1 && digits[0] == '0' {
+			digits = digits[1:]
+		}
+		if n, err := strconv.Atoi(digits); len(digits) > 4 || err != nil ||
+			n > maxNumberExponent {
+			return nil, false
+		}
+	}
+	return new(big.Rat).SetString(literal)
+}
+
+// isNumber returns true if the value is a json.Number or a numeric type.
+func isNumber(value interface{}) bool {
+	if _, ok := value.(json.Number); ok {
+		return true
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// numberLiteral returns the JSON literal of the value if it's a json.Number or a
+// numeric type.
+func numberLiteral(value interface{}) (json.Number, bool) {
+	if n, ok := value.(json.Number); ok {
+		return n, true
+	}
+	if _, ok := numberValue(value); !ok {
+		return "", false
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return json.Number(encoded), true
+}
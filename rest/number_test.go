@@ -0,0 +1,297 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// transferHandler is a ResourceHandler for transfers which echoes the account ID it
+// receives as an int64 and the amount as its raw literal.
+type transferHandler struct {
+	BaseResourceHandler
+}
+
+func (t transferHandler) ResourceName() string {
+	return "transfers"
+}
+
+func (t transferHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	account, err := data.GetInt64("account_id")
+	if err != nil {
+		return nil, BadRequest(err.Error())
+	}
+	amount, err := data.GetNumber("amount")
+	if err != nil {
+		return nil, BadRequest(err.Error())
+	}
+	return Payload{"account_id": account, "amount": amount}, nil
+}
+
+func (t transferHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "account_id", Type: Int64, Required: true, InputOnly: true,
+			Minimum: "1", Maximum: "9007199254740993"},
+		&Rule{FieldAlias: "amount", Required: true, InputOnly: true,
+			Minimum: "0.01", Maximum: "1000000.00"},
+	)
+}
+
+// serveTransfer sends the body to create a transfer using the Configuration.
+func serveTransfer(config *Configuration, body string) *httptest.ResponseRecorder {
+	api := NewAPI(config)
+	api.RegisterResourceHandler(transferHandler{})
+	req, _ := http.NewRequest("POST", "http://example.com/api/v1/transfers",
+		bytes.NewBufferString(body))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that unmarshalJSON preserves number literals when useNumber is true and
+// rejects trailing data like json.Unmarshal.
+func TestUnmarshalJSONUseNumber(t *testing.T) {
+	assert := assert.New(t)
+	var decoded map[string]interface{}
+
+	assert.Nil(unmarshalJSON([]byte(`{"id":9007199254740993}`), &decoded, true))
+	assert.Equal(json.Number("9007199254740993"), decoded["id"])
+
+	decoded = nil
+	assert.Nil(unmarshalJSON([]byte(`{"id":9007199254740993}`), &decoded, false))
+	assert.Equal(float64(9007199254740992), decoded["id"])
+
+	assert.NotNil(unmarshalJSON([]byte(`{"id":1}}`), &decoded, true))
+	assert.NotNil(unmarshalJSON([]byte(`{"id":1} {}`), &decoded, true))
+}
+
+// Ensures that the numeric getters parse json.Number literals exactly and GetNumber
+// returns the literal, or the encoding of other numbers.
+func TestPayloadNumberGetters(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{
+		"big":     json.Number("9007199254740993"),
+		"max":     json.Number("9223372036854775807"),
+		"over":    json.Number("9223372036854775808"),
+		"decimal": json.Number("0.1000000000000000055511151231257827"),
+		"float":   float64(2.5),
+		"name":    "a",
+	}
+
+	i, err := payload.GetInt64("big")
+	assert.Nil(err)
+	assert.Equal(int64(9007199254740993), i)
+
+	i, err = payload.GetInt64("max")
+	assert.Nil(err)
+	assert.Equal(int64(9223372036854775807), i)
+
+	_, err = payload.GetInt64("over")
+	assert.NotNil(err)
+	u, err := payload.GetUint64("over")
+	assert.Nil(err)
+	assert.Equal(uint64(9223372036854775808), u)
+
+	n, err := payload.GetInt("big")
+	assert.Nil(err)
+	assert.Equal(9007199254740993, n)
+
+	_, err = payload.GetInt8("big")
+	assert.NotNil(err)
+
+	f, err := payload.GetFloat64("decimal")
+	assert.Nil(err)
+	assert.Equal(0.1, f)
+
+	number, err := payload.GetNumber("decimal")
+	assert.Nil(err)
+	assert.Equal(json.Number("0.1000000000000000055511151231257827"), number)
+
+	number, err = payload.GetNumber("float")
+	assert.Nil(err)
+	assert.Equal(json.Number("2.5"), number)
+
+	_, err = payload.GetNumber("name")
+	assert.NotNil(err)
+}
+
+// Ensures that json.Numbers are coerced from their literals without rounding, that
+// integer literals out of range aren't coerced, and that fractions are truncated like
+// floats.
+func TestCoerceFromNumber(t *testing.T) {
+	assert := assert.New(t)
+
+	coerced, err := coerceType(json.Number("9007199254740993"), Int64)
+	assert.Nil(err)
+	assert.Equal(int64(9007199254740993), coerced)
+
+	coerced, err = coerceType(json.Number("18446744073709551615"), Uint64)
+	assert.Nil(err)
+	assert.Equal(uint64(18446744073709551615), coerced)
+
+	coerced, err = coerceType(json.Number("2.75"), Int)
+	assert.Nil(err)
+	assert.Equal(2, coerced)
+
+	coerced, err = coerceType(json.Number("123.4500000000000000001"), String)
+	assert.Nil(err)
+	assert.Equal("123.4500000000000000001", coerced)
+
+	_, err = coerceType(json.Number("300"), Uint8)
+	assert.NotNil(err)
+	_, err = coerceType(json.Number("-1"), Uint)
+	assert.NotNil(err)
+	_, err = coerceType(json.Number("1"), Bool)
+	assert.NotNil(err)
+}
+
+// Ensures that Rule ranges are validated exactly for json.Numbers on either side of
+// 2^53 and for high-precision decimals, and also for float64 values.
+func TestValidateRange(t *testing.T) {
+	assert := assert.New(t)
+	rule := Rule{FieldAlias: "id", Minimum: "1", Maximum: "9007199254740993"}
+
+	assert.Nil(rule.validateRange(json.Number("9007199254740993"), "id"))
+	err := rule.validateRange(json.Number("9007199254740994"), "id")
+	if assert.NotNil(err) {
+		assert.Equal(FieldTooLargeCode, err.Code)
+		assert.Equal(json.Number("9007199254740993"), err.Params["max"])
+	}
+	assert.Nil(rule.validateRange(float64(9007199254740992), "id"))
+	assert.NotNil(rule.validateRange(float64(0.5), "id"))
+	assert.Nil(rule.validateRange(int64(1), "id"))
+	assert.Nil(rule.validateRange("not a number", "id"))
+
+	rule = Rule{FieldAlias: "amount", Minimum: "0.10000000000000000001"}
+	err = rule.validateRange(json.Number("0.1"), "amount")
+	if assert.NotNil(err) {
+		assert.Equal(FieldTooSmallCode, err.Code)
+	}
+	assert.Nil(rule.validateRange(json.Number("0.10000000000000000001"), "amount"))
+}
+
+// Ensures that numbers which can't be compared to a Rule's bounds cheaply, because
+// their literal or exponent is too long, are rejected rather than allowed.
+func TestValidateRangeFailsClosed(t *testing.T) {
+	assert := assert.New(t)
+	rule := Rule{FieldAlias: "n", Minimum: "0", Maximum: "100"}
+
+	for _, n := range []string{"1e9999999", "-1e9999999", "1e-9999999", "1e1001",
+		"1" + strings.Repeat("0", maxNumberLiteral)} {
+		err := rule.validateRange(json.Number(n), "n")
+		if assert.NotNil(err, n) {
+			assert.Equal(FieldInvalidNumberCode, err.Code)
+		}
+	}
+	err := rule.validateRange(json.Number("1e1000"), "n")
+	if assert.NotNil(err) {
+		assert.Equal(FieldTooLargeCode, err.Code)
+	}
+	assert.Nil(rule.validateRange(json.Number("1e-1000"), "n"))
+	assert.Nil(rule.validateRange(json.Number("1E+0001"), "n"))
+}
+
+// Ensures that Rules with invalid or inverted bounds fail validation.
+func TestRulesValidateBounds(t *testing.T) {
+	assert := assert.New(t)
+
+	err := NewRules((*TestResource)(nil), &Rule{FieldAlias: "amount", Minimum: "ten"}).Validate()
+	assert.NotNil(err)
+
+	err = NewRules((*TestResource)(nil), &Rule{FieldAlias: "amount", Minimum: "10", Maximum: "9.99"}).Validate()
+	assert.NotNil(err)
+
+	err = NewRules((*TestResource)(nil), &Rule{FieldAlias: "amount", Minimum: "1/2"}).Validate()
+	assert.NotNil(err)
+
+	err = NewRules((*TestResource)(nil), &Rule{FieldAlias: "amount", Minimum: "-1e3", Maximum: "1e3"}).Validate()
+	assert.Nil(err)
+}
+
+// Ensures that with UseNumber, large integers and high-precision decimals reach the
+// handler and the response without rounding.
+func TestUseNumberPreservesPrecision(t *testing.T) {
+	assert := assert.New(t)
+
+	resp := serveTransfer(&Configuration{UseNumber: true},
+		`{"account_id": 9007199254740993, "amount": 1234.5678901234567890123}`)
+
+	assert.Equal(http.StatusCreated, resp.Code, resp.Body.String())
+	assert.Contains(resp.Body.String(), `"account_id":9007199254740993`)
+	assert.Contains(resp.Body.String(), `"amount":1234.5678901234567890123`)
+}
+
+// Ensures that without UseNumber, numbers are decoded as float64 and ranges are still
+// validated.
+func TestWithoutUseNumberRoundsLargeIntegers(t *testing.T) {
+	assert := assert.New(t)
+
+	resp := serveTransfer(&Configuration{},
+		`{"account_id": 9007199254740993, "amount": 12.5}`)
+
+	assert.Equal(http.StatusCreated, resp.Code, resp.Body.String())
+	assert.Contains(resp.Body.String(), `"account_id":9007199254740992`)
+	assert.Contains(resp.Body.String(), `"amount":12.5`)
+
+	resp = serveTransfer(&Configuration{}, `{"account_id": 1, "amount": 0.001}`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code)
+	assert.Contains(resp.Body.String(), FieldTooSmallCode)
+}
+
+// Ensures that with UseNumber, values just beyond a Rule's bounds are rejected even
+// where they'd round to the bound as float64.
+func TestUseNumberValidatesRange(t *testing.T) {
+	assert := assert.New(t)
+
+	resp := serveTransfer(&Configuration{UseNumber: true},
+		`{"account_id": 9007199254740994, "amount": 1}`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code)
+	assert.Contains(resp.Body.String(), FieldTooLargeCode)
+
+	resp = serveTransfer(&Configuration{UseNumber: true},
+		`{"account_id": 1, "amount": 1000000.000000000000000001}`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code)
+	assert.Contains(resp.Body.String(), FieldTooLargeCode)
+
+	resp = serveTransfer(&Configuration{UseNumber: true},
+		`{"account_id": 1, "amount": 1e9999999}`)
+	assert.Equal(http.StatusUnprocessableEntity, resp.Code)
+	assert.Contains(resp.Body.String(), FieldInvalidNumberCode)
+}
+
+// Ensures that integers and json.Numbers are serialized exactly.
+func TestSerializeNumbers(t *testing.T) {
+	assert := assert.New(t)
+
+	serialized, err := jsonSerializer{}.Serialize(Payload{
+		"int64":  int64(9007199254740993),
+		"uint64": uint64(18446744073709551615),
+		"number": json.Number("0.30000000000000000001"),
+	})
+
+	assert.Nil(err)
+	assert.Equal(`{"int64":9007199254740993,"number":0.30000000000000000001,`+
+		`"uint64":18446744073709551615}`, string(serialized))
+}
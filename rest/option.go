@@ -0,0 +1,74 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+// ResourceOption configures how a ResourceHandler is registered with an API. Options
+// are passed to API#RegisterResourceHandler. RequestMiddleware is a ResourceOption, so
+// middleware can be passed alongside other options. Note that a plain function must be
+// converted to RequestMiddleware, e.g. RequestMiddleware(myMiddleware), in order to be
+// passed as an option.
+type ResourceOption interface {
+	// apply applies the option to the provided resourceOptions.
+	apply(*resourceOptions)
+}
+
+// resourceOptions contains the settings a ResourceHandler was registered with.
+type resourceOptions struct {
+	middleware   []RequestMiddleware
+	gate         *FeatureGate
+	disabled     bool
+	transactions *Transactions
+	versions     []string
+	retries      *Retries
+	hedging      *Hedging
+	capture      *BodyCapture
+	cache        *ResponseCache
+	lookupKeys   *LookupKeys
+	rateLimit    *RateLimit
+	quota        *Quota
+	breaker      *CircuitBreaker
+	cors         *CORSPolicy
+	strictOutput *StrictOutput
+	capabilities *Capabilities
+	scopes       *ScopePolicy
+	validation   *PayloadValidation
+	export       *DataExport
+	authCache    *AuthCache
+}
+
+// newResourceOptions returns a resourceOptions with the provided ResourceOptions
+// applied in order.
+func newResourceOptions(options []ResourceOption) *resourceOptions {
+	opts := &resourceOptions{middleware: []RequestMiddleware{}}
+	for _, option := range options {
+		option.apply(opts)
+	}
+	return opts
+}
+
+// apply adds the RequestMiddleware to the resource's middleware.
+func (m RequestMiddleware) apply(opts *resourceOptions) {
+	opts.middleware = append(opts.middleware, m)
+}
+
+// registration is a ResourceHandler bound to an API along with the options it was
+// registered with and the routes generated for it.
+type registration struct {
+	handler ResourceHandler
+	options *resourceOptions
+	routes  []resourceRoute
+}
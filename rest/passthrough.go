@@ -0,0 +1,168 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PassthroughErrorsCounter counts PassthroughErrors written as-is, keyed by status.
+	PassthroughErrorsCounter = "passthrough_errors"
+
+	// PassthroughFailedCode is the error code of responses to requests whose
+	// PassthroughError body couldn't be read or exceeded the Configuration's
+	// MaxPassthroughBytes.
+	PassthroughFailedCode = "passthrough_failed"
+
+	// defaultMaxPassthroughBytes is the maximum size of PassthroughError bodies if the
+	// Configuration doesn't specify one.
+	defaultMaxPassthroughBytes = 1 << 20
+)
+
+// hopByHopHeaders are the headers which apply to a single connection and are never
+// relayed, per RFC 7230 section 6.1.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Proxy-Connection",
+	"TE", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// PassthroughError is an error a ResourceHandler can return to relay an upstream
+// service's failed response verbatim, e.g. to clients of the upstream's SDK which
+// expect its format. Its status, headers, and body are written as-is rather than
+// wrapped in the envelope, and ErrorMappers don't apply. Hop-by-hop headers are
+// stripped, and bodies larger than the Configuration's MaxPassthroughBytes are replaced
+// with a 502. The response is still counted, logged, captured, and audited with
+// its status.
+type PassthroughError struct {
+	// Status is the status of the upstream response. Defaults to 502 Bad Gateway.
+	Status int
+
+	// Header is the header of the upstream response.
+	Header http.Header
+
+	// Body is the body of the upstream response, used if Stream is nil.
+	Body []byte
+
+	// Stream is read for the body of the upstream response, e.g. the upstream
+	// http.Response's Body. It's closed after being read if it's an io.Closer.
+	Stream io.Reader
+}
+
+// Error returns a description of the upstream response.
+func (p *PassthroughError) Error() string {
+	return fmt.Sprintf("Upstream responded with %d", p.status())
+}
+
+// status returns the status of the upstream response.
+func (p *PassthroughError) status() int {
+	if p.Status == 0 {
+		return http.StatusBadGateway
+	}
+	return p.Status
+}
+
+// body returns the body of the upstream response, reading at most limit+1 bytes of the
+// Stream so oversized bodies can be detected.
+func (p *PassthroughError) body(limit int) ([]byte, error) {
+	if p.Stream == nil {
+		return p.Body, nil
+	}
+	if closer, ok := p.Stream.(io.Closer); ok {
+		defer closer.Close()
+	}
+	return ioutil.ReadAll(io.LimitReader(p.Stream, int64(limit)+1))
+}
+
+// maxPassthroughBytes returns the maximum size of PassthroughError bodies for the
+// Configuration.
+func maxPassthroughBytes(config *Configuration) int {
+	if config == nil || config.MaxPassthroughBytes == 0 {
+		return defaultMaxPassthroughBytes
+	}
+	return config.MaxPassthroughBytes
+}
+
+// sanitizedPassthroughHeader returns a copy of the header without hop-by-hop headers,
+// including those named by its Connection header, or the Content-Length, which is set
+// from the body written.
+func sanitizedPassthroughHeader(header http.Header) http.Header {
+	sanitized := make(http.Header, len(header))
+	for name, values := range header {
+		sanitized[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+	}
+	for _, connection := range sanitized["Connection"] {
+		for _, name := range strings.Split(connection, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				sanitized.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		sanitized.Del(name)
+	}
+	sanitized.Del("Content-Length")
+	return sanitized
+}
+
+// sendPassthrough writes the PassthroughError's response as-is, or a 502 if its body
+// can't be read or exceeds the Configuration's MaxPassthroughBytes. If the context
+// doesn't carry the request, the PassthroughError is sent like any other Error with
+// its status instead.
+func (h requestHandler) sendPassthrough(w http.ResponseWriter, ctx RequestContext,
+	passthrough *PassthroughError) {
+
+	config := h.Configuration()
+	req, ok := ctx.Request()
+	if !ok {
+		h.sendResponse(w, ctx.setError(Error{reason: passthrough.Error(),
+			status: passthrough.status()}))
+		return
+	}
+	limit := maxPassthroughBytes(config)
+	body, err := passthrough.body(limit)
+	if err != nil || len(body) > limit {
+		reason := "Upstream error response is too large"
+		if err != nil {
+			reason = fmt.Sprintf("Failed to read upstream error response: %s", err)
+		}
+		config.Logf("%s for %s %s", reason, req.Method, req.URL.Path)
+		h.sendResponse(w, ctx.setError(Error{reason: reason, status: http.StatusBadGateway,
+			code: PassthroughFailedCode}))
+		return
+	}
+
+	status := passthrough.status()
+	if stage, ok := rejectionStage(Error{status: status}); ok {
+		markRejected(req, stage, "")
+	}
+	h.Metrics().incr(PassthroughErrorsCounter, strconv.Itoa(status))
+	config.Logf("Passed through %d upstream error for %s %s", status, req.Method, req.URL.Path)
+
+	header := w.Header()
+	for name, values := range sanitizedPassthroughHeader(passthrough.Header) {
+		header[name] = values
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// upstreamBody is a streamed upstream response body which records being closed.
+type upstreamBody struct {
+	*bytes.Reader
+	closed bool
+}
+
+func (u *upstreamBody) Close() error {
+	u.closed = true
+	return nil
+}
+
+// upstreamHandler is a ResourceHandler proxying to an upstream service which fails
+// with a vendor-formatted error, relayed using a PassthroughError.
+type upstreamHandler struct {
+	BaseResourceHandler
+	stream *upstreamBody
+}
+
+func (u upstreamHandler) ResourceName() string {
+	return "upstreams"
+}
+
+func (u upstreamHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	header := http.Header{
+		"Content-Type":      {"application/vnd.vendor+json"},
+		"X-Vendor-Trace":    {"t-1"},
+		"Connection":        {"close, X-Hop"},
+		"X-Hop":             {"1"},
+		"Transfer-Encoding": {"chunked"},
+		"Content-Length":    {"999"},
+	}
+	switch id {
+	case "buffered":
+		return nil, &PassthroughError{Status: http.StatusTeapot, Header: header,
+			Body: []byte(`{"vendor_error":"short and stout"}`)}
+	case "streamed":
+		return nil, &PassthroughError{Status: http.StatusServiceUnavailable, Header: header,
+			Stream: u.stream}
+	case "forbidden":
+		return nil, &PassthroughError{Status: http.StatusForbidden, Body: []byte("no")}
+	}
+	return nil, &PassthroughError{Body: []byte(strings.Repeat("x", 65))}
+}
+
+// Ensures that a buffered PassthroughError is written as-is without the envelope or
+// ErrorMappers, with hop-by-hop headers stripped, and is counted and logged with its
+// status outside debug mode.
+func TestPassthroughErrorBuffered(t *testing.T) {
+	assert := assert.New(t)
+	logs := &bytes.Buffer{}
+	api := NewAPI(&Configuration{Logger: log.New(logs, "", 0)})
+	api.RegisterErrorMapper(func(err error) (Error, bool) {
+		return InternalServerError("mapped"), true
+	})
+	api.RegisterResourceHandler(upstreamHandler{})
+
+	resp := serve(api, "GET", "http://foo.com/api/v1/upstreams/buffered")
+	assert.Equal(http.StatusTeapot, resp.Code)
+	assert.Equal(`{"vendor_error":"short and stout"}`, resp.Body.String())
+	assert.Equal("application/vnd.vendor+json", resp.Header().Get("Content-Type"))
+	assert.Equal("t-1", resp.Header().Get("X-Vendor-Trace"))
+	assert.Equal("34", resp.Header().Get("Content-Length"))
+	for _, name := range []string{"Connection", "X-Hop", "Transfer-Encoding"} {
+		assert.Equal("", resp.Header().Get(name), name)
+	}
+	assert.Equal(uint64(1), api.Metrics().Counter(PassthroughErrorsCounter, "418"))
+	assert.Contains(logs.String(),
+		"Passed through 418 upstream error for GET /api/v1/upstreams/buffered")
+}
+
+// Ensures that a streamed PassthroughError's body is read, closed, and written as-is.
+func TestPassthroughErrorStreamed(t *testing.T) {
+	assert := assert.New(t)
+	stream := &upstreamBody{Reader: bytes.NewReader([]byte("<error>down</error>"))}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(upstreamHandler{stream: stream})
+
+	resp := serve(api, "GET", "http://foo.com/api/v1/upstreams/streamed")
+	assert.Equal(http.StatusServiceUnavailable, resp.Code)
+	assert.Equal("<error>down</error>", resp.Body.String())
+	assert.Equal("19", resp.Header().Get("Content-Length"))
+	assert.True(stream.closed)
+}
+
+// Ensures that PassthroughError bodies exceeding MaxPassthroughBytes, or which can't
+// be read, are replaced with a 502 in the envelope.
+func TestPassthroughErrorTooLarge(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{MaxPassthroughBytes: 64})
+	api.RegisterResourceHandler(upstreamHandler{})
+
+	resp := serve(api, "GET", "http://foo.com/api/v1/upstreams/large")
+	assert.Equal(http.StatusBadGateway, resp.Code)
+	assert.Equal(`{"code":"passthrough_failed","messages":["Upstream error response is too large"],`+
+		`"reason":"Bad Gateway","status":502}`, resp.Body.String())
+
+	failing := &PassthroughError{Stream: errorReader{errors.New("reset")}}
+	_, err := failing.body(64)
+	assert.NotNil(err)
+}
+
+// errorReader is an io.Reader which always fails with the error.
+type errorReader struct {
+	err error
+}
+
+func (e errorReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+// Ensures that PassthroughErrors are audited as rejections with their status.
+func TestPassthroughErrorAudited(t *testing.T) {
+	assert := assert.New(t)
+	sink := &recordingSink{}
+	api := NewAPI(&Configuration{RejectionAudit: &RejectionAudit{Sink: sink}})
+	api.RegisterResourceHandler(upstreamHandler{})
+
+	resp := serve(api, "GET", "http://foo.com/api/v1/upstreams/forbidden")
+	assert.Equal(http.StatusForbidden, resp.Code)
+	assert.Equal("no", resp.Body.String())
+	if assert.Len(sink.records, 1) {
+		assert.Equal(RejectedAuthorization, sink.records[0].Stage)
+		assert.Equal(http.StatusForbidden, sink.records[0].Status)
+	}
+}
+
+// requestlessContext is a RequestContext which doesn't carry its request.
+type requestlessContext struct {
+	RequestContext
+}
+
+func (r requestlessContext) Request() (*http.Request, bool) {
+	return nil, false
+}
+
+// Ensures that a PassthroughError whose context doesn't carry the request is sent in
+// the envelope with its status rather than as-is.
+func TestPassthroughErrorWithoutRequest(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/upstreams/forbidden", nil)
+	ctx := requestlessContext{NewContext(nil, req)}
+	resp := httptest.NewRecorder()
+
+	requestHandler{api}.sendPassthrough(resp, ctx,
+		&PassthroughError{Status: http.StatusForbidden, Body: []byte("no")})
+
+	assert.Equal(http.StatusForbidden, resp.Code)
+	assert.Contains(resp.Body.String(), "Upstream responded with 403")
+	assert.Equal(uint64(0), api.Metrics().Counter(PassthroughErrorsCounter, "403"))
+}
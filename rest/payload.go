@@ -17,11 +17,15 @@ limitations under the License.
 package rest
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 )
 
-// Payload is the unmarshalled request body.
+// Payload is the unmarshalled request body. Numbers decoded with the Configuration's
+// UseNumber are json.Number values, which the numeric getters parse from their
+// literals.
 type Payload map[string]interface{}
 
 // Get returns the value with the given key as an interface{}. If the key doesn't
@@ -43,6 +47,11 @@ func (p Payload) GetInt(key string) (int, error) {
 	if value, ok := value.(int); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseInt(string(n), 10, 0); err == nil {
+			return int(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not an int", key)
 }
 
@@ -56,6 +65,11 @@ func (p Payload) GetInt8(key string) (int8, error) {
 	if value, ok := value.(int8); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseInt(string(n), 10, 8); err == nil {
+			return int8(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not an int8", key)
 }
 
@@ -69,6 +83,11 @@ func (p Payload) GetInt16(key string) (int16, error) {
 	if value, ok := value.(int16); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseInt(string(n), 10, 16); err == nil {
+			return int16(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not an int16", key)
 }
 
@@ -82,6 +101,11 @@ func (p Payload) GetInt32(key string) (int32, error) {
 	if value, ok := value.(int32); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseInt(string(n), 10, 32); err == nil {
+			return int32(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not an int32", key)
 }
 
@@ -95,6 +119,11 @@ func (p Payload) GetInt64(key string) (int64, error) {
 	if value, ok := value.(int64); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			return parsed, nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not an int64", key)
 }
 
@@ -108,6 +137,11 @@ func (p Payload) GetUint(key string) (uint, error) {
 	if value, ok := value.(uint); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseUint(string(n), 10, 0); err == nil {
+			return uint(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not a uint", key)
 }
 
@@ -121,6 +155,11 @@ func (p Payload) GetUint8(key string) (uint8, error) {
 	if value, ok := value.(uint8); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseUint(string(n), 10, 8); err == nil {
+			return uint8(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not a uint8", key)
 }
 
@@ -134,6 +173,11 @@ func (p Payload) GetUint16(key string) (uint16, error) {
 	if value, ok := value.(uint16); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseUint(string(n), 10, 16); err == nil {
+			return uint16(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not a uint16", key)
 }
 
@@ -147,6 +191,11 @@ func (p Payload) GetUint32(key string) (uint32, error) {
 	if value, ok := value.(uint32); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseUint(string(n), 10, 32); err == nil {
+			return uint32(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not a uint32", key)
 }
 
@@ -160,6 +209,11 @@ func (p Payload) GetUint64(key string) (uint64, error) {
 	if value, ok := value.(uint64); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			return parsed, nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not a uint64", key)
 }
 
@@ -173,6 +227,11 @@ func (p Payload) GetFloat32(key string) (float32, error) {
 	if value, ok := value.(float32); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseFloat(string(n), 32); err == nil {
+			return float32(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not a float32", key)
 }
 
@@ -186,9 +245,29 @@ func (p Payload) GetFloat64(key string) (float64, error) {
 	if value, ok := value.(float64); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseFloat(string(n), 64); err == nil {
+			return parsed, nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not a float64", key)
 }
 
+// GetNumber returns the value with the given key as a json.Number, which is the raw
+// literal if it was decoded with the Configuration's UseNumber. This is preferable
+// for decimal-sensitive fields, such as monetary amounts. If the key doesn't exist
+// or is not a number, an empty json.Number is returned with an error.
+func (p Payload) GetNumber(key string) (json.Number, error) {
+	value, err := p.Get(key)
+	if err != nil {
+		return "", err
+	}
+	if number, ok := numberLiteral(value); ok {
+		return number, nil
+	}
+	return "", fmt.Errorf("Value with key '%s' not a number", key)
+}
+
 // GetByte returns the value with the given key as a byte. If the key doesn't
 // exist or is not a byte, the zero value is returned with an error.
 func (p Payload) GetByte(key string) (byte, error) {
@@ -199,6 +278,11 @@ func (p Payload) GetByte(key string) (byte, error) {
 	if value, ok := value.(byte); ok {
 		return value, nil
 	}
+	if n, ok := value.(json.Number); ok {
+		if parsed, err := strconv.ParseUint(string(n), 10, 8); err == nil {
+			return byte(parsed), nil
+		}
+	}
 	return 0, fmt.Errorf("Value with key '%s' not a byte", key)
 }
 
@@ -0,0 +1,123 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+
+	gcontext "github.com/gorilla/context"
+)
+
+// RequestIDHeader is the header identifying a request. If it's one of the
+// Configuration's PropagatedHeaders and a request doesn't include it, an ID is
+// generated for the request's outgoing headers.
+const RequestIDHeader = "X-Request-Id"
+
+// outgoingHeadersKey is the request context key under which the headers propagated to
+// downstream requests are recorded.
+type outgoingHeadersKey struct{}
+
+// newPropagationMiddleware returns a RequestMiddleware which records the request's
+// values for the propagated headers before any other middleware is invoked.
+func newPropagationMiddleware(propagated []string) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		if len(propagated) == 0 {
+			return wrapped
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			captureOutgoingHeaders(r, propagated)
+			wrapped(w, r)
+		}
+	}
+}
+
+// captureOutgoingHeaders records the request's values for the propagated headers so
+// they can be forwarded on downstream requests made while handling it.
+func captureOutgoingHeaders(req *http.Request, propagated []string) {
+	outgoing := http.Header{}
+	for _, name := range propagated {
+		name = http.CanonicalHeaderKey(name)
+		if values, ok := req.Header[name]; ok {
+			outgoing[name] = append([]string{}, values...)
+		} else if name == RequestIDHeader {
+			if id, err := newUUID(); err == nil {
+				outgoing.Set(name, id)
+			}
+		}
+	}
+	gcontext.Set(req, outgoingHeadersKey{}, outgoing)
+}
+
+// outgoingHeaders returns a copy of the headers recorded for the request by
+// captureOutgoingHeaders.
+func outgoingHeaders(req *http.Request) http.Header {
+	outgoing, _ := gcontext.Get(req, outgoingHeadersKey{}).(http.Header)
+	copied := make(http.Header, len(outgoing))
+	for name, values := range outgoing {
+		copied[name] = append([]string{}, values...)
+	}
+	return copied
+}
+
+// setOutgoingHeader sets the header on the request's outgoing headers, replacing any
+// propagated value.
+func setOutgoingHeader(req *http.Request, name, value string) {
+	outgoing, ok := gcontext.Get(req, outgoingHeadersKey{}).(http.Header)
+	if !ok {
+		outgoing = http.Header{}
+		gcontext.Set(req, outgoingHeadersKey{}, outgoing)
+	}
+	outgoing.Set(name, value)
+}
+
+// propagatingTransport is an http.RoundTripper which adds headers to every request
+// which doesn't already set them.
+type propagatingTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+// RoundTrip sends a copy of the request with the headers added using the base
+// RoundTripper.
+func (p *propagatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	outbound := *req
+	outbound.Header = make(http.Header, len(req.Header)+len(p.header))
+	for name, values := range req.Header {
+		outbound.Header[name] = values
+	}
+	for name, values := range p.header {
+		if _, ok := outbound.Header[name]; !ok {
+			outbound.Header[name] = values
+		}
+	}
+	return p.base.RoundTrip(&outbound)
+}
+
+// wrapHTTPClient returns a copy of the client whose requests include the headers. The
+// default client is used if the client is nil.
+func wrapHTTPClient(client *http.Client, header http.Header) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = &propagatingTransport{base, header}
+	return &wrapped
+}
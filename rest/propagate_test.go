@@ -0,0 +1,125 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// downstreamHandler is a ResourceHandler whose ReadResource calls a downstream service
+// and returns the headers it received.
+type downstreamHandler struct {
+	BaseResourceHandler
+	url string
+}
+
+func (d *downstreamHandler) ResourceName() string {
+	return "foo"
+}
+
+func (d *downstreamHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	resp, err := ctx.WrapHTTPClient(nil).Get(d.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// newDownstreamServer returns a server which responds with the tenant and request ID
+// headers it receives.
+func newDownstreamServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Header.Get("X-Tenant-Id"), r.Header.Get(RequestIDHeader))
+	}))
+}
+
+// Ensures that OutgoingHeaders contains only the propagated headers and synthesizes a
+// request ID when the request doesn't have one.
+func TestOutgoingHeaders(t *testing.T) {
+	assert := assert.New(t)
+	var outgoing http.Header
+	api := NewAPI(&Configuration{PropagatedHeaders: []string{"X-Tenant-ID", "X-Request-ID"}})
+	api.RegisterHandlerFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
+		outgoing = NewContext(nil, r).OutgoingHeaders()
+	})
+
+	req, _ := http.NewRequest("GET", "http://foo.com/foo", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set("Authorization", "secret")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal("acme", outgoing.Get("X-Tenant-ID"))
+	assert.Len(outgoing.Get(RequestIDHeader), 36)
+	assert.Equal("", outgoing.Get("Authorization"))
+
+	req, _ = http.NewRequest("GET", "http://foo.com/foo", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(http.Header{RequestIDHeader: []string{"abc"}}, outgoing)
+}
+
+// Ensures that clients wrapped using WrapHTTPClient forward each request's own
+// propagated headers to downstream services when requests are handled concurrently.
+func TestWrapHTTPClientConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	downstream := newDownstreamServer()
+	defer downstream.Close()
+	api := NewAPI(&Configuration{PropagatedHeaders: []string{"X-Tenant-ID", "X-Request-ID"}})
+	api.RegisterResourceHandler(&downstreamHandler{url: downstream.URL})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://foo.com/api/v1/foo/1", nil)
+			req.Header.Set("X-Tenant-ID", fmt.Sprintf("tenant-%d", i))
+			req.Header.Set("X-Request-ID", fmt.Sprintf("request-%d", i))
+			resp := httptest.NewRecorder()
+			api.ServeHTTP(resp, req)
+			assert.Equal(fmt.Sprintf(`{"messages":[],"reason":"OK",`+
+				`"result":"tenant-%d request-%d","status":200}`, i, i), resp.Body.String())
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Ensures that WrapHTTPClient doesn't override headers set on the outbound request.
+func TestWrapHTTPClientKeepsExplicitHeaders(t *testing.T) {
+	downstream := newDownstreamServer()
+	defer downstream.Close()
+	client := wrapHTTPClient(nil, http.Header{"X-Tenant-Id": []string{"acme"}})
+
+	req, _ := http.NewRequest("GET", downstream.URL, nil)
+	req.Header.Set("X-Tenant-ID", "other")
+	resp, err := client.Do(req)
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "other ", string(body))
+	assert.Equal(t, "other", req.Header.Get("X-Tenant-ID"))
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// sortKey is the name of the query string variable for the result sort order.
+	sortKey = "sort"
+
+	// filterPrefix is the prefix of query string variables which filter results,
+	// e.g. filter[name]=bob or filter[age][gt]=30.
+	filterPrefix = "filter["
+)
+
+// Filter operators which can be specified in the query string.
+const (
+	FilterEqual              = "eq"
+	FilterNotEqual           = "ne"
+	FilterLessThan           = "lt"
+	FilterLessThanOrEqual    = "lte"
+	FilterGreaterThan        = "gt"
+	FilterGreaterThanOrEqual = "gte"
+)
+
+// QueryFilter is a condition on a resource field parsed from a filter query string
+// variable. filter[name]=bob yields {name eq bob} and filter[age][gt]=30 yields
+// {age gt 30}. The Operator is not validated; it's up to the ResourceHandler to
+// reject operators it doesn't support.
+type QueryFilter struct {
+	Field    string
+	Operator string
+	Value    string
+}
+
+// SortField is a field to sort results by, parsed from the sort query string variable.
+// sort=name,-age yields name ascending followed by age descending.
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// parseFilters returns the QueryFilters specified in the query string, ordered by
+// field and operator. Variables which aren't well-formed filters are ignored.
+func parseFilters(query url.Values) []QueryFilter {
+	filters := []QueryFilter{}
+	for key, values := range query {
+		if !strings.HasPrefix(key, filterPrefix) || !strings.HasSuffix(key, "]") {
+			continue
+		}
+		parts := strings.Split(key[len(filterPrefix):len(key)-1], "][")
+		if len(parts) > 2 || parts[0] == "" {
+			continue
+		}
+		operator := FilterEqual
+		if len(parts) == 2 {
+			operator = parts[1]
+		}
+		for _, value := range values {
+			filters = append(filters, QueryFilter{
+				Field:    parts[0],
+				Operator: operator,
+				Value:    value,
+			})
+		}
+	}
+
+	sort.Sort(queryFilters(filters))
+	return filters
+}
+
+// parseSort returns the SortFields specified in the query string in order of
+// precedence. A field prefixed with "-" is sorted in descending order.
+func parseSort(query url.Values) []SortField {
+	fields := []SortField{}
+	for _, value := range query[sortKey] {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			descending := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(strings.TrimPrefix(field, "-"), "+")
+			if field == "" {
+				continue
+			}
+			fields = append(fields, SortField{Field: field, Descending: descending})
+		}
+	}
+	return fields
+}
+
+// queryFilters implements sort.Interface, ordering QueryFilters by field, operator,
+// and value so parsing is deterministic.
+type queryFilters []QueryFilter
+
+// Len returns the number of QueryFilters.
+func (q queryFilters) Len() int {
+	return len(q)
+}
+
+// Swap swaps the QueryFilters at the given indexes.
+func (q queryFilters) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+// Less returns true if the QueryFilter at i sorts before the one at j.
+func (q queryFilters) Less(i, j int) bool {
+	if q[i].Field != q[j].Field {
+		return q[i].Field < q[j].Field
+	}
+	if q[i].Operator != q[j].Operator {
+		return q[i].Operator < q[j].Operator
+	}
+	return q[i].Value < q[j].Value
+}
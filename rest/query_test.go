@@ -0,0 +1,56 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that Filters parses filter query string variables, defaulting to equality,
+// and ignores malformed ones.
+func TestFilters(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET",
+		"http://foo.com?filter[name]=bob&filter[age][gt]=30&filter[age][lt]=40"+
+			"&filter[]=x&filter[a][b][c]=y&filters=z", nil)
+	ctx := NewContext(nil, req)
+
+	assert.Equal([]QueryFilter{
+		{Field: "age", Operator: FilterGreaterThan, Value: "30"},
+		{Field: "age", Operator: FilterLessThan, Value: "40"},
+		{Field: "name", Operator: FilterEqual, Value: "bob"},
+	}, ctx.Filters())
+}
+
+// Ensures that Sort parses the sort query string variable in order of precedence.
+func TestSort(t *testing.T) {
+	assert := assert.New(t)
+	req, _ := http.NewRequest("GET", "http://foo.com?sort=name,-age,,+id", nil)
+	ctx := NewContext(nil, req)
+
+	assert.Equal([]SortField{
+		{Field: "name"},
+		{Field: "age", Descending: true},
+		{Field: "id"},
+	}, ctx.Sort())
+
+	req, _ = http.NewRequest("GET", "http://foo.com", nil)
+	assert.Equal([]SortField{}, NewContext(nil, req).Sort())
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// QuotaExceededCounter counts requests rejected because their key's quota was
+	// used up.
+	QuotaExceededCounter = "quota_exceeded"
+
+	// QuotaExceededCode is the error code of responses to requests whose key's quota
+	// was used up.
+	QuotaExceededCode = "quota_exceeded"
+
+	// QuotaLimitHeader is the response header carrying the number of bytes allowed per
+	// quota window.
+	QuotaLimitHeader = "X-Quota-Limit"
+
+	// QuotaRemainingHeader is the response header carrying the number of bytes
+	// remaining in the quota window before the request.
+	QuotaRemainingHeader = "X-Quota-Remaining"
+)
+
+// QuotaTier is the data transfer quota applied to a class of keys.
+type QuotaTier struct {
+	// Limit is the number of request and response bytes allowed per Window. Requests
+	// made after it's used up receive a 429. Zero means unlimited, in which case bytes
+	// are still metered.
+	Limit int64
+
+	// Window is the duration of each fixed window the Limit applies to, e.g. 30 days
+	// for a monthly plan. Windows start at multiples of the duration since the zero
+	// Time, like Time#Truncate, so those dividing a day start at midnight UTC but 30
+	// day windows don't start on the first of a month.
+	Window time.Duration
+}
+
+// QuotaEvent describes the bytes a request consumed from its key's quota.
+type QuotaEvent struct {
+	Resource string
+	Key      string
+	Tier     QuotaTier
+
+	// RequestBytes is the number of request body bytes read as received, before any
+	// decoding.
+	RequestBytes int64
+
+	// ResponseBytes is the number of response body bytes written as sent.
+	ResponseBytes int64
+
+	// Used is the number of bytes used in the window, including this request's.
+	Used  int64
+	Reset time.Time
+}
+
+// Quota is a ResourceOption which meters the request and response bytes transferred
+// per key, such as a partner's API key, in the Configuration's Store and enforces
+// data transfer quotas. Bytes are counted as they're read and written, so responses
+// without a Content-Length, such as streamed responses, are metered accurately.
+// Requests made once the key's tier Limit is used up in a window receive a 429, so
+// the request which crosses the Limit completes. Every request's consumption is
+// reported to OnConsumption. Keys with the same Quota share their consumption across
+// resources.
+type Quota struct {
+	// Tier returns the QuotaTier for the key.
+	Tier func(key string) QuotaTier
+
+	// Key returns the key the request is metered by. Defaults to the client's IP
+	// address.
+	Key func(*http.Request) string
+
+	// OnConsumption is invoked after each metered request, e.g. to export usage for
+	// billing. It's invoked synchronously, so it should hand off slow work.
+	OnConsumption func(QuotaEvent)
+}
+
+// apply sets the Quota on the resource.
+func (q Quota) apply(opts *resourceOptions) {
+	opts.quota = &q
+}
+
+// key returns the key the request is metered by.
+func (q *Quota) key(r *http.Request) string {
+	if q.Key != nil {
+		return q.Key(r)
+	}
+	return clientIP(r)
+}
+
+// newQuotaMiddleware returns a RequestMiddleware which applies the Quota to requests
+// for the resource.
+func newQuotaMiddleware(api *muxAPI, resource string, quota *Quota) RequestMiddleware {
+	if quota.Tier == nil {
+		panic(fmt.Sprintf("Quota for %s must specify a Tier", resource))
+	}
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := quota.key(r)
+			tier := quota.Tier(key)
+			if tier.Window <= 0 {
+				wrapped(w, r)
+				return
+			}
+			store := api.store()
+			used, _, err := store.IncrementBy(QuotaNamespace, key, 0, tier.Window)
+			if err != nil {
+				// Don't reject requests because the store is unavailable.
+				api.config.Logf("Quota store failed for %s: %s", resource, err)
+			}
+			if tier.Limit > 0 {
+				remaining := tier.Limit - used
+				if remaining < 0 {
+					remaining = 0
+				}
+				w.Header().Set(QuotaLimitHeader, strconv.FormatInt(tier.Limit, 10))
+				w.Header().Set(QuotaRemainingHeader, strconv.FormatInt(remaining, 10))
+				if remaining == 0 {
+					api.metrics.incr(QuotaExceededCounter, resource)
+					api.debugf(resource, "Quota exceeded by %s for %s: %s %s (429)",
+						key, resource, r.Method, r.URL.Path)
+					ctx := NewContext(nil, r).setError(TooManyRequests(
+						fmt.Sprintf("Quota of %d bytes exceeded", tier.Limit)).WithCode(QuotaExceededCode))
+					api.handler.sendResponse(w, ctx)
+					return
+				}
+			}
+
+			body := &countingReader{}
+			if r.Body != nil {
+				body.ReadCloser = r.Body
+				r.Body = body
+			}
+			counter := &countingWriter{ResponseWriter: w}
+			wrapped(counter, r)
+
+			event := QuotaEvent{
+				Resource:      resource,
+				Key:           key,
+				Tier:          tier,
+				RequestBytes:  body.count,
+				ResponseBytes: counter.count,
+			}
+			event.Used, event.Reset, err = store.IncrementBy(QuotaNamespace, key,
+				event.RequestBytes+event.ResponseBytes, tier.Window)
+			if err != nil {
+				api.config.Logf("Quota store failed for %s: %s", resource, err)
+			}
+			if quota.OnConsumption != nil {
+				quota.OnConsumption(event)
+			}
+		}
+	}
+}
+
+// countingReader is an io.ReadCloser which counts the bytes read.
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+// Read reads from the wrapped ReadCloser and counts the bytes read.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// countingWriter is an http.ResponseWriter which counts the body bytes written.
+type countingWriter struct {
+	http.ResponseWriter
+	count int64
+}
+
+// Write writes the bytes and counts them.
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(b)
+	c.count += int64(n)
+	return n, err
+}
+
+// Flush flushes the wrapped ResponseWriter if it supports flushing, so streamed
+// responses can be metered.
+func (c *countingWriter) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
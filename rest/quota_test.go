@@ -0,0 +1,162 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// quotaHandler is a ResourceHandler which echoes created resources.
+type quotaHandler struct {
+	BaseResourceHandler
+}
+
+func (q quotaHandler) ResourceName() string {
+	return "uploads"
+}
+
+func (q quotaHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	return data, nil
+}
+
+// upload sends a create request for the partner to the API.
+func upload(api API, partner, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v1/uploads",
+		bytes.NewBufferString(body))
+	req.Header.Set("X-Partner", partner)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// partnerKey returns the X-Partner header.
+func partnerKey(r *http.Request) string {
+	return r.Header.Get("X-Partner")
+}
+
+// Ensures that request and response bytes are metered per key and reported to the
+// hook.
+func TestQuotaMetering(t *testing.T) {
+	assert := assert.New(t)
+	events := []QuotaEvent{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(quotaHandler{}, Quota{
+		Tier:          func(key string) QuotaTier { return QuotaTier{Window: time.Hour} },
+		Key:           partnerKey,
+		OnConsumption: func(event QuotaEvent) { events = append(events, event) },
+	})
+
+	body := `{"name":"report.csv"}`
+	first := upload(api, "acme", body)
+	second := upload(api, "acme", body)
+	upload(api, "globex", body)
+
+	assert.Equal(http.StatusCreated, first.Code)
+	assert.Equal("", first.Header().Get(QuotaRemainingHeader))
+	if assert.Len(events, 3) {
+		size := int64(len(body) + first.Body.Len())
+		assert.Equal("uploads", events[0].Resource)
+		assert.Equal("acme", events[0].Key)
+		assert.Equal(int64(len(body)), events[0].RequestBytes)
+		assert.Equal(int64(first.Body.Len()), events[0].ResponseBytes)
+		assert.Equal(size, events[0].Used)
+		assert.Equal(size+int64(len(body)+second.Body.Len()), events[1].Used)
+		assert.Equal("globex", events[2].Key)
+		assert.Equal(size, events[2].Used)
+	}
+}
+
+// Ensures that requests made after a key's quota is used up receive a 429 with the
+// quota headers and code, and are counted.
+func TestQuotaExceeded(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(quotaHandler{}, Quota{
+		Tier: func(key string) QuotaTier { return QuotaTier{Limit: 100, Window: time.Hour} },
+		Key:  partnerKey,
+	})
+
+	body := `{"name":"report.csv","description":"a report which uses up the quota"}`
+	first := upload(api, "acme", body)
+	second := upload(api, "acme", body)
+
+	assert.Equal(http.StatusCreated, first.Code)
+	assert.Equal("100", first.Header().Get(QuotaLimitHeader))
+	assert.Equal("100", first.Header().Get(QuotaRemainingHeader))
+	assert.Equal(http.StatusTooManyRequests, second.Code)
+	assert.Equal("0", second.Header().Get(QuotaRemainingHeader))
+	assert.Contains(second.Body.String(), QuotaExceededCode)
+	assert.Equal(uint64(1), api.Metrics().Counter(QuotaExceededCounter, "uploads"))
+	assert.Equal(http.StatusCreated, upload(api, "globex", body).Code)
+}
+
+// Ensures that every concurrent request's bytes are counted.
+func TestQuotaConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	var mu sync.Mutex
+	var total int64
+	store := NewMemoryStore()
+	api := NewAPI(&Configuration{Store: store})
+	api.RegisterResourceHandler(quotaHandler{}, Quota{
+		Tier: func(key string) QuotaTier { return QuotaTier{Window: time.Hour} },
+		Key:  partnerKey,
+		OnConsumption: func(event QuotaEvent) {
+			mu.Lock()
+			total += event.RequestBytes + event.ResponseBytes
+			mu.Unlock()
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			upload(api, "acme", `{"name":"report.csv"}`)
+		}()
+	}
+	wg.Wait()
+
+	used, _, err := store.IncrementBy(QuotaNamespace, "acme", 0, time.Hour)
+	assert.Nil(err)
+	assert.Equal(total, used)
+}
+
+// Ensures that countingWriter counts every write of a response without a
+// Content-Length and flushes the wrapped writer.
+func TestCountingWriterStreamed(t *testing.T) {
+	assert := assert.New(t)
+	recorder := httptest.NewRecorder()
+	counter := &countingWriter{ResponseWriter: recorder}
+
+	for i := 0; i < 3; i++ {
+		counter.Write([]byte("chunk " + strconv.Itoa(i) + "\n"))
+		counter.Flush()
+	}
+
+	assert.Equal(int64(24), counter.count)
+	assert.True(recorder.Flushed)
+}
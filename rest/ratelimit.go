@@ -0,0 +1,282 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// RateLimitedCounter counts requests rejected because they exceeded a hard limit.
+	RateLimitedCounter = "rate_limited"
+
+	// RateLimitWarnedCounter counts requests which exceeded a soft threshold but were
+	// allowed.
+	RateLimitWarnedCounter = "rate_limit_warned"
+
+	// RateLimitedCode is the error code of responses to rate limited requests.
+	RateLimitedCode = "rate_limited"
+
+	// RateLimitLimitHeader is the response header carrying the number of requests
+	// allowed per window.
+	RateLimitLimitHeader = "X-RateLimit-Limit"
+
+	// RateLimitRemainingHeader is the response header carrying the number of requests
+	// remaining in the window.
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+
+	// RateLimitResetHeader is the response header carrying the Unix time at which the
+	// window resets.
+	RateLimitResetHeader = "X-RateLimit-Reset"
+
+	// RateLimitWarningHeader is the response header warning that a request exceeded
+	// the soft threshold of its limit.
+	RateLimitWarningHeader = "X-RateLimit-Warning"
+)
+
+// RateLimitTier is the limit applied to a class of keys.
+type RateLimitTier struct {
+	// Limit is the number of requests allowed per Window. Requests beyond it receive a
+	// 429. Zero means unlimited.
+	Limit int
+
+	// Window is the duration of each fixed window the Limit applies to.
+	Window time.Duration
+
+	// SoftThreshold is the fraction of the Limit, between 0 and 1, after which
+	// responses carry rate limit warnings. Zero disables warnings.
+	SoftThreshold float64
+}
+
+// softLimit returns the number of requests in a window after which requests are
+// warned, or 0 if they never are.
+func (t RateLimitTier) softLimit() int {
+	if t.SoftThreshold <= 0 || t.SoftThreshold >= 1 {
+		return 0
+	}
+	return int(math.Ceil(float64(t.Limit) * t.SoftThreshold))
+}
+
+// LimiterStore tracks rate limit consumption. Implementations backed by a shared
+// store allow limits to be enforced across instances.
+type LimiterStore interface {
+	// Tier returns the RateLimitTier for the key.
+	Tier(key string) (RateLimitTier, error)
+
+	// Increment records a request for the key in the current window of the duration
+	// and returns the number of requests recorded in it and when it resets.
+	Increment(key string, window time.Duration) (int, time.Time, error)
+}
+
+// RateLimitEvent describes a key crossing the soft threshold of its limit.
+type RateLimitEvent struct {
+	Resource string
+	Key      string
+	Tier     RateLimitTier
+	Count    int
+	Reset    time.Time
+}
+
+// RateLimit is a ResourceOption which limits the rate of requests to a resource per
+// key, such as a partner's API key. Requests beyond the key's tier Limit in a window
+// receive a 429. Once requests cross the tier's SoftThreshold, responses include the
+// X-RateLimit-Warning header along with the limit, remaining, and reset headers, and
+// OnSoftLimit is invoked once per window. Requests are limited after they're
+// authenticated, so keys may be derived from the principal.
+type RateLimit struct {
+	// Store tracks consumption and provides each key's tier. Defaults to tracking
+	// consumption in the Configuration's Store using Tier.
+	Store LimiterStore
+
+	// Tier returns the RateLimitTier for the key when Store isn't specified.
+	Tier func(key string) RateLimitTier
+
+	// Key returns the key the request is limited by. Defaults to the client's IP
+	// address.
+	Key func(*http.Request) string
+
+	// OnSoftLimit is invoked when a key first crosses its tier's soft threshold in a
+	// window. It's invoked synchronously, so it should hand off slow work.
+	OnSoftLimit func(RateLimitEvent)
+}
+
+// apply sets the RateLimit on the resource.
+func (l RateLimit) apply(opts *resourceOptions) {
+	opts.rateLimit = &l
+}
+
+// key returns the key the request is limited by.
+func (l *RateLimit) key(r *http.Request) string {
+	if l.Key != nil {
+		return l.Key(r)
+	}
+	return clientIP(r)
+}
+
+// clientIP returns the IP address of the client which sent the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newRateLimitMiddleware returns a RequestMiddleware which applies the RateLimit to
+// requests for the resource.
+func newRateLimitMiddleware(api *muxAPI, resource string, limit *RateLimit) RequestMiddleware {
+	store := limit.Store
+	if store == nil {
+		if limit.Tier == nil {
+			panic(fmt.Sprintf("RateLimit for %s must specify a Store or Tier", resource))
+		}
+		store = storeLimiter{api.store(), limit.Tier}
+	}
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := limit.key(r)
+			tier, err := store.Tier(key)
+			if err == nil && tier.Limit > 0 && tier.Window > 0 {
+				var count int
+				var reset time.Time
+				count, reset, err = store.Increment(resource+":"+key, tier.Window)
+				if err == nil && !api.checkRateLimit(w, r, resource, key, tier, count, reset,
+					limit.OnSoftLimit) {
+					return
+				}
+			}
+			if err != nil {
+				// Don't reject requests because the store is unavailable.
+				api.config.Logf("Rate limit store failed for %s: %s", resource, err)
+			}
+			wrapped(w, r)
+		}
+	}
+}
+
+// checkRateLimit sets the rate limit headers for the request's count in the window
+// and responds with a 429 if it's over the limit. Returns true if the request is
+// allowed.
+func (r *muxAPI) checkRateLimit(w http.ResponseWriter, req *http.Request, resource,
+	key string, tier RateLimitTier, count int, reset time.Time,
+	onSoftLimit func(RateLimitEvent)) bool {
+
+	soft := tier.softLimit()
+	if count <= tier.Limit && (soft == 0 || count < soft) {
+		return true
+	}
+
+	remaining := tier.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set(RateLimitLimitHeader, strconv.Itoa(tier.Limit))
+	w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(remaining))
+	w.Header().Set(RateLimitResetHeader, strconv.FormatInt(reset.Unix(), 10))
+
+	if count > tier.Limit {
+		r.metrics.incr(RateLimitedCounter, resource)
+		r.debugf(resource, "Rate limited %s for %s: %s %s (429)", key, resource,
+			req.Method, req.URL.Path)
+		retryAfter := int(math.Ceil(reset.Sub(time.Now()).Seconds()))
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+		ctx := NewContext(nil, req).setError(TooManyRequests(
+			fmt.Sprintf("Rate limit of %d requests exceeded", tier.Limit)).WithCode(RateLimitedCode))
+		r.handler.sendResponse(w, ctx)
+		return false
+	}
+
+	r.metrics.incr(RateLimitWarnedCounter, resource)
+	w.Header().Set(RateLimitWarningHeader,
+		fmt.Sprintf("%d of %d requests used", count, tier.Limit))
+	if count == soft && onSoftLimit != nil {
+		onSoftLimit(RateLimitEvent{
+			Resource: resource,
+			Key:      key,
+			Tier:     tier,
+			Count:    count,
+			Reset:    reset,
+		})
+	}
+	return true
+}
+
+// MemoryLimiterStore is a LimiterStore which tracks consumption in memory, so limits
+// apply per instance. It's safe for concurrent use.
+type MemoryLimiterStore struct {
+	tier    func(key string) RateLimitTier
+	mu      sync.Mutex
+	windows map[string]*limiterWindow
+	swept   time.Time
+	now     func() time.Time
+}
+
+// limiterWindow is the consumption of a key in a window.
+type limiterWindow struct {
+	count int64
+	reset time.Time
+}
+
+// NewMemoryLimiterStore returns a MemoryLimiterStore which assigns keys to tiers using
+// the function.
+func NewMemoryLimiterStore(tier func(key string) RateLimitTier) *MemoryLimiterStore {
+	return &MemoryLimiterStore{
+		tier:    tier,
+		windows: map[string]*limiterWindow{},
+		now:     time.Now,
+	}
+}
+
+// Tier returns the RateLimitTier for the key.
+func (m *MemoryLimiterStore) Tier(key string) (RateLimitTier, error) {
+	return m.tier(key), nil
+}
+
+// Increment records a request for the key in the current window of the duration and
+// returns the number of requests recorded in it and when it resets. Expired windows
+// are discarded periodically.
+func (m *MemoryLimiterStore) Increment(key string, window time.Duration) (int, time.Time,
+	error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if !now.Before(m.swept.Add(window)) {
+		for k, w := range m.windows {
+			if !now.Before(w.reset) {
+				delete(m.windows, k)
+			}
+		}
+		m.swept = now
+	}
+
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &limiterWindow{reset: now.Truncate(window).Add(window)}
+		m.windows[key] = w
+	}
+	w.count++
+	return int(w.count), w.reset, nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingLimiterStore is a LimiterStore which is unavailable.
+type failingLimiterStore struct{}
+
+func (f failingLimiterStore) Tier(key string) (RateLimitTier, error) {
+	return RateLimitTier{}, fmt.Errorf("unavailable")
+}
+
+func (f failingLimiterStore) Increment(key string, window time.Duration) (int, time.Time,
+	error) {
+	return 0, time.Time{}, fmt.Errorf("unavailable")
+}
+
+// serveAs sends a request for the partner to the API and returns the response.
+func serveAs(api API, partner string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/foo/1", nil)
+	req.Header.Set("X-Partner", partner)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that requests past the soft threshold succeed with warning headers and fire
+// the hook once, while requests past the limit receive a 429, with both counted.
+func TestRateLimitSoftAndHardLimits(t *testing.T) {
+	assert := assert.New(t)
+	events := []RateLimitEvent{}
+	store := NewMemoryLimiterStore(func(key string) RateLimitTier {
+		if key == "gold" {
+			return RateLimitTier{Limit: 10, Window: time.Hour, SoftThreshold: 0.8}
+		}
+		return RateLimitTier{Limit: 4, Window: time.Hour, SoftThreshold: 0.5}
+	})
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(&blockingHandler{}, RateLimit{
+		Store:       store,
+		Key:         func(r *http.Request) string { return r.Header.Get("X-Partner") },
+		OnSoftLimit: func(event RateLimitEvent) { events = append(events, event) },
+	})
+
+	resp := serveAs(api, "acme")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("", resp.Header().Get(RateLimitWarningHeader))
+
+	for i := 2; i <= 4; i++ {
+		resp = serveAs(api, "acme")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.Equal(fmt.Sprintf("%d of 4 requests used", i),
+			resp.Header().Get(RateLimitWarningHeader))
+		assert.Equal(fmt.Sprint(4-i), resp.Header().Get(RateLimitRemainingHeader))
+		assert.Equal("4", resp.Header().Get(RateLimitLimitHeader))
+		assert.NotEqual("", resp.Header().Get(RateLimitResetHeader))
+	}
+
+	resp = serveAs(api, "acme")
+	assert.Equal(statusTooManyRequests, resp.Code)
+	assert.Equal(`{"code":"rate_limited","messages":["Rate limit of 4 requests exceeded"],`+
+		`"reason":"Too Many Requests","status":429}`, resp.Body.String())
+	assert.Equal("0", resp.Header().Get(RateLimitRemainingHeader))
+	assert.NotEqual("", resp.Header().Get("Retry-After"))
+
+	resp = serveAs(api, "gold")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("", resp.Header().Get(RateLimitWarningHeader))
+
+	if assert.Len(events, 1) {
+		assert.Equal("acme", events[0].Key)
+		assert.Equal("foo", events[0].Resource)
+		assert.Equal(2, events[0].Count)
+	}
+	assert.Equal(uint64(3), api.Metrics().Counter(RateLimitWarnedCounter, "foo"))
+	assert.Equal(uint64(1), api.Metrics().Counter(RateLimitedCounter, "foo"))
+}
+
+// Ensures that MemoryLimiterStore starts a new window once the previous one resets.
+func TestMemoryLimiterStoreWindows(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2014, 6, 1, 12, 0, 30, 0, time.UTC)
+	store := NewMemoryLimiterStore(func(key string) RateLimitTier { return RateLimitTier{} })
+	store.now = func() time.Time { return now }
+
+	count, reset, err := store.Increment("acme", time.Minute)
+	assert.Nil(err)
+	assert.Equal(1, count)
+	assert.Equal(time.Date(2014, 6, 1, 12, 1, 0, 0, time.UTC), reset)
+	count, _, _ = store.Increment("acme", time.Minute)
+	assert.Equal(2, count)
+
+	now = now.Add(time.Minute)
+	count, reset, _ = store.Increment("acme", time.Minute)
+	assert.Equal(1, count)
+	assert.Equal(time.Date(2014, 6, 1, 12, 2, 0, 0, time.UTC), reset)
+}
+
+// Ensures that requests are allowed if the LimiterStore fails.
+func TestRateLimitStoreFailure(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(&blockingHandler{}, RateLimit{Store: failingLimiterStore{}})
+
+	assert.Equal(t, http.StatusOK, serveAs(api, "acme").Code)
+}
+
+// Ensures that RateLimits without a LimiterStore count requests in the Configuration's
+// Store, so instances sharing a Store share limits.
+func TestRateLimitConfigurationStore(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	limit := RateLimit{
+		Key:  func(r *http.Request) string { return r.Header.Get("X-Partner") },
+		Tier: func(string) RateLimitTier { return RateLimitTier{Limit: 2, Window: time.Hour} },
+	}
+	first := NewAPI(&Configuration{Store: store})
+	first.RegisterResourceHandler(&blockingHandler{}, limit)
+	second := NewAPI(&Configuration{Store: store})
+	second.RegisterResourceHandler(&blockingHandler{}, limit)
+
+	assert.Equal(http.StatusOK, serveAs(first, "acme").Code)
+	assert.Equal(http.StatusOK, serveAs(second, "acme").Code)
+	assert.Equal(statusTooManyRequests, serveAs(first, "acme").Code)
+
+	count, _, _ := store.Increment(RateLimitNamespace, "foo:acme", time.Hour)
+	assert.Equal(int64(4), count)
+
+	isolated := NewAPI(&Configuration{})
+	isolated.RegisterResourceHandler(&blockingHandler{}, limit)
+	assert.Equal(http.StatusOK, serveAs(isolated, "acme").Code)
+}
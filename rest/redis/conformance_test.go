@@ -0,0 +1,35 @@
+//go:build redis
+
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Workiva/go-rest/rest"
+	"github.com/Workiva/go-rest/rest/storetest"
+)
+
+// Ensures that the Store conforms to the Store contract against the Redis server at
+// REDIS_ADDR, or localhost:6379. Run with -tags redis.
+func TestStoreConformance(t *testing.T) {
+	storetest.Run(t, func() rest.Store {
+		return NewStore(Options{Addr: os.Getenv("REDIS_ADDR"), Prefix: "rest-test:"})
+	})
+}
@@ -0,0 +1,372 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redis provides a rest.Store backed by Redis, so state such as rate limit
+// counts is shared by every API instance using the same Redis server. Use it by
+// setting the API Configuration's Store:
+//
+//	config.Store = redis.NewStore(redis.Options{Addr: "redis:6379"})
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Default Options.
+const (
+	defaultAddr     = "localhost:6379"
+	defaultPrefix   = "rest:"
+	defaultTimeout  = 5 * time.Second
+	defaultPoolSize = 10
+)
+
+// incrementScript increments the count of the window resetting at ARGV[1], in Unix
+// milliseconds, by ARGV[2] and returns it along with when the window resets. The count's hash
+// records its window, so a later window restarts the count while an earlier one, from
+// an instance whose clock is behind, counts in the current window.
+const incrementScript = `
+local reset = tonumber(redis.call('HGET', KEYS[1], 'reset') or '0')
+if reset < tonumber(ARGV[1]) then
+  redis.call('DEL', KEYS[1])
+  redis.call('HSET', KEYS[1], 'reset', ARGV[1])
+  redis.call('PEXPIREAT', KEYS[1], ARGV[1])
+  reset = tonumber(ARGV[1])
+end
+return {redis.call('HINCRBY', KEYS[1], 'n', ARGV[2]), reset}`
+
+// compareAndSwapScript sets a value if the current value matches, or if there's no
+// value when ARGV[1] is 0.
+const compareAndSwapScript = `
+local current = redis.call('GET', KEYS[1])
+if ARGV[1] == '0' then
+  if current then return 0 end
+elseif current ~= ARGV[2] then
+  return 0
+end
+if tonumber(ARGV[4]) > 0 then
+  redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+else
+  redis.call('SET', KEYS[1], ARGV[3])
+end
+return 1`
+
+// Options configures a Store.
+type Options struct {
+	// Addr is the host:port of the Redis server. Defaults to localhost:6379.
+	Addr string
+
+	// Password authenticates connections if set.
+	Password string
+
+	// DB is the database to select. Defaults to 0.
+	DB int
+
+	// Prefix is prepended to every key. Defaults to "rest:".
+	Prefix string
+
+	// Timeout limits dialing and each command. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// PoolSize is the number of idle connections kept open. Defaults to 10.
+	PoolSize int
+}
+
+// Store is a rest.Store backed by Redis. Increments and CompareAndSwap are atomic
+// since they run as scripts, and Range returns values in the order they were appended,
+// so it meets the expectations of every feature as long as instances' clocks are
+// synchronized with each other. It's safe for concurrent use.
+type Store struct {
+	opts Options
+	pool chan *conn
+}
+
+// conn is a connection to Redis.
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply from Redis.
+type redisError string
+
+// Error returns the error message.
+func (r redisError) Error() string {
+	return "redis: " + string(r)
+}
+
+// NewStore returns a Store using the Options. Connections are opened as needed.
+func NewStore(opts Options) *Store {
+	if opts.Addr == "" {
+		opts.Addr = defaultAddr
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = defaultPrefix
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.PoolSize == 0 {
+		opts.PoolSize = defaultPoolSize
+	}
+	return &Store{opts: opts, pool: make(chan *conn, opts.PoolSize)}
+}
+
+// Get returns the value of the key and true, or false if it doesn't exist.
+func (s *Store) Get(namespace, key string) ([]byte, bool, error) {
+	reply, err := s.do("GET", s.key('v', namespace, key))
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %v", reply)
+	}
+	return value, true, nil
+}
+
+// Set sets the value of the key. It expires after the ttl unless it's zero.
+func (s *Store) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", s.key('v', namespace, key), string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(milliseconds(ttl), 10))
+	}
+	_, err := s.do(args...)
+	return err
+}
+
+// Delete deletes the key's value, list, and count, if any.
+func (s *Store) Delete(namespace, key string) error {
+	_, err := s.do("DEL", s.key('v', namespace, key), s.key('l', namespace, key),
+		s.key('c', namespace, key))
+	return err
+}
+
+// Increment increments the key's count in the current fixed window of the duration
+// and returns the count in the window and when it resets. Windows are aligned to the
+// local clock.
+func (s *Store) Increment(namespace, key string, window time.Duration) (int64,
+	time.Time, error) {
+
+	return s.IncrementBy(namespace, key, 1, window)
+}
+
+// IncrementBy increments the key's count in the current fixed window of the duration
+// by n and returns the count in the window and when it resets. Windows are aligned to
+// the local clock.
+func (s *Store) IncrementBy(namespace, key string, n int64, window time.Duration) (int64,
+	time.Time, error) {
+
+	reset := time.Now().Truncate(window).Add(window)
+	reply, err := s.do("EVAL", incrementScript, "1", s.key('c', namespace, key),
+		strconv.FormatInt(reset.UnixNano()/int64(time.Millisecond), 10),
+		strconv.FormatInt(n, 10))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return 0, time.Time{}, fmt.Errorf("redis: unexpected EVAL reply %v", reply)
+	}
+	count, ok := items[0].(int64)
+	resetMillis, resetOK := items[1].(int64)
+	if !ok || !resetOK {
+		return 0, time.Time{}, fmt.Errorf("redis: unexpected EVAL reply %v", reply)
+	}
+	return count, time.Unix(0, resetMillis*int64(time.Millisecond)), nil
+}
+
+// CompareAndSwap sets the key's value to the new value if its current value is old,
+// or if it doesn't exist when old is nil. It expires after the ttl unless it's zero.
+// Returns true if the value was set.
+func (s *Store) CompareAndSwap(namespace, key string, old, value []byte,
+	ttl time.Duration) (bool, error) {
+
+	hasOld := "1"
+	if old == nil {
+		hasOld = "0"
+	}
+	reply, err := s.do("EVAL", compareAndSwapScript, "1", s.key('v', namespace, key),
+		hasOld, string(old), string(value), strconv.FormatInt(milliseconds(ttl), 10))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+// Append appends the value to the key's list.
+func (s *Store) Append(namespace, key string, value []byte) error {
+	_, err := s.do("RPUSH", s.key('l', namespace, key), string(value))
+	return err
+}
+
+// Range returns the values in the key's list in the order they were appended.
+func (s *Store) Range(namespace, key string) ([][]byte, error) {
+	reply, err := s.do("LRANGE", s.key('l', namespace, key), "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected LRANGE reply %v", reply)
+	}
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		values[i], _ = item.([]byte)
+	}
+	return values, nil
+}
+
+// key returns the Redis key of the kind, v for values, l for lists, or c for counts,
+// for the namespace's key. The namespace's length is included so namespaces and keys
+// containing separators can't collide.
+func (s *Store) key(kind byte, namespace, key string) string {
+	return fmt.Sprintf("%s%c:%d:%s:%s", s.opts.Prefix, kind, len(namespace), namespace, key)
+}
+
+// milliseconds returns the duration in milliseconds, rounding up so small durations
+// aren't treated as zero.
+func milliseconds(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// do sends the command to Redis and returns its reply. Replies are []byte for bulk
+// strings, string for simple strings, int64 for integers, []interface{} for arrays,
+// or nil.
+func (s *Store) do(args ...string) (interface{}, error) {
+	c, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(s.opts.Timeout))
+	reply, err := c.command(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// The connection's state is unknown after an I/O error.
+		c.Close()
+		return nil, err
+	}
+	s.put(c)
+	return reply, err
+}
+
+// get returns an idle connection or opens a new one.
+func (s *Store) get() (*conn, error) {
+	select {
+	case c := <-s.pool:
+		return c, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", s.opts.Addr, s.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	c.SetDeadline(time.Now().Add(s.opts.Timeout))
+	if s.opts.Password != "" {
+		if _, err := c.command("AUTH", s.opts.Password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if s.opts.DB != 0 {
+		if _, err := c.command("SELECT", strconv.Itoa(s.opts.DB)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns the connection to the pool or closes it if the pool is full.
+func (s *Store) put(c *conn) {
+	select {
+	case s.pool <- c:
+	default:
+		c.Close()
+	}
+}
+
+// command writes the command and reads its reply.
+func (c *conn) command(args ...string) (interface{}, error) {
+	if _, err := c.Write(encodeCommand(args)); err != nil {
+		return nil, err
+	}
+	return readReply(c.reader)
+}
+
+// encodeCommand returns the command encoded as a RESP array of bulk strings.
+func encodeCommand(args []string) []byte {
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n", len(arg))...)
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readReply reads a RESP reply.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redis
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that commands are encoded as RESP arrays of bulk strings.
+func TestEncodeCommand(t *testing.T) {
+	assert.Equal(t, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\na\r\nb!\r\n",
+		string(encodeCommand([]string{"SET", "k", "a\r\nb!"})))
+}
+
+// Ensures that each RESP reply type is decoded.
+func TestReadReply(t *testing.T) {
+	assert := assert.New(t)
+	reply := func(s string) (interface{}, error) {
+		return readReply(bufio.NewReader(strings.NewReader(s)))
+	}
+
+	value, err := reply("+OK\r\n")
+	assert.Equal("OK", value)
+	assert.Nil(err)
+
+	_, err = reply("-WRONGTYPE bad\r\n")
+	assert.Equal(redisError("WRONGTYPE bad"), err)
+
+	value, _ = reply(":42\r\n")
+	assert.Equal(int64(42), value)
+
+	value, _ = reply("$5\r\na\r\nb!\r\n")
+	assert.Equal([]byte("a\r\nb!"), value)
+
+	value, err = reply("$-1\r\n")
+	assert.Nil(value)
+	assert.Nil(err)
+
+	value, _ = reply("*2\r\n$1\r\na\r\n:1\r\n")
+	assert.Equal([]interface{}{[]byte("a"), int64(1)}, value)
+
+	_, err = reply("?\r\n")
+	assert.NotNil(err)
+}
+
+// Ensures that keys of different kinds and namespaces don't collide.
+func TestKey(t *testing.T) {
+	assert := assert.New(t)
+	store := NewStore(Options{})
+	assert.Equal("rest:v:1:a:b:c", store.key('v', "a", "b:c"))
+	assert.NotEqual(store.key('v', "a:b", "c"), store.key('v', "a", "b:c"))
+	assert.NotEqual(store.key('v', "a", "b"), store.key('l', "a", "b"))
+	assert.Equal(int64(1), milliseconds(time.Microsecond))
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	gcontext "github.com/gorilla/context"
+)
+
+// RejectionStage identifies where in the request pipeline a request was rejected.
+type RejectionStage string
+
+// Stages at which requests are rejected.
+const (
+	// RejectedAuthentication is the stage of requests the ResourceHandler's
+	// Authenticate rejected.
+	RejectedAuthentication RejectionStage = "authentication"
+
+	// RejectedAuthorization is the stage of requests which received a 403.
+	RejectedAuthorization RejectionStage = "authorization"
+
+	// RejectedValidation is the stage of requests whose bodies failed Rules or other
+	// validation, receiving a 422.
+	RejectedValidation RejectionStage = "validation"
+
+	// RejectedRateLimit is the stage of requests rejected by a RateLimit.
+	RejectedRateLimit RejectionStage = "rate_limit"
+
+	// RejectedQuota is the stage of requests rejected by a Quota.
+	RejectedQuota RejectionStage = "quota"
+)
+
+const (
+	// RejectionsCounterPrefix prefixes the names of the counters of rejected requests
+	// per stage, e.g. rejections_authentication.
+	RejectionsCounterPrefix = "rejections_"
+
+	// RejectionsDroppedCounter counts rejections which weren't sent to the
+	// RejectionSink because more than the RejectionAudit's MaxPerSecond occurred.
+	RejectionsDroppedCounter = "rejections_dropped"
+)
+
+// rejectionKey is the request context key under which a request's rejection is
+// recorded.
+type rejectionKey struct{}
+
+// rejection is the stage and error code of a request's rejection.
+type rejection struct {
+	stage RejectionStage
+	code  string
+}
+
+// RejectionRecord describes a rejected request for security investigations.
+type RejectionRecord struct {
+	Timestamp time.Time
+	ClientIP  string
+	Resource  string
+	Method    string
+	URL       string
+	Stage     RejectionStage
+	Status    int
+	Code      string
+	RequestID string
+
+	// Principal is the RejectionAudit's Principal for the request, with sensitive
+	// fields redacted, if any.
+	Principal interface{}
+
+	// Payload is a fragment of the request body with sensitive fields redacted, if
+	// the RejectionAudit captures payloads.
+	Payload          []byte
+	PayloadTruncated bool
+}
+
+// RejectionSink receives records of rejected requests.
+type RejectionSink interface {
+	// RecordRejection records the rejection. It's invoked synchronously after the
+	// response is written, so it should hand off slow work. Errors are logged and don't
+	// affect the response.
+	RecordRejection(RejectionRecord) error
+}
+
+// RejectionAudit sends records of rejected requests, such as failed authentication and
+// rate limited requests, to a RejectionSink. Rejections of every stage are counted in
+// the Metrics whether or not they're recorded.
+type RejectionAudit struct {
+	Sink RejectionSink
+
+	// Stages are the rejection stages recorded. Defaults to every stage.
+	Stages []RejectionStage
+
+	// Resources are the names of the resources whose rejections are recorded. Defaults
+	// to every resource.
+	Resources []string
+
+	// MaxPerSecond is the maximum number of rejections of each stage recorded per
+	// second. Others are dropped and counted so an attack doesn't flood the Sink.
+	// Defaults to no maximum.
+	MaxPerSecond int
+
+	// MaxPayloadBytes is the maximum number of bytes of the request body included in
+	// records, with the values of Sensitive fields and those listed in Redact
+	// redacted. Only that many bytes are buffered, so longer bodies with fields to
+	// redact can't be decoded and aren't included. Defaults to 0, meaning bodies aren't
+	// included.
+	MaxPayloadBytes int
+
+	// Redact lists additional field names whose values are redacted.
+	Redact []string
+
+	// Principal returns a snapshot of the request's principal, if any, to include in
+	// records. It must be encodable as JSON so sensitive fields can be redacted.
+	Principal func(*http.Request) interface{}
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[RejectionStage]int
+	now    func() time.Time
+}
+
+// records returns true if rejections at the stage for the resource are recorded.
+func (a *RejectionAudit) records(resource string, stage RejectionStage) bool {
+	if len(a.Resources) > 0 && !containsFold(a.Resources, resource) {
+		return false
+	}
+	if len(a.Stages) == 0 {
+		return true
+	}
+	for _, s := range a.Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// allow returns true if the rejection at the stage is within the MaxPerSecond.
+func (a *RejectionAudit) allow(stage RejectionStage) bool {
+	if a.MaxPerSecond <= 0 {
+		return true
+	}
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if window := now.Truncate(time.Second); !window.Equal(a.window) {
+		a.window = window
+		a.counts = map[RejectionStage]int{}
+	}
+	a.counts[stage]++
+	return a.counts[stage] <= a.MaxPerSecond
+}
+
+// markRejected records the stage and code of the request's rejection.
+func markRejected(r *http.Request, stage RejectionStage, code string) {
+	gcontext.Set(r, rejectionKey{}, rejection{stage, code})
+}
+
+// rejectionStage returns the stage of a request rejected with the error and true, or
+// false if the error isn't a rejection.
+func rejectionStage(err error) (RejectionStage, bool) {
+	restErr, ok := err.(Error)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case restErr.Code() == RateLimitedCode:
+		return RejectedRateLimit, true
+	case restErr.Code() == QuotaExceededCode:
+		return RejectedQuota, true
+	case restErr.Status() == http.StatusForbidden:
+		return RejectedAuthorization, true
+	case restErr.Status() == http.StatusUnprocessableEntity || len(restErr.FieldErrors()) > 0:
+		return RejectedValidation, true
+	}
+	return "", false
+}
+
+// newRejectionMiddleware returns a RequestMiddleware which counts rejected requests to
+// the ResourceHandler and sends records of them to the RejectionAudit's Sink, if it
+// isn't nil.
+func newRejectionMiddleware(api *muxAPI, h ResourceHandler, audit *RejectionAudit) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		resource := h.ResourceName()
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			body := &limitedBuffer{}
+			if audit != nil && audit.MaxPayloadBytes > 0 && r.Body != nil {
+				body.max = audit.MaxPayloadBytes
+				r.Body = readCloser{io.TeeReader(r.Body, body), r.Body}
+			}
+			recorder := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+			wrapped(recorder, r)
+
+			rejected, ok := gcontext.Get(r, rejectionKey{}).(rejection)
+			if !ok {
+				return
+			}
+			api.metrics.incr(RejectionsCounterPrefix+string(rejected.stage), resource)
+			if audit == nil || audit.Sink == nil || !audit.records(resource, rejected.stage) {
+				return
+			}
+			if !audit.allow(rejected.stage) {
+				api.metrics.incr(RejectionsDroppedCounter, resource)
+				return
+			}
+
+			record := RejectionRecord{
+				Timestamp: start,
+				ClientIP:  clientIP(r),
+				Resource:  resource,
+				Method:    r.Method,
+				URL:       r.URL.String(),
+				Stage:     rejected.stage,
+				Status:    recorder.status,
+				Code:      rejected.code,
+				RequestID: r.Header.Get(RequestIDHeader),
+			}
+			if record.RequestID == "" {
+				record.RequestID = outgoingHeaders(r).Get(RequestIDHeader)
+			}
+			redact := map[string]bool{}
+			if audit.Principal != nil || audit.MaxPayloadBytes > 0 {
+				redact = sensitiveFields(h.Rules().ForVersion(NewContext(nil, r).Version()),
+					audit.Redact)
+			}
+			if audit.Principal != nil {
+				record.Principal = capturePrincipal(audit.Principal(r), redact)
+			}
+			if audit.MaxPayloadBytes > 0 {
+				record.Payload, record.PayloadTruncated = captureBody(body.Bytes(), redact,
+					audit.MaxPayloadBytes)
+				record.PayloadTruncated = record.PayloadTruncated || body.truncated
+			}
+			api.recordRejection(audit.Sink, record)
+		}
+	}
+}
+
+// limitedBuffer is an io.Writer which buffers at most max bytes, discarding the rest
+// and recording that it did.
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write buffers as many of the bytes as fit within the maximum.
+func (l *limitedBuffer) Write(b []byte) (int, error) {
+	if room := l.max - l.Len(); len(b) > room {
+		l.truncated = true
+		if room > 0 {
+			l.Buffer.Write(b[:room])
+		}
+		return len(b), nil
+	}
+	return l.Buffer.Write(b)
+}
+
+// recordRejection sends the record to the sink, logging any error or panic rather than
+// letting it affect the response.
+func (r *muxAPI) recordRejection(sink RejectionSink, record RejectionRecord) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			r.config.Logf("Rejection sink panicked for %s: %v", record.Resource, recovered)
+		}
+	}()
+	if err := sink.RecordRejection(record); err != nil {
+		r.config.Logf("Rejection sink failed for %s: %s", record.Resource, err)
+	}
+}
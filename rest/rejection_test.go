@@ -0,0 +1,228 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink is a RejectionSink which keeps the records it receives.
+type recordingSink struct {
+	mu      sync.Mutex
+	records []RejectionRecord
+	err     error
+	panics  bool
+}
+
+func (s *recordingSink) RecordRejection(record RejectionRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	if s.panics {
+		panic("sink down")
+	}
+	return s.err
+}
+
+// vaultHandler is a ResourceHandler requiring an API key whose resources have a
+// sensitive password.
+type vaultHandler struct {
+	BaseResourceHandler
+}
+
+func (a vaultHandler) ResourceName() string {
+	return "vaults"
+}
+
+func (a vaultHandler) Authenticate(r *http.Request) error {
+	if r.Header.Get("X-Api-Key") == "" {
+		return UnauthorizedRequest("Missing API key")
+	}
+	return nil
+}
+
+func (a vaultHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil),
+		&Rule{Field: "name", Required: true},
+		&Rule{Field: "password", Sensitive: true, InputOnly: true},
+	)
+}
+
+func (a vaultHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return nil, ResourceNotPermitted("Account belongs to another tenant")
+}
+
+// serveVault sends a request with the API key, if any, and body to the API.
+func serveVault(api API, method, url, key, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set(RequestIDHeader, "req-1")
+	if key != "" {
+		req.Header.Set("X-Api-Key", key)
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that authentication, authorization, validation, and rate limit rejections
+// are recorded with their context and counted per stage, with payloads redacted.
+func TestRejectionAuditStages(t *testing.T) {
+	assert := assert.New(t)
+	sink := &recordingSink{}
+	api := NewAPI(&Configuration{RejectionAudit: &RejectionAudit{
+		Sink:            sink,
+		MaxPayloadBytes: 1024,
+		Principal: func(r *http.Request) interface{} {
+			return map[string]string{"key": r.Header.Get("X-Api-Key")}
+		},
+	}})
+	api.RegisterResourceHandler(vaultHandler{}, RateLimit{Tier: func(string) RateLimitTier {
+		return RateLimitTier{Limit: 3, Window: time.Hour}
+	}})
+
+	serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "", "")
+	serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "k", "")
+	serveVault(api, "POST", "http://foo.com/api/v1/vaults", "k", `{"password":"hunter2"}`)
+	serveVault(api, "GET", "http://foo.com/api/v1/vaults", "k", "")
+	resp := serveVault(api, "GET", "http://foo.com/api/v1/vaults", "k", "")
+	assert.Equal(http.StatusTooManyRequests, resp.Code)
+
+	if assert.Len(sink.records, 4) {
+		auth := sink.records[0]
+		assert.Equal(RejectedAuthentication, auth.Stage)
+		assert.Equal(http.StatusUnauthorized, auth.Status)
+		assert.Equal("192.0.2.1", auth.ClientIP)
+		assert.Equal("vaults", auth.Resource)
+		assert.Equal("GET", auth.Method)
+		assert.Equal("req-1", auth.RequestID)
+		assert.False(auth.Timestamp.IsZero())
+
+		assert.Equal(RejectedAuthorization, sink.records[1].Stage)
+		assert.Equal(http.StatusForbidden, sink.records[1].Status)
+		assert.Equal(map[string]interface{}{"key": "k"}, sink.records[1].Principal)
+
+		validation := sink.records[2]
+		assert.Equal(RejectedValidation, validation.Stage)
+		assert.Equal(http.StatusUnprocessableEntity, validation.Status)
+		assert.JSONEq(`{"password":"[REDACTED]"}`, string(validation.Payload))
+
+		assert.Equal(RejectedRateLimit, sink.records[3].Stage)
+		assert.Equal(RateLimitedCode, sink.records[3].Code)
+	}
+	for _, stage := range []RejectionStage{RejectedAuthentication, RejectedAuthorization,
+		RejectedValidation, RejectedRateLimit} {
+		assert.Equal(uint64(1), api.Metrics().Counter(RejectionsCounterPrefix+string(stage),
+			"vaults"), string(stage))
+	}
+}
+
+// Ensures that rejections beyond MaxPerSecond and those of unselected stages or
+// resources are counted but not recorded.
+func TestRejectionAuditSampling(t *testing.T) {
+	assert := assert.New(t)
+	sink := &recordingSink{}
+	now := time.Unix(1000, 0)
+	audit := &RejectionAudit{Sink: sink, MaxPerSecond: 2, now: func() time.Time { return now }}
+	api := NewAPI(&Configuration{RejectionAudit: audit})
+	api.RegisterResourceHandler(vaultHandler{})
+
+	for i := 0; i < 5; i++ {
+		serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "", "")
+	}
+	assert.Len(sink.records, 2)
+	assert.Equal(uint64(5), api.Metrics().Counter(
+		RejectionsCounterPrefix+string(RejectedAuthentication), "vaults"))
+	assert.Equal(uint64(3), api.Metrics().Counter(RejectionsDroppedCounter, "vaults"))
+
+	now = now.Add(time.Second)
+	serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "", "")
+	assert.Len(sink.records, 3)
+
+	audit.Stages = []RejectionStage{RejectedValidation}
+	serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "", "")
+	audit.Stages = nil
+	audit.Resources = []string{"other"}
+	serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "", "")
+	assert.Len(sink.records, 3)
+	assert.Equal(uint64(8), api.Metrics().Counter(
+		RejectionsCounterPrefix+string(RejectedAuthentication), "vaults"))
+}
+
+// Ensures that sink errors and panics are logged without affecting the response.
+func TestRejectionAuditSinkFailure(t *testing.T) {
+	assert := assert.New(t)
+	for _, sink := range []*recordingSink{{err: fmt.Errorf("disk full")}, {panics: true}} {
+		api := NewAPI(&Configuration{RejectionAudit: &RejectionAudit{Sink: sink}})
+		api.RegisterResourceHandler(vaultHandler{})
+
+		resp := serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "", "")
+		assert.Equal(http.StatusUnauthorized, resp.Code)
+		assert.Equal("Missing API key", resp.Body.String())
+		assert.Len(sink.records, 1)
+	}
+}
+
+// Ensures that rejections are counted per stage when there's no RejectionAudit.
+func TestRejectionsCountedWithoutAudit(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(vaultHandler{})
+
+	serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "", "")
+	serveVault(api, "GET", "http://foo.com/api/v1/vaults/1", "k", "")
+
+	assert.Equal(uint64(1), api.Metrics().Counter(
+		RejectionsCounterPrefix+string(RejectedAuthentication), "vaults"))
+	assert.Equal(uint64(1), api.Metrics().Counter(
+		RejectionsCounterPrefix+string(RejectedAuthorization), "vaults"))
+}
+
+// Ensures that only MaxPayloadBytes of the request body are buffered for records, and
+// that truncated bodies with fields to redact aren't included.
+func TestRejectionAuditPayloadLimit(t *testing.T) {
+	assert := assert.New(t)
+	sink := &recordingSink{}
+	api := NewAPI(&Configuration{RejectionAudit: &RejectionAudit{Sink: sink,
+		MaxPayloadBytes: 8}})
+	api.RegisterResourceHandler(vaultHandler{})
+	body := `{"password":"hunter2","note":"` + strings.Repeat("a", 1024) + `"}`
+
+	serveVault(api, "POST", "http://foo.com/api/v1/vaults", "k", body)
+
+	if assert.Len(sink.records, 1) {
+		assert.Nil(sink.records[0].Payload)
+		assert.True(sink.records[0].PayloadTruncated)
+	}
+
+	buffer := &limitedBuffer{max: 8}
+	n, err := buffer.Write([]byte(body))
+	assert.Nil(err)
+	assert.Equal(len(body), n)
+	assert.Equal(`{"passwo`, buffer.String())
+	assert.True(buffer.truncated)
+}
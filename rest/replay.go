@@ -0,0 +1,362 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// replayHeader is the request header marking replayed requests. Its value is a token
+// generated when the process starts, so clients can't mark their own requests.
+const replayHeader = "X-Rest-Replay"
+
+// replayToken is the value of the replayHeader on requests sent by Replay.
+var replayToken = newReplayToken()
+
+// newReplayToken returns a random token.
+func newReplayToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Recording is a captured request and its response in a form which can be saved and
+// replayed using Replay. Credential headers are never recorded and sensitive fields
+// are redacted before the Recording is made.
+type Recording struct {
+	Resource          string           `json:"resource"`
+	Method            string           `json:"method"`
+	URL               string           `json:"url"`
+	Header            http.Header      `json:"header"`
+	Body              string           `json:"body,omitempty"`
+	BodyTruncated     bool             `json:"body_truncated,omitempty"`
+	Principal         interface{}      `json:"principal,omitempty"`
+	ConfigFingerprint string           `json:"config_fingerprint"`
+	Response          RecordedResponse `json:"response"`
+}
+
+// RecordedResponse is the response to a recorded request.
+type RecordedResponse struct {
+	Status        int         `json:"status"`
+	Header        http.Header `json:"header"`
+	Body          string      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// Recording returns the CapturedExchange as a Recording.
+func (c CapturedExchange) Recording() Recording {
+	return Recording{
+		Resource:          c.Resource,
+		Method:            c.Method,
+		URL:               c.URL,
+		Header:            c.RequestHeader,
+		Body:              string(c.RequestBody),
+		BodyTruncated:     c.RequestTruncated,
+		Principal:         c.Principal,
+		ConfigFingerprint: c.ConfigFingerprint,
+		Response: RecordedResponse{
+			Status:        c.Status,
+			Header:        c.ResponseHeader,
+			Body:          string(c.ResponseBody),
+			BodyTruncated: c.ResponseTruncated,
+		},
+	}
+}
+
+// RecordingSink returns a BodyCapture Sink which writes a Recording of each triggered
+// exchange, e.g. one requested using the debug header, to a JSON file in the
+// directory. Sampled exchanges are ignored.
+func RecordingSink(dir string) func(CapturedExchange) {
+	return func(exchange CapturedExchange) {
+		if !exchange.Triggered {
+			return
+		}
+		encoded, err := json.MarshalIndent(exchange.Recording(), "", "  ")
+		if err != nil {
+			log.Printf("Failed to encode recording: %s", err)
+			return
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%s-%d.json",
+			fileNamePrefix(exchange.Resource), time.Now().UnixNano()))
+		if err := ioutil.WriteFile(file, encoded, 0644); err != nil {
+			log.Printf("Failed to write recording: %s", err)
+		}
+	}
+}
+
+// ReplayResult is the outcome of replaying a Recording.
+type ReplayResult struct {
+	Recording Recording
+	Status    int
+	Header    http.Header
+	Body      []byte
+
+	// Diff describes each difference between the replayed response and the recorded
+	// one. It's empty if they match. Redacted and truncated values aren't compared.
+	Diff []string
+}
+
+// Replay re-executes the request recorded in the capture file against the API through
+// its full request pipeline and compares the response to the recorded one. Replayed
+// requests are marked so handlers can avoid real side effects using
+// RequestContext#Replayed or, in Authenticate, IsReplay. Since credentials aren't
+// recorded, Authenticate must accept replayed requests for them to reach the handler.
+// Sensitive fields are replayed with their redacted values.
+func Replay(api API, captureFile string) (*ReplayResult, error) {
+	data, err := ioutil.ReadFile(captureFile)
+	if err != nil {
+		return nil, err
+	}
+	var recording Recording
+	if err := json.Unmarshal(data, &recording); err != nil {
+		return nil, err
+	}
+
+	resp, err := replayRecording(api, recording)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReplayResult{
+		Recording: recording,
+		Status:    resp.Code,
+		Header:    resp.Header(),
+		Body:      resp.Body.Bytes(),
+	}
+	if fingerprint := configFingerprint(api.Configuration()); recording.ConfigFingerprint != "" &&
+		recording.ConfigFingerprint != fingerprint {
+		result.Diff = append(result.Diff, fmt.Sprintf("config fingerprint: recorded %s, replayed %s",
+			recording.ConfigFingerprint, fingerprint))
+	}
+	if recording.Response.Status != resp.Code {
+		result.Diff = append(result.Diff, fmt.Sprintf("status: recorded %d, replayed %d",
+			recording.Response.Status, resp.Code))
+	}
+	if !recording.Response.BodyTruncated {
+		result.Diff = append(result.Diff,
+			replayDiff.bodies([]byte(recording.Response.Body), result.Body)...)
+	}
+	return result, nil
+}
+
+// replayRecording sends the request in the Recording, marked as a replay, to the API
+// and returns the response.
+func replayRecording(api API, recording Recording) (*httptest.ResponseRecorder, error) {
+	url := recording.URL
+	if strings.HasPrefix(url, "/") {
+		url = "http://replay" + url
+	}
+	req, err := http.NewRequest(recording.Method, url, bytes.NewBufferString(recording.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI = req.URL.RequestURI()
+	for name, values := range recording.Header {
+		req.Header[name] = append([]string{}, values...)
+	}
+	req.Header.Set(replayHeader, replayToken)
+
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp, nil
+}
+
+// IsReplay returns true if the request was sent by Replay.
+func IsReplay(r *http.Request) bool {
+	token := r.Header.Get(replayHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(replayToken)) == 1
+}
+
+// valueDiff compares decoded JSON values, describing differences using the labels of
+// the two sides. Paths for which ignored returns true aren't compared.
+type valueDiff struct {
+	labels  [2]string
+	ignored func(path string) bool
+}
+
+// replayDiff compares recorded responses to replayed ones.
+var replayDiff = valueDiff{labels: [2]string{"recorded", "replayed"}}
+
+// bodies returns the differences between the bodies. JSON bodies are compared by
+// value, skipping redacted values.
+func (d valueDiff) bodies(a, b []byte) []string {
+	var aValue, bValue interface{}
+	if unmarshalJSON(a, &aValue, true) != nil || unmarshalJSON(b, &bValue, true) != nil {
+		if bytes.Equal(a, b) {
+			return nil
+		}
+		return []string{fmt.Sprintf("body: %s %q, %s %q", d.labels[0], a, d.labels[1], b)}
+	}
+	return d.values("body", aValue, bValue)
+}
+
+// values returns the differences between decoded JSON values at the path.
+func (d valueDiff) values(path string, a, b interface{}) []string {
+	if a == redactedValue || d.ignored != nil && d.ignored(path) {
+		return nil
+	}
+
+	aMap, aIsMap := a.(map[string]interface{})
+	bMap, bIsMap := b.(map[string]interface{})
+	if aIsMap && bIsMap {
+		keys := make([]string, 0, len(aMap)+len(bMap))
+		for key := range aMap {
+			keys = append(keys, key)
+		}
+		for key := range bMap {
+			if _, ok := aMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		diffs := []string{}
+		for _, key := range keys {
+			diffs = append(diffs, d.values(path+"."+key, aMap[key], bMap[key])...)
+		}
+		return diffs
+	}
+
+	aSlice, aIsSlice := a.([]interface{})
+	bSlice, bIsSlice := b.([]interface{})
+	if aIsSlice && bIsSlice && len(aSlice) == len(bSlice) {
+		diffs := []string{}
+		for i := range aSlice {
+			diffs = append(diffs, d.values(fmt.Sprintf("%s[%d]", path, i),
+				aSlice[i], bSlice[i])...)
+		}
+		return diffs
+	}
+
+	aNumber, aIsNumber := a.(json.Number)
+	bNumber, bIsNumber := b.(json.Number)
+	if aIsNumber && bIsNumber {
+		aRat, aOK := numberValue(aNumber)
+		bRat, bOK := numberValue(bNumber)
+		if aOK && bOK && aRat.Cmp(bRat) == 0 {
+			return nil
+		}
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	return []string{fmt.Sprintf("%s: %s %s, %s %s", path, d.labels[0], aJSON, d.labels[1], bJSON)}
+}
+
+// unfingerprintedFields are the Configuration fields which don't affect how requests
+// are processed, so they're excluded from its fingerprint. Every other field is
+// included, so fields added to the Configuration are fingerprinted by default.
+var unfingerprintedFields = map[string]bool{
+	"Logger":              true,
+	"GenerateDocs":        true,
+	"DocsDirectory":       true,
+	"ExamplesDirectory":   true,
+	"StatsURI":            true,
+	"OnMaintenanceChange": true,
+	"Store":               true,
+	"RuntimeFlags":        true,
+}
+
+// configFingerprint returns a hash of the Configuration settings which affect how
+// requests are processed.
+func configFingerprint(config *Configuration) string {
+	var settings bytes.Buffer
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" || unfingerprintedFields[field.Name] {
+			continue
+		}
+		settings.WriteString(field.Name + "=")
+		writeFingerprint(&settings, value.Field(i))
+		settings.WriteString("|")
+	}
+	sum := sha256.Sum256(settings.Bytes())
+	return hex.EncodeToString(sum[:8])
+}
+
+// writeFingerprint writes an encoding of the value's data which is the same in every
+// process, following pointers and skipping functions, interfaces, channels, and
+// unexported fields other than those of Times.
+func writeFingerprint(buf *bytes.Buffer, value reflect.Value) {
+	if t, ok := value.Interface().(time.Time); ok {
+		buf.WriteString(t.UTC().Format(time.RFC3339Nano))
+		return
+	}
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			buf.WriteString("nil")
+			return
+		}
+		writeFingerprint(buf, value.Elem())
+	case reflect.Func, reflect.Interface, reflect.Chan, reflect.UnsafePointer:
+		buf.WriteString("-")
+	case reflect.Struct:
+		buf.WriteString("{")
+		for i := 0; i < value.NumField(); i++ {
+			if field := value.Type().Field(i); field.PkgPath == "" {
+				buf.WriteString(field.Name + ":")
+				writeFingerprint(buf, value.Field(i))
+				buf.WriteString(",")
+			}
+		}
+		buf.WriteString("}")
+	case reflect.Slice, reflect.Array:
+		buf.WriteString("[")
+		for i := 0; i < value.Len(); i++ {
+			writeFingerprint(buf, value.Index(i))
+			buf.WriteString(",")
+		}
+		buf.WriteString("]")
+	case reflect.Map:
+		entries := make([]string, 0, value.Len())
+		for _, key := range value.MapKeys() {
+			var entry bytes.Buffer
+			writeFingerprint(&entry, key)
+			entry.WriteString(":")
+			writeFingerprint(&entry, value.MapIndex(key))
+			entries = append(entries, entry.String())
+		}
+		sort.Strings(entries)
+		buf.WriteString("{" + strings.Join(entries, ",") + "}")
+	case reflect.String:
+		fmt.Fprintf(buf, "%q", value.String())
+	default:
+		fmt.Fprintf(buf, "%v", value.Interface())
+	}
+}
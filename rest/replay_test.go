@@ -0,0 +1,176 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replayHandler is an accountHandler which requires a bearer token except for
+// replayed requests and records whether requests were replayed.
+type replayHandler struct {
+	accountHandler
+	suffix   string
+	replayed *bool
+}
+
+func (r replayHandler) Authenticate(req *http.Request) error {
+	if IsReplay(req) || req.Header.Get("Authorization") == "Bearer token" {
+		return nil
+	}
+	return fmt.Errorf("Not authorized")
+}
+
+func (r replayHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	*r.replayed = ctx.Replayed()
+	resource, err := r.accountHandler.CreateResource(ctx, data, version)
+	resource.(*account).Name += r.suffix
+	return resource, err
+}
+
+// recordAccount records a triggered create request to the directory and returns the
+// recording file.
+func recordAccount(t *testing.T, dir string) string {
+	replayed := false
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(replayHandler{replayed: &replayed}, BodyCapture{
+		Sink:        RecordingSink(dir),
+		DebugHeader: "X-Debug-Capture",
+		DebugToken:  "secret",
+		Redact:      []string{"ssn"},
+		Principal: func(r *http.Request) interface{} {
+			return map[string]string{"user": "bob", "password": "hunter2"}
+		},
+	})
+	postAccount(api, map[string]string{"Authorization": "Bearer token"})
+	postAccount(api, map[string]string{"Authorization": "Bearer token",
+		"X-Debug-Capture": "secret"})
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 recording, got %d", len(files))
+	}
+	return files[0]
+}
+
+// Ensures that triggered requests are recorded with credentials and sensitive fields
+// removed, including from the principal.
+func TestRecordingSink(t *testing.T) {
+	assert := assert.New(t)
+	dir, _ := ioutil.TempDir("", "recordings")
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile(recordAccount(t, dir))
+	assert.Nil(err)
+	var recording Recording
+	assert.Nil(json.Unmarshal(data, &recording))
+
+	assert.Equal("accounts", recording.Resource)
+	assert.Equal("POST", recording.Method)
+	assert.Equal("http://foo.com/api/v1/accounts", recording.URL)
+	assert.Equal("", recording.Header.Get("Authorization"))
+	assert.Equal("", recording.Header.Get("X-Debug-Capture"))
+	assert.Equal(`{"name":"bob","password":"[REDACTED]","profile":{"age":40,"ssn":"[REDACTED]"}}`,
+		recording.Body)
+	assert.Equal(map[string]interface{}{"user": "bob", "password": "[REDACTED]"},
+		recording.Principal)
+	assert.Equal(configFingerprint(&Configuration{}), recording.ConfigFingerprint)
+	assert.Equal(http.StatusCreated, recording.Response.Status)
+}
+
+// Ensures that Replay re-executes a recording through the pipeline with the request
+// marked as replayed and reports differences from the recorded response.
+func TestReplay(t *testing.T) {
+	assert := assert.New(t)
+	dir, _ := ioutil.TempDir("", "recordings")
+	defer os.RemoveAll(dir)
+	file := recordAccount(t, dir)
+
+	replayed := false
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(replayHandler{replayed: &replayed})
+	result, err := Replay(api, file)
+	assert.Nil(err)
+	assert.True(replayed)
+	assert.Equal(http.StatusCreated, result.Status)
+	assert.Empty(result.Diff)
+
+	api = NewAPI(&Configuration{ValidateUTF8: true})
+	api.RegisterResourceHandler(replayHandler{suffix: "by", replayed: &replayed})
+	result, err = Replay(api, file)
+	assert.Nil(err)
+	if assert.Len(result.Diff, 2) {
+		assert.Contains(result.Diff[0], "config fingerprint")
+		assert.Equal(`body.result.name: recorded "bob", replayed "bobby"`, result.Diff[1])
+	}
+
+	replayed = false
+	postAccount(api, map[string]string{replayHeader: "guess", "Authorization": "Bearer token"})
+	assert.False(replayed)
+
+	_, err = Replay(api, filepath.Join(dir, "missing.json"))
+	assert.NotNil(err)
+}
+
+// Ensures that every Configuration field which isn't excluded from the fingerprint
+// changes it, so fields added to the Configuration are fingerprinted, and that the
+// fingerprint is the same for equal Configurations.
+func TestConfigFingerprintFields(t *testing.T) {
+	assert := assert.New(t)
+	base := configFingerprint(&Configuration{})
+	assert.Equal(base, configFingerprint(&Configuration{}))
+
+	configType := reflect.TypeOf(Configuration{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		if unfingerprintedFields[field.Name] {
+			continue
+		}
+		config := &Configuration{}
+		value := reflect.ValueOf(config).Elem().Field(i)
+		switch field.Type.Kind() {
+		case reflect.Bool:
+			value.SetBool(true)
+		case reflect.String:
+			value.SetString("x")
+		case reflect.Int, reflect.Int64:
+			value.SetInt(1)
+		case reflect.Slice:
+			value.Set(reflect.MakeSlice(field.Type, 1, 1))
+		case reflect.Ptr:
+			value.Set(reflect.New(field.Type.Elem()))
+		}
+		assert.NotEqual(base, configFingerprint(config), field.Name)
+	}
+
+	maintenance := &Configuration{Maintenance: &MaintenanceWindow{Start: time.Unix(0, 0)}}
+	assert.NotEqual(configFingerprint(&Configuration{Maintenance: &MaintenanceWindow{}}),
+		configFingerprint(maintenance))
+	assert.Equal(configFingerprint(maintenance), configFingerprint(&Configuration{
+		Maintenance: &MaintenanceWindow{Start: time.Unix(0, 0).In(time.FixedZone("", 3600))}}))
+}
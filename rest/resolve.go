@@ -0,0 +1,218 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PreconditionFailedCode is the error code of responses to requests whose If-Match
+// header doesn't match the resource's ETag.
+const PreconditionFailedCode = "precondition_failed"
+
+// ResourceRef is a lightweight reference to a resource, resolved without loading it.
+type ResourceRef struct {
+	// ID is the resource's ID.
+	ID string
+
+	// Exists indicates if the resource exists. Requests for resources which don't
+	// receive a 404.
+	Exists bool
+
+	// ETag is the resource's entity tag, e.g. a hash or revision of its contents,
+	// returned in the ETag header and compared to If-Match and If-None-Match headers.
+	// It's quoted if it isn't already, and may be weak, e.g. W/"3".
+	ETag string
+
+	// LastModified is when the resource last changed, returned in the Last-Modified
+	// header and compared to If-Modified-Since headers. Zero if unknown.
+	LastModified time.Time
+
+	// SizeHint is the approximate size of the resource in bytes, returned to HEAD
+	// requests in the X-Resource-Size header. Zero if unknown.
+	SizeHint int64
+
+	// Value is anything the ResourceResolver needs to materialize the resource, e.g.
+	// the row's primary key.
+	Value interface{}
+}
+
+// ResourceResolver is implemented by ResourceHandlers which can resolve a cheap
+// ResourceRef to a resource before loading it. For reads, the framework resolves the
+// resource, responds to HEAD requests, conditional requests whose If-None-Match or
+// If-Modified-Since header matches, and those whose If-Match header doesn't, from
+// the ResourceRef, and only calls MaterializeResource when the response needs a
+// body. Updates and deletes with If-Match headers which don't match the ResourceRef's
+// ETag receive a 412 without the handler being invoked. ResourceHandlers which don't
+// implement it, are registered with LookupKeys, or whose ReadResource is decorated
+// with WrapRead, read resources in one phase using ReadResource.
+type ResourceResolver interface {
+	// ResolveResource returns a ResourceRef for the resource with the ID and version.
+	ResolveResource(RequestContext, string, string) (ResourceRef, error)
+
+	// MaterializeResource loads the resource the ResourceRef refers to.
+	MaterializeResource(RequestContext, ResourceRef) (Resource, error)
+}
+
+// resolverFor returns the ResourceHandler's ResourceResolver and true if it reads
+// resources in two phases.
+func resolverFor(h ResourceHandler) (ResourceResolver, bool) {
+	if lookupKeys(h) != nil || readDecorated(h) {
+		return nil, false
+	}
+	resolver, ok := unwrapHandler(h).(ResourceResolver)
+	return resolver, ok
+}
+
+// resolve returns the ResourceRef for the request's resource, or a 404 Error if it
+// doesn't exist.
+func resolve(ctx RequestContext, resolver ResourceResolver) (ResourceRef, error) {
+	ref, err := resolver.ResolveResource(ctx, ctx.ResourceID(), ctx.Version())
+	if err == nil && !ref.Exists {
+		err = ResourceNotFound("Resource " + ctx.ResourceID() + " not found")
+	}
+	return ref, err
+}
+
+// handleResolvedRead responds to a read of a resource using the ResourceResolver,
+// materializing it only if the response needs a body.
+func (h requestHandler) handleResolvedRead(w http.ResponseWriter, r *http.Request,
+	handler ResourceHandler, resolver ResourceResolver) {
+
+	ctx := NewContext(nil, r)
+	ref, err := resolve(ctx, resolver)
+	if err != nil {
+		h.sendResponse(w, ctx.setError(err))
+		return
+	}
+
+	setValidators(w.Header(), ref)
+	if err := checkIfMatch(r, ref); err != nil {
+		h.sendResponse(w, ctx.setError(err))
+		return
+	}
+	if notModified(r, ref) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == "HEAD" {
+		if ref.SizeHint > 0 {
+			w.Header().Set("X-Resource-Size", strconv.FormatInt(ref.SizeHint, 10))
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	version := ctx.Version()
+	rules := handler.Rules()
+	resource, err := resolver.MaterializeResource(ctx, ref)
+	if err == nil {
+		resource = applyOutboundRules(resource, rules, version)
+		resource, err = h.enforceOutput(handler, resource, rules, version)
+	}
+	ctx = ctx.setResult(resource)
+	ctx = ctx.setError(err)
+	ctx = ctx.setStatus(http.StatusOK)
+	h.sendResponse(w, ctx)
+}
+
+// checkWritePrecondition returns a 412 Error if the request has an If-Match header
+// which doesn't match the ETag of the resource resolved by the ResourceHandler's
+// ResourceResolver, if it has one.
+func checkWritePrecondition(ctx RequestContext, handler ResourceHandler) error {
+	r, ok := ctx.Request()
+	if !ok || r.Header.Get("If-Match") == "" {
+		return nil
+	}
+	resolver, ok := resolverFor(handler)
+	if !ok {
+		return nil
+	}
+	ref, err := resolve(ctx, resolver)
+	if err != nil {
+		return err
+	}
+	return checkIfMatch(r, ref)
+}
+
+// setValidators sets the ETag and Last-Modified headers from the ResourceRef.
+func setValidators(header http.Header, ref ResourceRef) {
+	if ref.ETag != "" {
+		header.Set("ETag", formatETag(ref.ETag))
+	}
+	if !ref.LastModified.IsZero() {
+		header.Set("Last-Modified", ref.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// checkIfMatch returns a 412 Error if the request's If-Match header doesn't match the
+// ResourceRef's ETag using the strong comparison.
+func checkIfMatch(r *http.Request, ref ResourceRef) error {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || etagListMatches(ifMatch, ref.ETag, false) {
+		return nil
+	}
+	return PreconditionFailed("Resource " + ref.ID + " has changed").WithCode(PreconditionFailedCode)
+}
+
+// notModified returns true if the request's If-None-Match header matches the
+// ResourceRef's ETag using the weak comparison or, without one, its
+// If-Modified-Since header isn't before the ResourceRef's LastModified.
+func notModified(r *http.Request, ref ResourceRef) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagListMatches(ifNoneMatch, ref.ETag, true)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || ref.LastModified.IsZero() {
+		return false
+	}
+	return !ref.LastModified.Truncate(time.Second).After(since)
+}
+
+// formatETag returns the entity tag quoted if it isn't already.
+func formatETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// etagListMatches returns true if the comma-separated list of entity tags, or *,
+// matches the ETag. Weak tags only match using the weak comparison.
+func etagListMatches(list, etag string, weak bool) bool {
+	if etag == "" {
+		return strings.TrimSpace(list) == "*"
+	}
+	etag = formatETag(etag)
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
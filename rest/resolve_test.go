@@ -0,0 +1,157 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blobModified is when every blob served by blobHandler last changed.
+var blobModified = time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// blobHandler is a ResourceHandler which resolves blobs before materializing them and
+// counts how often each phase and delete are invoked.
+type blobHandler struct {
+	BaseResourceHandler
+	resolved     *int
+	materialized *int
+	deleted      *int
+}
+
+func (b blobHandler) ResourceName() string {
+	return "blobs"
+}
+
+func (b blobHandler) ResolveResource(ctx RequestContext, id string,
+	version string) (ResourceRef, error) {
+	*b.resolved++
+	return ResourceRef{ID: id, Exists: id != "missing", ETag: "rev-" + id,
+		LastModified: blobModified, SizeHint: 2048, Value: "row-" + id}, nil
+}
+
+func (b blobHandler) MaterializeResource(ctx RequestContext, ref ResourceRef) (Resource, error) {
+	*b.materialized++
+	return Payload{"id": ref.ID, "row": ref.Value}, nil
+}
+
+func (b blobHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	*b.deleted++
+	return Payload{"id": id}, nil
+}
+
+// newBlobAPI returns an API serving blobs and the counts of blobHandler invocations.
+func newBlobAPI() (API, *blobHandler) {
+	handler := &blobHandler{resolved: new(int), materialized: new(int), deleted: new(int)}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(*handler)
+	return api, handler
+}
+
+// serveConditional sends a request with the header to the API.
+func serveConditional(api API, method, url, name, value string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, nil)
+	if name != "" {
+		req.Header.Set(name, value)
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that a ResourceResolver's resources are materialized for GET requests
+// needing a body, which carry the ResourceRef's validators.
+func TestResolvedRead(t *testing.T) {
+	assert := assert.New(t)
+	api, handler := newBlobAPI()
+
+	resp := serveConditional(api, "GET", "http://foo.com/api/v1/blobs/1", "", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(`{"messages":[],"reason":"OK","result":{"id":"1","row":"row-1"},"status":200}`,
+		resp.Body.String())
+	assert.Equal(`"rev-1"`, resp.Header().Get("ETag"))
+	assert.Equal("Sun, 01 Jun 2014 12:00:00 GMT", resp.Header().Get("Last-Modified"))
+	assert.Equal(1, *handler.materialized)
+
+	resp = serveConditional(api, "GET", "http://foo.com/api/v1/blobs/1", "If-None-Match", `"rev-0"`)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(2, *handler.materialized)
+
+	resp = serveConditional(api, "GET", "http://foo.com/api/v1/blobs/missing", "", "")
+	assert.Equal(http.StatusNotFound, resp.Code)
+	assert.Equal(2, *handler.materialized)
+}
+
+// Ensures that 304 responses to conditional GETs and responses to HEAD requests are
+// produced from the ResourceRef without calling MaterializeResource.
+func TestResolvedReadNotModified(t *testing.T) {
+	assert := assert.New(t)
+	api, handler := newBlobAPI()
+
+	resp := serveConditional(api, "GET", "http://foo.com/api/v1/blobs/1", "If-None-Match",
+		`"rev-0", W/"rev-1"`)
+	assert.Equal(http.StatusNotModified, resp.Code)
+	assert.Equal("", resp.Body.String())
+	assert.Equal(`"rev-1"`, resp.Header().Get("ETag"))
+
+	resp = serveConditional(api, "GET", "http://foo.com/api/v1/blobs/1", "If-None-Match", "*")
+	assert.Equal(http.StatusNotModified, resp.Code)
+
+	resp = serveConditional(api, "GET", "http://foo.com/api/v1/blobs/1", "If-Modified-Since",
+		blobModified.Format(http.TimeFormat))
+	assert.Equal(http.StatusNotModified, resp.Code)
+
+	resp = serveConditional(api, "HEAD", "http://foo.com/api/v1/blobs/1", "", "")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal("", resp.Body.String())
+	assert.Equal(`"rev-1"`, resp.Header().Get("ETag"))
+	assert.Equal("2048", resp.Header().Get("X-Resource-Size"))
+
+	assert.Equal(4, *handler.resolved)
+	assert.Equal(0, *handler.materialized)
+
+	resp = serveConditional(api, "GET", "http://foo.com/api/v1/blobs/1", "If-Modified-Since",
+		blobModified.Add(-time.Second).Format(http.TimeFormat))
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(1, *handler.materialized)
+}
+
+// Ensures that reads and deletes whose If-Match header doesn't match the ResourceRef's
+// ETag receive a 412 without the resource being materialized or deleted.
+func TestResolvedIfMatch(t *testing.T) {
+	assert := assert.New(t)
+	api, handler := newBlobAPI()
+
+	resp := serveConditional(api, "GET", "http://foo.com/api/v1/blobs/1", "If-Match", `W/"rev-1"`)
+	assert.Equal(http.StatusPreconditionFailed, resp.Code)
+	assert.Equal(`{"code":"precondition_failed","messages":["Resource 1 has changed"],`+
+		`"reason":"Precondition Failed","status":412}`, resp.Body.String())
+
+	resp = serveConditional(api, "DELETE", "http://foo.com/api/v1/blobs/1", "If-Match", `"rev-0"`)
+	assert.Equal(http.StatusPreconditionFailed, resp.Code)
+	assert.Equal(0, *handler.deleted)
+
+	resp = serveConditional(api, "DELETE", "http://foo.com/api/v1/blobs/1", "If-Match", `"rev-1"`)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(1, *handler.deleted)
+	assert.Equal(0, *handler.materialized)
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	gcontext "github.com/gorilla/context"
+)
+
+const (
+	// RetriesCounter counts handler invocations which were retried after a retryable
+	// error.
+	RetriesCounter = "retries"
+
+	// IdempotencyKeyHeader is the request header clients use to mark a mutating
+	// request as safe to retry.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// RetryAttemptsHeader is the response header which reports how many times the
+	// handler was invoked for the request. It's only sent in debug mode.
+	RetryAttemptsHeader = "X-Retry-Attempts"
+
+	// defaultRetryAttempts is the maximum number of attempts if Retries doesn't
+	// specify one.
+	defaultRetryAttempts = 3
+)
+
+// retryAttemptsKey is the request context key under which the number of handler
+// attempts is recorded.
+type retryAttemptsKey struct{}
+
+// Retries is a ResourceOption which retries ResourceHandler methods that fail with
+// transient errors, such as serialization conflicts or deadlocks. Reads are always
+// retried. Creates, updates, and deletes are only retried if the resource is marked
+// Idempotent or the request includes an Idempotency-Key header.
+//
+// Each attempt receives its own copy of the request Payload, so changes a handler
+// makes to it don't leak into the next attempt. The delay between attempts grows
+// exponentially from Backoff with random jitter. No attempt is made which would
+// begin after the RequestContext's deadline, and retrying stops if the
+// RequestContext is canceled. When combined with Transactions, each attempt runs in
+// its own transaction.
+type Retries struct {
+	// Retryable reports whether an error is transient and the method may be retried.
+	// Nothing is retried if it's nil.
+	Retryable func(error) bool
+
+	// MaxAttempts is the maximum number of times the method is invoked, including the
+	// first attempt. Defaults to 3.
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry. It doubles with each subsequent
+	// retry. Defaults to no delay.
+	Backoff time.Duration
+
+	// MaxBackoff caps the delay between attempts. Zero means no cap.
+	MaxBackoff time.Duration
+
+	// Indicates if the resource's create, update, and delete methods are idempotent
+	// and may be retried without an Idempotency-Key. Defaults to false.
+	Idempotent bool
+}
+
+// apply sets the Retries on the resource.
+func (r Retries) apply(opts *resourceOptions) {
+	opts.retries = &r
+}
+
+// maxAttempts returns the maximum number of attempts.
+func (r *Retries) maxAttempts() int {
+	if r.MaxAttempts <= 0 {
+		return defaultRetryAttempts
+	}
+	return r.MaxAttempts
+}
+
+// backoff returns the delay before the given retry, starting at 1, with jitter
+// applied such that it's between half and all of the exponential delay.
+func (r *Retries) backoff(retry int) time.Duration {
+	if r.Backoff <= 0 {
+		return 0
+	}
+	delay := r.Backoff
+	for i := 1; i < retry && (r.MaxBackoff <= 0 || delay < r.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if r.MaxBackoff > 0 && delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// retryingHandler is a ResourceHandler which retries the ResourceHandler methods as
+// configured by Retries.
+type retryingHandler struct {
+	ResourceHandler
+	retries *Retries
+	metrics Metrics
+}
+
+// unwrap returns the wrapped ResourceHandler.
+func (r retryingHandler) unwrap() ResourceHandler {
+	return r.ResourceHandler
+}
+
+// CreateResource invokes the wrapped ResourceHandler's CreateResource, retrying if
+// it's idempotent.
+func (r retryingHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+
+	return r.retry(ctx, r.mutationRetryable(ctx), func() (interface{}, error) {
+		return r.ResourceHandler.CreateResource(ctx, copyPayload(data), version)
+	})
+}
+
+// ReadResource invokes the wrapped ResourceHandler's ReadResource with retries.
+func (r retryingHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	return r.retry(ctx, true, func() (interface{}, error) {
+		return r.ResourceHandler.ReadResource(ctx, id, version)
+	})
+}
+
+// ReadResourceList invokes the wrapped ResourceHandler's ReadResourceList with
+// retries.
+func (r retryingHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+
+	var next string
+	result, err := r.retry(ctx, true, func() (interface{}, error) {
+		resources, nextCursor, err := r.ResourceHandler.ReadResourceList(
+			ctx, limit, cursor, version)
+		next = nextCursor
+		return resources, err
+	})
+	resources, _ := result.([]Resource)
+	return resources, next, err
+}
+
+// UpdateResourceList invokes the wrapped ResourceHandler's UpdateResourceList,
+// retrying if it's idempotent.
+func (r retryingHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+
+	result, err := r.retry(ctx, r.mutationRetryable(ctx), func() (interface{}, error) {
+		copied := make([]Payload, len(data))
+		for i, payload := range data {
+			copied[i] = copyPayload(payload)
+		}
+		return r.ResourceHandler.UpdateResourceList(ctx, copied, version)
+	})
+	resources, _ := result.([]Resource)
+	return resources, err
+}
+
+// UpdateResource invokes the wrapped ResourceHandler's UpdateResource, retrying if
+// it's idempotent.
+func (r retryingHandler) UpdateResource(ctx RequestContext, id string, data Payload,
+	version string) (Resource, error) {
+
+	return r.retry(ctx, r.mutationRetryable(ctx), func() (interface{}, error) {
+		return r.ResourceHandler.UpdateResource(ctx, id, copyPayload(data), version)
+	})
+}
+
+// DeleteResource invokes the wrapped ResourceHandler's DeleteResource, retrying if
+// it's idempotent.
+func (r retryingHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	return r.retry(ctx, r.mutationRetryable(ctx), func() (interface{}, error) {
+		return r.ResourceHandler.DeleteResource(ctx, id, version)
+	})
+}
+
+// mutationRetryable returns true if a mutating request may be retried, meaning the
+// resource is marked Idempotent or the request has an Idempotency-Key.
+func (r retryingHandler) mutationRetryable(ctx RequestContext) bool {
+	return r.retries.Idempotent || ctx.Header().Get(IdempotencyKeyHeader) != ""
+}
+
+// retry invokes the function until it succeeds, returns an error which isn't
+// retryable, or the attempts or time run out. The last result is returned. The
+// number of attempts is recorded on the request.
+func (r retryingHandler) retry(ctx RequestContext, retryable bool,
+	f func() (interface{}, error)) (result interface{}, err error) {
+
+	attempts := 0
+	defer func() {
+		if req, ok := ctx.Request(); ok {
+			gcontext.Set(req, retryAttemptsKey{}, attempts)
+		}
+	}()
+
+	for {
+		attempts++
+		result, err = f()
+		if err == nil || !retryable || r.retries.Retryable == nil ||
+			!r.retries.Retryable(err) || attempts >= r.retries.maxAttempts() {
+			return result, err
+		}
+
+		delay := r.retries.backoff(attempts)
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Add(delay).Before(deadline) {
+			return result, err
+		}
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(delay):
+		}
+		r.metrics.incr(RetriesCounter, r.ResourceName())
+	}
+}
+
+// setRetryAttemptsHeader sets the RetryAttemptsHeader if the handler was retried for
+// the request.
+func setRetryAttemptsHeader(w http.ResponseWriter, ctx RequestContext) {
+	req, ok := ctx.Request()
+	if !ok {
+		return
+	}
+	if attempts, ok := gcontext.GetOk(req, retryAttemptsKey{}); ok {
+		w.Header().Set(RetryAttemptsHeader, strconv.Itoa(attempts.(int)))
+	}
+}
+
+// copyPayload returns a deep copy of the Payload's maps and slices.
+func copyPayload(payload Payload) Payload {
+	if payload == nil {
+		return nil
+	}
+	copied := make(Payload, len(payload))
+	for key, value := range payload {
+		copied[key] = copyValue(value)
+	}
+	return copied
+}
+
+// copyValue returns a deep copy of decoded JSON maps and slices. Other values are
+// returned as-is.
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case Payload:
+		return copyPayload(v)
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			copied[key] = copyValue(val)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, val := range v {
+			copied[i] = copyValue(val)
+		}
+		return copied
+	default:
+		return value
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/stretchr/testify/assert"
+)
+
+// errDeadlock is a transient error used by the retry tests.
+var errDeadlock = fmt.Errorf("deadlock detected")
+
+// flakyHandler is a ResourceHandler which fails with errDeadlock a set number of
+// times before succeeding.
+type flakyHandler struct {
+	BaseResourceHandler
+	failures int
+	calls    int
+	payloads []Payload
+}
+
+func (f *flakyHandler) ResourceName() string {
+	return "foo"
+}
+
+func (f *flakyHandler) fail() error {
+	f.calls++
+	if f.calls <= f.failures {
+		return errDeadlock
+	}
+	return nil
+}
+
+func (f *flakyHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func (f *flakyHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	f.payloads = append(f.payloads, copyPayload(data))
+	data["foo"] = "modified"
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// retryDeadlocks is a Retries which retries errDeadlock.
+var retryDeadlocks = Retries{
+	Retryable: func(err error) bool { return err == errDeadlock },
+	Backoff:   time.Millisecond,
+}
+
+// Ensures that reads are retried until they succeed, retries are counted, and the
+// attempts are reported in debug mode.
+func TestRetriesRead(t *testing.T) {
+	assert := assert.New(t)
+	handler := &flakyHandler{failures: 2}
+	api := NewAPI(&Configuration{Debug: true, Logger: NewConfiguration().Logger})
+	api.RegisterResourceHandler(handler, retryDeadlocks)
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/foo/42", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(3, handler.calls)
+	assert.Equal("3", resp.Header().Get(RetryAttemptsHeader))
+	assert.Equal(uint64(2), api.Metrics().Counter(RetriesCounter, "foo"))
+}
+
+// Ensures that reads stop being retried after MaxAttempts and errors which aren't
+// retryable aren't retried.
+func TestRetriesExhausted(t *testing.T) {
+	assert := assert.New(t)
+	handler := &flakyHandler{failures: 5}
+	retries := retryDeadlocks
+	retries.MaxAttempts = 2
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, retries)
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/foo/42", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+	assert.Equal(2, handler.calls)
+	assert.Equal("", resp.Header().Get(RetryAttemptsHeader))
+
+	handler = &flakyHandler{failures: 5}
+	retries.Retryable = func(error) bool { return false }
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, retries)
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(1, handler.calls)
+}
+
+// Ensures that creates are only retried with an Idempotency-Key or when marked
+// Idempotent, and each attempt receives the original Payload.
+func TestRetriesCreate(t *testing.T) {
+	assert := assert.New(t)
+	handler := &flakyHandler{failures: 1}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, retryDeadlocks)
+
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v1/foo",
+		bytes.NewBufferString(`{"foo": "bar"}`))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+	assert.Equal(1, handler.calls)
+
+	handler.calls = 0
+	req, _ = http.NewRequest("POST", "http://foo.com/api/v1/foo",
+		bytes.NewBufferString(`{"foo": "bar"}`))
+	req.Header.Set(IdempotencyKeyHeader, "abc")
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal(2, handler.calls)
+	assert.Equal(Payload{"foo": "bar"}, handler.payloads[2])
+
+	handler = &flakyHandler{failures: 1}
+	retries := retryDeadlocks
+	retries.Idempotent = true
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler, retries)
+	req, _ = http.NewRequest("POST", "http://foo.com/api/v1/foo",
+		bytes.NewBufferString(`{"foo": "bar"}`))
+	resp = httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusCreated, resp.Code)
+	assert.Equal(2, handler.calls)
+}
+
+// Ensures that no retry is attempted which would begin after the RequestContext's
+// deadline.
+func TestRetriesDeadline(t *testing.T) {
+	assert := assert.New(t)
+	handler := &flakyHandler{failures: 2}
+	retries := retryDeadlocks
+	retries.Backoff = time.Hour
+	retrying := retryingHandler{handler, &retries, newMetrics()}
+
+	req, _ := http.NewRequest("GET", "http://foo.com/api/v1/foo/42", nil)
+	parent, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := retrying.ReadResource(NewContext(parent, req), "42", "1")
+
+	assert.Equal(errDeadlock, err)
+	assert.Equal(1, handler.calls)
+}
+
+// Ensures that backoff grows exponentially up to MaxBackoff with jitter.
+func TestRetriesBackoff(t *testing.T) {
+	assert := assert.New(t)
+	retries := &Retries{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	for i := 0; i < 10; i++ {
+		delay := retries.backoff(3)
+		assert.True(delay >= 200*time.Millisecond && delay <= 400*time.Millisecond)
+		delay = retries.backoff(10)
+		assert.True(delay >= 500*time.Millisecond && delay <= time.Second)
+	}
+	assert.Equal(time.Duration(0), (&Retries{}).backoff(1))
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteKind is the mechanism a route was registered with.
+type RouteKind string
+
+const (
+	// ResourceRoute is a route generated for a ResourceHandler.
+	ResourceRoute RouteKind = "resource"
+
+	// HandlerRoute is a route registered using API#RegisterHandler or
+	// API#RegisterHandlerFunc.
+	HandlerRoute RouteKind = "handler"
+
+	// PathPrefixRoute is a route registered using API#RegisterPathPrefix.
+	PathPrefixRoute RouteKind = "path_prefix"
+
+	// LegacyRoute is a route registered using API#MountLegacyHandler.
+	LegacyRoute RouteKind = "legacy"
+
+	// StatsRoute is the route serving the API's Stats at the Configuration's StatsURI.
+	StatsRoute RouteKind = "stats"
+
+	// FlagsRoute is a route serving the API's runtime flags at the Configuration's
+	// RuntimeFlagsEndpoint.
+	FlagsRoute RouteKind = "flags"
+)
+
+// routeSample is the value path variables are replaced with to build a request matching
+// a route when checking whether other routes shadow it.
+const routeSample = "route-sample"
+
+// RouteInfo describes a route registered with the API.
+type RouteInfo struct {
+	// Name is the route's name, e.g. widgets:read, or empty if it has none.
+	Name string
+
+	Kind RouteKind
+
+	// Method is the method the route serves, or empty if it serves every method.
+	Method string
+
+	// Path is the gorilla/mux path template of the route, or the prefix for
+	// PathPrefixRoutes.
+	Path string
+
+	// MethodOverride is the X-HTTP-Method-Override header value the route requires,
+	// if any.
+	MethodOverride string
+
+	// CallSite is the file:line of the call which registered the route.
+	CallSite string
+
+	route *mux.Route
+}
+
+// String describes the route and where it was registered.
+func (i RouteInfo) String() string {
+	method := i.Method
+	if method == "" {
+		method = "*"
+	}
+	if i.MethodOverride != "" {
+		method += " (" + i.MethodOverride + " override)"
+	}
+	path := i.Path
+	if i.Kind == PathPrefixRoute {
+		path += "*"
+	}
+	name := string(i.Kind)
+	if i.Name != "" {
+		name = i.Name
+	}
+	return fmt.Sprintf("%s %s (%s at %s)", method, path, name, i.CallSite)
+}
+
+// shape returns the route's path with its variables' names and patterns removed, so
+// routes serving the same paths have the same shape.
+func (i RouteInfo) shape() string {
+	return pathVars.ReplaceAllString(i.Path, "{}")
+}
+
+// sampleRequest returns a request which the route matches, with its path variables
+// replaced by the routeSample.
+func (i RouteInfo) sampleRequest() (*http.Request, error) {
+	method := i.Method
+	if method == "" {
+		method = "GET"
+	}
+	req, err := http.NewRequest(method, pathVars.ReplaceAllString(i.Path, routeSample), nil)
+	if err != nil {
+		return nil, err
+	}
+	if i.MethodOverride != "" {
+		req.Header.Set("X-HTTP-Method-Override", i.MethodOverride)
+	}
+	return req, nil
+}
+
+// RouteConflict describes a route which collides with or is shadowed by one registered
+// before it.
+type RouteConflict struct {
+	// Route is the route registered later, which loses to Other.
+	Route RouteInfo
+
+	// Other is the route registered first, which requests are routed to.
+	Other RouteInfo
+
+	// Shadowed is true if Other serves some of the Route's requests, e.g. an item route
+	// swallowing a later count route or a custom route swallowing some of a later item
+	// route's paths, and false if they serve exactly the same requests.
+	Shadowed bool
+}
+
+// String describes the conflict.
+func (c RouteConflict) String() string {
+	if c.Shadowed {
+		return fmt.Sprintf("Route %s is shadowed by %s", c.Route, c.Other)
+	}
+	return fmt.Sprintf("Route %s collides with %s", c.Route, c.Other)
+}
+
+// callSite returns the file:line of the caller skip frames above the caller of
+// callSite.
+func callSite(skip int) string {
+	_, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+	return fmt.Sprintf("%s:%d", filepath.Base(file), line)
+}
+
+// Routes returns the routes registered with the API in precedence order, i.e. a request
+// is routed to the first one matching it.
+func (r *muxAPI) Routes() []RouteInfo {
+	return append([]RouteInfo{}, r.routes...)
+}
+
+// WhichRoute returns the route a request with the method and path would be routed to,
+// or false if none would. Routes requiring an X-HTTP-Method-Override aren't considered.
+func (r *muxAPI) WhichRoute(method, path string) (RouteInfo, bool) {
+	req, err := http.NewRequest(strings.ToUpper(method), path, nil)
+	if err != nil {
+		return RouteInfo{}, false
+	}
+	var match mux.RouteMatch
+	if !r.router.Match(req, &match) || match.MatchErr != nil {
+		return RouteInfo{}, false
+	}
+	for _, info := range r.routes {
+		if info.route == match.Route {
+			return info, true
+		}
+	}
+	return RouteInfo{}, false
+}
+
+// addRoute records the route registered using the mux.Route and any conflicts with those
+// registered before it. Collisions are logged, and shadowing is logged in debug mode.
+func (r *muxAPI) addRoute(info RouteInfo, route *mux.Route) {
+	info.route = route
+	for _, conflict := range r.conflictsWith(info) {
+		if conflict.Shadowed {
+			r.debugf("", "%s", conflict)
+		} else {
+			r.config.Logf("%s", conflict)
+		}
+		r.routeConflicts = append(r.routeConflicts, conflict)
+	}
+	r.routes = append(r.routes, info)
+}
+
+// conflictsWith returns the conflicts between the route and those already registered.
+func (r *muxAPI) conflictsWith(info RouteInfo) []RouteConflict {
+	sample, err := info.sampleRequest()
+	if err != nil {
+		return nil
+	}
+	conflicts := []RouteConflict{}
+	for _, other := range r.routes {
+		sameMethod := other.Method == "" || info.Method == "" || other.Method == info.Method
+		if sameMethod && other.MethodOverride == info.MethodOverride &&
+			(other.Kind == PathPrefixRoute) == (info.Kind == PathPrefixRoute) &&
+			other.shape() == info.shape() {
+			conflicts = append(conflicts, RouteConflict{Route: info, Other: other})
+			continue
+		}
+
+		if matches(other.route, sample) || (!sameResource(info, other) && overlaps(info, other)) {
+			conflicts = append(conflicts, RouteConflict{Route: info, Other: other, Shadowed: true})
+		}
+	}
+	return conflicts
+}
+
+// overlaps returns true if the route matches the other's sample request, so it's
+// unreachable for some of the paths the other was registered before it to serve.
+func overlaps(info, other RouteInfo) bool {
+	sample, err := other.sampleRequest()
+	return err == nil && matches(info.route, sample)
+}
+
+// sameResource returns true if both routes were generated for the same resource, whose
+// routes are deliberately ordered so more specific ones, such as count, come first.
+func sameResource(info, other RouteInfo) bool {
+	return info.Kind == ResourceRoute && other.Kind == ResourceRoute &&
+		strings.SplitN(info.Name, ":", 2)[0] == strings.SplitN(other.Name, ":", 2)[0]
+}
+
+// matches returns true if the mux.Route matches the request.
+func matches(route *mux.Route, req *http.Request) bool {
+	var match mux.RouteMatch
+	return route != nil && route.Match(req, &match) && match.MatchErr == nil
+}
+
+// routeProblems returns descriptions of the route collisions and shadowing found when
+// routes were registered.
+func (r *muxAPI) routeProblems() (collisions, shadowing []string) {
+	for _, conflict := range r.routeConflicts {
+		if conflict.Shadowed {
+			shadowing = append(shadowing, conflict.String())
+		} else {
+			collisions = append(collisions, conflict.String())
+		}
+	}
+	return collisions, shadowing
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// widgetsPath is the path template of the widgets resource's list routes.
+const widgetsPath = "/api/v{version:[^/]+}/widgets"
+
+// routeMechanisms register a route at the path using each registration mechanism.
+var routeMechanisms = map[RouteKind]func(api API, path string) error{
+	ResourceRoute: func(api API, path string) error {
+		api.RegisterResourceHandler(namedHandler{name: "widgets"})
+		return nil
+	},
+	HandlerRoute: func(api API, path string) error {
+		api.RegisterHandlerFunc(path, func(w http.ResponseWriter, r *http.Request) {})
+		return nil
+	},
+	PathPrefixRoute: func(api API, path string) error {
+		api.RegisterPathPrefix(path, func(w http.ResponseWriter, r *http.Request) {})
+		return nil
+	},
+	LegacyRoute: func(api API, path string) error {
+		return api.MountLegacyHandler("GET", path, legacyHandler)
+	},
+}
+
+// Ensures that registering routes serving the same requests using any pair of
+// mechanisms is a collision, and a prefix overlapping another route is shadowing,
+// reported with both registrations' call sites.
+func TestRouteConflictsAcrossMechanisms(t *testing.T) {
+	assert := assert.New(t)
+	for first, registerFirst := range routeMechanisms {
+		for second, registerSecond := range routeMechanisms {
+			pair := string(first) + " then " + string(second)
+			api := NewAPI(&Configuration{})
+			assert.Nil(registerFirst(api, widgetsPath), pair)
+			if err := registerSecond(api, widgetsPath); err != nil {
+				// MountLegacyHandler refuses routes conflicting with a resource's.
+				assert.Equal(ResourceRoute, first, pair)
+				assert.Equal(LegacyRoute, second, pair)
+				continue
+			}
+
+			err := api.Validate()
+			if (first == PathPrefixRoute) != (second == PathPrefixRoute) {
+				assert.Nil(err, pair)
+				api.Configuration().StrictValidation = true
+				err = api.Validate()
+				if assert.NotNil(err, pair) {
+					assert.Contains(err.Error(), "is shadowed by", pair)
+				}
+				continue
+			}
+			if assert.IsType(ValidationError{}, err, pair) {
+				problem := err.(ValidationError).Problems[0]
+				assert.Contains(problem, "collides with", pair)
+				assert.Equal(2, strings.Count(problem, "routes_test.go:"), pair)
+			}
+		}
+	}
+}
+
+// Ensures that the stats route collides with or shadows routes registered after it
+// using every other mechanism.
+func TestRouteConflictsWithStats(t *testing.T) {
+	assert := assert.New(t)
+	for kind, register := range routeMechanisms {
+		api := NewAPI(&Configuration{StatsURI: "/api/v1/widgets", StrictValidation: true})
+		assert.Nil(register(api, "/api/v1/widgets"), kind)
+		err := api.Validate()
+		if assert.NotNil(err, kind) {
+			assert.Contains(err.Error(), "stats at routes_test.go:", kind)
+		}
+	}
+}
+
+// Ensures that Routes lists routes in precedence order and WhichRoute reports the one a
+// request would be routed to, such as a custom route registered before the item route
+// it shadows.
+func TestRoutesWhichRoute(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterHandlerFunc("/api/v1/widgets/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	api.RegisterResourceHandler(namedHandler{name: "widgets"})
+
+	routes := api.Routes()
+	assert.Equal(HandlerRoute, routes[0].Kind)
+	assert.Equal("/api/v1/widgets/{id}", routes[0].Path)
+	assert.Equal("widgets:create", routes[1].Name)
+	assert.Equal("POST", routes[1].Method)
+	assert.Contains(routes[1].CallSite, "routes_test.go:")
+
+	route, ok := api.WhichRoute("GET", "/api/v1/widgets/1")
+	assert.True(ok)
+	assert.Equal(HandlerRoute, route.Kind)
+	route, ok = api.WhichRoute("get", "/api/v2/widgets/1")
+	assert.True(ok)
+	assert.Equal("widgets:read", route.Name)
+	route, ok = api.WhichRoute("DELETE", "/api/v2/widgets/1")
+	assert.True(ok)
+	assert.Equal("widgets:delete", route.Name)
+	_, ok = api.WhichRoute("GET", "/nowhere")
+	assert.False(ok)
+
+	assert.Nil(api.Validate())
+	api.Configuration().StrictValidation = true
+	err := api.Validate()
+	if assert.NotNil(err) {
+		assert.Contains(err.Error(), "GET /api/v{version:[^/]+}/widgets/{resource_id} "+
+			"(widgets:read at routes_test.go:")
+		assert.Contains(err.Error(), "is shadowed by * /api/v1/widgets/{id} (handler at routes_test.go:")
+	}
+}
+
+// Ensures that a resource's routes don't conflict with each other, so requests
+// overriding POST with PUT reach update list rather than create.
+func TestResourceRoutesDontConflict(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{StrictValidation: true})
+	api.RegisterResourceHandler(noteHandler{notes: &replicatedNotes{}})
+	assert.Equal(ValidationError{Problems: []string{"Handler for notes has no Rules"}},
+		api.Validate())
+
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v1/notes", bytes.NewBufferString(`[]`))
+	req.Header.Set("X-HTTP-Method-Override", "PUT")
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(http.StatusNotImplemented, resp.Code)
+}
@@ -17,9 +17,12 @@ limitations under the License.
 package rest
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"math/big"
 	"reflect"
+	"sort"
 )
 
 // TODO:
@@ -106,6 +109,22 @@ func (r *rules) Validate() error {
 			}
 		}
 
+		if rule.MaxLength > 0 && rule.MinLength > rule.MaxLength {
+			return fmt.Errorf(
+				"Invalid Rule for %s: field '%s' has MinLength greater than MaxLength",
+				resourceType, rule.Name())
+		}
+
+		if err := rule.validateBounds(); err != nil {
+			return fmt.Errorf("Invalid Rule for %s: field '%s' %s", resourceType, rule.Name(), err)
+		}
+
+		if rule.OutputOnly && (rule.Unique != nil || rule.Exists != nil) {
+			return fmt.Errorf(
+				"Invalid Rule for %s: field '%s' is OutputOnly but has input constraints",
+				resourceType, rule.Name())
+		}
+
 		// Validate nested Rules.
 		if rule.Rules != nil {
 			if err := rule.Rules.Validate(); err != nil {
@@ -211,6 +230,48 @@ type Rule struct {
 	// Nested Rules to apply to field value.
 	Rules Rules
 
+	// Indicates if string values must be valid UTF-8. If a value is not, a 400 will be
+	// returned identifying the field and byte offset. Defaults to false.
+	ValidateUTF8 bool
+
+	// Indicates if string values should be normalized to Unicode Normalization Form C
+	// before validation and before being passed to the handler. This implies
+	// ValidateUTF8. Defaults to false.
+	NormalizeUnicode bool
+
+	// Indicates if control characters, other than tab, line feed, and carriage return,
+	// should be stripped from string values. Defaults to false.
+	StripControlChars bool
+
+	// Minimum length of string values. Zero means no minimum.
+	MinLength int
+
+	// Maximum length of string values. Zero means no maximum.
+	MaxLength int
+
+	// Indicates if MinLength and MaxLength count runes rather than bytes. Lengths are
+	// checked after normalization.
+	RuneLength bool
+
+	// Minimum and Maximum are the inclusive bounds of numeric values, given as JSON
+	// number literals, e.g. "0" or "9007199254740993". Values are compared exactly
+	// whether or not the Configuration's UseNumber is set. Empty means no bound.
+	Minimum json.Number
+	Maximum json.Number
+
+	// Unique reports whether the field's value isn't already in use, e.g. by another
+	// user. Exists reports whether the resource the value refers to exists. They're
+	// called concurrently once every static constraint passes, and values they reject
+	// fail with FieldNotUniqueCode and FieldDoesNotExistCode respectively. Errors they
+	// return are mapped like the ResourceHandler's. Updates should exclude the
+	// resource being updated, identified by RequestContext#ResourceID, from Unique.
+	Unique ConstraintFunc
+	Exists ConstraintFunc
+
+	// Indicates if the field contains sensitive data. Sensitive values are redacted
+	// from bodies captured by BodyCapture. Defaults to false.
+	Sensitive bool
+
 	// Description used in documentation.
 	DocString string
 
@@ -253,6 +314,116 @@ func (r Rule) validType(fieldType reflect.Type) bool {
 	return fieldType.Kind() == kind
 }
 
+// validateLength verifies that the value satisfies the Rule's MinLength and MaxLength
+// if it's a string. Returns a FieldError for the field path if it doesn't, nil
+// otherwise.
+func (r Rule) validateLength(value interface{}, path string) *FieldError {
+	s, ok := value.(string)
+	if !ok || r.MinLength == 0 && r.MaxLength == 0 {
+		return nil
+	}
+
+	unit := "bytes"
+	if r.RuneLength {
+		unit = "characters"
+	}
+
+	length := stringLength(s, r.RuneLength)
+	if length < r.MinLength {
+		return &FieldError{
+			Field:   path,
+			Code:    FieldTooShortCode,
+			Params:  map[string]interface{}{"min": r.MinLength, "unit": unit},
+			Message: fmt.Sprintf("Field '%s' must be at least %d %s", r.Name(), r.MinLength, unit),
+		}
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		return &FieldError{
+			Field:   path,
+			Code:    FieldTooLongCode,
+			Params:  map[string]interface{}{"max": r.MaxLength, "unit": unit},
+			Message: fmt.Sprintf("Field '%s' must be at most %d %s", r.Name(), r.MaxLength, unit),
+		}
+	}
+
+	return nil
+}
+
+// validateBounds returns an error if the Rule's Minimum or Maximum isn't a number or
+// the Minimum is greater than the Maximum.
+func (r Rule) validateBounds() error {
+	min, max, err := r.bounds()
+	if err != nil {
+		return err
+	}
+	if min != nil && max != nil && min.Cmp(max) > 0 {
+		return fmt.Errorf("has Minimum greater than Maximum")
+	}
+	return nil
+}
+
+// bounds returns the Rule's Minimum and Maximum as exact numbers, nil if unbounded.
+func (r Rule) bounds() (*big.Rat, *big.Rat, error) {
+	var min, max *big.Rat
+	if r.Minimum != "" {
+		var ok bool
+		if min, ok = numberValue(r.Minimum); !ok {
+			return nil, nil, fmt.Errorf("has invalid Minimum %q", r.Minimum)
+		}
+	}
+	if r.Maximum != "" {
+		var ok bool
+		if max, ok = numberValue(r.Maximum); !ok {
+			return nil, nil, fmt.Errorf("has invalid Maximum %q", r.Maximum)
+		}
+	}
+	return min, max, nil
+}
+
+// validateRange verifies that the value satisfies the Rule's Minimum and Maximum if
+// it's a number. Returns a FieldError for the field path if it doesn't, nil
+// otherwise.
+func (r Rule) validateRange(value interface{}, path string) *FieldError {
+	if r.Minimum == "" && r.Maximum == "" {
+		return nil
+	}
+	number, ok := numberValue(value)
+	if !ok {
+		if !isNumber(value) {
+			return nil
+		}
+		// Fail closed for numbers which can't be compared to the bounds.
+		return &FieldError{
+			Field:   path,
+			Code:    FieldInvalidNumberCode,
+			Message: fmt.Sprintf("Field '%s' must be a number within its bounds", r.Name()),
+		}
+	}
+	min, max, err := r.bounds()
+	if err != nil {
+		return nil
+	}
+
+	if min != nil && number.Cmp(min) < 0 {
+		return &FieldError{
+			Field:   path,
+			Code:    FieldTooSmallCode,
+			Params:  map[string]interface{}{"min": r.Minimum},
+			Message: fmt.Sprintf("Field '%s' must be at least %s", r.Name(), r.Minimum),
+		}
+	}
+	if max != nil && number.Cmp(max) > 0 {
+		return &FieldError{
+			Field:   path,
+			Code:    FieldTooLargeCode,
+			Params:  map[string]interface{}{"max": r.Maximum},
+			Message: fmt.Sprintf("Field '%s' must be at most %s", r.Name(), r.Maximum),
+		}
+	}
+
+	return nil
+}
+
 // isResourceRule returns true if this Rule corresponds to a resource field, false
 // if not. Non-resource Rules allow you to specify input fields that do not directly
 // correspond to a resource.
@@ -264,43 +435,83 @@ func (r Rule) isResourceRule() bool {
 // provided Payload. If the Payload is nil, an empty Payload will be returned. If no
 // Rules are provided, this acts as an identity function. If Rules are provided, any
 // incoming fields which are not specified will be discarded. If Rules specify types,
-// incoming values will attempted to be coerced. If coercion fails, an error will be
-// returned. If Rules specify nested Rules, they will be recursively applied to the
-// field value, taking precedence over a type coercion.
+// incoming values will attempted to be coerced. If Rules specify nested Rules, they
+// will be recursively applied to the field value, taking precedence over a type
+// coercion. If any fields fail coercion or validation, FieldErrors describing every
+// failure will be returned, sorted by field path and then code.
 func applyInboundRules(payload Payload, rules Rules, version string) (Payload, error) {
+	errs := FieldErrors{}
+	newPayload := applyInboundRulesAt(payload, rules, version, "", &errs)
+	if len(errs) > 0 {
+		errs.sort()
+		return nil, errs
+	}
+	return newPayload, nil
+}
+
+// applyInboundRulesAt applies inbound Rules to the Payload found at the field path,
+// adding any failures to the FieldErrors. Fields are processed in order of name so
+// logging is deterministic.
+func applyInboundRulesAt(payload Payload, rules Rules, version, path string,
+	errs *FieldErrors) Payload {
+
 	if payload == nil {
-		return Payload{}, nil
+		return Payload{}
 	}
 
 	// Apply only inbound Rules.
-	rules = rules.Filter(true).ForVersion(version)
+	rules = inboundRulesFor(rules, version)
 
 	if rules.Size() == 0 {
-		return payload, nil
+		return payload
 	}
 
+	fields := make([]string, 0, len(payload))
+	for field := range payload {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
 	newPayload := Payload{}
 
 fieldLoop:
-	for field, value := range payload {
+	for _, field := range fields {
+		value := payload[field]
 		for _, rule := range rules.Contents() {
 			if rule.Name() == field {
+				fieldPath := joinFieldPath(path, field)
+				if s, ok := value.(string); ok {
+					value = sanitizeString(s, rule.StripControlChars, rule.NormalizeUnicode)
+				}
+
 				if nestedInboundRulesApply(value, rule.Rules, version) {
 					// Nested Rules take precedence over type coercion.
-					v, err := applyNestedInboundRules(value, rule.Rules, version)
-					if err != nil {
-						return nil, err
-					}
-					value = v
+					value = applyNestedInboundRules(value, rule.Rules, version, fieldPath, errs)
 				} else if rule.Type != Unspecified {
 					// Coerce to specified type.
 					coerced, err := coerceType(value, rule.Type)
 					if err != nil {
-						return nil, err
+						*errs = append(*errs, FieldError{
+							Field:   fieldPath,
+							Code:    FieldTypeCode,
+							Params:  map[string]interface{}{"type": typeToName[rule.Type]},
+							Message: err.Error(),
+						})
+						continue fieldLoop
 					}
 					value = coerced
 				}
 
+				if err := rule.validateLength(value, fieldPath); err != nil {
+					*errs = append(*errs, *err)
+					continue fieldLoop
+				}
+
+				if err := rule.validateRange(value, fieldPath); err != nil {
+					*errs = append(*errs, *err)
+					continue fieldLoop
+				}
+
 				if rule.InputHandler != nil {
 					value = rule.InputHandler(value)
 				}
@@ -314,18 +525,19 @@ fieldLoop:
 	}
 
 	// Ensure no required fields are missing.
-	if err := enforceRequiredFields(rules, newPayload); err != nil {
-		log.Println(err)
-		return nil, err
+	for _, err := range missingRequiredFields(rules, payload, path) {
+		log.Println(err.Message)
+		*errs = append(*errs, err)
 	}
 
-	return newPayload, nil
+	return newPayload
 }
 
 // applyNestedInboundRules recursively applies nested Rules which are not specified as
-// output only to the provided value.
-func applyNestedInboundRules(
-	value interface{}, rules Rules, version string) (interface{}, error) {
+// output only to the provided value found at the field path, adding any failures to
+// the FieldErrors.
+func applyNestedInboundRules(value interface{}, rules Rules, version, path string,
+	errs *FieldErrors) interface{} {
 
 	var fieldValue interface{}
 	valueType := reflect.TypeOf(value).Kind()
@@ -334,26 +546,25 @@ func applyNestedInboundRules(
 		s := reflect.ValueOf(value)
 		nestedValues := make([]interface{}, s.Len())
 		for i := 0; i < s.Len(); i++ {
-			var payload map[string]interface{}
-			payload, err := applyInboundRules(
-				s.Index(i).Interface().(map[string]interface{}), rules, version)
-			if err != nil {
-				return nil, err
-			}
-			nestedValues[i] = payload
+			nestedValues[i] = map[string]interface{}(applyInboundRulesAt(
+				s.Index(i).Interface().(map[string]interface{}), rules, version,
+				fmt.Sprintf("%s[%d]", path, i), errs))
 		}
 		fieldValue = nestedValues
 	} else {
-		var payload map[string]interface{}
-		payload, err := applyInboundRules(
-			value.(map[string]interface{}), rules, version)
-		if err != nil {
-			return nil, err
-		}
-		fieldValue = payload
+		fieldValue = map[string]interface{}(applyInboundRulesAt(
+			value.(map[string]interface{}), rules, version, path, errs))
 	}
 
-	return fieldValue, nil
+	return fieldValue
+}
+
+// joinFieldPath returns the path of the field within the object at the path.
+func joinFieldPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
 }
 
 // nestedInboundRulesApply returns true if the Rules contain inbound Rules and
@@ -369,7 +580,7 @@ func nestedInboundRulesApply(value interface{}, rules Rules, version string) boo
 		return false
 	}
 
-	return rules.Filter(Inbound).ForVersion(version).Size() > 0
+	return inboundRulesFor(rules, version).Size() > 0
 }
 
 // applyOutboundRules applies Rules which are not specified as input only to the
@@ -496,10 +707,13 @@ func applyNestedOutboundRules(resource Resource, rule *Rule, version string) Res
 	return fieldValue
 }
 
-// enforceRequiredFields verifies that the provided Payload has values for any Rules
-// with the Required flag set to true. If any required fields are missing, an error
-// will be returned. Otherwise nil is returned.
-func enforceRequiredFields(rules Rules, payload Payload) error {
+// missingRequiredFields returns a FieldError for each Rule with the Required flag set
+// to true which doesn't have a value in the provided Payload found at the field path.
+// It's given the payload as received rather than with Rules applied, so a required
+// field which fails validation is reported once, for that failure, rather than also
+// as missing.
+func missingRequiredFields(rules Rules, payload Payload, path string) FieldErrors {
+	missing := FieldErrors{}
 ruleLoop:
 	for _, rule := range rules.Contents() {
 		if !rule.Required {
@@ -512,10 +726,14 @@ ruleLoop:
 			}
 		}
 
-		return fmt.Errorf("Missing required field '%s'", rule.Name())
+		missing = append(missing, FieldError{
+			Field:   joinFieldPath(path, rule.Name()),
+			Code:    FieldRequiredCode,
+			Message: fmt.Sprintf("Missing required field '%s'", rule.Name()),
+		})
 	}
 
-	return nil
+	return missing
 }
 
 // isNil returns true if the given Resource is a nil value or pointer, false if
@@ -17,7 +17,7 @@ limitations under the License.
 package rest
 
 import (
-	"fmt"
+	"encoding/json"
 	"reflect"
 	"testing"
 	"time"
@@ -64,7 +64,7 @@ func TestApplyInboundRulesMissingRequiredField(t *testing.T) {
 	), "1")
 
 	assert.Nil(actual, "Return value should be nil")
-	assert.Equal(fmt.Errorf("Missing required field 'baz'"), err, "Incorrect error")
+	assert.EqualError(err, "Missing required field 'baz'", "Incorrect error")
 }
 
 // Ensures that only inbound rules are applied and unspecified input fields are discarded.
@@ -117,7 +117,7 @@ func TestApplyInboundRulesCoerceBoolError(t *testing.T) {
 	actual, err := applyInboundRules(payload, rules, "1")
 
 	assert.Nil(actual, "Return value should be nil")
-	assert.Equal(fmt.Errorf("Unable to coerce bool to float32"), err, "Incorrect error")
+	assert.EqualError(err, "Unable to coerce bool to float32", "Incorrect error")
 }
 
 // Ensures that inbound rules which specify bool correctly coerce bool.
@@ -171,7 +171,7 @@ func TestApplyInboundRulesCoerceFloatError(t *testing.T) {
 	actual, err := applyInboundRules(payload, rules, "1")
 
 	assert.Nil(actual, "Return value should be nil")
-	assert.Equal(fmt.Errorf("Unable to coerce float to bool"), err, "Incorrect error")
+	assert.EqualError(err, "Unable to coerce float to bool", "Incorrect error")
 }
 
 // Ensures that inbound rules which specify int correctly coerce float64.
@@ -441,8 +441,7 @@ func TestApplyInboundRulesCoerceStringError(t *testing.T) {
 	actual, err := applyInboundRules(payload, rules, "1")
 
 	assert.Nil(actual, "Return value should be nil")
-	assert.Equal(fmt.Errorf("Unable to coerce string to map[string]interface{}"),
-		err, "Incorrect error")
+	assert.EqualError(err, "Unable to coerce string to map[string]interface{}", "Incorrect error")
 }
 
 // Ensure that if type coercion from string to int fails, the error is returned.
@@ -863,7 +862,7 @@ func TestApplyInboundRulesCoerceSliceError(t *testing.T) {
 	actual, err := applyInboundRules(payload, rules, "1")
 
 	assert.Nil(actual, "Return value should be nil")
-	assert.Equal(fmt.Errorf("Unable to coerce slice to bool"), err, "Incorrect error")
+	assert.EqualError(err, "Unable to coerce slice to bool", "Incorrect error")
 }
 
 // Ensures that inbound rules which specify slice correctly coerce slice.
@@ -899,7 +898,7 @@ func TestApplyInboundRulesCoerceMapError(t *testing.T) {
 	actual, err := applyInboundRules(payload, rules, "1")
 
 	assert.Nil(actual, "Return value should be nil")
-	assert.Equal(fmt.Errorf("Unable to coerce map to bool"), err, "Incorrect error")
+	assert.EqualError(err, "Unable to coerce map to bool", "Incorrect error")
 }
 
 // Ensures that inbound rules which specify map correctly coerce map.
@@ -1462,3 +1461,60 @@ func TestRulesValidateNonResourceRule(t *testing.T) {
 
 	assert.Nil(rules.Validate())
 }
+
+// Ensures that applyInboundRules reports every failing field, sorted by field path
+// and then code, and the serialized errors are identical across runs.
+func TestApplyInboundRulesFieldErrorsStable(t *testing.T) {
+	assert := assert.New(t)
+	nested := NewRules((*TestResource)(nil),
+		&Rule{Field: "name", Required: true},
+		&Rule{Field: "count", Type: Int},
+	)
+	rules := NewRules((*TestResource)(nil),
+		&Rule{Field: "a", Type: Int},
+		&Rule{Field: "b", Type: Bool},
+		&Rule{Field: "c", Type: Float64},
+		&Rule{Field: "d", MinLength: 5},
+		&Rule{Field: "e", MaxLength: 2},
+		&Rule{Field: "f", Required: true},
+		&Rule{Field: "g", Required: true},
+		&Rule{Field: "h", Type: Map},
+		&Rule{Field: "i", Type: Int, Required: true},
+		&Rule{Field: "items", Rules: nested},
+		&Rule{Field: "owner", Rules: nested},
+	)
+	payload := func() Payload {
+		return Payload{
+			"a":     "one",
+			"b":     "yes",
+			"c":     true,
+			"d":     "abc",
+			"e":     "abcdef",
+			"h":     "map",
+			"i":     "two",
+			"items": []interface{}{map[string]interface{}{"count": "x"}, map[string]interface{}{}},
+			"owner": map[string]interface{}{"name": "bob", "count": false},
+		}
+	}
+
+	_, err := applyInboundRules(payload(), rules, "1")
+	first, _ := json.Marshal(err)
+
+	fields := []string{}
+	for _, field := range err.(FieldErrors) {
+		fields = append(fields, field.Field+" "+field.Code)
+	}
+	assert.Equal([]string{
+		"a invalid_type", "b invalid_type", "c invalid_type", "d too_short", "e too_long",
+		"f required", "g required", "h invalid_type", "i invalid_type",
+		"items[0].count invalid_type",
+		"items[0].name required", "items[1].name required", "owner.count invalid_type",
+	}, fields)
+	assert.Equal(map[string]interface{}{"min": 5, "unit": "bytes"}, err.(FieldErrors)[3].Params)
+
+	for i := 0; i < 100; i++ {
+		_, err := applyInboundRules(payload(), rules, "1")
+		serialized, _ := json.Marshal(err)
+		assert.Equal(string(first), string(serialized))
+	}
+}
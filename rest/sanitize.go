@@ -0,0 +1,156 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// RejectedPathCounter counts requests rejected because of their path, keyed by the
+	// reason, e.g. traversal.
+	RejectedPathCounter = "rejected_paths"
+
+	// InvalidPathCode is the error code of responses to requests rejected because of
+	// their path.
+	InvalidPathCode = "invalid_path"
+
+	// defaultMaxPathLength is the maximum length of request paths if the Configuration
+	// doesn't specify one.
+	defaultMaxPathLength = 2048
+
+	// defaultMaxPathSegments is the maximum number of request path segments if the
+	// Configuration doesn't specify one.
+	defaultMaxPathSegments = 64
+
+	// apiPrefix is the prefix of the paths of resource endpoints, which are followed by
+	// the version.
+	apiPrefix = "/api/"
+)
+
+// Reasons requests are rejected because of their path.
+const (
+	pathNullByte        = "null_byte"
+	pathInvalidEncoding = "invalid_encoding"
+	pathTraversal       = "traversal"
+	pathTooLong         = "too_long"
+	pathTooManySegments = "too_many_segments"
+)
+
+// maxPathLength returns the maximum length of request paths for the Configuration.
+func maxPathLength(config *Configuration) int {
+	if config == nil || config.MaxPathLength == 0 {
+		return defaultMaxPathLength
+	}
+	return config.MaxPathLength
+}
+
+// maxPathSegments returns the maximum number of request path segments for the
+// Configuration.
+func maxPathSegments(config *Configuration) int {
+	if config == nil || config.MaxPathSegments == 0 {
+		return defaultMaxPathSegments
+	}
+	return config.MaxPathSegments
+}
+
+// sanitizePath decodes and normalizes the escaped request path. It returns the decoded
+// path with dot-segments removed, or the reason the path is rejected: it's too long,
+// has too many segments, is invalidly encoded, contains null bytes, or escapes the API
+// prefix and version it starts with once normalized.
+func sanitizePath(escaped string, config *Configuration) (string, string) {
+	if len(escaped) > maxPathLength(config) {
+		return "", pathTooLong
+	}
+	if strings.Count(escaped, "/") > maxPathSegments(config) {
+		return "", pathTooManySegments
+	}
+
+	decoded, err := url.PathUnescape(escaped)
+	if err != nil || !utf8.ValidString(decoded) {
+		// Overlong escapes, e.g. %c0%ae, decode to invalid UTF-8.
+		return "", pathInvalidEncoding
+	}
+	if strings.IndexByte(decoded, 0) >= 0 {
+		return "", pathNullByte
+	}
+	if strings.Count(decoded, "/") > maxPathSegments(config) {
+		// Encoded slashes add segments once decoded.
+		return "", pathTooManySegments
+	}
+
+	if !strings.HasPrefix(decoded, "/") {
+		decoded = "/" + decoded
+	}
+	cleaned := path.Clean(decoded)
+	if strings.HasSuffix(decoded, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	if prefix := versionPrefix(decoded); prefix != "" && !strings.HasPrefix(cleaned+"/", prefix) {
+		return "", pathTraversal
+	}
+	return cleaned, ""
+}
+
+// versionPrefix returns the API prefix and version segment a path starts with, e.g.
+// /api/v1/, or an empty string if it doesn't start with the API prefix.
+func versionPrefix(p string) string {
+	if !strings.HasPrefix(p, apiPrefix) {
+		return ""
+	}
+	end := strings.Index(p[len(apiPrefix):], "/")
+	if end < 0 {
+		return p + "/"
+	}
+	return p[:len(apiPrefix)+end+1]
+}
+
+// rejectInvalidPath responds with a 400 if the request's path is rejected by
+// sanitizePath and returns true. Otherwise the request's path is replaced with its
+// normalized form before it's routed and false is returned.
+func (r *muxAPI) rejectInvalidPath(w http.ResponseWriter, req *http.Request) bool {
+	escaped := req.URL.EscapedPath()
+	if req.RequestURI != "" && !strings.HasPrefix(req.RequestURI, "*") {
+		// The request URI is what the client sent, before the server decoded it.
+		escaped = strings.SplitN(req.RequestURI, "?", 2)[0]
+		if u, err := url.Parse(escaped); err == nil && u.IsAbs() {
+			escaped = u.EscapedPath()
+		}
+	}
+
+	cleaned, reason := sanitizePath(escaped, r.config)
+	if reason != "" {
+		r.metrics.incr(RejectedPathCounter, reason)
+		r.debugf("", "Rejected path (%s): %q", reason, escaped)
+		ctx := NewContext(nil, req).setError(BadRequest(
+			fmt.Sprintf("Invalid request path: %s", strings.Replace(reason, "_", " ", -1))).
+			WithCode(InvalidPathCode))
+		r.handler.sendResponse(w, ctx)
+		return true
+	}
+
+	if cleaned != req.URL.Path {
+		req.URL.Path = cleaned
+		req.URL.RawPath = ""
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// idHandler is a ResourceHandler whose reads return the requested ID.
+type idHandler struct {
+	BaseResourceHandler
+}
+
+func (i idHandler) ResourceName() string {
+	return "foo"
+}
+
+func (i idHandler) ReadResource(ctx RequestContext, id string, version string) (Resource, error) {
+	return id, nil
+}
+
+// serveRaw sends a GET request with the raw request URI to the API.
+func serveRaw(api API, requestURI string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RequestURI = requestURI
+	if u, err := req.URL.Parse(requestURI); err == nil {
+		req.URL = u
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that sanitizePath rejects each class of malformed path and normalizes
+// dot-segments in the others.
+func TestSanitizePath(t *testing.T) {
+	assert := assert.New(t)
+	config := &Configuration{MaxPathLength: 64, MaxPathSegments: 8}
+	cases := []struct {
+		escaped, cleaned, reason string
+	}{
+		{"/api/v1/foo/1", "/api/v1/foo/1", ""},
+		{"/api/v1/foo/", "/api/v1/foo/", ""},
+		{"/api/v1/foo/hello%20w%C3%B6rld", "/api/v1/foo/hello wörld", ""},
+		{"/api/v1/bar/../foo/./1", "/api/v1/foo/1", ""},
+		{"/api/v1/foo/%00", "", pathNullByte},
+		{"/api/v1/foo/%zz", "", pathInvalidEncoding},
+		{"/api/v1/foo/%c0%ae%c0%ae", "", pathInvalidEncoding},
+		{"/api/v1/foo/..%2f..%2fadmin", "", pathTraversal},
+		{"/api/v1/../../admin", "", pathTraversal},
+		{"/api/v1/../v2/foo", "", pathTraversal},
+		{"/api/v1", "/api/v1", ""},
+		{"/api/v1/foo/" + strings.Repeat("a", 64), "", pathTooLong},
+		{"/a/b/c/d/e/f/g/h/i", "", pathTooManySegments},
+		{"/a/b%2fc%2fd%2fe%2ff%2fg%2fh%2fi", "", pathTooManySegments},
+		{"/docs/../index.html", "/index.html", ""},
+	}
+	for _, c := range cases {
+		cleaned, reason := sanitizePath(c.escaped, config)
+		assert.Equal(c.reason, reason, c.escaped)
+		assert.Equal(c.cleaned, cleaned, c.escaped)
+	}
+}
+
+// Ensures that requests with rejected paths receive a 400 before reaching handlers
+// and that encoded IDs still reach them.
+func TestServeHTTPRejectsInvalidPaths(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(idHandler{})
+
+	resp := serveRaw(api, "/api/v1/foo/..%2f..%2fadmin")
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.Contains(resp.Body.String(), `"code":"invalid_path"`)
+	assert.Equal(uint64(1), api.Metrics().Counter(RejectedPathCounter, pathTraversal))
+
+	resp = serveRaw(api, "/api/v1/foo/a%00b")
+	assert.Equal(http.StatusBadRequest, resp.Code)
+	assert.Equal(uint64(1), api.Metrics().Counter(RejectedPathCounter, pathNullByte))
+
+	resp = serveRaw(api, "/api/v1/foo/hello%20w%C3%B6rld?format=json")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"result":"hello wörld"`)
+
+	resp = serveRaw(api, "/api/v1/bar/../foo/1")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Contains(resp.Body.String(), `"result":"1"`)
+}
+
+// FuzzSanitizePath ensures that paths accepted by sanitizePath are valid UTF-8
+// without null bytes or dot-segments, within the limits, and stay under the API
+// prefix and version they started under.
+func FuzzSanitizePath(f *testing.F) {
+	seeds := []string{
+		"/api/v1/foo/1",
+		"/api/v1/foo/hello%20w%C3%B6rld",
+		"/api/v1/foo/%00",
+		"/api/v1/foo/%zz",
+		"/api/v1/foo/%c0%ae%c0%ae%2f",
+		"/api/v1/foo/..%2f..%2fadmin",
+		"/api/v1/%2e%2e/%2e%2e/admin",
+		"/api/v1/foo/" + strings.Repeat("a/", 70),
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	config := &Configuration{}
+
+	f.Fuzz(func(t *testing.T, escaped string) {
+		cleaned, reason := sanitizePath(escaped, config)
+		if reason != "" {
+			if cleaned != "" {
+				t.Errorf("Rejected path %q returned %q", escaped, cleaned)
+			}
+			return
+		}
+		if !utf8.ValidString(cleaned) || strings.IndexByte(cleaned, 0) >= 0 {
+			t.Errorf("Accepted path %q decoded to invalid %q", escaped, cleaned)
+		}
+		for _, segment := range strings.Split(cleaned, "/") {
+			if segment == "." || segment == ".." {
+				t.Errorf("Accepted path %q kept dot-segments: %q", escaped, cleaned)
+			}
+		}
+		if len(escaped) > defaultMaxPathLength ||
+			strings.Count(cleaned, "/") > defaultMaxPathSegments {
+			t.Errorf("Accepted path %q exceeds the limits", escaped)
+		}
+		decoded, _ := url.PathUnescape(escaped)
+		prefix := versionPrefix("/" + strings.TrimPrefix(decoded, "/"))
+		if prefix != "" && !strings.HasPrefix(cleaned+"/", prefix) {
+			t.Errorf("Accepted path %q escaped the API prefix: %q", escaped, cleaned)
+		}
+	})
+}
+
+// FuzzServeHTTPPath ensures that no request path causes the API to panic, route a
+// rejected path to a handler, or pass a handler an ID with null bytes.
+func FuzzServeHTTPPath(f *testing.F) {
+	f.Add("0/1")
+	f.Add("0/..%2f..%2fadmin")
+	f.Add("0/%00")
+	f.Add("0/%c0%ae")
+	f.Add("0/hello%20w%C3%B6rld")
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(idHandler{})
+
+	f.Fuzz(func(t *testing.T, suffix string) {
+		if strings.ContainsAny(suffix, "?#") {
+			return
+		}
+		escaped := "/api/v1/foo/" + suffix
+		resp := serveRaw(api, escaped)
+		if _, reason := sanitizePath(escaped, api.Configuration()); reason != "" &&
+			resp.Code != http.StatusBadRequest {
+			t.Errorf("Rejected path %q received %d", escaped, resp.Code)
+		}
+		if strings.Contains(resp.Body.String(), `\u0000`) {
+			t.Errorf("Path %q reached the handler with a null byte", escaped)
+		}
+	})
+}
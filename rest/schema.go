@@ -0,0 +1,129 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+// inboundSchema describes the request bodies an endpoint accepts for a version once
+// every layer of inbound processing is applied. It's computed from the same Rules and
+// Configuration used when handling requests so it can't drift from them.
+type inboundSchema struct {
+	Verb    string `json:"verb"`
+	Version string `json:"version"`
+
+	// List indicates if the body is an array of objects matching the schema.
+	List bool `json:"list,omitempty"`
+
+	// Open indicates if the endpoint has no inbound Rules for the version, in which
+	// case bodies are passed to the handler as-is.
+	Open bool `json:"open,omitempty"`
+
+	// DiscardsUnknown indicates if fields not in the schema are dropped.
+	DiscardsUnknown bool `json:"discards_unknown,omitempty"`
+
+	// Constraints are applied to every string value in the body.
+	Constraints []string `json:"constraints,omitempty"`
+
+	Fields []schemaField `json:"fields,omitempty"`
+}
+
+// schemaField describes a field accepted in request bodies.
+type schemaField struct {
+	Name        string        `json:"name"`
+	Type        string        `json:"type"`
+	Required    bool          `json:"required"`
+	Coerced     bool          `json:"coerced,omitempty"`
+	Transformed bool          `json:"transformed,omitempty"`
+	Constraints []string      `json:"constraints,omitempty"`
+	Fields      []schemaField `json:"fields,omitempty"`
+}
+
+// newInboundSchema returns the inboundSchema for bodies sent to the verb's endpoint
+// with the Rules and Configuration for the version.
+func newInboundSchema(config *Configuration, rules Rules, verb, version string) inboundSchema {
+	schema := inboundSchema{
+		Verb:        verb,
+		Version:     version,
+		List:        verb == ExampleUpdateList,
+		Constraints: configConstraints(config),
+		Fields:      schemaFields(rules, version),
+	}
+	schema.Open = len(schema.Fields) == 0
+	schema.DiscardsUnknown = !schema.Open
+	return schema
+}
+
+// configConstraints returns human-readable descriptions of the string hygiene the
+// Configuration applies to every request body.
+func configConstraints(config *Configuration) []string {
+	if config == nil {
+		return nil
+	}
+	constraints := []string{}
+	if config.ValidateUTF8 || config.NormalizeUnicode {
+		constraints = append(constraints, "Must be valid UTF-8")
+	}
+	if config.NormalizeUnicode {
+		constraints = append(constraints, "Normalized to Unicode NFC")
+	}
+	if config.StripControlChars {
+		constraints = append(constraints, "Control characters are removed")
+	}
+	if len(constraints) == 0 {
+		return nil
+	}
+	return constraints
+}
+
+// schemaFields returns the fields accepted by the Rules applied to request bodies for
+// the version, following nested Rules as applyInboundRules does.
+func schemaFields(rules Rules, version string) []schemaField {
+	if rules == nil {
+		return nil
+	}
+	rules = inboundRulesFor(rules, version)
+	fields := make([]schemaField, 0, rules.Size())
+	for _, rule := range rules.Contents() {
+		field := schemaField{
+			Name:        rule.Name(),
+			Type:        typeToName[rule.Type],
+			Required:    rule.Required,
+			Transformed: rule.InputHandler != nil,
+		}
+		if constraints := ruleConstraints(rule); len(constraints) > 0 {
+			field.Constraints = constraints
+		}
+		if rule.Rules != nil && inboundRulesFor(rule.Rules, version).Size() > 0 {
+			// Nested Rules take precedence over type coercion for objects and arrays.
+			field.Fields = schemaFields(rule.Rules, version)
+			field.Type = resourceTypeName(rule.Rules.ResourceType().String())
+			if rule.Type == Slice {
+				field.Type = "[]" + field.Type
+			}
+		} else if rule.Type != Unspecified {
+			field.Coerced = true
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// inboundRulesFor returns the Rules applied to request bodies for the version.
+func inboundRulesFor(rules Rules, version string) Rules {
+	return rules.Filter(Inbound).ForVersion(version)
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaResource is the resource type of schemaHandler.
+type schemaResource struct {
+	Name   string
+	Count  int
+	Legacy string
+	Parts  []schemaPart
+}
+
+// schemaPart is a nested resource of schemaResource.
+type schemaPart struct {
+	Label string
+}
+
+// schemaHandler is a ResourceHandler with layered inbound Rules which records the
+// payloads it receives.
+type schemaHandler struct {
+	BaseResourceHandler
+	received Payload
+}
+
+func (s *schemaHandler) ResourceName() string {
+	return "gizmos"
+}
+
+func (s *schemaHandler) CreateDocumentation() string {
+	return "Creates a gizmo"
+}
+
+func (s *schemaHandler) ReadDocumentation() string {
+	return "Reads a gizmo"
+}
+
+func (s *schemaHandler) Rules() Rules {
+	return NewRules((*schemaResource)(nil),
+		&Rule{Field: "Name", FieldAlias: "name", Type: String, Required: true, MaxLength: 5},
+		&Rule{Field: "Count", FieldAlias: "count", Type: Int},
+		&Rule{Field: "Legacy", FieldAlias: "legacy", Type: String, Versions: []string{"1"}},
+		&Rule{Field: "Parts", FieldAlias: "parts", Type: Slice, Rules: NewRules((*schemaPart)(nil),
+			&Rule{Field: "Label", FieldAlias: "label", Type: String, Required: true},
+		)},
+		&Rule{FieldAlias: "token", InputOnly: true, InputHandler: upperInput},
+		&Rule{Field: "Name", FieldAlias: "id", OutputOnly: true},
+	)
+}
+
+func (s *schemaHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+
+	s.received = data
+	return schemaResource{}, nil
+}
+
+// upperInput upper-cases string input values.
+func upperInput(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		return strings.ToUpper(s)
+	}
+	return value
+}
+
+// schemaAccepts returns true if the payload satisfies the required fields, coerced
+// string types, and length constraints described by the schema fields.
+func schemaAccepts(fields []schemaField, payload map[string]interface{}) bool {
+	for _, field := range fields {
+		value, ok := payload[field.Name]
+		if !ok {
+			if field.Required {
+				return false
+			}
+			continue
+		}
+		if field.Coerced && field.Type == "string" {
+			s, isString := value.(string)
+			if !isString {
+				return false
+			}
+			for _, constraint := range field.Constraints {
+				if constraint == "Maximum length 5 bytes" && len(s) > 5 {
+					return false
+				}
+			}
+		}
+		if field.Fields != nil {
+			for _, item := range value.([]interface{}) {
+				if !schemaAccepts(field.Fields, item.(map[string]interface{})) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Ensures that the inbound schema reflects version filtering, nested Rules, coercion,
+// input handlers, and the Configuration's string hygiene.
+func TestNewInboundSchema(t *testing.T) {
+	assert := assert.New(t)
+	config := &Configuration{StripControlChars: true}
+
+	schema := newInboundSchema(config, (&schemaHandler{}).Rules(), ExampleCreate, "2")
+	assert.False(schema.Open)
+	assert.True(schema.DiscardsUnknown)
+	assert.Equal([]string{"Control characters are removed"}, schema.Constraints)
+	assert.Equal([]schemaField{
+		{Name: "name", Type: "string", Required: true, Coerced: true,
+			Constraints: []string{"Maximum length 5 bytes"}},
+		{Name: "count", Type: "int", Coerced: true},
+		{Name: "parts", Type: "[]schemaPart", Fields: []schemaField{
+			{Name: "label", Type: "string", Required: true, Coerced: true},
+		}},
+		{Name: "token", Type: "interface{}", Transformed: true},
+	}, schema.Fields)
+
+	schema = newInboundSchema(config, (&schemaHandler{}).Rules(), ExampleUpdateList, "1")
+	assert.True(schema.List)
+	assert.Equal("legacy", schema.Fields[2].Name)
+
+	schema = newInboundSchema(nil, NewRules((*schemaResource)(nil)), ExampleCreate, "1")
+	assert.True(schema.Open)
+	assert.False(schema.DiscardsUnknown)
+}
+
+// Ensures that the documented inbound schema agrees with the request pipeline about
+// which fixture payloads are accepted and which fields reach the handler.
+func TestInboundSchemaMatchesPipeline(t *testing.T) {
+	assert := assert.New(t)
+	handler := &schemaHandler{}
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(handler)
+	schema := newInboundSchema(api.Configuration(), handler.Rules(), ExampleCreate, "2")
+
+	fixtures := []string{
+		`{"name": "abc"}`,
+		`{"name": "abc", "count": 3, "parts": [{"label": "x"}]}`,
+		`{"count": 3}`,
+		`{"name": "abcdef"}`,
+		`{"name": "abc", "parts": [{}]}`,
+		`{"name": "abc", "legacy": "old", "unknown": true}`,
+		`{"name": "abc", "token": "t"}`,
+	}
+	known := map[string]bool{}
+	for _, field := range schema.Fields {
+		known[field.Name] = true
+	}
+
+	for _, fixture := range fixtures {
+		var payload map[string]interface{}
+		assert.Nil(json.Unmarshal([]byte(fixture), &payload))
+		handler.received = nil
+
+		req, _ := http.NewRequest("POST", "http://example.com/api/v2/gizmos",
+			bytes.NewBufferString(fixture))
+		resp := httptest.NewRecorder()
+		api.ServeHTTP(resp, req)
+
+		accepted := schemaAccepts(schema.Fields, payload)
+		assert.Equal(accepted, resp.Code == http.StatusCreated, fixture)
+		if !accepted {
+			continue
+		}
+		for name := range payload {
+			_, ok := handler.received[name]
+			assert.Equal(known[name] || !schema.DiscardsUnknown, ok, fixture+" "+name)
+		}
+	}
+}
+
+// Ensures that generated documentation includes the inbound schema for mutating
+// endpoints only.
+func TestGenerateIncludesInboundSchema(t *testing.T) {
+	assert := assert.New(t)
+	generator := &defaultContextGenerator{config: &Configuration{}}
+
+	context, err := generator.generate(&resourceHandlerProxy{&schemaHandler{}}, "2")
+	assert.Nil(err)
+	endpoints := context["endpoints"].([]endpoint)
+	assert.Len(endpoints, 2)
+
+	var schema inboundSchema
+	assert.Nil(json.Unmarshal([]byte(endpoints[0]["inboundSchema"].(string)), &schema))
+	assert.Equal(ExampleCreate, schema.Verb)
+	assert.Equal(newInboundSchema(&Configuration{}, (&schemaHandler{}).Rules(),
+		ExampleCreate, "2"), schema)
+	assert.Nil(endpoints[1]["inboundSchema"])
+}
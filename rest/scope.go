@@ -0,0 +1,180 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	gcontext "github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// InsufficientScopeCounter counts requests rejected because their scopes don't
+	// grant the scope the operation requires.
+	InsufficientScopeCounter = "insufficient_scope"
+
+	// InsufficientScopeCode is the error code of responses to requests whose scopes
+	// don't grant the scope the operation requires.
+	InsufficientScopeCode = "insufficient_scope"
+
+	// AdminScope is the scope which grants every other scope.
+	AdminScope = "admin"
+
+	// anyScope is the scope which grants every other scope, and the last segment of a
+	// scope granting every scope nested under the rest of it.
+	anyScope = "*"
+
+	// scopeSeparator separates the segments of hierarchical scopes, e.g. foo:read.
+	scopeSeparator = ":"
+)
+
+// scopesKey is the request context key under which the request's Scopes are stored.
+type scopesKey struct{}
+
+// Scopes are the scopes granted to a request's principal, e.g. by its API token.
+// Scopes are hierarchical with segments separated by colons, such as foo:read, and a
+// scope grants:
+//
+//   - itself,
+//   - every scope nested under it, so foo grants foo:read and foo:items:write,
+//   - with a last segment of *, every scope nested under the rest of it, so foo:*
+//     grants foo:read but not foo,
+//   - and, for * or AdminScope, everything.
+//
+// Siblings don't grant each other, so foo:write doesn't grant foo:read.
+type Scopes []string
+
+// ParseScopes returns the Scopes in the space-separated list, e.g. "foo:read bar:*".
+func ParseScopes(scopes string) Scopes {
+	return Scopes(strings.Fields(scopes))
+}
+
+// Allows returns true if any of the Scopes grants the required scope.
+func (s Scopes) Allows(required string) bool {
+	for _, granted := range s {
+		if granted == anyScope || granted == AdminScope || granted == required {
+			return true
+		}
+		prefix := strings.TrimSuffix(granted, anyScope)
+		if !strings.HasSuffix(prefix, scopeSeparator) {
+			prefix += scopeSeparator
+		}
+		if strings.HasPrefix(required, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetScopes attaches the Scopes granted to the request's principal to the request.
+// Authenticate implementations call it once they've verified the request's token or
+// API key so a ScopePolicy can be enforced.
+func SetScopes(r *http.Request, scopes Scopes) {
+	gcontext.Set(r, scopesKey{}, scopes)
+}
+
+// RequestScopes returns the Scopes attached to the request using SetScopes, or nil if
+// there are none.
+func RequestScopes(r *http.Request) Scopes {
+	scopes, _ := gcontext.Get(r, scopesKey{}).(Scopes)
+	return scopes
+}
+
+// ScopePolicy is a ResourceOption which requires requests to a resource to have a
+// scope, attached by Authenticate using SetScopes, granting the scope required for
+// the operation. Requests without one receive a 403 with the insufficient_scope code
+// and a WWW-Authenticate header naming the required scope before the handler runs.
+// The Configuration's ScopePolicy applies to every resource registered without one.
+// The required scopes are included in the resource's CapabilitiesDocument and
+// Manifest.
+type ScopePolicy struct {
+	// Read is the scope required to read the resource, e.g. using GET, HEAD, or
+	// OPTIONS requests. Defaults to <resource>:read.
+	Read string
+
+	// Write is the scope required by every other method. Defaults to
+	// <resource>:write.
+	Write string
+
+	// Operations are the scopes required by particular operations, named as in
+	// the Manifest, e.g. delete or count, instead of Read or Write.
+	Operations map[string]string
+}
+
+// apply sets the ScopePolicy on the resource.
+func (p ScopePolicy) apply(opts *resourceOptions) {
+	opts.scopes = &p
+}
+
+// required returns the scope required to perform the operation on the resource using
+// the method.
+func (p *ScopePolicy) required(resource, operation, method string) string {
+	if scope, ok := p.Operations[strings.TrimSuffix(operation, "Override")]; ok {
+		return scope
+	}
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		if p.Read != "" {
+			return p.Read
+		}
+		return resource + scopeSeparator + "read"
+	}
+	if p.Write != "" {
+		return p.Write
+	}
+	return resource + scopeSeparator + "write"
+}
+
+// effectiveScopePolicy returns the ScopePolicy for a resource registered with the
+// options, or nil if scopes aren't enforced.
+func (r *muxAPI) effectiveScopePolicy(opts *resourceOptions) *ScopePolicy {
+	if opts.scopes != nil {
+		return opts.scopes
+	}
+	return r.config.Scopes
+}
+
+// newScopeMiddleware returns a RequestMiddleware which rejects requests to the
+// resource whose Scopes don't grant the scope the ScopePolicy requires for the
+// operation.
+func newScopeMiddleware(api *muxAPI, resource string, policy *ScopePolicy) RequestMiddleware {
+	return func(wrapped http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			operation := ""
+			if route := mux.CurrentRoute(r); route != nil {
+				operation = strings.TrimPrefix(route.GetName(), resource+":")
+			}
+			required := policy.required(resource, operation, r.Method)
+			if RequestScopes(r).Allows(required) {
+				wrapped(w, r)
+				return
+			}
+
+			api.metrics.incr(InsufficientScopeCounter, resource)
+			api.debugf(resource, "Insufficient scope for %s: %s %s requires %s (403)",
+				resource, r.Method, r.URL.Path, required)
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, required))
+			ctx := NewContext(nil, r).setError(ResourceNotPermitted(
+				fmt.Sprintf("Scope %s is required", required)).WithCode(InsufficientScopeCode))
+			api.handler.sendResponse(w, ctx)
+		}
+	}
+}
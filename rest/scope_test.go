@@ -0,0 +1,191 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Ensures that Scopes grant themselves, nested scopes, wildcard children, and, for
+// admin and *, everything, but not siblings or parents.
+func TestScopesAllows(t *testing.T) {
+	assert := assert.New(t)
+	cases := []struct {
+		granted  string
+		required string
+		allowed  bool
+	}{
+		{"foo:read", "foo:read", true},
+		{"foo:read bar:*", "bar:write", true},
+		{"foo:read", "foo:write", false},
+		{"foo:write", "foo:read", false},
+		{"foo", "foo:read", true},
+		{"foo", "foo:items:write", true},
+		{"foo:*", "foo:items:write", true},
+		{"foo:*", "foo", false},
+		{"foo:items", "foo:read", false},
+		{"food:read", "foo:read", false},
+		{"foo:re", "foo:read", false},
+		{"admin", "bar:write", true},
+		{"*", "bar:write", true},
+		{"", "foo:read", false},
+	}
+	for _, c := range cases {
+		assert.Equal(c.allowed, ParseScopes(c.granted).Allows(c.required),
+			"%q allows %q", c.granted, c.required)
+	}
+}
+
+// ledgerHandler is a ResourceHandler which authenticates requests using tokens whose
+// value is the space-separated list of scopes they grant.
+type ledgerHandler struct {
+	BaseResourceHandler
+	calls *int
+}
+
+func (l ledgerHandler) ResourceName() string {
+	return "ledgers"
+}
+
+func (l ledgerHandler) Authenticate(r *http.Request) error {
+	token := r.Header.Get("X-Token")
+	if token == "" {
+		return UnauthorizedRequest("Missing token")
+	}
+	SetScopes(r, ParseScopes(token))
+	return nil
+}
+
+func (l ledgerHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	*l.calls++
+	return data, nil
+}
+
+func (l ledgerHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	*l.calls++
+	return Payload{"id": id}, nil
+}
+
+func (l ledgerHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	*l.calls++
+	return Payload{"id": id}, nil
+}
+
+// serveLedger sends a request with the token, if any, to the API.
+func serveLedger(api API, method, url, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(`{"name":"a"}`))
+	if token != "" {
+		req.Header.Set("X-Token", token)
+	}
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that requests whose scopes don't grant the operation's default or
+// configured scope receive a 403 with a WWW-Authenticate hint before the handler runs.
+func TestScopePolicyEnforced(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(ledgerHandler{calls: &calls},
+		ScopePolicy{Operations: map[string]string{"delete": "ledgers:admin"}})
+
+	resp := serveLedger(api, "GET", "http://foo.com/api/v1/ledgers/1", "ledgers:read")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(1, calls)
+
+	resp = serveLedger(api, "POST", "http://foo.com/api/v1/ledgers", "ledgers:read other:*")
+	assert.Equal(http.StatusForbidden, resp.Code)
+	assert.Equal(`Bearer error="insufficient_scope", scope="ledgers:write"`,
+		resp.Header().Get("WWW-Authenticate"))
+	assert.Equal(`{"code":"insufficient_scope","messages":["Scope ledgers:write is required"],`+
+		`"reason":"Forbidden","status":403}`, resp.Body.String())
+	assert.Equal(1, calls)
+	assert.Equal(uint64(1), api.Metrics().Counter(InsufficientScopeCounter, "ledgers"))
+
+	resp = serveLedger(api, "POST", "http://foo.com/api/v1/ledgers", "ledgers:*")
+	assert.Equal(http.StatusCreated, resp.Code)
+	resp = serveLedger(api, "DELETE", "http://foo.com/api/v1/ledgers/1", "ledgers:write")
+	assert.Equal(http.StatusForbidden, resp.Code)
+	resp = serveLedger(api, "DELETE", "http://foo.com/api/v1/ledgers/1", AdminScope)
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.Equal(3, calls)
+
+	resp = serveLedger(api, "GET", "http://foo.com/api/v1/ledgers/1", "")
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+}
+
+// Ensures that the Configuration's ScopePolicy applies to resources registered
+// without one, and that resources aren't scoped without either.
+func TestScopePolicyConfiguration(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	api := NewAPI(&Configuration{Scopes: &ScopePolicy{Read: "ledgers"}})
+	api.RegisterResourceHandler(ledgerHandler{calls: &calls})
+	assert.Equal(http.StatusOK,
+		serveLedger(api, "GET", "http://foo.com/api/v1/ledgers/1", "ledgers").Code)
+	assert.Equal(http.StatusForbidden,
+		serveLedger(api, "GET", "http://foo.com/api/v1/ledgers/1", "ledgers:read").Code)
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(ledgerHandler{calls: &calls})
+	assert.Equal(http.StatusOK,
+		serveLedger(api, "GET", "http://foo.com/api/v1/ledgers/1", "other").Code)
+}
+
+// Ensures that the scope required by each operation is documented in the
+// CapabilitiesDocument and Manifest.
+func TestScopePolicyDocumented(t *testing.T) {
+	assert := assert.New(t)
+	calls := 0
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(ledgerHandler{calls: &calls}, Capabilities{},
+		ScopePolicy{Operations: map[string]string{"delete": "ledgers:admin"}})
+
+	resp := serveLedger(api, "GET", "http://foo.com/api/v1/ledgers/_capabilities", "ledgers:read")
+	var decoded struct{ Capabilities CapabilitiesDocument }
+	assert.Nil(json.Unmarshal(resp.Body.Bytes(), &decoded))
+	scopes := map[string]string{}
+	for _, operation := range decoded.Capabilities.Operations {
+		scopes[operation.Name] = operation.Scope
+	}
+	assert.Equal("ledgers:read", scopes["capabilities"])
+	assert.Equal("ledgers:read", scopes["readList"])
+	assert.Equal("ledgers:write", scopes["create"])
+	assert.Equal("ledgers:admin", scopes["delete"])
+
+	encoded, err := api.ExportManifest("json")
+	assert.Nil(err)
+	var manifest Manifest
+	assert.Nil(json.Unmarshal(encoded, &manifest))
+	scopes = map[string]string{}
+	for _, route := range manifest.Routes {
+		scopes[route.Name] = route.Scope
+	}
+	assert.Equal("ledgers:write", scopes["ledgers:update"])
+	assert.Equal("ledgers:admin", scopes["ledgers:deleteOverride"])
+}
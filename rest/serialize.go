@@ -17,9 +17,13 @@ limitations under the License.
 package rest
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
 	"reflect"
+	"sort"
+	"strconv"
+	"sync"
 )
 
 const (
@@ -29,8 +33,15 @@ const (
 	result   = "result"
 	results  = "results"
 	next     = "next"
+	code     = "code"
+	restart  = "restart"
+	errs     = "errors"
 )
 
+// SerializationFailedCode is the error code of responses whose result failed to
+// serialize.
+const SerializationFailedCode = "serialization_failed"
+
 // response is a data structure holding the serializable response body for a request and
 // HTTP status code. It should be created using NewResponse.
 type response struct {
@@ -49,18 +60,129 @@ type ResponseSerializer interface {
 	ContentType() string
 }
 
+// envelopeBuffers pools the buffers JSON responses are encoded into.
+var envelopeBuffers = sync.Pool{New: func() interface{} { return &bytes.Buffer{} }}
+
 // jsonSerializer is an implementation of ResponseSerializer which serializes responses
 // as JSON.
 type jsonSerializer struct{}
 
 // Serialize marshals a response payload into a JSON byte slice to be sent over the wire.
+// The envelope, and the Payloads and slices of Resources produced by applying Rules,
+// are written directly to the output with object fields in sorted order, e.g.
+// messages, next, reason, results, status, and other values are encoded exactly once
+// into the same buffer. The output is identical to json.Marshal's.
 func (j jsonSerializer) Serialize(p Payload) ([]byte, error) {
-	return json.Marshal(p)
+	buf := envelopeBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer envelopeBuffers.Put(buf)
+
+	if err := writeJSON(buf, json.NewEncoder(buf), p); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// writeJSON writes the value to the buffer as JSON. Payloads, maps, slices of
+// Resources, integers, and strings which don't need escaping are written directly,
+// and other values are encoded using the Encoder writing to the buffer. Neither
+// integers nor json.Numbers are converted to float64, so they're written exactly.
+func writeJSON(buf *bytes.Buffer, encoder *json.Encoder, value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		if plainJSONString(v) {
+			buf.WriteByte('"')
+			buf.WriteString(v)
+			buf.WriteByte('"')
+			return nil
+		}
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+		return nil
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+		return nil
+	case uint64:
+		buf.WriteString(strconv.FormatUint(v, 10))
+		return nil
+	case Payload:
+		if v != nil {
+			return writeJSONObject(buf, encoder, v)
+		}
+	case map[string]interface{}:
+		if v != nil {
+			return writeJSONObject(buf, encoder, v)
+		}
+	case []Resource:
+		if v != nil {
+			return writeJSONArray(buf, encoder, v)
+		}
+	}
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	// The Encoder terminates each value with a newline.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// writeJSONObject writes the object to the buffer as JSON with its keys sorted.
+func writeJSONObject(buf *bytes.Buffer, encoder *json.Encoder, object map[string]interface{}) error {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSON(buf, encoder, key); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := writeJSON(buf, encoder, object[key]); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// writeJSONArray writes the Resources to the buffer as a JSON array.
+func writeJSONArray(buf *bytes.Buffer, encoder *json.Encoder, values []Resource) error {
+	buf.WriteByte('[')
+	for i, value := range values {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := writeJSON(buf, encoder, value); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
 }
 
-// ContentType returns the JSON MIME type of the response.
+// plainJSONString returns true if the string can be written as JSON without escaping,
+// i.e. it's printable ASCII without quotes, backslashes, or characters json.Marshal
+// escapes for HTML.
+func plainJSONString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			return false
+		}
+	}
+	return true
+}
+
+// ContentType returns the JSON MIME type of the response. Responses are always
+// encoded as UTF-8.
 func (j jsonSerializer) ContentType() string {
-	return "application/json"
+	return "application/json; charset=utf-8"
 }
 
 // NewResponse constructs a new response struct containing the payload to send back.
@@ -106,8 +228,10 @@ func newSuccessResponse(ctx RequestContext) response {
 func newErrorResponse(ctx RequestContext) response {
 	err := ctx.Error()
 	s := http.StatusInternalServerError
+	errorCode := ""
 	if restError, ok := err.(Error); ok {
 		s = restError.Status()
+		errorCode = restError.Code()
 	}
 
 	payload := Payload{
@@ -115,6 +239,17 @@ func newErrorResponse(ctx RequestContext) response {
 		reason:   http.StatusText(s),
 		messages: ctx.Messages(),
 	}
+	if errorCode != "" {
+		payload[code] = errorCode
+	}
+	if restError, ok := err.(Error); ok && len(restError.FieldErrors()) > 0 {
+		payload[errs] = restError.FieldErrors()
+	}
+	if errorCode == StaleCursorCode {
+		if restartURL, err := restartURL(ctx); err == nil {
+			payload[restart] = restartURL
+		}
+	}
 
 	response := response{
 		Payload: payload,
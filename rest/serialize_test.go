@@ -0,0 +1,124 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mediumResource returns the Payload of a medium-sized resource with Rules applied.
+func mediumResource(i int) Resource {
+	return Payload{
+		"id":          fmt.Sprintf("res-%d", i),
+		"name":        fmt.Sprintf("Resource <%d> & co", i),
+		"description": "A resource of moderate size used to measure serialization cost",
+		"count":       i,
+		"ratio":       float64(i) / 3,
+		"active":      i%2 == 0,
+		"tags":        []string{"alpha", "beta", "gamma"},
+		"created":     time.Unix(int64(1400000000+i), 0).UTC(),
+		"owner":       Payload{"id": i, "name": "owner", "email": "owner@example.com"},
+		"metadata":    map[string]interface{}{"region": "us-east-1", "tier": 2},
+		"parent":      nil,
+		"score":       int64(i) * 1000,
+	}
+}
+
+// mediumList returns a list response Payload of 100 medium-sized resources.
+func mediumList() Payload {
+	resources := make([]Resource, 100)
+	for i := range resources {
+		resources[i] = mediumResource(i)
+	}
+	return Payload{status: 200, reason: "OK", messages: []string{}, results: resources,
+		next: "http://example.com/api/v1/foo?next=abc"}
+}
+
+// Ensures that the JSON serializer's output is identical to marshaling the whole
+// Payload for single resources, lists, and error responses.
+func TestJSONSerializerMatchesMarshal(t *testing.T) {
+	assert := assert.New(t)
+	payloads := []Payload{
+		{status: 200, reason: "OK", messages: []string{}, result: mediumResource(1)},
+		mediumList(),
+		{status: 200, reason: "OK", messages: []string{}, results: []Resource{}},
+		{status: 422, reason: "Unprocessable Entity", messages: []string{"Invalid <input>"},
+			errs: FieldErrors{{Field: "name", Code: FieldRequiredCode, Message: "required"}}},
+		{status: 410, reason: "Gone", messages: []string{}, code: StaleCursorCode,
+			restart: "http://example.com/api/v1/foo"},
+		{"count": int64(42)},
+		{},
+		nil,
+	}
+	for _, payload := range payloads {
+		expected, err := json.Marshal(payload)
+		assert.Nil(err)
+		actual, err := jsonSerializer{}.Serialize(payload)
+		assert.Nil(err)
+		assert.Equal(string(expected), string(actual))
+	}
+}
+
+// Ensures that the JSON serializer returns the error of a value which fails to
+// encode.
+func TestJSONSerializerError(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{status: 200, result: balance{Amount: -1}}
+	_, expected := json.Marshal(payload)
+	_, err := jsonSerializer{}.Serialize(payload)
+	assert.NotNil(err)
+	assert.Equal(expected.Error(), err.Error())
+
+	resp := serve(newBalanceAPI(), "GET", "http://example.com/api/v1/balances/1")
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+}
+
+// newBalanceAPI returns an API serving balances which fail to serialize.
+func newBalanceAPI() API {
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(balanceHandler{balances: []Resource{balance{Amount: -1}}})
+	return api
+}
+
+// BenchmarkJSONSerializeList measures serializing a 100-item list response.
+func BenchmarkJSONSerializeList(b *testing.B) {
+	payload := mediumList()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := (jsonSerializer{}).Serialize(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkJSONMarshalList measures serializing a 100-item list response by
+// marshaling the whole Payload, as the JSON serializer previously did.
+func BenchmarkJSONMarshalList(b *testing.B) {
+	payload := mediumList()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Stats returns a snapshot of the API's operational state, including its counters,
+// maintenance state, circuit breakers, background tasks, runtime flags, and
+// authentication cache. This is what the stats endpoint serves.
+func (r *muxAPI) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"counters":    r.metrics.Counters(),
+		"maintenance": r.maintenance.state().stats(),
+		"circuits":    r.circuitStats(),
+		"tasks":       r.tasks.statuses(),
+		"flags":       r.flags.stats(),
+		"auth_cache":  r.authDecisions.stats(),
+	}
+}
+
+// handleStats is an http.HandlerFunc which serves the API's Stats as JSON.
+func (r *muxAPI) handleStats(w http.ResponseWriter, req *http.Request) {
+	stats, err := json.Marshal(r.Stats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", jsonSerializer{}.ContentType())
+	w.Write(stats)
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// Store namespaces used by framework features.
+const (
+	// RateLimitNamespace holds the request counts of RateLimit windows.
+	RateLimitNamespace = "ratelimit"
+
+	// ResponseCacheNamespace holds the responses cached by ResponseCache.
+	ResponseCacheNamespace = "responsecache"
+
+	// QuotaNamespace holds the bytes transferred in Quota windows.
+	QuotaNamespace = "quota"
+
+	// ExportNamespace holds the state of DataExport jobs.
+	ExportNamespace = "export"
+)
+
+// Store is shared state used by framework features which must coordinate across API
+// instances, such as rate limits. Keys are namespaced by feature so a single Store can
+// back every feature. The Configuration's Store is used by every feature, so swapping
+// the in-memory Store for a shared one, e.g. the Redis implementation in the redis
+// sub-package, only requires a Configuration change. The storetest sub-package
+// provides conformance tests for implementations.
+//
+// Features have these expectations of the Store:
+//   - Rate limits rely on Increment being atomic. Windows are aligned to the clock of
+//     the calling instance, so instances should have synchronized clocks.
+//   - Quotas rely on IncrementBy being atomic so concurrent requests' bytes are all
+//     counted.
+//   - Idempotency keys rely on CompareAndSwap being atomic and on Get observing a
+//     successful CompareAndSwap, i.e. linearizable access to a single key.
+//   - Response caches tolerate stale Gets and lost Sets, so a Store may be eventually
+//     consistent for them.
+//   - Export jobs rely on Get observing the latest Set so their progress is reported
+//     accurately.
+//   - Outboxes rely on Append being atomic and durable once it returns and on Range
+//     returning values in the order they were appended.
+//
+// Expired values are never returned. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value of the key and true, or false if it doesn't exist.
+	Get(namespace, key string) ([]byte, bool, error)
+
+	// Set sets the value of the key. It expires after the ttl unless it's zero.
+	Set(namespace, key string, value []byte, ttl time.Duration) error
+
+	// Delete deletes the key's value, list, and count, if any.
+	Delete(namespace, key string) error
+
+	// Increment increments the key's count in the current fixed window of the
+	// duration and returns the count in the window and when it resets.
+	Increment(namespace, key string, window time.Duration) (int64, time.Time, error)
+
+	// IncrementBy increments the key's count in the current fixed window of the
+	// duration by n, which may be zero to read the count, and returns the count in the
+	// window and when it resets. It shares counts with Increment.
+	IncrementBy(namespace, key string, n int64, window time.Duration) (int64, time.Time, error)
+
+	// CompareAndSwap sets the key's value to the new value if its current value is
+	// old, or if it doesn't exist when old is nil. It expires after the ttl unless
+	// it's zero. Returns true if the value was set.
+	CompareAndSwap(namespace, key string, old, value []byte, ttl time.Duration) (bool, error)
+
+	// Append appends the value to the key's list.
+	Append(namespace, key string, value []byte) error
+
+	// Range returns the values in the key's list in the order they were appended.
+	Range(namespace, key string) ([][]byte, error)
+}
+
+// store returns the Store used by the API's features, which is the Configuration's
+// Store or, if it doesn't have one, an in-memory Store.
+func (r *muxAPI) store() Store {
+	if r.config.Store != nil {
+		return r.config.Store
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.memoryStore == nil {
+		r.memoryStore = NewMemoryStore()
+	}
+	return r.memoryStore
+}
+
+// MemoryStore is a Store which keeps state in memory, so it's shared only within an
+// instance. It's safe for concurrent use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	values  map[string]memoryValue
+	windows map[string]limiterWindow
+	lists   map[string][][]byte
+	swept   time.Time
+	now     func() time.Time
+}
+
+// memoryValue is a value in a MemoryStore.
+type memoryValue struct {
+	value   []byte
+	expires time.Time
+}
+
+// expired returns true if the value has expired at the time.
+func (m memoryValue) expired(now time.Time) bool {
+	return !m.expires.IsZero() && !now.Before(m.expires)
+}
+
+// memorySweepInterval is how often a MemoryStore discards expired values.
+const memorySweepInterval = time.Minute
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		values:  map[string]memoryValue{},
+		windows: map[string]limiterWindow{},
+		lists:   map[string][][]byte{},
+		now:     time.Now,
+	}
+}
+
+// storeKey returns the key of the namespace's key in a MemoryStore.
+func storeKey(namespace, key string) string {
+	return namespace + "\x00" + key
+}
+
+// Get returns the value of the key and true, or false if it doesn't exist.
+func (m *MemoryStore) Get(namespace, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.get(storeKey(namespace, key))
+	return value, ok, nil
+}
+
+// get returns a copy of the value of the key if it exists and hasn't expired. The
+// caller must hold the lock.
+func (m *MemoryStore) get(key string) ([]byte, bool) {
+	value, ok := m.values[key]
+	if !ok || value.expired(m.now()) {
+		return nil, false
+	}
+	return append([]byte{}, value.value...), true
+}
+
+// Set sets the value of the key. It expires after the ttl unless it's zero.
+func (m *MemoryStore) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(storeKey(namespace, key), value, ttl)
+	return nil
+}
+
+// set sets a copy of the value of the key. The caller must hold the lock.
+func (m *MemoryStore) set(key string, value []byte, ttl time.Duration) {
+	now := m.now()
+	m.sweep(now)
+	stored := memoryValue{value: append([]byte{}, value...)}
+	if ttl > 0 {
+		stored.expires = now.Add(ttl)
+	}
+	m.values[key] = stored
+}
+
+// sweep discards expired values and windows if they haven't been discarded recently.
+// The caller must hold the lock.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Before(m.swept.Add(memorySweepInterval)) {
+		return
+	}
+	for k, v := range m.values {
+		if v.expired(now) {
+			delete(m.values, k)
+		}
+	}
+	for k, w := range m.windows {
+		if !now.Before(w.reset) {
+			delete(m.windows, k)
+		}
+	}
+	m.swept = now
+}
+
+// Delete deletes the key's value, list, and count, if any.
+func (m *MemoryStore) Delete(namespace, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := storeKey(namespace, key)
+	delete(m.values, k)
+	delete(m.windows, k)
+	delete(m.lists, k)
+	return nil
+}
+
+// Increment increments the key's count in the current fixed window of the duration
+// and returns the count in the window and when it resets.
+func (m *MemoryStore) Increment(namespace, key string, window time.Duration) (int64,
+	time.Time, error) {
+
+	return m.IncrementBy(namespace, key, 1, window)
+}
+
+// IncrementBy increments the key's count in the current fixed window of the duration
+// by n and returns the count in the window and when it resets.
+func (m *MemoryStore) IncrementBy(namespace, key string, n int64, window time.Duration) (
+	int64, time.Time, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := storeKey(namespace, key)
+	now := m.now()
+	m.sweep(now)
+	w, ok := m.windows[k]
+	if !ok || !now.Before(w.reset) {
+		w = limiterWindow{reset: now.Truncate(window).Add(window)}
+	}
+	w.count += n
+	m.windows[k] = w
+	return w.count, w.reset, nil
+}
+
+// CompareAndSwap sets the key's value to the new value if its current value is old,
+// or if it doesn't exist when old is nil. It expires after the ttl unless it's zero.
+// Returns true if the value was set.
+func (m *MemoryStore) CompareAndSwap(namespace, key string, old, value []byte,
+	ttl time.Duration) (bool, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := storeKey(namespace, key)
+	current, ok := m.get(k)
+	if old == nil && ok || old != nil && (!ok || !bytes.Equal(current, old)) {
+		return false, nil
+	}
+	m.set(k, value, ttl)
+	return true, nil
+}
+
+// Append appends the value to the key's list.
+func (m *MemoryStore) Append(namespace, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := storeKey(namespace, key)
+	m.lists[k] = append(m.lists[k], append([]byte{}, value...))
+	return nil
+}
+
+// Range returns the values in the key's list in the order they were appended.
+func (m *MemoryStore) Range(namespace, key string) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := m.lists[storeKey(namespace, key)]
+	values := make([][]byte, len(list))
+	for i, value := range list {
+		values[i] = append([]byte{}, value...)
+	}
+	return values, nil
+}
+
+// storeLimiter is a LimiterStore which tracks consumption in a Store.
+type storeLimiter struct {
+	store Store
+	tier  func(key string) RateLimitTier
+}
+
+// Tier returns the RateLimitTier for the key.
+func (s storeLimiter) Tier(key string) (RateLimitTier, error) {
+	return s.tier(key), nil
+}
+
+// Increment records a request for the key in the current window of the duration in
+// the Store and returns the number of requests recorded in it and when it resets.
+func (s storeLimiter) Increment(key string, window time.Duration) (int, time.Time, error) {
+	count, reset, err := s.store.Increment(RateLimitNamespace, key, window)
+	return int(count), reset, err
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package storetest provides conformance tests for implementations of rest.Store.
+package storetest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Workiva/go-rest/rest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run runs the conformance tests against Stores returned by the function. Each test
+// uses its own namespace, so the Stores may share state. Tests of expiration sleep
+// briefly, so implementations must expire values within 100 milliseconds.
+func Run(t *testing.T, newStore func() rest.Store) {
+	namespace := fmt.Sprintf("storetest-%d", time.Now().UnixNano())
+	tests := []struct {
+		name string
+		test func(*testing.T, rest.Store, string)
+	}{
+		{"GetSet", testGetSet},
+		{"Expiration", testExpiration},
+		{"Delete", testDelete},
+		{"Increment", testIncrement},
+		{"IncrementConcurrent", testIncrementConcurrent},
+		{"IncrementBy", testIncrementBy},
+		{"IncrementByConcurrent", testIncrementByConcurrent},
+		{"CompareAndSwap", testCompareAndSwap},
+		{"CompareAndSwapConcurrent", testCompareAndSwapConcurrent},
+		{"AppendRange", testAppendRange},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore(), namespace+"-"+test.name)
+		})
+	}
+}
+
+// testGetSet ensures that values are set and returned and that namespaces are
+// separate.
+func testGetSet(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	_, ok, err := store.Get(namespace, "key")
+	assert.Nil(err)
+	assert.False(ok)
+
+	assert.Nil(store.Set(namespace, "key", []byte("value"), 0))
+	value, ok, err := store.Get(namespace, "key")
+	assert.Nil(err)
+	assert.True(ok)
+	assert.Equal([]byte("value"), value)
+
+	_, ok, _ = store.Get(namespace+"-other", "key")
+	assert.False(ok)
+
+	assert.Nil(store.Set(namespace, "key", []byte{}, 0))
+	value, ok, _ = store.Get(namespace, "key")
+	assert.True(ok)
+	assert.Len(value, 0)
+}
+
+// testExpiration ensures that values expire after their ttl.
+func testExpiration(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	assert.Nil(store.Set(namespace, "key", []byte("value"), 50*time.Millisecond))
+	ok, err := store.CompareAndSwap(namespace, "swapped", nil, []byte("value"),
+		50*time.Millisecond)
+	assert.True(ok)
+	assert.Nil(err)
+	_, ok, _ = store.Get(namespace, "key")
+	assert.True(ok)
+
+	time.Sleep(150 * time.Millisecond)
+	_, ok, _ = store.Get(namespace, "key")
+	assert.False(ok)
+	_, ok, _ = store.Get(namespace, "swapped")
+	assert.False(ok)
+}
+
+// testDelete ensures that deleting a key removes its value, list, and count.
+func testDelete(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	assert.Nil(store.Set(namespace, "key", []byte("value"), 0))
+	assert.Nil(store.Append(namespace, "list", []byte("value")))
+	store.Increment(namespace, "count", time.Hour)
+	store.Increment(namespace, "count", time.Hour)
+
+	assert.Nil(store.Delete(namespace, "key"))
+	assert.Nil(store.Delete(namespace, "list"))
+	assert.Nil(store.Delete(namespace, "count"))
+	assert.Nil(store.Delete(namespace, "missing"))
+	_, ok, _ := store.Get(namespace, "key")
+	assert.False(ok)
+	values, err := store.Range(namespace, "list")
+	assert.Nil(err)
+	assert.Len(values, 0)
+	count, _, err := store.Increment(namespace, "count", time.Hour)
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+}
+
+// testIncrement ensures that counts increase within a window and restart in the next.
+func testIncrement(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	window := 200 * time.Millisecond
+	// Start at the beginning of a window so the counts don't straddle two.
+	time.Sleep(time.Until(time.Now().Truncate(window).Add(window)))
+
+	count, reset, err := store.Increment(namespace, "key", window)
+	assert.Nil(err)
+	assert.Equal(int64(1), count)
+	assert.True(reset.After(time.Now()))
+	assert.False(reset.After(time.Now().Add(window)))
+	count, _, _ = store.Increment(namespace, "key", window)
+	assert.Equal(int64(2), count)
+	count, _, _ = store.Increment(namespace, "other", window)
+	assert.Equal(int64(1), count)
+
+	time.Sleep(time.Until(reset))
+	count, next, _ := store.Increment(namespace, "key", window)
+	assert.Equal(int64(1), count)
+	assert.True(next.After(reset))
+}
+
+// testIncrementConcurrent ensures that concurrent increments are atomic.
+func testIncrementConcurrent(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	counts := map[int64]bool{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			count, _, err := store.Increment(namespace, "key", time.Hour)
+			assert.Nil(err)
+			mu.Lock()
+			counts[count] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Len(counts, 50)
+	assert.True(counts[50])
+}
+
+// testIncrementBy ensures that counts increase by the amount, that zero reads the
+// count, and that counts are shared with Increment.
+func testIncrementBy(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+
+	count, reset, err := store.IncrementBy(namespace, "key", 0, time.Hour)
+	assert.Nil(err)
+	assert.Equal(int64(0), count)
+	assert.True(reset.After(time.Now()))
+	count, _, _ = store.IncrementBy(namespace, "key", 5000000000, time.Hour)
+	assert.Equal(int64(5000000000), count)
+	count, _, _ = store.Increment(namespace, "key", time.Hour)
+	assert.Equal(int64(5000000001), count)
+	count, _, _ = store.IncrementBy(namespace, "key", 0, time.Hour)
+	assert.Equal(int64(5000000001), count)
+}
+
+// testIncrementByConcurrent ensures that concurrent increments by an amount are all
+// counted.
+func testIncrementByConcurrent(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := store.IncrementBy(namespace, "key", 3, time.Hour)
+			assert.Nil(err)
+		}()
+	}
+	wg.Wait()
+	count, _, err := store.IncrementBy(namespace, "key", 0, time.Hour)
+	assert.Nil(err)
+	assert.Equal(int64(150), count)
+}
+
+// testCompareAndSwap ensures that values are only swapped if they match.
+func testCompareAndSwap(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	ok, err := store.CompareAndSwap(namespace, "key", nil, []byte("a"), 0)
+	assert.Nil(err)
+	assert.True(ok)
+	ok, _ = store.CompareAndSwap(namespace, "key", nil, []byte("b"), 0)
+	assert.False(ok)
+	ok, _ = store.CompareAndSwap(namespace, "key", []byte("b"), []byte("c"), 0)
+	assert.False(ok)
+	ok, _ = store.CompareAndSwap(namespace, "key", []byte("a"), []byte("c"), 0)
+	assert.True(ok)
+	value, _, _ := store.Get(namespace, "key")
+	assert.Equal([]byte("c"), value)
+
+	ok, _ = store.CompareAndSwap(namespace, "missing", []byte("a"), []byte("b"), 0)
+	assert.False(ok)
+}
+
+// testCompareAndSwapConcurrent ensures that exactly one of many concurrent swaps of
+// the same value succeeds.
+func testCompareAndSwapConcurrent(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	swapped := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := store.CompareAndSwap(namespace, "key", nil, []byte(fmt.Sprint(i)), 0)
+			assert.Nil(err)
+			if ok {
+				mu.Lock()
+				swapped++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(1, swapped)
+}
+
+// testAppendRange ensures that lists keep values in the order they were appended.
+func testAppendRange(t *testing.T, store rest.Store, namespace string) {
+	assert := assert.New(t)
+	values, err := store.Range(namespace, "list")
+	assert.Nil(err)
+	assert.Len(values, 0)
+
+	for _, value := range []string{"a", "b", "c"} {
+		assert.Nil(store.Append(namespace, "list", []byte(value)))
+	}
+	values, err = store.Range(namespace, "list")
+	assert.Nil(err)
+	assert.Equal([][]byte{[]byte("a"), []byte("b"), []byte("c")}, values)
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	// UndeclaredFieldsCounter counts responses containing fields not declared by the
+	// resource's Rules for the version.
+	UndeclaredFieldsCounter = "undeclared_fields"
+
+	// UndeclaredFieldsCode is the error code of responses failed by an enforcing
+	// StrictOutput because they contained undeclared fields.
+	UndeclaredFieldsCode = "undeclared_fields"
+)
+
+// OutputMode determines what StrictOutput does with undeclared fields.
+type OutputMode int
+
+const (
+	// OutputWarn removes undeclared fields from responses, logging and counting them.
+	OutputWarn OutputMode = iota
+
+	// OutputEnforce fails responses containing undeclared fields with a 500, logging
+	// and counting them. It's intended for staging environments.
+	OutputEnforce
+)
+
+// StrictOutput is a ResourceOption which guarantees a resource's responses contain only
+// the fields declared by its outbound Rules for the requested version, at every level,
+// so a field added to a type without a Rule is never exposed. The Configuration's
+// StrictOutput applies to every resource, and one passed as a ResourceOption replaces
+// it for that resource.
+//
+// Fields are checked after Rules are applied, using the payload they produce, so only
+// values which aren't already maps or slices, such as structs returned by an
+// OutputHandler or types with a custom MarshalJSON, are marshaled to be inspected. A
+// field declared without nested Rules is treated as a declared value as a whole, and
+// a resource with Rules, but none for the version, has no declared fields. Resources
+// without any Rules aren't checked, since they declare no contract.
+type StrictOutput struct {
+	// Mode determines whether undeclared fields are removed or fail the response.
+	// Defaults to OutputWarn.
+	Mode OutputMode
+}
+
+// apply sets the StrictOutput on the resource.
+func (s StrictOutput) apply(opts *resourceOptions) {
+	opts.strictOutput = &s
+}
+
+// strictHandler is a ResourceHandler whose responses are checked against its Rules.
+type strictHandler struct {
+	ResourceHandler
+	strict *StrictOutput
+}
+
+// unwrap returns the wrapped ResourceHandler.
+func (s strictHandler) unwrap() ResourceHandler {
+	return s.ResourceHandler
+}
+
+// strictOutput returns the StrictOutput the ResourceHandler was registered with, if
+// any.
+func strictOutput(h ResourceHandler) *StrictOutput {
+	for {
+		if strict, ok := h.(strictHandler); ok {
+			return strict.strict
+		}
+		wrapper, ok := h.(handlerWrapper)
+		if !ok {
+			return nil
+		}
+		h = wrapper.unwrap()
+	}
+}
+
+// enforceOutput checks the result, with outbound Rules applied, against the handler's
+// StrictOutput, if any, unless it has no Rules. Returns the result with undeclared fields removed in warn mode
+// or a 500 Error if there are any in enforce mode.
+func (h requestHandler) enforceOutput(handler ResourceHandler, result Resource,
+	rules Rules, version string) (Resource, error) {
+
+	strict := strictOutput(handler)
+	if strict == nil || !declaresOutput(rules) {
+		return result, nil
+	}
+	declared, undeclared := declaredOutput(result, rules, version, "")
+	return declared, h.undeclaredFields(handler.ResourceName(), strict, undeclared)
+}
+
+// enforceOutputList checks the results, with outbound Rules applied, against the
+// handler's StrictOutput, if any, like enforceOutput. Field paths are prefixed by the
+// result's index.
+func (h requestHandler) enforceOutputList(handler ResourceHandler, results []Resource,
+	rules Rules, version string) ([]Resource, error) {
+
+	strict := strictOutput(handler)
+	if strict == nil || !declaresOutput(rules) {
+		return results, nil
+	}
+	all := []string{}
+	for i, result := range results {
+		declared, undeclared := declaredOutput(result, rules, version, fmt.Sprintf("[%d]", i))
+		results[i] = declared
+		all = append(all, undeclared...)
+	}
+	return results, h.undeclaredFields(handler.ResourceName(), strict, all)
+}
+
+// declaresOutput indicates if there are Rules to check responses against.
+func declaresOutput(rules Rules) bool {
+	return rules != nil && rules.Size() > 0
+}
+
+// undeclaredFields logs and counts the undeclared field paths, if any, and returns a
+// 500 Error if the StrictOutput enforces the contract.
+func (h requestHandler) undeclaredFields(resource string, strict *StrictOutput,
+	undeclared []string) error {
+
+	if len(undeclared) == 0 {
+		return nil
+	}
+	h.Metrics().incr(UndeclaredFieldsCounter, resource)
+	h.Configuration().Logf("Response for %s contains undeclared fields: %s", resource,
+		strings.Join(undeclared, ", "))
+	if strict.Mode != OutputEnforce {
+		return nil
+	}
+	return InternalServerError("Response contains undeclared fields").
+		WithCode(UndeclaredFieldsCode)
+}
+
+// declaredOutput returns the value with fields the outbound Rules for the version don't
+// declare removed, along with the paths of those fields prefixed by the path. The value
+// is returned as-is if there are none.
+func declaredOutput(value interface{}, rules Rules, version, path string) (interface{},
+	[]string) {
+
+	switch v := genericOutput(value).(type) {
+	case Payload:
+		return declaredFields(v, rules, version, path, value)
+	case map[string]interface{}:
+		return declaredFields(v, rules, version, path, value)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		undeclared := []string{}
+		for i, item := range v {
+			var paths []string
+			items[i], paths = declaredOutput(item, rules, version, fmt.Sprintf("%s[%d]", path, i))
+			undeclared = append(undeclared, paths...)
+		}
+		if len(undeclared) > 0 {
+			return items, undeclared
+		}
+	}
+	return value, nil
+}
+
+// declaredFields returns the object with fields the outbound Rules for the version
+// don't declare removed, along with their paths, or the original value if there are
+// none.
+func declaredFields(object map[string]interface{}, rules Rules, version, path string,
+	original interface{}) (interface{}, []string) {
+
+	declared := map[string]*Rule{}
+	if rules != nil {
+		for _, rule := range rules.Filter(false).ForVersion(version).Contents() {
+			if rule.isResourceRule() {
+				declared[rule.Name()] = rule
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	cleaned := make(Payload, len(object))
+	undeclared := []string{}
+	for _, key := range keys {
+		fieldPath := joinFieldPath(path, key)
+		rule, ok := declared[key]
+		if !ok {
+			undeclared = append(undeclared, fieldPath)
+			continue
+		}
+		value := object[key]
+		if rule.Rules != nil {
+			var paths []string
+			value, paths = declaredOutput(value, rule.Rules, version, fieldPath)
+			undeclared = append(undeclared, paths...)
+		}
+		cleaned[key] = value
+	}
+	if len(undeclared) == 0 {
+		return original, nil
+	}
+	return cleaned, undeclared
+}
+
+// genericOutput returns the value as the maps and slices it's serialized as. Values
+// which already are, and scalars, are returned as-is. Others are marshaled and decoded,
+// or returned as-is if they can't be.
+func genericOutput(value interface{}) interface{} {
+	switch value.(type) {
+	case nil, Payload, map[string]interface{}, []interface{}, string, bool, float64, int,
+		int64, json.Number:
+		return value
+	}
+	if _, ok := value.(json.Marshaler); !ok {
+		switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+		case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Interface:
+		default:
+			return value
+		}
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var decoded interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(encoded)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// strictAudit is embedded in strictDocument, so its fields are flattened.
+type strictAudit struct {
+	CreatedBy string `json:"created_by"`
+	ClientIP  string `json:"client_ip"`
+}
+
+// strictOwner is a nested struct of strictDocument.
+type strictOwner struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// strictToken has a custom MarshalJSON which emits a field its type doesn't have.
+type strictToken struct {
+	Label string
+}
+
+func (s strictToken) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"label": s.Label, "secret": "hunter2"})
+}
+
+// strictDocument is a resource with fields at several levels.
+type strictDocument struct {
+	strictAudit
+	ID     string                 `json:"id"`
+	Owner  strictOwner            `json:"owner"`
+	Labels map[string]interface{} `json:"labels"`
+	Token  strictToken            `json:"token"`
+}
+
+// strictHandlerV1 is a ResourceHandler whose Rules only cover version 1.
+type strictHandlerV1 struct {
+	BaseResourceHandler
+}
+
+func (s strictHandlerV1) ResourceName() string {
+	return "documents"
+}
+
+func (s strictHandlerV1) Rules() Rules {
+	return NewRules((*strictDocument)(nil),
+		&Rule{Field: "ID", FieldAlias: "id", Versions: []string{"1"}},
+		&Rule{Field: "Owner", FieldAlias: "owner", Versions: []string{"1"},
+			Rules: NewRules((*strictOwner)(nil), &Rule{Field: "Name", FieldAlias: "name"})},
+	)
+}
+
+func (s strictHandlerV1) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &strictDocument{
+		strictAudit: strictAudit{CreatedBy: "bob", ClientIP: "10.0.0.1"},
+		ID:          id,
+		Owner:       strictOwner{Name: "alice", Email: "alice@example.com"},
+	}, nil
+}
+
+// newStrictAPI returns an API serving strictHandlerV1 with the StrictOutput and its
+// log output.
+func newStrictAPI(strict StrictOutput) (API, *bytes.Buffer) {
+	logs := &bytes.Buffer{}
+	config := NewConfiguration()
+	config.Logger = log.New(logs, "", 0)
+	api := NewAPI(config)
+	api.RegisterResourceHandler(strictHandlerV1{}, strict)
+	return api, logs
+}
+
+// Ensures that undeclared fields are found in nested structs, embedded structs, maps,
+// and custom MarshalJSON output, and that declared values are left as-is.
+func TestDeclaredOutput(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*strictDocument)(nil),
+		&Rule{Field: "ID", FieldAlias: "id"},
+		&Rule{Field: "Owner", FieldAlias: "owner",
+			Rules: NewRules((*strictOwner)(nil), &Rule{Field: "Name", FieldAlias: "name"})},
+		&Rule{Field: "Labels", FieldAlias: "labels",
+			Rules: NewRules((*strictOwner)(nil), &Rule{Field: "Name", FieldAlias: "name"})},
+		&Rule{Field: "Token", FieldAlias: "token",
+			Rules: NewRules((*strictToken)(nil), &Rule{Field: "Label", FieldAlias: "label"})},
+	)
+	document := strictDocument{
+		strictAudit: strictAudit{CreatedBy: "bob"},
+		ID:          "1",
+		Owner:       strictOwner{Name: "alice", Email: "alice@example.com"},
+		Labels:      map[string]interface{}{"name": "x", "cost": 3},
+		Token:       strictToken{Label: "api"},
+	}
+
+	declared, undeclared := declaredOutput(document, rules, "1", "")
+	assert.Equal([]string{"client_ip", "created_by", "labels.cost", "owner.email",
+		"token.secret"}, undeclared)
+	encoded, _ := json.Marshal(declared)
+	assert.JSONEq(`{"id": "1", "owner": {"name": "alice"}, "labels": {"name": "x"},
+		"token": {"label": "api"}}`, string(encoded))
+
+	payload := Payload{"id": "1", "owner": []interface{}{Payload{"name": "a"}}}
+	declared, undeclared = declaredOutput(payload, rules, "1", "")
+	assert.Empty(undeclared)
+	assert.Equal(payload, declared)
+
+	declared, undeclared = declaredOutput([]interface{}{document.Owner}, rules.Contents()[1].Rules,
+		"1", "owners")
+	assert.Equal([]string{"owners[0].email"}, undeclared)
+	assert.Equal([]interface{}{Payload{"name": "alice"}}, declared)
+}
+
+// Ensures that in warn mode undeclared fields are removed from responses, logged, and
+// counted, while declared responses are unchanged.
+func TestStrictOutputWarn(t *testing.T) {
+	assert := assert.New(t)
+	api, logs := newStrictAPI(StrictOutput{})
+
+	resp := serve(api, "GET", "http://example.com/api/v1/documents/1")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.JSONEq(`{"status": 200, "reason": "OK", "messages": [],
+		"result": {"id": "1", "owner": {"name": "alice"}}}`, resp.Body.String())
+	assert.Equal(uint64(0), api.Metrics().Counter(UndeclaredFieldsCounter, "documents"))
+
+	resp = serve(api, "GET", "http://example.com/api/v2/documents/1")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.JSONEq(`{"status": 200, "reason": "OK", "messages": [], "result": {}}`,
+		resp.Body.String())
+	assert.Equal(uint64(1), api.Metrics().Counter(UndeclaredFieldsCounter, "documents"))
+	assert.Contains(logs.String(), "client_ip, created_by, id, labels, owner, token")
+}
+
+// Ensures that in enforce mode responses with undeclared fields fail with a 500 and a
+// distinct code, and that the Configuration's StrictOutput applies by default.
+func TestStrictOutputEnforce(t *testing.T) {
+	assert := assert.New(t)
+	api, _ := newStrictAPI(StrictOutput{Mode: OutputEnforce})
+
+	resp := serve(api, "GET", "http://example.com/api/v2/documents/1")
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+	assert.Contains(resp.Body.String(), `"code":"`+UndeclaredFieldsCode+`"`)
+	assert.NotContains(resp.Body.String(), "10.0.0.1")
+
+	config := NewConfiguration()
+	config.StrictOutput = &StrictOutput{Mode: OutputEnforce}
+	api = NewAPI(config)
+	api.RegisterResourceHandler(strictHandlerV1{})
+	resp = serve(api, "GET", "http://example.com/api/v2/documents/1")
+	assert.Equal(http.StatusInternalServerError, resp.Code)
+	assert.Equal(uint64(1), api.Metrics().Counter(UndeclaredFieldsCounter, "documents"))
+}
+
+// strictHandlerV2 is a ResourceHandler whose Rules declare fields of strictDocument's
+// embedded struct and of its token's MarshalJSON output.
+type strictHandlerV2 struct {
+	strictHandlerV1
+}
+
+func (s strictHandlerV2) Rules() Rules {
+	return NewRules((*strictDocument)(nil),
+		&Rule{Field: "ID", FieldAlias: "id"},
+		&Rule{Field: "CreatedBy", FieldAlias: "created_by"},
+		&Rule{Field: "Token", FieldAlias: "token",
+			Rules: NewRules((*strictToken)(nil), &Rule{Field: "Label", FieldAlias: "label"})},
+	)
+}
+
+func (s strictHandlerV2) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return &strictDocument{
+		strictAudit: strictAudit{CreatedBy: "bob", ClientIP: "10.0.0.1"},
+		ID:          id,
+		Token:       strictToken{Label: "api"},
+	}, nil
+}
+
+// Ensures that in warn mode fields declared by Rules are kept when they belong to
+// embedded structs or types with a custom MarshalJSON, and the others are removed.
+func TestStrictOutputEmbeddedAndMarshaler(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(NewConfiguration())
+	api.RegisterResourceHandler(strictHandlerV2{}, StrictOutput{})
+
+	resp := serve(api, "GET", "http://example.com/api/v1/documents/1")
+	assert.Equal(http.StatusOK, resp.Code)
+	assert.JSONEq(`{"status": 200, "reason": "OK", "messages": [],
+		"result": {"id": "1", "created_by": "bob", "token": {"label": "api"}}}`,
+		resp.Body.String())
+	assert.NotContains(resp.Body.String(), "hunter2")
+	assert.NotContains(resp.Body.String(), "10.0.0.1")
+}
+
+// Ensures that responses of resources without Rules aren't checked in either mode.
+func TestStrictOutputWithoutRules(t *testing.T) {
+	assert := assert.New(t)
+	for _, mode := range []OutputMode{OutputWarn, OutputEnforce} {
+		api := NewAPI(NewConfiguration())
+		api.RegisterResourceHandler(&roleHandler{}, StrictOutput{Mode: mode})
+
+		resp := serveCached(api, "acme", "admin")
+		assert.Equal(http.StatusOK, resp.Code)
+		assert.JSONEq(`{"status": 200, "reason": "OK", "messages": [],
+			"result": {"id": "1", "salary": 100}}`, resp.Body.String())
+		assert.Equal(uint64(0), api.Metrics().Counter(UndeclaredFieldsCounter, "reports"))
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+// Background task states.
+const (
+	TaskRunning    = "running"
+	TaskRestarting = "restarting"
+	TaskStopped    = "stopped"
+	TaskFailed     = "failed"
+)
+
+// TaskStatus describes a background goroutine run by the framework.
+type TaskStatus struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// restartPolicy determines whether and when a failed background task is restarted.
+type restartPolicy struct {
+	// maxRestarts is the number of times the task is restarted after failing.
+	// Negative means it's always restarted.
+	maxRestarts int
+
+	// backoff is the delay before restarting the task.
+	backoff time.Duration
+}
+
+// task is a background goroutine run by a supervisor.
+type task struct {
+	name     string
+	run      func(context.Context) error
+	policy   restartPolicy
+	state    string
+	restarts int
+	lastErr  error
+}
+
+// supervisor runs the framework's background goroutines, tying them to the API's
+// lifecycle. Tasks receive a Context which is canceled on shutdown. A task which
+// returns an error or panics is restarted according to its restartPolicy rather than
+// taking down the process. It is safe for concurrent use.
+type supervisor struct {
+	mu     sync.Mutex
+	tasks  []*task
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	logf   func(string, ...interface{})
+}
+
+// newSupervisor returns a supervisor which logs task failures using the function.
+func newSupervisor(logf func(string, ...interface{})) *supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &supervisor{ctx: ctx, cancel: cancel, logf: logf}
+}
+
+// start runs the function in a background goroutine under the name until it returns
+// nil or the supervisor is stopped. If the function returns an error or panics, it's
+// restarted per the restartPolicy. Tasks started after the supervisor is stopped are
+// not run.
+func (s *supervisor) start(name string, policy restartPolicy, run func(context.Context) error) {
+	t := &task{name: name, run: run, policy: policy, state: TaskRunning}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks = append(s.tasks, t)
+	if s.ctx.Err() != nil {
+		t.state = TaskStopped
+		return
+	}
+	s.wg.Add(1)
+	go s.supervise(t)
+}
+
+// supervise runs the task, restarting it after failures until the restartPolicy is
+// exhausted or the supervisor is stopped.
+func (s *supervisor) supervise(t *task) {
+	defer s.wg.Done()
+	for {
+		err := s.runOnce(t)
+		if err == nil || s.ctx.Err() != nil {
+			s.setState(t, TaskStopped, err)
+			return
+		}
+
+		s.mu.Lock()
+		t.lastErr = err
+		exhausted := t.policy.maxRestarts >= 0 && t.restarts >= t.policy.maxRestarts
+		if exhausted {
+			t.state = TaskFailed
+		} else {
+			t.state = TaskRestarting
+		}
+		s.mu.Unlock()
+
+		if exhausted {
+			s.logf("Background task %s failed: %s", t.name, err)
+			return
+		}
+		s.logf("Background task %s failed, restarting: %s", t.name, err)
+
+		select {
+		case <-s.ctx.Done():
+			s.setState(t, TaskStopped, err)
+			return
+		case <-time.After(t.policy.backoff):
+		}
+
+		s.mu.Lock()
+		t.restarts++
+		t.state = TaskRunning
+		s.mu.Unlock()
+	}
+}
+
+// runOnce runs the task, converting a panic into an error after logging its stack.
+func (s *supervisor) runOnce(t *task) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.logf("Background task %s panicked: %v\n%s", t.name, recovered, debug.Stack())
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return t.run(s.ctx)
+}
+
+// setState sets the task's state and, if non-nil, its last error.
+func (s *supervisor) setState(t *task, state string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.state = state
+	if err != nil {
+		t.lastErr = err
+	}
+}
+
+// stop cancels the tasks' Context and waits for them to return. Returns an error if
+// they don't return before the timeout.
+func (s *supervisor) stop(timeout time.Duration) error {
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("Timed out waiting for background tasks to stop")
+	}
+}
+
+// statuses returns the status of each task in the order they were started.
+func (s *supervisor) statuses() []TaskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]TaskStatus, len(s.tasks))
+	for i, t := range s.tasks {
+		statuses[i] = TaskStatus{Name: t.name, State: t.state, Restarts: t.restarts}
+		if t.lastErr != nil {
+			statuses[i].LastError = t.lastErr.Error()
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/context"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLog collects messages logged by a supervisor.
+type testLog struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLog) logf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *testLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.messages, "\n")
+}
+
+// waitForState waits for the supervisor's first task to reach the state.
+func waitForState(s *supervisor, state string) TaskStatus {
+	for i := 0; i < 100; i++ {
+		if status := s.statuses()[0]; status.State == state {
+			return status
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return s.statuses()[0]
+}
+
+// Ensures that a panicking task is recovered, logged with its stack, and restarted
+// until its restartPolicy is exhausted.
+func TestSupervisorRestartsPanickingTask(t *testing.T) {
+	assert := assert.New(t)
+	logs := &testLog{}
+	s := newSupervisor(logs.logf)
+	runs := make(chan bool, 10)
+
+	s.start("refresher", restartPolicy{maxRestarts: 2}, func(ctx context.Context) error {
+		runs <- true
+		panic("boom")
+	})
+
+	status := waitForState(s, TaskFailed)
+	assert.Equal(TaskStatus{Name: "refresher", State: TaskFailed, Restarts: 2,
+		LastError: "panic: boom"}, status)
+	assert.Len(runs, 3)
+	assert.Contains(logs.String(), "Background task refresher panicked: boom")
+	assert.Contains(logs.String(), "runtime/debug.Stack")
+	assert.Nil(s.stop(time.Second))
+}
+
+// Ensures that stopping the supervisor cancels running tasks and waits for them to
+// return.
+func TestSupervisorStopCancelsTasks(t *testing.T) {
+	assert := assert.New(t)
+	s := newSupervisor((&testLog{}).logf)
+	started := make(chan bool)
+
+	s.start("poller", restartPolicy{maxRestarts: -1}, func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-started
+
+	assert.Nil(s.stop(time.Second))
+	status := s.statuses()[0]
+	assert.Equal(TaskStopped, status.State)
+	assert.Equal(0, status.Restarts)
+	assert.Equal(context.Canceled.Error(), status.LastError)
+
+	s.start("late", restartPolicy{}, func(ctx context.Context) error {
+		t.Error("Task started after stop was run")
+		return nil
+	})
+	assert.Equal(TaskStopped, s.statuses()[1].State)
+}
+
+// Ensures that stop returns an error if a task ignores cancellation past the timeout.
+func TestSupervisorStopTimeout(t *testing.T) {
+	release := make(chan bool)
+	s := newSupervisor((&testLog{}).logf)
+	s.start("stuck", restartPolicy{}, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	assert.NotNil(t, s.stop(10*time.Millisecond))
+	close(release)
+}
+
+// Ensures that Shutdown waits for requests in flight, rejects new requests with a 503,
+// stops background tasks, and reports them in BackgroundTasks and Stats.
+func TestShutdownDrainsRequests(t *testing.T) {
+	assert := assert.New(t)
+	handler := &blockingHandler{started: make(chan bool), release: make(chan bool)}
+	api := newMaintenanceAPI(&Configuration{}, handler)
+	api.(*muxAPI).tasks.start("refresher", restartPolicy{}, func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- serve(api, "GET", "http://foo.com/api/v0.1/foo/1").Code
+	}()
+	<-handler.started
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- api.Shutdown(time.Second)
+	}()
+	for !api.(*muxAPI).isShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+
+	resp := serve(api, "GET", "http://foo.com/api/v0.1/foo")
+	assert.Equal(http.StatusServiceUnavailable, resp.Code, "Incorrect response code")
+	assert.Equal(TaskRunning, api.BackgroundTasks()[0].State)
+
+	handler.release <- true
+	assert.Equal(http.StatusOK, <-done, "Request in flight was interrupted")
+	assert.Nil(<-shutdown)
+
+	assert.Equal([]TaskStatus{{Name: "refresher", State: TaskStopped}}, api.BackgroundTasks())
+	assert.Equal(api.BackgroundTasks(), api.Stats()["tasks"])
+}
+
+// Ensures that Shutdown returns an error if requests in flight don't complete before
+// the timeout.
+func TestShutdownTimeout(t *testing.T) {
+	handler := &blockingHandler{started: make(chan bool), release: make(chan bool)}
+	api := newMaintenanceAPI(&Configuration{}, handler)
+	go serve(api, "GET", "http://foo.com/api/v0.1/foo/1")
+	<-handler.started
+
+	assert.NotNil(t, api.Shutdown(20*time.Millisecond))
+	handler.release <- true
+}
+
+// Ensures that Start returns nil once the API is shut down.
+func TestShutdownStopsStart(t *testing.T) {
+	api := NewAPI(&Configuration{})
+	started := make(chan error)
+	go func() {
+		started <- api.Start(":0")
+	}()
+	for {
+		api.(*muxAPI).mu.RLock()
+		listening := len(api.(*muxAPI).servers) > 0
+		api.(*muxAPI).mu.RUnlock()
+		if listening {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Nil(t, api.Shutdown(time.Second))
+	assert.Nil(t, <-started)
+}
+
+// Ensures that Shutdown closes connections kept alive between requests.
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: wrapMiddleware(api)}
+	served := make(chan error)
+	go func() {
+		served <- api.(*muxAPI).serve(server, func() error { return server.Serve(listener) })
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /missing HTTP/1.1\r\nHost: foo.com\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if assert.Nil(err) {
+		assert.Equal(http.StatusNotFound, resp.StatusCode)
+		ioutil.ReadAll(resp.Body)
+	}
+
+	assert.Nil(api.Shutdown(time.Second))
+	assert.Nil(<-served)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = reader.ReadByte()
+	assert.Equal(io.EOF, err)
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+// TransactionManager is responsible for the database specifics of a per-request
+// transaction. The framework calls Begin before invoking a mutating ResourceHandler
+// method and Commit or Rollback afterwards depending on the outcome.
+type TransactionManager interface {
+	// Begin starts a transaction and returns a RequestContext carrying the transaction
+	// handle, typically using RequestContext#WithValue. The returned RequestContext
+	// is passed to the ResourceHandler, so the handle is reachable via
+	// RequestContext#Value. If an error is returned, the handler is not invoked.
+	Begin(RequestContext) (RequestContext, error)
+
+	// Commit commits the transaction carried by the RequestContext returned by Begin.
+	Commit(RequestContext) error
+
+	// Rollback aborts the transaction carried by the RequestContext returned by Begin.
+	Rollback(RequestContext) error
+}
+
+// Transactions is a ResourceOption which wraps a resource's create, update, and delete
+// handlers in a transaction managed by the TransactionManager. The ordering is:
+//
+//  1. The request payload is decoded and inbound Rules are applied.
+//  2. Begin is called.
+//  3. The ResourceHandler method is invoked with the transaction's RequestContext.
+//  4. BeforeCommit, if provided, is invoked with the result.
+//  5. If the handler and BeforeCommit succeeded, Commit is called. Otherwise, or if
+//     either panicked, Rollback is called and the panic propagates.
+//  6. Outbound Rules are applied and the response is serialized and written.
+//
+// Since the response is written after the transaction completes, a failed Commit
+// results in an error response rather than a success for uncommitted changes.
+type Transactions struct {
+	// Manager begins, commits, and rolls back transactions.
+	Manager TransactionManager
+
+	// BeforeCommit is invoked within the transaction after the ResourceHandler
+	// succeeds, e.g. to synchronously write audit records. If it returns an error,
+	// the transaction is rolled back and the error is returned to the client.
+	BeforeCommit func(RequestContext, interface{}) error
+
+	// Indicates if read and read list handlers should also be wrapped in a
+	// transaction. Defaults to false.
+	IncludeReads bool
+}
+
+// apply sets the Transactions on the resource.
+func (t Transactions) apply(opts *resourceOptions) {
+	opts.transactions = &t
+}
+
+// transactionalHandler is a ResourceHandler which wraps the ResourceHandler methods
+// in transactions as configured by Transactions.
+type transactionalHandler struct {
+	ResourceHandler
+	transactions *Transactions
+	api          *muxAPI
+}
+
+// unwrap returns the wrapped ResourceHandler.
+func (t transactionalHandler) unwrap() ResourceHandler {
+	return t.ResourceHandler
+}
+
+// CreateResource invokes the wrapped ResourceHandler's CreateResource in a
+// transaction.
+func (t transactionalHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+
+	result, err := t.transact(ctx, func(ctx RequestContext) (interface{}, error) {
+		return t.ResourceHandler.CreateResource(ctx, data, version)
+	})
+	return result, err
+}
+
+// ReadResource invokes the wrapped ResourceHandler's ReadResource in a transaction if
+// reads are included.
+func (t transactionalHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	if !t.transactions.IncludeReads {
+		return t.ResourceHandler.ReadResource(ctx, id, version)
+	}
+	result, err := t.transact(ctx, func(ctx RequestContext) (interface{}, error) {
+		return t.ResourceHandler.ReadResource(ctx, id, version)
+	})
+	return result, err
+}
+
+// ReadResourceList invokes the wrapped ResourceHandler's ReadResourceList in a
+// transaction if reads are included.
+func (t transactionalHandler) ReadResourceList(ctx RequestContext, limit int,
+	cursor string, version string) ([]Resource, string, error) {
+
+	if !t.transactions.IncludeReads {
+		return t.ResourceHandler.ReadResourceList(ctx, limit, cursor, version)
+	}
+	var next string
+	result, err := t.transact(ctx, func(ctx RequestContext) (interface{}, error) {
+		resources, nextCursor, err := t.ResourceHandler.ReadResourceList(
+			ctx, limit, cursor, version)
+		next = nextCursor
+		return resources, err
+	})
+	resources, _ := result.([]Resource)
+	return resources, next, err
+}
+
+// UpdateResourceList invokes the wrapped ResourceHandler's UpdateResourceList in a
+// transaction.
+func (t transactionalHandler) UpdateResourceList(ctx RequestContext, data []Payload,
+	version string) ([]Resource, error) {
+
+	result, err := t.transact(ctx, func(ctx RequestContext) (interface{}, error) {
+		return t.ResourceHandler.UpdateResourceList(ctx, data, version)
+	})
+	resources, _ := result.([]Resource)
+	return resources, err
+}
+
+// UpdateResource invokes the wrapped ResourceHandler's UpdateResource in a
+// transaction.
+func (t transactionalHandler) UpdateResource(ctx RequestContext, id string, data Payload,
+	version string) (Resource, error) {
+
+	result, err := t.transact(ctx, func(ctx RequestContext) (interface{}, error) {
+		return t.ResourceHandler.UpdateResource(ctx, id, data, version)
+	})
+	return result, err
+}
+
+// DeleteResource invokes the wrapped ResourceHandler's DeleteResource in a
+// transaction.
+func (t transactionalHandler) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+
+	result, err := t.transact(ctx, func(ctx RequestContext) (interface{}, error) {
+		return t.ResourceHandler.DeleteResource(ctx, id, version)
+	})
+	return result, err
+}
+
+// transact invokes the function in a transaction. The transaction is committed if the
+// function and BeforeCommit succeed and rolled back otherwise, including if either
+// panics, in which case the panic is propagated after rolling back.
+func (t transactionalHandler) transact(ctx RequestContext,
+	f func(RequestContext) (interface{}, error)) (result interface{}, err error) {
+
+	manager := t.transactions.Manager
+	txCtx, err := manager.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := false
+	defer func() {
+		if done {
+			return
+		}
+		// f or BeforeCommit panicked.
+		t.rollback(manager, txCtx)
+		panic(recover())
+	}()
+
+	result, err = f(txCtx)
+	if err == nil && t.transactions.BeforeCommit != nil {
+		err = t.transactions.BeforeCommit(txCtx, result)
+	}
+	done = true
+
+	if err != nil {
+		t.rollback(manager, txCtx)
+		return nil, err
+	}
+	if err := manager.Commit(txCtx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// rollback rolls back the transaction, logging any failure since the original error
+// takes precedence.
+func (t transactionalHandler) rollback(manager TransactionManager, txCtx RequestContext) {
+	if err := manager.Rollback(txCtx); err != nil {
+		t.api.debugf(t.ResourceName(), "Transaction rollback failed for %s: %s",
+			t.ResourceName(), err)
+	}
+}
@@ -0,0 +1,268 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// txKey is the RequestContext key for the fake transaction handle.
+type txKey struct{}
+
+// eventLog records the order in which transaction events occur.
+type eventLog struct {
+	events []string
+}
+
+func (e *eventLog) record(event string) {
+	e.events = append(e.events, event)
+}
+
+// fakeTransactionManager is a TransactionManager which records calls.
+type fakeTransactionManager struct {
+	*eventLog
+	commitErr error
+}
+
+func (f *fakeTransactionManager) Begin(ctx RequestContext) (RequestContext, error) {
+	f.record("begin")
+	return ctx.WithValue(txKey{}, "tx"), nil
+}
+
+func (f *fakeTransactionManager) Commit(ctx RequestContext) error {
+	f.record("commit:" + ctx.Value(txKey{}).(string))
+	return f.commitErr
+}
+
+func (f *fakeTransactionManager) Rollback(ctx RequestContext) error {
+	f.record("rollback:" + ctx.Value(txKey{}).(string))
+	return nil
+}
+
+// transactionalResource is a ResourceHandler which records calls.
+type transactionalResource struct {
+	BaseResourceHandler
+	*eventLog
+	err   error
+	panic bool
+}
+
+func (t *transactionalResource) ResourceName() string {
+	return "foo"
+}
+
+func (t *transactionalResource) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	t.record("create:" + ctx.Value(txKey{}).(string))
+	if t.panic {
+		panic("boom")
+	}
+	return &TestResource{Foo: "hello"}, t.err
+}
+
+func (t *transactionalResource) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	tx, _ := ctx.Value(txKey{}).(string)
+	t.record("read:" + tx)
+	return &TestResource{Foo: id}, nil
+}
+
+func (t *transactionalResource) DeleteResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	t.record("delete:" + ctx.Value(txKey{}).(string))
+	return nil, t.err
+}
+
+// recordingSerializer is a ResponseSerializer which records serialization.
+type recordingSerializer struct {
+	*eventLog
+}
+
+func (r recordingSerializer) Serialize(p Payload) ([]byte, error) {
+	r.record("serialize")
+	return jsonSerializer{}.Serialize(p)
+}
+
+func (r recordingSerializer) ContentType() string {
+	return "application/json"
+}
+
+// recordingWriter is an http.ResponseWriter which records when the response is
+// written.
+type recordingWriter struct {
+	*httptest.ResponseRecorder
+	*eventLog
+}
+
+func (r recordingWriter) WriteHeader(status int) {
+	r.record(fmt.Sprintf("write:%d", status))
+	r.ResponseRecorder.WriteHeader(status)
+}
+
+// newTransactionalAPI returns an API with a transactional foo resource whose events
+// are recorded in the eventLog.
+func newTransactionalAPI(handler *transactionalResource, transactions Transactions) API {
+	api := NewAPI(&Configuration{})
+	api.RegisterResponseSerializer("json", recordingSerializer{handler.eventLog})
+	api.RegisterResourceHandler(handler, transactions)
+	return api
+}
+
+// serveRecorded sends a request to the named route and records the response write.
+func serveRecorded(api API, log *eventLog, route, method, url string) *httptest.ResponseRecorder {
+	handler, _ := api.(*muxAPI).getRouteHandler(route)
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString("{}"))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(recordingWriter{resp, log}, req)
+	return resp
+}
+
+// Ensures that a successful create begins a transaction before the handler, invokes
+// BeforeCommit and commits within it, and serializes and writes the response after
+// the commit.
+func TestTransactionsCommit(t *testing.T) {
+	assert := assert.New(t)
+	log := &eventLog{}
+	handler := &transactionalResource{eventLog: log}
+	api := newTransactionalAPI(handler, Transactions{
+		Manager: &fakeTransactionManager{eventLog: log},
+		BeforeCommit: func(ctx RequestContext, result interface{}) error {
+			log.record("audit:" + result.(*TestResource).Foo)
+			return nil
+		},
+	})
+
+	resp := serveRecorded(api, log, "foo:create", "POST", "http://foo.com/api/v0.1/foo")
+
+	assert.Equal(http.StatusCreated, resp.Code, "Incorrect response code")
+	assert.Equal([]string{
+		"begin", "create:tx", "audit:hello", "commit:tx", "serialize", "write:201",
+	}, log.events)
+}
+
+// Ensures that the transaction is rolled back before the response is written if the
+// handler fails.
+func TestTransactionsHandlerError(t *testing.T) {
+	assert := assert.New(t)
+	log := &eventLog{}
+	handler := &transactionalResource{eventLog: log, err: ResourceConflict("conflict")}
+	api := newTransactionalAPI(handler, Transactions{
+		Manager: &fakeTransactionManager{eventLog: log},
+		BeforeCommit: func(ctx RequestContext, result interface{}) error {
+			log.record("audit")
+			return nil
+		},
+	})
+
+	resp := serveRecorded(api, log, "foo:delete", "DELETE", "http://foo.com/api/v0.1/foo/1")
+
+	assert.Equal(http.StatusConflict, resp.Code, "Incorrect response code")
+	assert.Equal([]string{
+		"begin", "delete:tx", "rollback:tx", "serialize", "write:409",
+	}, log.events)
+}
+
+// Ensures that the transaction is rolled back and the error returned if BeforeCommit
+// fails.
+func TestTransactionsBeforeCommitError(t *testing.T) {
+	assert := assert.New(t)
+	log := &eventLog{}
+	handler := &transactionalResource{eventLog: log}
+	api := newTransactionalAPI(handler, Transactions{
+		Manager: &fakeTransactionManager{eventLog: log},
+		BeforeCommit: func(ctx RequestContext, result interface{}) error {
+			log.record("audit")
+			return fmt.Errorf("audit sink unavailable")
+		},
+	})
+
+	resp := serveRecorded(api, log, "foo:create", "POST", "http://foo.com/api/v0.1/foo")
+
+	assert.Equal(http.StatusInternalServerError, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":["audit sink unavailable"],"reason":"Internal Server Error","status":500}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+	assert.Equal([]string{
+		"begin", "create:tx", "audit", "rollback:tx", "serialize", "write:500",
+	}, log.events)
+}
+
+// Ensures that a failed commit results in an error response.
+func TestTransactionsCommitError(t *testing.T) {
+	assert := assert.New(t)
+	log := &eventLog{}
+	handler := &transactionalResource{eventLog: log}
+	api := newTransactionalAPI(handler, Transactions{
+		Manager: &fakeTransactionManager{eventLog: log, commitErr: fmt.Errorf("deadlock")},
+	})
+
+	resp := serveRecorded(api, log, "foo:create", "POST", "http://foo.com/api/v0.1/foo")
+
+	assert.Equal(http.StatusInternalServerError, resp.Code, "Incorrect response code")
+	assert.Equal([]string{"begin", "create:tx", "commit:tx", "serialize", "write:500"},
+		log.events)
+}
+
+// Ensures that the transaction is rolled back if the handler panics and that the
+// panic propagates without a response being written.
+func TestTransactionsPanic(t *testing.T) {
+	assert := assert.New(t)
+	log := &eventLog{}
+	handler := &transactionalResource{eventLog: log, panic: true}
+	api := newTransactionalAPI(handler, Transactions{
+		Manager: &fakeTransactionManager{eventLog: log},
+	})
+
+	assert.Panics(func() {
+		serveRecorded(api, log, "foo:create", "POST", "http://foo.com/api/v0.1/foo")
+	})
+	assert.Equal([]string{"begin", "create:tx", "rollback:tx"}, log.events)
+}
+
+// Ensures that reads are excluded from transactions by default but can opt in.
+func TestTransactionsReads(t *testing.T) {
+	assert := assert.New(t)
+	log := &eventLog{}
+	handler := &transactionalResource{eventLog: log}
+	api := newTransactionalAPI(handler, Transactions{
+		Manager: &fakeTransactionManager{eventLog: log},
+	})
+
+	serveRecorded(api, log, "foo:read", "GET", "http://foo.com/api/v0.1/foo/1")
+
+	assert.Equal([]string{"read:", "serialize", "write:200"}, log.events)
+
+	log = &eventLog{}
+	handler = &transactionalResource{eventLog: log}
+	api = newTransactionalAPI(handler, Transactions{
+		Manager:      &fakeTransactionManager{eventLog: log},
+		IncludeReads: true,
+	})
+
+	serveRecorded(api, log, "foo:read", "GET", "http://foo.com/api/v0.1/foo/1")
+
+	assert.Equal([]string{"begin", "read:tx", "commit:tx", "serialize", "write:200"},
+		log.events)
+}
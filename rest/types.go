@@ -17,9 +17,11 @@ limitations under the License.
 package rest
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -97,6 +99,22 @@ var typeToKind = map[Type]reflect.Kind{
 	Time:      reflect.Struct,
 }
 
+// typeBits maps integer Types to their size in bits for parsing. Zero is the size of
+// int and uint.
+var typeBits = map[Type]int{
+	Int:      0,
+	Int8:     8,
+	Int16:    16,
+	Int32:    32,
+	Int64:    64,
+	Uint:     0,
+	Uint8:    8,
+	Uint16:   16,
+	Uint32:   32,
+	Uint64:   64,
+	Duration: 64,
+}
+
 // timeLayout is the format in which strings are parsed as time.Time (ISO 8601).
 const timeLayout = "2006-01-02T15:04:05Z"
 
@@ -107,12 +125,15 @@ func coerceType(value interface{}, coerceTo Type) (interface{}, error) {
 		return value, nil
 	}
 
-	// json.Unmarshal converts values to bool, float64, string, nil, slice, and map.
+	// json.Unmarshal converts values to bool, float64 or json.Number, string, nil,
+	// slice, and map.
 	switch value.(type) {
 	case bool:
 		return coerceFromBool(value.(bool), coerceTo)
 	case float64:
 		return coerceFromFloat(value.(float64), coerceTo)
+	case json.Number:
+		return coerceFromNumber(value.(json.Number), coerceTo)
 	case string:
 		return coerceFromString(value.(string), coerceTo)
 	case nil:
@@ -191,6 +212,89 @@ func coerceFromFloat(value float64, coerceTo Type) (interface{}, error) {
 	}
 }
 
+// coerceFromNumber attempts to convert the given json.Number to the specified Type by
+// parsing its literal, so integers aren't rounded through float64. Integer literals
+// out of the Type's range can't be coerced, while those with a fraction or exponent
+// are converted like floats. If it cannot be coerced, nil will be returned along
+// with an error.
+func coerceFromNumber(value json.Number, coerceTo Type) (interface{}, error) {
+	literal := string(value)
+	switch coerceTo {
+	// To int.
+	case Int, Int8, Int16, Int32, Int64, Duration:
+		i, err := strconv.ParseInt(literal, 10, typeBits[coerceTo])
+		if err != nil {
+			return coerceFromNumberLiteral(literal, coerceTo)
+		}
+		switch coerceTo {
+		case Int:
+			return int(i), nil
+		case Int8:
+			return int8(i), nil
+		case Int16:
+			return int16(i), nil
+		case Int32:
+			return int32(i), nil
+		case Duration:
+			return time.Duration(i), nil
+		}
+		return i, nil
+
+	// To unsigned int.
+	case Uint, Uint8, Uint16, Uint32, Uint64:
+		u, err := strconv.ParseUint(literal, 10, typeBits[coerceTo])
+		if err != nil {
+			return coerceFromNumberLiteral(literal, coerceTo)
+		}
+		switch coerceTo {
+		case Uint:
+			return uint(u), nil
+		case Uint8:
+			return uint8(u), nil
+		case Uint16:
+			return uint16(u), nil
+		case Uint32:
+			return uint32(u), nil
+		}
+		return u, nil
+
+	// To float.
+	case Float32:
+		f, err := strconv.ParseFloat(literal, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to coerce number to %s", typeToName[coerceTo])
+		}
+		return float32(f), nil
+	case Float64:
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to coerce number to %s", typeToName[coerceTo])
+		}
+		return f, nil
+
+	// To string.
+	case String:
+		return literal, nil
+
+	// Bool and Time cases left off intentionally.
+	default:
+		return nil, fmt.Errorf("Unable to coerce number to %s", typeToName[coerceTo])
+	}
+}
+
+// coerceFromNumberLiteral converts a literal which failed to parse as an integer
+// Type like a float if it has a fraction or exponent. Otherwise it's out of range or
+// negative for an unsigned Type, so nil will be returned along with an error.
+func coerceFromNumberLiteral(literal string, coerceTo Type) (interface{}, error) {
+	if strings.ContainsAny(literal, ".eE") {
+		if f, err := strconv.ParseFloat(literal, 64); err == nil {
+			return coerceFromFloat(f, coerceTo)
+		}
+	}
+	return nil, fmt.Errorf("Unable to coerce %s to %s: out of range", literal,
+		typeToName[coerceTo])
+}
+
 // coerceFromString attempts to convert the given string to the specified Type. If
 // it cannot be coerced, nil will be returned along with an error.
 func coerceFromString(value string, coerceTo Type) (interface{}, error) {
@@ -0,0 +1,284 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// pathElement is a single step in the path to a value within a JSON document.
+type pathElement struct {
+	name  string
+	index bool
+}
+
+// jsonPath is the path to a value within a JSON document.
+type jsonPath []pathElement
+
+// String returns the path in dot notation, e.g. foo.bar[0].baz.
+func (p jsonPath) String() string {
+	var buf bytes.Buffer
+	for _, elem := range p {
+		if elem.index {
+			buf.WriteString("[" + elem.name + "]")
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(".")
+		}
+		buf.WriteString(elem.name)
+	}
+	return buf.String()
+}
+
+// utf8Violation describes invalid UTF-8 found in a JSON document.
+type utf8Violation struct {
+	path   jsonPath
+	offset int
+}
+
+// jsonFrame tracks the position within a JSON object or array while scanning.
+type jsonFrame struct {
+	object    bool
+	expectKey bool
+	key       string
+	index     int
+}
+
+// findInvalidUTF8 scans the JSON document and returns the location of every string
+// containing invalid UTF-8. Offsets are byte offsets into the document. This must be
+// done on the raw bytes since decoding replaces invalid sequences with U+FFFD.
+func findInvalidUTF8(body []byte) []utf8Violation {
+	if utf8.Valid(body) {
+		return nil
+	}
+
+	violations := []utf8Violation{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	stack := []*jsonFrame{}
+
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err != nil {
+			// Either EOF or malformed JSON, which is left for the decoder to report.
+			return violations
+		}
+		end := decoder.InputOffset()
+
+		var top *jsonFrame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		if top != nil && top.object && top.expectKey {
+			if key, ok := token.(string); ok {
+				top.key = key
+				top.expectKey = false
+				violations = appendViolation(violations, body, start, end, framePath(stack))
+				continue
+			}
+		}
+
+		switch t := token.(type) {
+		case json.Delim:
+			if t == '{' || t == '[' {
+				stack = append(stack, &jsonFrame{object: t == '{', expectKey: t == '{'})
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				stack[len(stack)-1].advance()
+			}
+		case string:
+			violations = appendViolation(violations, body, start, end, framePath(stack))
+			if top != nil {
+				top.advance()
+			}
+		default:
+			if top != nil {
+				top.advance()
+			}
+		}
+	}
+}
+
+// advance moves the frame past a value.
+func (f *jsonFrame) advance() {
+	if f.object {
+		f.expectKey = true
+	} else {
+		f.index++
+	}
+}
+
+// framePath returns the path to the current position described by the frames.
+func framePath(stack []*jsonFrame) jsonPath {
+	path := make(jsonPath, 0, len(stack))
+	for _, frame := range stack {
+		if frame.object {
+			path = append(path, pathElement{name: frame.key})
+		} else {
+			path = append(path, pathElement{name: strconv.Itoa(frame.index), index: true})
+		}
+	}
+	return path
+}
+
+// appendViolation appends a utf8Violation if the given span of the document contains
+// invalid UTF-8.
+func appendViolation(violations []utf8Violation, body []byte, start, end int64,
+	path jsonPath) []utf8Violation {
+
+	if offset := invalidUTF8Offset(body[start:end]); offset >= 0 {
+		violations = append(violations, utf8Violation{path: path, offset: int(start) + offset})
+	}
+	return violations
+}
+
+// invalidUTF8Offset returns the byte offset of the first invalid UTF-8 sequence in the
+// slice or -1 if it's valid.
+func invalidUTF8Offset(b []byte) int {
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return -1
+}
+
+// validateUTF8 verifies that the JSON request body contains only valid UTF-8 where
+// required. If enforce is true, every string must be valid. Otherwise, only strings
+// for fields whose Rules specify ValidateUTF8 or NormalizeUnicode must be valid.
+// Returns a 400 Error naming the field and byte offset of the first violation.
+func validateUTF8(body []byte, rules Rules, version string, enforce bool) error {
+	for _, violation := range findInvalidUTF8(body) {
+		if enforce || utf8Enforced(violation.path, rules, version) {
+			return BadRequest(fmt.Sprintf(
+				"Invalid UTF-8 in field '%s' at byte offset %d",
+				violation.path, violation.offset))
+		}
+	}
+	return nil
+}
+
+// utf8Enforced returns true if a Rule along the given path requires valid UTF-8.
+func utf8Enforced(path jsonPath, rules Rules, version string) bool {
+	if rules == nil {
+		return false
+	}
+	rules = rules.Filter(Inbound).ForVersion(version)
+
+pathLoop:
+	for _, elem := range path {
+		if elem.index {
+			// Array indexes don't correspond to Rules.
+			continue
+		}
+
+		for _, rule := range rules.Contents() {
+			if rule.Name() != elem.name {
+				continue
+			}
+			if rule.ValidateUTF8 || rule.NormalizeUnicode {
+				return true
+			}
+			if rule.Rules == nil {
+				return false
+			}
+			rules = rule.Rules.Filter(Inbound).ForVersion(version)
+			continue pathLoop
+		}
+
+		return false
+	}
+
+	return false
+}
+
+// sanitizeString strips control characters from and normalizes the string as
+// specified.
+func sanitizeString(s string, stripControl, normalize bool) string {
+	if stripControl {
+		s = stripControlChars(s)
+	}
+	if normalize {
+		s = norm.NFC.String(s)
+	}
+	return s
+}
+
+// sanitizeValue recursively applies sanitizeString to every string within the value,
+// including map keys.
+func sanitizeValue(value interface{}, stripControl, normalize bool) interface{} {
+	switch v := value.(type) {
+	case string:
+		return sanitizeString(v, stripControl, normalize)
+	case []interface{}:
+		for i, item := range v {
+			v[i] = sanitizeValue(item, stripControl, normalize)
+		}
+		return v
+	case map[string]interface{}:
+		sanitized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			sanitized[sanitizeString(key, stripControl, normalize)] =
+				sanitizeValue(item, stripControl, normalize)
+		}
+		return sanitized
+	default:
+		return value
+	}
+}
+
+// sanitizePayload applies sanitizeValue to every value in the Payload.
+func sanitizePayload(payload Payload, stripControl, normalize bool) Payload {
+	if payload == nil || !stripControl && !normalize {
+		return payload
+	}
+	sanitized := sanitizeValue(map[string]interface{}(payload), stripControl, normalize)
+	return Payload(sanitized.(map[string]interface{}))
+}
+
+// stripControlChars removes control characters other than tab, line feed, and
+// carriage return from the string.
+func stripControlChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// stringLength returns the length of the string in runes or bytes.
+func stringLength(s string, runes bool) int {
+	if runes {
+		return utf8.RuneCountInString(s)
+	}
+	return len(s)
+}
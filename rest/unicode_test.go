@@ -0,0 +1,208 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type utf8Resource struct {
+	Name   string
+	Nested fooResource
+}
+
+// Ensures that findInvalidUTF8 returns nil for valid documents.
+func TestFindInvalidUTF8Valid(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(findInvalidUTF8([]byte(`{"foo": "h\u00e9llo", "bar": [1, "wörld"]}`)))
+}
+
+// Ensures that findInvalidUTF8 reports the path and byte offset of invalid strings,
+// including those nested in objects and arrays.
+func TestFindInvalidUTF8Invalid(t *testing.T) {
+	assert := assert.New(t)
+	body := []byte("{\"foo\": \"ok\", \"bar\": {\"baz\": [\"fine\", \"b\xffd\"]}, \"qux\": \"\xc3\"}")
+
+	violations := findInvalidUTF8(body)
+
+	if assert.Len(violations, 2) {
+		assert.Equal("bar.baz[1]", violations[0].path.String())
+		assert.Equal(bytes.IndexByte(body, 0xff), violations[0].offset)
+		assert.Equal("qux", violations[1].path.String())
+		assert.Equal(bytes.IndexByte(body, 0xc3), violations[1].offset)
+	}
+}
+
+// Ensures that findInvalidUTF8 reports invalid object keys.
+func TestFindInvalidUTF8Key(t *testing.T) {
+	assert := assert.New(t)
+	body := []byte("{\"f\xfeo\": \"bar\"}")
+
+	violations := findInvalidUTF8(body)
+
+	if assert.Len(violations, 1) {
+		assert.Equal(3, violations[0].offset)
+	}
+}
+
+// Ensures that validateUTF8 only enforces validity for fields whose Rules require it
+// unless enforcement is global.
+func TestValidateUTF8(t *testing.T) {
+	assert := assert.New(t)
+	rules := NewRules((*utf8Resource)(nil),
+		&Rule{Field: "Name", FieldAlias: "name", ValidateUTF8: true},
+		&Rule{Field: "Nested", FieldAlias: "nested", Rules: NewRules((*fooResource)(nil),
+			&Rule{Field: "Foo", FieldAlias: "foo", NormalizeUnicode: true},
+			&Rule{Field: "Bar", FieldAlias: "bar"},
+		)},
+	)
+
+	assert.Nil(validateUTF8([]byte("{\"other\": \"\xff\"}"), rules, "1", false))
+	assert.Nil(validateUTF8([]byte("{\"nested\": {\"bar\": \"\xff\"}}"), rules, "1", false))
+	assert.Equal(
+		BadRequest("Invalid UTF-8 in field 'name' at byte offset 10"),
+		validateUTF8([]byte("{\"name\": \"\xff\"}"), rules, "1", false),
+	)
+	assert.Equal(
+		BadRequest("Invalid UTF-8 in field 'nested.foo' at byte offset 20"),
+		validateUTF8([]byte("{\"nested\": {\"foo\": \"\xff\"}}"), rules, "1", false),
+	)
+	assert.Equal(
+		BadRequest("Invalid UTF-8 in field 'other' at byte offset 11"),
+		validateUTF8([]byte("{\"other\": \"\xff\"}"), rules, "1", true),
+	)
+}
+
+// Ensures that sanitizeString strips control characters and normalizes to NFC.
+func TestSanitizeString(t *testing.T) {
+	assert := assert.New(t)
+	decomposed := "e\u0301te\u0301\x00\x1b\t\n"
+
+	assert.Equal(decomposed, sanitizeString(decomposed, false, false))
+	assert.Equal("e\u0301te\u0301\t\n", sanitizeString(decomposed, true, false))
+	assert.Equal("\u00e9t\u00e9\x00\x1b\t\n", sanitizeString(decomposed, false, true))
+	assert.Equal("\u00e9t\u00e9\t\n", sanitizeString(decomposed, true, true))
+}
+
+// Ensures that sanitizePayload recursively sanitizes nested values.
+func TestSanitizePayload(t *testing.T) {
+	assert := assert.New(t)
+	payload := Payload{
+		"foo": "e\u0301",
+		"bar": []interface{}{"e\u0301", 1.0},
+		"baz": map[string]interface{}{"qux": "e\u0301"},
+	}
+
+	sanitized := sanitizePayload(payload, false, true)
+
+	assert.Equal(Payload{
+		"foo": "\u00e9",
+		"bar": []interface{}{"\u00e9", 1.0},
+		"baz": map[string]interface{}{"qux": "\u00e9"},
+	}, sanitized)
+}
+
+// Ensures that the create handler returns a 400 for invalid UTF-8 while RawBody
+// preserves the original bytes and the handler receives normalized values.
+func TestHandleCreateStringHygiene(t *testing.T) {
+	assert := assert.New(t)
+	handler := new(MockResourceHandler)
+	api := NewAPI(&Configuration{})
+	rules := NewRules((*TestResource)(nil), &Rule{
+		Field:            "Foo",
+		FieldAlias:       "foo",
+		Type:             String,
+		NormalizeUnicode: true,
+		MaxLength:        1,
+		RuneLength:       true,
+	})
+
+	handler.On("ResourceName").Return("foo")
+	handler.On("Authenticate").Return(nil)
+	handler.On("Rules").Return(rules)
+
+	api.RegisterResourceHandler(handler)
+	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v0.1/foo",
+		bytes.NewReader([]byte("{\"foo\": \"\xff\"}")))
+	resp := httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusBadRequest, resp.Code, "Incorrect response code")
+	assert.Equal(
+		`{"messages":["Invalid UTF-8 in field 'foo' at byte offset 9"],"reason":"Bad Request","status":400}`,
+		resp.Body.String(),
+		"Incorrect response string",
+	)
+
+	body := []byte("{\"foo\": \"e\u0301\"}")
+	handler.On("CreateResource").Return(&TestResource{Foo: "bar"}, nil)
+	req, _ = http.NewRequest("POST", "http://foo.com/api/v0.1/foo", bytes.NewReader(body))
+	resp = httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	handler.Mock.AssertExpectations(t)
+	assert.Equal(http.StatusCreated, resp.Code, "Incorrect response code")
+}
+
+// normalizingHandler records the context and payload passed to CreateResource.
+type normalizingHandler struct {
+	BaseResourceHandler
+	ctx  RequestContext
+	data Payload
+}
+
+func (n *normalizingHandler) ResourceName() string {
+	return "foo"
+}
+
+func (n *normalizingHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	n.ctx = ctx
+	n.data = data
+	return &TestResource{}, nil
+}
+
+// Ensures that the Configuration normalizes every payload string before the handler
+// sees it while RawBody keeps the original bytes.
+func TestHandleCreateGlobalNormalization(t *testing.T) {
+	assert := assert.New(t)
+	handler := &normalizingHandler{}
+	api := NewAPI(&Configuration{NormalizeUnicode: true, StripControlChars: true})
+
+	api.RegisterResourceHandler(handler)
+	createHandler, _ := api.(*muxAPI).getRouteHandler("foo:create")
+
+	body := []byte("{\"foo\": \"e\u0301\\u0007\"}")
+	req, _ := http.NewRequest("POST", "http://foo.com/api/v0.1/foo", bytes.NewReader(body))
+	resp := httptest.NewRecorder()
+
+	createHandler.ServeHTTP(resp, req)
+
+	assert.Equal(http.StatusCreated, resp.Code, "Incorrect response code")
+	assert.Equal(Payload{"foo": "\u00e9"}, handler.data)
+	assert.Equal(body, handler.ctx.RawBody())
+}
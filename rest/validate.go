@@ -0,0 +1,154 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValidationError is returned by API#Validate and describes every problem found with
+// the API's registered resources.
+type ValidationError struct {
+	// Problems describes each problem, naming the resource it affects.
+	Problems []string
+}
+
+// Error returns the problem if there's one, or a list of the problems otherwise.
+func (v ValidationError) Error() string {
+	if len(v.Problems) == 1 {
+		return v.Problems[0]
+	}
+	return fmt.Sprintf("%d problems with API resources:\n  %s", len(v.Problems),
+		strings.Join(v.Problems, "\n  "))
+}
+
+// validate returns a ValidationError describing every problem with the registered
+// resources, or nil if there are none. Warnings are problems only in strict mode and
+// are logged otherwise.
+func (r *muxAPI) validate() error {
+	problems := []string{}
+	resources := make([]string, 0, len(r.versionRouters))
+	for resource := range r.versionRouters {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	for _, resource := range resources {
+		for _, err := range r.versionRouters[resource].validate(r.config.SupportedVersions) {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	collisions, warnings := r.routeProblems()
+	problems = append(problems, collisions...)
+	for _, handler := range r.ResourceHandlers() {
+		rules := handler.Rules()
+		if rules == nil || rules.Size() == 0 {
+			warnings = append(warnings, fmt.Sprintf("Handler for %s has no Rules",
+				handler.ResourceName()))
+			continue
+		}
+		if err := rules.Validate(); err != nil {
+			// The Rule errors already name the resource type.
+			problems = append(problems, err.Error())
+			continue
+		}
+		problems = append(problems, r.serializationProblems(handler)...)
+	}
+
+	if r.config.StrictValidation {
+		problems = append(problems, warnings...)
+	} else {
+		for _, warning := range warnings {
+			r.debugf("", "Validation warning: %s", warning)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return ValidationError{Problems: problems}
+}
+
+// serializationProblems returns descriptions of the errors and panics encountered
+// serializing a zero value of the ResourceHandler's resource type with each registered
+// ResponseSerializer for each version it serves.
+func (r *muxAPI) serializationProblems(handler ResourceHandler) []string {
+	resourceType := handler.Rules().ResourceType()
+	if resourceType == nil {
+		return nil
+	}
+	var zero Resource
+	switch resourceType.Kind() {
+	case reflect.Struct:
+		zero = reflect.New(resourceType).Interface()
+	case reflect.Map:
+		zero = reflect.MakeMap(resourceType).Interface()
+	default:
+		return nil
+	}
+
+	versions := handlerVersions(handler)
+	for _, version := range r.config.SupportedVersions {
+		if !containsFold(versions, normalizeVersion(version)) {
+			versions = append(versions, normalizeVersion(version))
+		}
+	}
+	if len(versions) == 0 {
+		versions = []string{""}
+	}
+
+	r.mu.RLock()
+	formats := make([]string, 0, len(r.serializerRegistry))
+	for format := range r.serializerRegistry {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	serializers := make([]ResponseSerializer, len(formats))
+	for i, format := range formats {
+		serializers[i] = r.serializerRegistry[format]
+	}
+	r.mu.RUnlock()
+
+	problems := []string{}
+	for _, version := range versions {
+		for i, serializer := range serializers {
+			if err := drySerialize(handler, zero, version, serializer); err != nil {
+				problems = append(problems, fmt.Sprintf(
+					"Handler for %s can't serialize a zero-value %s as %s for version %q: %s",
+					handler.ResourceName(), resourceType, formats[i], version, err))
+			}
+		}
+	}
+	return problems
+}
+
+// drySerialize applies the ResourceHandler's Rules to the resource for the version and
+// serializes it, returning any error or panic.
+func drySerialize(handler ResourceHandler, resource Resource, version string,
+	serializer ResponseSerializer) (err error) {
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	result := applyOutboundRules(resource, handler.Rules(), version)
+	_, err = serializer.Serialize(Payload{"result": result})
+	return err
+}
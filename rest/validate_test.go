@@ -0,0 +1,99 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// validateHandler is a ResourceHandler whose Rules are configurable.
+type validateHandler struct {
+	BaseResourceHandler
+	name  string
+	rules Rules
+}
+
+func (v validateHandler) ResourceName() string {
+	return v.name
+}
+
+func (v validateHandler) Rules() Rules {
+	return v.rules
+}
+
+// Ensures that Validate reports every problem with the registered resources rather
+// than only the first.
+func TestValidateReportsAllProblems(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{SupportedVersions: []string{"v1", "v2"}})
+	api.RegisterResourceHandler(validateHandler{
+		name:  "bad-field",
+		rules: NewRules((*TestResource)(nil), &Rule{Field: "bar"}),
+	}, ForVersions("v1"))
+	api.RegisterResourceHandler(validateHandler{
+		name:  "bad-type",
+		rules: NewRules((*TestResource)(nil), &Rule{Field: "Foo", Type: Int}),
+	})
+
+	err := api.Validate()
+
+	if assert.IsType(ValidationError{}, err) {
+		problems := err.(ValidationError).Problems
+		assert.Len(problems, 3)
+		assert.Equal("No handler for version 2 of bad-field", problems[0])
+		assert.Contains(err.Error(), "3 problems")
+	}
+}
+
+// Ensures that Validate reports a panic serializing a zero-value resource instead of
+// panicking.
+func TestValidateSerializationPanic(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(validateHandler{
+		name: "panics",
+		rules: NewRules((*TestResource)(nil), &Rule{
+			Field: "Foo",
+			Type:  String,
+			OutputHandler: func(value interface{}) interface{} {
+				return value.(*string)
+			},
+		}),
+	})
+
+	err := api.Validate()
+
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Handler for panics can't serialize a zero-value")
+		assert.Contains(err.Error(), "panic:")
+	}
+}
+
+// Ensures that resources without Rules are only problems in strict mode.
+func TestValidateStrict(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(validateHandler{name: "unruled", rules: &rules{}})
+
+	assert.Nil(api.Validate())
+
+	api.Configuration().StrictValidation = true
+
+	assert.Equal("Handler for unruled has no Rules", api.Validate().Error())
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// PayloadValidationsCounter counts requests to resources' _validate endpoints.
+	PayloadValidationsCounter = "payload_validations"
+
+	// PayloadTooLargeCode is the error code of responses to bodies larger than a
+	// PayloadValidation's MaxBodyBytes.
+	PayloadTooLargeCode = "payload_too_large"
+
+	// validatePath is appended to a resource's create URI to form the URI validating
+	// bodies.
+	validatePath = "/_validate"
+
+	// defaultMaxValidationBytes is the maximum size of bodies validated if the
+	// PayloadValidation doesn't specify one.
+	defaultMaxValidationBytes = 1 << 20
+)
+
+// PayloadValidation is a ResourceOption which serves POST
+// /api/:version/resource/_validate, letting clients check a body, or an array of them,
+// before sending it. The body goes through the same decoding, string hygiene, and
+// inbound Rules for the version as a create, but the ResourceHandler is never invoked,
+// so transactions and retries don't apply. The response's result is a
+// ValidationResult with a 200 whether or not the body is valid, so invalid bodies
+// aren't recorded as rejections. Requests are authenticated, scoped, and limited like
+// writes to the resource since responses reveal its schema. Versioned resources must
+// specify PayloadValidation for every version or none.
+type PayloadValidation struct {
+	// MaxBodyBytes is the maximum size, in bytes, of bodies validated. Larger ones
+	// receive a 413. Defaults to 1 MiB.
+	MaxBodyBytes int
+
+	// CheckConstraints runs the Rules' Unique and Exists checks on bodies which pass
+	// every static constraint. Defaults to false, since the checks may be expensive.
+	CheckConstraints bool
+}
+
+// apply sets the PayloadValidation on the resource.
+func (v PayloadValidation) apply(opts *resourceOptions) {
+	opts.validation = &v
+}
+
+// maxBodyBytes returns the maximum size of bodies validated.
+func (v *PayloadValidation) maxBodyBytes() int {
+	if v.MaxBodyBytes <= 0 {
+		return defaultMaxValidationBytes
+	}
+	return v.MaxBodyBytes
+}
+
+// ValidationResult is the result of a request to a resource's _validate endpoint.
+type ValidationResult struct {
+	// Valid indicates if the body would be accepted.
+	Valid bool `json:"valid"`
+
+	// Errors describe each field which failed validation if the body is invalid.
+	Errors FieldErrors `json:"errors,omitempty"`
+
+	// Document is the body as the ResourceHandler would receive it, with values
+	// coerced and normalized and unknown fields discarded, if it's valid.
+	Document interface{} `json:"document,omitempty"`
+}
+
+// validationRoutes returns the routes with the route validating bodies added after the
+// create route.
+func (r *muxAPI) validationRoutes(h ResourceHandler, validation *PayloadValidation,
+	routes []resourceRoute, middleware []RequestMiddleware) []resourceRoute {
+
+	handler := applyMiddleware(r.handler.handleValidate(h, validation), middleware)
+	withValidation := make([]resourceRoute, 0, len(routes)+1)
+	for _, route := range routes {
+		withValidation = append(withValidation, route)
+		if route.name == "create" {
+			withValidation = append(withValidation, resourceRoute{
+				"validate", "validate", "POST",
+				strings.TrimSuffix(h.CreateURI(), "/") + validatePath, "", handler,
+			})
+		}
+	}
+	return withValidation
+}
+
+// handleValidate returns a HandlerFunc which decodes the request body and applies the
+// ResourceHandler's inbound Rules to it as a create or, for arrays, update list would,
+// then responds with the ValidationResult without invoking the handler.
+func (h requestHandler) handleValidate(handler ResourceHandler,
+	validation *PayloadValidation) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := NewContext(nil, r)
+		version := ctx.Version()
+		rules := handler.Rules()
+		resource := handler.ResourceName()
+		h.Metrics().incr(PayloadValidationsCounter, resource)
+
+		max := validation.maxBodyBytes()
+		raw, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(max)+1))
+		if err != nil {
+			h.sendResponse(w, ctx.setError(BadRequest(err.Error())))
+			return
+		}
+		if len(raw) > max {
+			h.sendResponse(w, ctx.setError(Error{
+				reason: fmt.Sprintf("Body exceeds %d bytes", max),
+				status: http.StatusRequestEntityTooLarge,
+				code:   PayloadTooLargeCode,
+			}))
+			return
+		}
+
+		ctx = ctx.setRawBody(raw)
+		body, err := h.requestBody(raw, r.Header)
+		if err != nil {
+			// Body failed digest verification or its charset is not supported.
+			h.sendResponse(w, ctx.setError(err))
+			return
+		}
+
+		document, err := h.validateBody(body, rules, version)
+		if err == nil && validation.CheckConstraints {
+			err = checkConstraints(ctx, document, rules, version)
+		}
+		if err != nil {
+			if fields, ok := err.(FieldErrors); ok {
+				h.debugf(resource, "Validated invalid %s body: %s", resource,
+					fields)
+				ctx = ctx.setResult(ValidationResult{Errors: fields})
+				h.sendResponse(w, ctx.setStatus(http.StatusOK))
+				return
+			}
+			h.sendResponse(w, ctx.setError(err))
+			return
+		}
+
+		ctx = ctx.setResult(ValidationResult{Valid: true, Document: document})
+		h.sendResponse(w, ctx.setStatus(http.StatusOK))
+	}
+}
+
+// validateBody decodes the body and applies the inbound Rules for the version to it,
+// returning the Payload, or slice of them if the body is an array, the handler would
+// receive. Returns FieldErrors if Rules fail and an Error if the body can't be
+// decoded.
+func (h requestHandler) validateBody(body []byte, rules Rules, version string) (interface{},
+	error) {
+
+	useNumber := h.Configuration().UseNumber
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		data, err := decodePayloadSlice(body, useNumber)
+		if err != nil {
+			return nil, BadRequest(err.Error())
+		}
+		if err := h.validateStrings(body, rules, version); err != nil {
+			return nil, err
+		}
+		if err := applyInboundRulesList(h.sanitizePayload, data, rules, version); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	data, err := decodePayload(body, useNumber)
+	if err != nil {
+		return nil, BadRequest(err.Error())
+	}
+	if err := h.validateStrings(body, rules, version); err != nil {
+		return nil, err
+	}
+	return applyInboundRules(h.sanitizePayload(data), rules, version)
+}
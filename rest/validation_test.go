@@ -0,0 +1,192 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// orderHandler is a ResourceHandler for orders which counts the orders it creates.
+type orderHandler struct {
+	BaseResourceHandler
+	created *int
+}
+
+func (o orderHandler) ResourceName() string {
+	return "orders"
+}
+
+func (o orderHandler) CreateResource(ctx RequestContext, data Payload,
+	version string) (Resource, error) {
+	*o.created++
+	return data, nil
+}
+
+func (o orderHandler) Rules() Rules {
+	return NewRules((*TestResource)(nil),
+		&Rule{FieldAlias: "quantity", Type: Int, Required: true, Minimum: "1"},
+		&Rule{FieldAlias: "sku", Type: String, Required: true, MaxLength: 8,
+			StripControlChars: true},
+		&Rule{FieldAlias: "gift", Type: Bool, Versions: []string{"2"}},
+	)
+}
+
+// validationResponse is the envelope of responses from _validate endpoints.
+type validationResponse struct {
+	Status int              `json:"status"`
+	Result ValidationResult `json:"result"`
+}
+
+// serveValidate sends the body to the orders _validate endpoint for the version.
+func serveValidate(api API, version, body string) (*httptest.ResponseRecorder,
+	validationResponse) {
+
+	req, _ := http.NewRequest("POST", "http://example.com/api/v"+version+"/orders/_validate",
+		bytes.NewBufferString(body))
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	var decoded validationResponse
+	json.Unmarshal(resp.Body.Bytes(), &decoded)
+	return resp, decoded
+}
+
+// Ensures that valid bodies are reported with the coerced and normalized document the
+// handler would receive, without invoking it.
+func TestPayloadValidationValid(t *testing.T) {
+	assert := assert.New(t)
+	created := 0
+	api := NewAPI(&Configuration{StrictValidation: true})
+	api.RegisterResourceHandler(orderHandler{created: &created}, PayloadValidation{})
+	assert.Nil(api.Validate())
+
+	resp, decoded := serveValidate(api, "1",
+		`{"quantity": "3", "sku": "ab\u0007c", "gift": true, "coupon": "x"}`)
+
+	assert.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	assert.True(decoded.Result.Valid)
+	assert.Empty(decoded.Result.Errors)
+	assert.Equal(map[string]interface{}{"quantity": float64(3), "sku": "abc"},
+		decoded.Result.Document)
+	assert.Equal(0, created)
+	assert.Equal(uint64(1), api.Metrics().Counter(PayloadValidationsCounter, "orders"))
+}
+
+// Ensures that the Rules for the requested version are applied.
+func TestPayloadValidationVersion(t *testing.T) {
+	assert := assert.New(t)
+	created := 0
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(orderHandler{created: &created}, PayloadValidation{})
+
+	_, decoded := serveValidate(api, "2", `{"quantity": 1, "sku": "a", "gift": "true"}`)
+
+	assert.True(decoded.Result.Valid)
+	assert.Equal(map[string]interface{}{"quantity": float64(1), "sku": "a", "gift": true},
+		decoded.Result.Document)
+}
+
+// Ensures that invalid bodies are reported with their field errors in a 200 which
+// isn't recorded as a rejection.
+func TestPayloadValidationInvalid(t *testing.T) {
+	assert := assert.New(t)
+	created := 0
+	sink := &recordingSink{}
+	api := NewAPI(&Configuration{RejectionAudit: &RejectionAudit{Sink: sink}})
+	api.RegisterResourceHandler(orderHandler{created: &created}, PayloadValidation{})
+
+	resp, decoded := serveValidate(api, "1", `{"quantity": 0, "sku": "abcdefghij"}`)
+
+	assert.Equal(http.StatusOK, resp.Code, resp.Body.String())
+	assert.False(decoded.Result.Valid)
+	assert.Nil(decoded.Result.Document)
+	if assert.Len(decoded.Result.Errors, 2) {
+		assert.Equal("quantity", decoded.Result.Errors[0].Field)
+		assert.Equal(FieldTooSmallCode, decoded.Result.Errors[0].Code)
+		assert.Equal("sku", decoded.Result.Errors[1].Field)
+		assert.Equal(FieldTooLongCode, decoded.Result.Errors[1].Code)
+	}
+	assert.Empty(sink.records)
+	assert.Equal(0, created)
+}
+
+// Ensures that arrays are validated item by item like update list bodies.
+func TestPayloadValidationList(t *testing.T) {
+	assert := assert.New(t)
+	created := 0
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(orderHandler{created: &created}, PayloadValidation{})
+
+	_, decoded := serveValidate(api, "1", `[{"quantity": 1, "sku": "a"}, {"sku": "b"}]`)
+
+	assert.False(decoded.Result.Valid)
+	if assert.Len(decoded.Result.Errors, 1) {
+		assert.Equal("[1].quantity", decoded.Result.Errors[0].Field)
+		assert.Equal(FieldRequiredCode, decoded.Result.Errors[0].Code)
+	}
+
+	_, decoded = serveValidate(api, "1", `[{"quantity": 1, "sku": "a"}]`)
+
+	assert.True(decoded.Result.Valid)
+	assert.Equal([]interface{}{map[string]interface{}{"quantity": float64(1), "sku": "a"}},
+		decoded.Result.Document)
+}
+
+// Ensures that malformed bodies receive a 400 and bodies over the limit a 413.
+func TestPayloadValidationRejectsBodies(t *testing.T) {
+	assert := assert.New(t)
+	created := 0
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(orderHandler{created: &created},
+		PayloadValidation{MaxBodyBytes: 32})
+
+	resp, _ := serveValidate(api, "1", `{"quantity":`)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+
+	resp, _ = serveValidate(api, "1", `{"quantity": 1, "sku": "a", "padding": "xxxxxxxx"}`)
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Contains(resp.Body.String(), PayloadTooLargeCode)
+}
+
+// Ensures that validation requests are rate limited like other requests to the
+// resource, and that the endpoint isn't served without the option.
+func TestPayloadValidationRateLimitedAndOptIn(t *testing.T) {
+	assert := assert.New(t)
+	created := 0
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(orderHandler{created: &created}, PayloadValidation{},
+		RateLimit{Tier: func(string) RateLimitTier {
+			return RateLimitTier{Limit: 1, Window: time.Hour}
+		}})
+
+	resp, _ := serveValidate(api, "1", `{"quantity": 1, "sku": "a"}`)
+	assert.Equal(http.StatusOK, resp.Code)
+	resp, _ = serveValidate(api, "1", `{"quantity": 1, "sku": "a"}`)
+	assert.Equal(statusTooManyRequests, resp.Code)
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(orderHandler{created: &created})
+	resp, _ = serveValidate(api, "1", `{"quantity": 1, "sku": "a"}`)
+	assert.NotEqual(http.StatusOK, resp.Code)
+	assert.Equal(0, created)
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// versionsOption is a ResourceOption which scopes a ResourceHandler to a set of API
+// versions.
+type versionsOption []string
+
+// ForVersions returns a ResourceOption which scopes a ResourceHandler to the provided
+// API versions. This allows registering multiple ResourceHandlers for the same
+// resource name, each serving a distinct set of versions, e.g.:
+//
+//	api.RegisterResourceHandler(v1v2Handler, rest.ForVersions("v1", "v2"))
+//	api.RegisterResourceHandler(v3Handler, rest.ForVersions("v3"))
+//
+// Requests are dispatched to the handler for the request's version and receive a 404
+// if no handler serves it. Versions are compared without a leading "v", so "v1" and
+// "1" are equivalent, but the version passed to the handler is unchanged. The
+// handlers must use the same URIs, each containing the version path variable.
+// Registration panics if a version is served by more than one handler, and Validate
+// returns an error if the handlers leave any of the Configuration's
+// SupportedVersions unserved.
+func ForVersions(versions ...string) ResourceOption {
+	return versionsOption(versions)
+}
+
+// apply sets the versions on the resource.
+func (v versionsOption) apply(opts *resourceOptions) {
+	opts.versions = append(opts.versions, v...)
+}
+
+// normalizeVersion returns the version without a leading "v".
+func normalizeVersion(version string) string {
+	return strings.TrimPrefix(version, "v")
+}
+
+// versionedHandler is a ResourceHandler which serves only the versions it was
+// registered for using ForVersions.
+type versionedHandler struct {
+	ResourceHandler
+	versions map[string]bool
+}
+
+// unwrap returns the wrapped ResourceHandler.
+func (v versionedHandler) unwrap() ResourceHandler {
+	return v.ResourceHandler
+}
+
+// servesVersions returns the normalized versions the ResourceHandler serves in
+// sorted order.
+func (v versionedHandler) servesVersions() []string {
+	versions := make([]string, 0, len(v.versions))
+	for version := range v.versions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// servesVersion returns true if the ResourceHandler serves the version. Handlers which
+// weren't registered using ForVersions serve every version.
+func servesVersion(h ResourceHandler, version string) bool {
+	versioned, ok := h.(versionedHandler)
+	return !ok || versioned.versions[normalizeVersion(version)]
+}
+
+// versionRouter dispatches requests for a resource with ResourceHandlers registered
+// using ForVersions to the handler serving the request's version.
+type versionRouter struct {
+	resource string
+	uris     map[string]string
+	handlers map[string]map[string]http.HandlerFunc
+}
+
+// newVersionRouter returns a versionRouter for the named resource. The routes' URIs
+// are those of the first ResourceHandler registered.
+func newVersionRouter(resource string, routes []resourceRoute) *versionRouter {
+	uris := make(map[string]string, len(routes))
+	for _, route := range routes {
+		uris[route.name] = route.uri
+	}
+	return &versionRouter{
+		resource: resource,
+		uris:     uris,
+		handlers: map[string]map[string]http.HandlerFunc{},
+	}
+}
+
+// add routes the versions to the versionedHandler's routes. It panics if any of the
+// versions are already served or the routes don't match those of the handlers already
+// registered for the resource.
+func (v *versionRouter) add(h versionedHandler, routes []resourceRoute) {
+	if len(h.versions) == 0 {
+		panic(fmt.Sprintf("ForVersions for %s must specify at least one version", v.resource))
+	}
+	for _, route := range routes {
+		if v.uris[route.name] != route.uri {
+			panic(fmt.Sprintf("Handlers for %s must use the same URIs: %s is %s, not %s",
+				v.resource, route.name, route.uri, v.uris[route.name]))
+		}
+		if !strings.Contains(route.uri, "{"+versionKey) {
+			panic(fmt.Sprintf("URI %s for %s must contain the version variable",
+				route.uri, v.resource))
+		}
+	}
+
+	versions := h.servesVersions()
+	for _, version := range versions {
+		if _, ok := v.handlers[version]; ok {
+			panic(fmt.Sprintf("Version %s of %s is served by more than one handler",
+				version, v.resource))
+		}
+	}
+
+	for _, version := range versions {
+		handlers := make(map[string]http.HandlerFunc, len(routes))
+		for _, route := range routes {
+			handlers[route.name] = route.handler
+		}
+		v.handlers[version] = handlers
+	}
+}
+
+// route returns an http.HandlerFunc which dispatches requests for the named route to
+// the handler serving the request's version. Requests for versions whose handler
+// doesn't have the route, e.g. because it doesn't implement ResourceCounter, receive a
+// 404.
+func (v *versionRouter) route(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := v.handlers[normalizeVersion(mux.Vars(r)[versionKey])][name]
+		if !ok {
+			// Respond as the router would for an unknown route.
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// validate returns an error for each supported version which isn't served and each
+// version served which isn't supported. Any set of versions is valid if no
+// supported versions are declared.
+func (v *versionRouter) validate(supported []string) []error {
+	if len(supported) == 0 {
+		return nil
+	}
+
+	errs := []error{}
+	declared := make(map[string]bool, len(supported))
+	for _, version := range supported {
+		version = normalizeVersion(version)
+		declared[version] = true
+		if _, ok := v.handlers[version]; !ok {
+			errs = append(errs, fmt.Errorf("No handler for version %s of %s", version, v.resource))
+		}
+	}
+	served := make([]string, 0, len(v.handlers))
+	for version := range v.handlers {
+		served = append(served, version)
+	}
+	sort.Strings(served)
+	for _, version := range served {
+		if !declared[version] {
+			errs = append(errs, fmt.Errorf("Handler for %s serves unsupported version %s",
+				v.resource, version))
+		}
+	}
+	return errs
+}
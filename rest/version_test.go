@@ -0,0 +1,152 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// versionTestHandler is a ResourceHandler which reads the version it was invoked with
+// back to the client.
+type versionTestHandler struct {
+	BaseResourceHandler
+	label   string
+	readURI string
+}
+
+func (v versionTestHandler) ResourceName() string {
+	return "foo"
+}
+
+func (v versionTestHandler) ReadURI() string {
+	return v.readURI
+}
+
+func (v versionTestHandler) ReadResource(ctx RequestContext, id string,
+	version string) (Resource, error) {
+	return v.label + ":" + version, nil
+}
+
+// serveVersion sends a read request for the version to the API.
+func serveVersion(api API, version string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "http://foo.com/api/"+version+"/foo/1", nil)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	return resp
+}
+
+// Ensures that requests are dispatched to the handler registered for the request's
+// version, which receives the version unchanged, and unserved versions receive a 404.
+func TestForVersionsDispatch(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+
+	api.RegisterResourceHandler(versionTestHandler{label: "old"}, ForVersions("v1", "2"))
+	api.RegisterResourceHandler(versionTestHandler{label: "new"}, ForVersions("v3"))
+
+	assert.Contains(serveVersion(api, "v1").Body.String(), `"result":"old:1"`)
+	assert.Contains(serveVersion(api, "v2").Body.String(), `"result":"old:2"`)
+	assert.Contains(serveVersion(api, "v3").Body.String(), `"result":"new:3"`)
+	assert.Equal(http.StatusNotFound, serveVersion(api, "v4").Code)
+	assert.Len(api.ResourceHandlers(), 2)
+}
+
+// countingVersionHandler is a versionTestHandler which also counts resources.
+type countingVersionHandler struct {
+	versionTestHandler
+}
+
+func (c countingVersionHandler) CountResources(ctx RequestContext, version string) (int64,
+	error) {
+	return 3, nil
+}
+
+// Ensures that requests for a route only some versions' handlers have receive a 404
+// for the others.
+func TestForVersionsMissingRoute(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(countingVersionHandler{}, ForVersions("v1"))
+	api.RegisterResourceHandler(versionTestHandler{}, ForVersions("v2"))
+
+	for version, status := range map[string]int{"v1": http.StatusOK, "v2": http.StatusNotFound} {
+		req, _ := http.NewRequest("GET", "http://foo.com/api/"+version+"/foo/count", nil)
+		resp := httptest.NewRecorder()
+		api.ServeHTTP(resp, req)
+		assert.Equal(status, resp.Code, version)
+	}
+}
+
+// Ensures that registration panics if version sets overlap, a handler for the same
+// resource omits ForVersions, or the handlers' URIs differ.
+func TestForVersionsRegistrationPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	api := NewAPI(&Configuration{})
+	api.RegisterResourceHandler(versionTestHandler{}, ForVersions("v1", "v2"))
+	assert.Panics(func() {
+		api.RegisterResourceHandler(versionTestHandler{}, ForVersions("2", "3"))
+	})
+	assert.Panics(func() {
+		api.RegisterResourceHandler(versionTestHandler{})
+	})
+	assert.Panics(func() {
+		api.RegisterResourceHandler(versionTestHandler{readURI: "/api/v{version}/bar/{id}"},
+			ForVersions("v3"))
+	})
+
+	api = NewAPI(&Configuration{})
+	api.RegisterResourceHandler(versionTestHandler{})
+	assert.Panics(func() {
+		api.RegisterResourceHandler(versionTestHandler{}, ForVersions("v1"))
+	})
+}
+
+// Ensures that Validate returns an error if versioned handlers leave a supported
+// version unserved or serve an unsupported one.
+func TestForVersionsValidate(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{SupportedVersions: []string{"v1", "v2", "v3"}})
+
+	api.RegisterResourceHandler(versionTestHandler{}, ForVersions("v1", "v2"))
+	assert.Equal("No handler for version 3 of foo", api.Validate().Error())
+
+	api.RegisterResourceHandler(versionTestHandler{}, ForVersions("v3"))
+	assert.Nil(api.Validate())
+
+	api.RegisterResourceHandler(versionTestHandler{}, ForVersions("v4"))
+	assert.Equal("Handler for foo serves unsupported version 4", api.Validate().Error())
+}
+
+// Ensures that documentation attributes each version to the handler serving it.
+func TestForVersionsDocs(t *testing.T) {
+	assert := assert.New(t)
+	api := NewAPI(&Configuration{})
+
+	api.RegisterResourceHandler(versionTestHandler{label: "old"}, ForVersions("v1", "v2"))
+	api.RegisterResourceHandler(versionTestHandler{label: "new"}, ForVersions("v3"))
+	handlers := api.documentedResourceHandlers()
+
+	assert.Equal([]string{"1", "2", "3"}, versions(handlers))
+	assert.True(servesVersion(handlers[0], "2"))
+	assert.False(servesVersion(handlers[0], "3"))
+	assert.True(servesVersion(handlers[1], "3"))
+}